@@ -1,4 +1,11 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  // a provider login comes back with the token in the URL fragment
+  const fragment = new URLSearchParams(window.location.hash.slice(1));
+  if (fragment.get('token')) {
+    localStorage.setItem('token', fragment.get('token'));
+    history.replaceState(null, '', window.location.pathname);
+  }
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -8,9 +15,35 @@ document.addEventListener('DOMContentLoaded', async () => {
   } else {
     document.getElementById('auth-section').style.display = 'block';
     document.getElementById('video-section').style.display = 'none';
+    await showLoginProviders();
   }
 });
 
+// list endpoints wrap their items in an envelope unless LIST_ENVELOPE is off
+function listData(body) {
+  return Array.isArray(body) ? body : body.data;
+}
+
+async function showLoginProviders() {
+  try {
+    const res = await fetch('/api/auth/providers');
+    if (!res.ok) {
+      return;
+    }
+    const providers = listData(await res.json());
+    const container = document.getElementById('sso-providers');
+    container.innerHTML = '';
+    for (const provider of providers) {
+      const link = document.createElement('a');
+      link.href = `/api/auth/${provider}/login`;
+      link.textContent = `Login with ${provider.charAt(0).toUpperCase()}${provider.slice(1)}`;
+      container.appendChild(link);
+    }
+  } catch (error) {
+    console.error(error);
+  }
+}
+
 document.getElementById('video-draft-form').addEventListener('submit', async (event) => {
   event.preventDefault();
   await createVideoDraft();
@@ -161,6 +194,8 @@ async function uploadVideoFile(videoID) {
 
   uploadBtnSelector = 'upload-video-btn';
   setUploadButtonState(true, uploadBtnSelector);
+  // asked here because browsers only prompt from a click
+  await subscribeToPush();
 
   try {
     const res = await fetch(`/api/video_upload/${videoID}`, {
@@ -184,6 +219,37 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+// subscribeToPush registers the service worker and sends its push
+// subscription to the server, so long uploads can notify the user after
+// they've left the page. It quietly does nothing if the browser or the
+// server doesn't support it, or the user says no.
+async function subscribeToPush() {
+  if (!('serviceWorker' in navigator) || !('PushManager' in window)) return;
+  try {
+    const keyRes = await fetch('/api/push/public_key');
+    if (!keyRes.ok) return;
+    const { public_key } = await keyRes.json();
+
+    if ((await Notification.requestPermission()) !== 'granted') return;
+    const registration = await navigator.serviceWorker.register('sw.js');
+    const subscription = await registration.pushManager.subscribe({
+      userVisibleOnly: true,
+      applicationServerKey: public_key,
+    });
+
+    await fetch('/api/push/subscriptions', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      body: JSON.stringify(subscription),
+    });
+  } catch (error) {
+    console.log(`Couldn't subscribe to push notifications: ${error.message}`);
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
@@ -199,12 +265,28 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const videos = listData(await res.json());
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
+      if (video.duration_seconds) {
+        const badge = document.createElement('span');
+        badge.className = 'duration-badge';
+        badge.textContent = formatDuration(video.duration_seconds);
+        listItem.appendChild(badge);
+      }
+      if (video.preview_url) {
+        const preview = document.createElement('img');
+        preview.className = 'hover-preview';
+        preview.alt = '';
+        listItem.onmouseenter = () => {
+          preview.src = video.preview_url;
+          listItem.appendChild(preview);
+        };
+        listItem.onmouseleave = () => preview.remove();
+      }
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
@@ -213,6 +295,14 @@ async function getVideos() {
   }
 }
 
+function formatDuration(seconds) {
+  const total = Math.round(seconds);
+  const h = Math.floor(total / 3600);
+  const m = Math.floor((total % 3600) / 60);
+  const s = String(total % 60).padStart(2, '0');
+  return h > 0 ? `${h}:${String(m).padStart(2, '0')}:${s}` : `${m}:${s}`;
+}
+
 function createVideoStateHandler() {
   let currentVideoID = null;
 
@@ -0,0 +1,16 @@
+// Shows the notifications the server pushes when a long upload finishes
+self.addEventListener('push', (event) => {
+  const data = event.data ? event.data.json() : {};
+  event.waitUntil(
+    self.registration.showNotification(data.title || 'Tubely', {
+      body: data.body,
+      tag: data.video_id,
+      data: { videoID: data.video_id },
+    })
+  );
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  event.waitUntil(self.clients.openWindow(self.registration.scope));
+});
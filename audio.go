@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+type audioFormat string
+
+const (
+	audioMP3 audioFormat = "mp3"
+	audioAAC audioFormat = "aac"
+)
+
+func (f audioFormat) contentType() string {
+	if f == audioAAC {
+		return "audio/mp4"
+	}
+	return "audio/mpeg"
+}
+
+// AAC goes in an M4A container, which podcast apps and browsers can seek in
+func (f audioFormat) extension() string {
+	if f == audioAAC {
+		return "m4a"
+	}
+	return "mp3"
+}
+
+func audioPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("audio/%s/", videoID)
+}
+
+func newAudioKey(videoID uuid.UUID, format audioFormat) (string, error) {
+	randomHex := make([]byte, 16)
+	if _, err := rand.Read(randomHex); err != nil {
+		return "", fmt.Errorf("couldn't generate random hex: %w", err)
+	}
+	return fmt.Sprintf("%s%x.%s", audioPrefix(videoID), randomHex, format.extension()), nil
+}
+
+// extractAudio writes the first audio track of the video at filePath to a
+// file next to it. An AAC track is copied as is when AAC is asked for.
+func extractAudio(ctx context.Context, runner *media.Runner, filePath string, format audioFormat, sourceCodec string) (string, error) {
+	ext := filepath.Ext(filePath)
+	outputFilePath := strings.TrimSuffix(filePath, ext) + ".audio." + format.extension()
+
+	args := []string{
+		"-i", filePath,
+		"-map", "0:a:0",
+		"-vn",
+	}
+	switch {
+	case format == audioAAC && sourceCodec == "aac":
+		args = append(args, "-c:a", "copy", "-f", "ipod")
+	case format == audioAAC:
+		args = append(args, "-c:a", "aac", "-b:a", "160k", "-f", "ipod")
+	default:
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2", "-f", "mp3")
+	}
+	args = append(args, "-progress", "pipe:1", "-nostats", outputFilePath)
+	if err := runFFmpeg(ctx, runner, args, func(time.Duration) {}); err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// authenticate identifies the caller from either a JWT or an API key, writing
+// the error response itself if neither checks out. API keys and scoped JWTs
+// also need scope; an unscoped JWT can do anything its user can.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope auth.Scope) (uuid.UUID, bool) {
+	res := cfg.requestAuth(r)
+	if res.noToken {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT or API key", res.err)
+		return uuid.Nil, false
+	}
+	if res.principal == nil {
+		respondWithError(w, res.status, res.message, res.err)
+		return uuid.Nil, false
+	}
+
+	p := res.principal
+	if !p.allows(scope) {
+		credential := "Token"
+		if p.viaAPIKey() {
+			credential = "API key"
+		}
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("%s doesn't have the %s scope", credential, scope), nil)
+		return uuid.Nil, false
+	}
+	return p.UserID, true
+}
+
+// authenticateJWT identifies the caller from an unscoped JWT, for account
+// management that API keys and scoped tokens mustn't reach, writing the
+// error response itself if there isn't one
+func (cfg *apiConfig) authenticateJWT(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	p, ok := cfg.requireJWT(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+	if len(p.Scopes) > 0 {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", auth.ErrRestrictedToken)
+		return uuid.Nil, false
+	}
+	return p.UserID, true
+}
+
+// requireJWT returns the caller if they signed in with a JWT, scoped or
+// not, writing the error response itself if they didn't
+func (cfg *apiConfig) requireJWT(w http.ResponseWriter, r *http.Request) (*principal, bool) {
+	res := cfg.requestAuth(r)
+	if res.noToken {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", res.err)
+		return nil, false
+	}
+	if res.principal == nil || res.principal.viaAPIKey() {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", res.err)
+		return nil, false
+	}
+	return res.principal, true
+}
+
+// requireAdmin checks the caller's JWT belongs to one of the operators in
+// ADMIN_EMAILS, writing the error response itself if not. API keys never
+// grant admin access. Scoped JWTs are checked for the admin scope by the
+// requireScope every admin route is behind.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	p, ok := cfg.requireJWT(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	user, err := p.loadUser(cfg.db)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return uuid.Nil, false
+	}
+	if user == nil || !cfg.adminEmails[strings.ToLower(user.Email)] {
+		respondWithError(w, http.StatusForbidden, "You aren't an admin", nil)
+		return uuid.Nil, false
+	}
+	return p.UserID, true
+}
+
+// requireScope turns away scoped JWTs that don't have scope before next
+// runs. API keys, unscoped JWTs and requests without a valid token are let
+// through for next to authenticate as usual.
+func (cfg *apiConfig) requireScope(scope auth.Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := cfg.requestPrincipal(r); ok && !p.viaAPIKey() && !p.allows(scope) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Token doesn't have the %s scope", scope), nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
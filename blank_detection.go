@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+type blankVideoMode string
+
+const (
+	blankVideoOff  blankVideoMode = "off"
+	blankVideoFlag blankVideoMode = "flag"
+	blankVideoWarn blankVideoMode = "warn"
+)
+
+// a video counts as black or frozen when at least this share of it is
+const blankVideoThreshold = 0.95
+
+var (
+	durationRe       = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+	blackDurationRe  = regexp.MustCompile(`black_duration:\s*(\d+(?:\.\d+)?)`)
+	freezeStartRe    = regexp.MustCompile(`freeze_start:\s*(\d+(?:\.\d+)?)`)
+	freezeDurationRe = regexp.MustCompile(`freeze_duration:\s*(\d+(?:\.\d+)?)`)
+)
+
+type blankReport struct {
+	Duration       float64
+	BlackDuration  float64
+	FrozenDuration float64
+}
+
+func (b blankReport) warnings() []string {
+	if b.Duration <= 0 {
+		return nil
+	}
+	warnings := []string{}
+	if b.BlackDuration/b.Duration >= blankVideoThreshold {
+		warnings = append(warnings, "video appears to be entirely black")
+	} else if b.FrozenDuration/b.Duration >= blankVideoThreshold {
+		warnings = append(warnings, "video appears to be a single still image")
+	}
+	return warnings
+}
+
+// detectBlankVideo decodes the video once through ffmpeg's blackdetect and
+// freezedetect filters and totals how much of it is black or frozen
+func detectBlankVideo(ctx context.Context, runner *media.Runner, filePath string) (blankReport, error) {
+	args := []string{
+		"-hide_banner",
+		"-i", filePath,
+		"-vf", "blackdetect=d=0.5:pix_th=0.10,freezedetect=n=-60dB:d=2",
+		"-an",
+		"-f", "null",
+		"-",
+	}
+	var stderr bytes.Buffer
+	if err := runner.FFmpeg(ctx, args, media.Options{Stderr: &stderr}); err != nil {
+		return blankReport{}, fmt.Errorf("ffmpeg blank detection failed: %w", err)
+	}
+
+	return parseBlankDetection(stderr.String()), nil
+}
+
+func parseBlankDetection(output string) blankReport {
+	var report blankReport
+
+	if m := durationRe.FindStringSubmatch(output); m != nil {
+		hours, _ := strconv.ParseFloat(m[1], 64)
+		minutes, _ := strconv.ParseFloat(m[2], 64)
+		seconds, _ := strconv.ParseFloat(m[3], 64)
+		report.Duration = hours*3600 + minutes*60 + seconds
+	}
+
+	for _, m := range blackDurationRe.FindAllStringSubmatch(output, -1) {
+		d, _ := strconv.ParseFloat(m[1], 64)
+		report.BlackDuration += d
+	}
+
+	for _, m := range freezeDurationRe.FindAllStringSubmatch(output, -1) {
+		d, _ := strconv.ParseFloat(m[1], 64)
+		report.FrozenDuration += d
+	}
+	// a freeze that lasts until the end of the file never gets a duration
+	starts := freezeStartRe.FindAllStringSubmatch(output, -1)
+	ends := freezeDurationRe.FindAllStringSubmatch(output, -1)
+	if len(starts) > len(ends) {
+		start, _ := strconv.ParseFloat(starts[len(starts)-1][1], 64)
+		if report.Duration > start {
+			report.FrozenDuration += report.Duration - start
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+var errBlockedContent = errors.New("upload matches blocked content")
+
+// blocklistMatch is the error an upload fails with when it matches a
+// blocklist entry
+type blocklistMatch struct {
+	entry database.BlocklistEntry
+	// distance is how many bits a perceptual hash was off by
+	distance int
+}
+
+func (m *blocklistMatch) Error() string {
+	return fmt.Sprintf("%s: blocklist entry %s (%s)", errBlockedContent, m.entry.ID, m.entry.HashType)
+}
+
+func (m *blocklistMatch) Unwrap() error { return errBlockedContent }
+
+func quarantinePrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("quarantine/%s/", videoID)
+}
+
+// validBlocklistHash checks a hash is lowercase hex of the right length: 32
+// bytes for SHA-256, 8 for a perceptual hash
+func validBlocklistHash(hashType database.BlocklistHashType, hash string) bool {
+	var size int
+	switch hashType {
+	case database.BlocklistSHA256:
+		size = sha256.Size
+	case database.BlocklistPerceptual:
+		size = 8
+	default:
+		return false
+	}
+	b, err := hex.DecodeString(hash)
+	return err == nil && len(b) == size && hex.EncodeToString(b) == hash
+}
+
+// screenUpload checks the file at path, whose SHA-256 is sum, against the
+// blocklist, returning a *blocklistMatch if it's listed
+func (cfg *apiConfig) screenUpload(ctx context.Context, path, sum string, duration time.Duration) error {
+	entry, err := cfg.db.FindBlocklistEntry(database.BlocklistSHA256, sum)
+	if err != nil {
+		return fmt.Errorf("couldn't check blocklist: %w", err)
+	}
+	if entry.ID != uuid.Nil {
+		return &blocklistMatch{entry: entry}
+	}
+
+	entries, err := cfg.db.GetBlocklistEntries(database.BlocklistPerceptual)
+	if err != nil {
+		return fmt.Errorf("couldn't check blocklist: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	// a frame that can't be hashed only loses the fuzzy match, the exact
+	// one has already been done
+	hash, err := perceptualHash(ctx, cfg.media, path, duration)
+	if err != nil {
+		log.Printf("Couldn't compute perceptual hash of %s: %v", path, err)
+		return nil
+	}
+	for _, entry := range entries {
+		listed, err := strconv.ParseUint(entry.Hash, 16, 64)
+		if err != nil {
+			continue
+		}
+		if distance := bits.OnesCount64(hash ^ listed); distance <= cfg.blocklistDistance {
+			return &blocklistMatch{entry: entry, distance: distance}
+		}
+	}
+	return nil
+}
+
+// quarantineUpload keeps a copy of a blocked upload out of public reach so
+// it can be reviewed or handed over, and returns its key
+func (cfg *apiConfig) quarantineUpload(ctx context.Context, job *uploadJob, path string) (string, error) {
+	target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+	if err != nil {
+		return "", err
+	}
+	key, err := cfg.quarantineKey(job.UserID, job.VideoID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := putFile(ctx, target, key, path, "application/octet-stream", func(int64) {}); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// quarantineKey returns a new key under the video's quarantine prefix
+func (cfg *apiConfig) quarantineKey(userID, videoID uuid.UUID) (string, error) {
+	randomHex := make([]byte, 16)
+	if _, err := rand.Read(randomHex); err != nil {
+		return "", fmt.Errorf("couldn't generate random hex: %w", err)
+	}
+	return fmt.Sprintf("%s%x", cfg.keyLayout.Key(userID, quarantinePrefix(videoID)), randomHex), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// perceptualHash is a difference hash of the frame in the middle of the
+// video: each bit says whether a pixel of a 9x8 grayscale thumbnail is
+// brighter than its right-hand neighbour. Re-encoding, resizing or small
+// edits only flip a few bits.
+func perceptualHash(ctx context.Context, runner *media.Runner, path string, duration time.Duration) (uint64, error) {
+	args := []string{
+		"-v", "error",
+		"-ss", fmt.Sprintf("%.3f", (duration / 2).Seconds()),
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", "scale=9:8,format=gray",
+		"-f", "image2", "-c:v", "png", "pipe:1",
+	}
+	var out bytes.Buffer
+	if err := runner.FFmpeg(ctx, args, media.Options{Stdout: &out}); err != nil {
+		return 0, fmt.Errorf("couldn't extract frame: %w", err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't decode frame: %w", err)
+	}
+	return differenceHash(img), nil
+}
+
+func differenceHash(img image.Image) uint64 {
+	b := img.Bounds()
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luma(img, b.Min.X+x, b.Min.Y+y) > luma(img, b.Min.X+x+1, b.Min.Y+y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luma(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const maxCaptionBytes = 2 << 20
+
+func captionPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("captions/%s/", videoID)
+}
+
+var (
+	languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+	// SRT allows font tags that WebVTT doesn't; i, b and u carry over as is
+	srtFontTag = regexp.MustCompile(`(?i)</?font[^>]*>`)
+)
+
+// normalizeLanguage checks a BCP 47 tag like "en" or "pt-BR" and returns it
+// in its usual case, so "PT-br" and "pt-BR" are the same track
+func normalizeLanguage(tag string) (string, bool) {
+	if !languageTagPattern.MatchString(tag) {
+		return "", false
+	}
+	subtags := strings.Split(tag, "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i := 1; i < len(subtags); i++ {
+		switch len(subtags[i]) {
+		case 2:
+			subtags[i] = strings.ToUpper(subtags[i])
+		case 4:
+			subtags[i] = strings.ToUpper(subtags[i][:1]) + strings.ToLower(subtags[i][1:])
+		default:
+			subtags[i] = strings.ToLower(subtags[i])
+		}
+	}
+	return strings.Join(subtags, "-"), true
+}
+
+// captionsToWebVTT validates an SRT or WebVTT file and returns it as WebVTT
+func captionsToWebVTT(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		return "", errors.New("captions must be UTF-8 text")
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	blocks := splitCaptionBlocks(text)
+	if len(blocks) == 0 {
+		return "", errors.New("captions file is empty")
+	}
+	if header := blocks[0][0]; header == "WEBVTT" || strings.HasPrefix(header, "WEBVTT ") || strings.HasPrefix(header, "WEBVTT\t") {
+		if err := validateWebVTT(blocks[1:]); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(text) + "\n", nil
+	}
+	return srtToWebVTT(blocks)
+}
+
+// splitCaptionBlocks splits on blank lines, dropping the blank lines
+func splitCaptionBlocks(text string) [][]string {
+	var blocks [][]string
+	var block []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = nil
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func validateWebVTT(blocks [][]string) error {
+	cues := 0
+	for _, block := range blocks {
+		switch {
+		case strings.HasPrefix(block[0], "NOTE"), block[0] == "STYLE", block[0] == "REGION":
+			continue
+		}
+		// a cue may start with an identifier line before its timings
+		timing := block[0]
+		if !strings.Contains(timing, "-->") && len(block) > 1 {
+			timing = block[1]
+		}
+		if _, _, err := parseCueTiming(timing, '.'); err != nil {
+			return fmt.Errorf("cue %d: %w", cues+1, err)
+		}
+		cues++
+	}
+	if cues == 0 {
+		return errors.New("captions file has no cues")
+	}
+	return nil
+}
+
+func srtToWebVTT(blocks [][]string) (string, error) {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, block := range blocks {
+		// the cue number is optional in practice
+		if _, err := strconv.Atoi(strings.TrimSpace(block[0])); err == nil && len(block) > 1 {
+			block = block[1:]
+		}
+		start, end, err := parseCueTiming(block[0], ',')
+		if err != nil {
+			return "", fmt.Errorf("cue %d: %w", i+1, err)
+		}
+		fmt.Fprintf(&b, "\n%s --> %s\n", vttTimestamp(start), vttTimestamp(end))
+		for _, line := range block[1:] {
+			line = srtFontTag.ReplaceAllString(line, "")
+			// "-->" would end the cue text early in WebVTT
+			b.WriteString(strings.ReplaceAll(line, "-->", "->") + "\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// parseCueTiming reads a "start --> end" line, ignoring any cue settings
+// after it. SRT separates milliseconds with a comma, WebVTT with a dot.
+func parseCueTiming(line string, sep byte) (time.Duration, time.Duration, error) {
+	startText, rest, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q isn't a cue timing line", line)
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("%q has no end time", line)
+	}
+	start, err := parseCueTimestamp(strings.TrimSpace(startText), sep)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseCueTimestamp(fields[0], sep)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("cue ends at %s, before it starts at %s", fields[0], strings.TrimSpace(startText))
+	}
+	return start, end, nil
+}
+
+// parseCueTimestamp reads [hh:]mm:ss<sep>mmm
+func parseCueTimestamp(s string, sep byte) (time.Duration, error) {
+	clock, millis, ok := strings.Cut(s, string(sep))
+	if !ok || len(millis) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	parts := strings.Split(clock, ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0"}, parts...)
+	}
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var values [4]int
+	for i, part := range append(parts, millis) {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (i > 0 && i < 3 && (n > 59 || len(part) != 2)) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		values[i] = n
+	}
+	return time.Duration(values[0])*time.Hour +
+		time.Duration(values[1])*time.Minute +
+		time.Duration(values[2])*time.Second +
+		time.Duration(values[3])*time.Millisecond, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runApplyLifecycle sets the lifecycle rules the LIFECYCLE_ settings ask
+// for on the default bucket and the storage regions' buckets, replacing the
+// ones set before. Organizations' own buckets are theirs to manage.
+func runApplyLifecycle(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("apply-lifecycle", flag.ExitOnError)
+	flags.Parse(args)
+
+	rules := a.lifecycle.Rules()
+	for _, bucket := range a.buckets() {
+		manager, ok := bucket.store.(storage.LifecycleManager)
+		if !ok {
+			fmt.Println("the local store has no lifecycle rules, nothing to do")
+			continue
+		}
+		if err := manager.SetLifecycleRules(ctx, storage.LifecycleRulePrefix, rules); err != nil {
+			return fmt.Errorf("couldn't set lifecycle rules of bucket %s: %w", bucket.name, err)
+		}
+		fmt.Printf("set %d lifecycle rules on bucket %s\n", len(rules), bucket.name)
+	}
+	return nil
+}
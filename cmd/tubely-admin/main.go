@@ -0,0 +1,267 @@
+// Command tubely-admin runs maintenance on a Tubely deployment's database
+// and default object store: finding and purging objects nothing refers to,
+// moving to another bucket, rewriting stored URLs, recomputing storage
+// usage, re-keying objects and setting bucket lifecycle rules. It reads the
+// same settings as the server, from the environment, .env and CONFIG_FILE.
+//
+// Videos stored in an organization's own bucket are left alone. Those in a
+// storage region are only re-keyed, and the regions' buckets given
+// lifecycle rules; everything else maintains just the default store.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/joho/godotenv"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, a *admin, args []string) error
+}
+
+var commands = []command{
+	{"reconcile", "list objects missing from the store and objects nothing refers to", runReconcile},
+	{"purge-orphans", "delete the objects nothing refers to", runPurgeOrphans},
+	{"migrate-bucket", "copy every object to another S3 bucket", runMigrateBucket},
+	{"rewrite-urls", "replace the base URL of stored video URLs", runRewriteURLs},
+	{"recompute-usage", "read the stored size of every video again", runRecomputeUsage},
+	{"rekey-objects", "move objects stored under an earlier OBJECT_KEY_LAYOUT", runRekeyObjects},
+	{"apply-lifecycle", "set the configured lifecycle rules on the buckets", runApplyLifecycle},
+}
+
+// admin is what every command works on
+type admin struct {
+	db    database.Client
+	store storage.ObjectStore
+	// baseURL is what stored URLs of objects in store start with, before
+	// the "/" and the key
+	baseURL string
+	// s3Region and s3Endpoint are the default bucket's, for migrate-bucket
+	s3Region   string
+	s3Endpoint string
+	// bucket is the default bucket's name, empty for the local store
+	bucket string
+	// regions are the STORAGE_REGIONS buckets by name
+	regions   map[string]bucketTarget
+	keyLayout storage.KeyLayout
+	lifecycle storage.LifecyclePolicy
+}
+
+// bucketTarget is a bucket with the base URL of the objects in it
+type bucketTarget struct {
+	name    string
+	store   storage.ObjectStore
+	baseURL string
+}
+
+func (t bucketTarget) objectURL(key string) string {
+	return t.baseURL + "/" + key
+}
+
+func (t bucketTarget) keyFromURL(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, t.baseURL+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+func (a *admin) defaultTarget() bucketTarget {
+	return bucketTarget{name: a.bucket, store: a.store, baseURL: a.baseURL}
+}
+
+// buckets are the default bucket and the regions' buckets
+func (a *admin) buckets() []bucketTarget {
+	names := make([]string, 0, len(a.regions))
+	for name := range a.regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buckets := []bucketTarget{a.defaultTarget()}
+	for _, name := range names {
+		buckets = append(buckets, a.regions[name])
+	}
+	return buckets
+}
+
+// targetFor returns the bucket the video's files are in, reporting false
+// for videos in an organization's own bucket
+func (a *admin) targetFor(video database.Video) (bucketTarget, bool, error) {
+	if video.OrganizationID.Valid {
+		st, err := a.db.GetOrganizationStorage(video.OrganizationID.UUID)
+		if err != nil {
+			return bucketTarget{}, false, err
+		}
+		if st.Bucket != "" {
+			return bucketTarget{}, false, nil
+		}
+	}
+	if video.StorageRegion == "" {
+		return a.defaultTarget(), true, nil
+	}
+	region, ok := a.regions[video.StorageRegion]
+	if !ok {
+		return bucketTarget{}, false, fmt.Errorf("storage region %q isn't configured", video.StorageRegion)
+	}
+	return region, true, nil
+}
+
+func (a *admin) keyFromURL(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, a.baseURL+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// inDefaultStore reports whether the video's files are in the store the
+// commands work on
+func inDefaultStore(video database.Video) bool {
+	return !video.OrganizationID.Valid && video.StorageRegion == ""
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		a, err := loadAdmin()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cmd.run(context.Background(), a, os.Args[2:]); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		return
+	}
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tubely-admin <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run tubely-admin <command> -h for a command's flags")
+}
+
+func loadAdmin() (*admin, error) {
+	godotenv.Load(".env")
+	env, err := config.NewLoader(os.LookupEnv, os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	dbPath := env.Required("DB_PATH")
+	backend := env.OneOf("STORAGE_BACKEND", "s3", "s3", "local")
+	a := &admin{regions: map[string]bucketTarget{}}
+	var distribution, regions string
+	switch backend {
+	case "s3":
+		a.bucket = env.Required("S3_BUCKET")
+		a.s3Region = env.Required("S3_REGION")
+		distribution = env.Required("S3_CF_DISTRO")
+		a.s3Endpoint = env.Getenv("S3_ENDPOINT")
+		regions = env.Getenv("STORAGE_REGIONS")
+	case "local":
+		a.baseURL = "http://localhost:" + env.Required("PORT") + "/assets"
+		a.store = storage.NewLocalStore(env.Required("ASSETS_ROOT"), a.baseURL)
+	}
+	a.keyLayout, err = storage.ParseKeyLayout(env.Getenv("OBJECT_KEY_LAYOUT"))
+	if err != nil {
+		env.Invalid("OBJECT_KEY_LAYOUT", err)
+	}
+	a.lifecycle = storage.LifecyclePolicy{
+		ColdOriginalsAfter: env.Duration("LIFECYCLE_COLD_ORIGINALS_AFTER", 0, 0),
+		ColdStorageClass:   env.String("LIFECYCLE_COLD_STORAGE_CLASS", "GLACIER"),
+		ExpireTrash:        env.Bool("LIFECYCLE_EXPIRE_TRASH", false),
+		TrashRetention:     env.Duration("TRASH_RETENTION", 30*24*time.Hour, 0),
+	}
+	if err := env.Err(); err != nil {
+		return nil, err
+	}
+
+	if backend == "s3" {
+		store, err := newS3Store(context.Background(), a.bucket, a.s3Region, a.s3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		a.store = store
+		a.baseURL = "https://" + distribution
+		if err := a.loadRegions(regions); err != nil {
+			return nil, fmt.Errorf("invalid STORAGE_REGIONS: %w", err)
+		}
+	}
+
+	a.db, err = database.NewClient(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to database: %w", err)
+	}
+	return a, nil
+}
+
+// loadRegions reads STORAGE_REGIONS, name=aws-region/bucket entries split
+// by commas, which the server has already checked more closely
+func (a *admin) loadRegions(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(entry, "=")
+		awsRegion, bucket, ok2 := strings.Cut(location, "/")
+		if !ok || !ok2 || name == "" || awsRegion == "" || bucket == "" {
+			return fmt.Errorf("%q isn't name=aws-region/bucket", entry)
+		}
+		store, err := newS3Store(context.Background(), bucket, awsRegion, a.s3Endpoint)
+		if err != nil {
+			return err
+		}
+		baseURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, awsRegion)
+		if a.s3Endpoint != "" {
+			baseURL = strings.TrimSuffix(a.s3Endpoint, "/") + "/" + bucket
+		}
+		a.regions[name] = bucketTarget{name: bucket, store: store, baseURL: baseURL}
+	}
+	return nil
+}
+
+func newS3Store(ctx context.Context, bucket, region, endpoint string) (*storage.S3Store, error) {
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return storage.NewS3Store(client, bucket), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runMigrateBucket copies every object of the default store to another S3
+// bucket, keeping keys, content types and storage classes. Objects already
+// there with the same size are skipped, so an interrupted run can be
+// started again. It doesn't touch the database: once it's done, point
+// S3_BUCKET at the new bucket and, if its base URL differs, run
+// rewrite-urls.
+func runMigrateBucket(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("migrate-bucket", flag.ExitOnError)
+	bucket := flags.String("bucket", "", "the bucket to copy to (required)")
+	region := flags.String("region", a.s3Region, "the destination bucket's region")
+	endpoint := flags.String("endpoint", a.s3Endpoint, "the destination's S3 endpoint, for S3-compatible stores")
+	prefix := flags.String("prefix", "", "only copy keys under this prefix")
+	dryRun := flags.Bool("dry-run", false, "list what would be copied without copying it")
+	flags.Parse(args)
+
+	if *bucket == "" {
+		return errors.New("-bucket is required")
+	}
+	if *region == "" {
+		return errors.New("-region is required")
+	}
+	dst, err := newS3Store(ctx, *bucket, *region, *endpoint)
+	if err != nil {
+		return err
+	}
+
+	keys, err := a.store.List(ctx, *prefix)
+	if err != nil {
+		return fmt.Errorf("couldn't list objects: %w", err)
+	}
+
+	var copied, skipped, failed int
+	for _, key := range keys {
+		info, err := a.store.Head(ctx, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't check %s: %v\n", key, err)
+			failed++
+			continue
+		}
+		existing, err := dst.Head(ctx, key)
+		if err == nil && existing.Size == info.Size {
+			skipped++
+			continue
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "couldn't check %s in %s: %v\n", key, *bucket, err)
+			failed++
+			continue
+		}
+		if info.Archived {
+			fmt.Fprintf(os.Stderr, "%s is archived, restore it first\n", key)
+			failed++
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would copy %s\n", key)
+			copied++
+			continue
+		}
+		if err := copyObject(ctx, a.store, dst, key, info); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't copy %s: %v\n", key, err)
+			failed++
+			continue
+		}
+		copied++
+	}
+
+	verb := "copied"
+	if *dryRun {
+		verb = "would copy"
+	}
+	fmt.Printf("%s %d objects to %s, %d already there\n", verb, copied, *bucket, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d objects couldn't be copied", failed)
+	}
+	return nil
+}
+
+func copyObject(ctx context.Context, src storage.ObjectStore, dst *storage.S3Store, key string, info storage.ObjectInfo) error {
+	body, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	ctx = storage.WithPutOptions(ctx, storage.PutOptions{StorageClass: info.StorageClass})
+	return dst.PutStream(ctx, key, body, info.ContentType)
+}
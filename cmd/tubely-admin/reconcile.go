@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type missingObject struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Key     string    `json:"key"`
+}
+
+type reconcileReport struct {
+	Objects int             `json:"objects"`
+	Videos  int             `json:"videos"`
+	Missing []missingObject `json:"missing"`
+	Orphans []string        `json:"orphans"`
+	// Unknown are URLs of videos in the default store that don't start with
+	// its base URL. Their objects can't be told apart from orphans.
+	Unknown []string `json:"unknown"`
+}
+
+// videoKeys returns the keys of the video's files in the default store, and
+// the URLs that aren't in it
+func (a *admin) videoKeys(video database.Video) (keys, unknown []string) {
+	var all []string
+	urls := []*string{
+		video.VideoURL,
+		video.ThumbnailURL,
+		video.ThumbnailSmallURL,
+		video.PreviewURL,
+		video.SpriteURL,
+		video.SpriteVTTURL,
+		video.AudioURL,
+	}
+	for _, url := range urls {
+		if url != nil {
+			all = append(all, *url)
+		}
+	}
+	for _, rendition := range video.Renditions {
+		all = append(all, rendition.URL)
+	}
+	for _, caption := range video.Captions {
+		all = append(all, caption.URL)
+	}
+	for _, url := range all {
+		if key, ok := a.keyFromURL(url); ok {
+			keys = append(keys, key)
+		} else {
+			unknown = append(unknown, url)
+		}
+	}
+	return keys, unknown
+}
+
+// reconcile compares the store with the database. An object is referenced
+// if a video's URL points at it or it's under a prefix named after a video,
+// like HLS segments; objects already queued for deletion aren't orphans.
+func (a *admin) reconcile(ctx context.Context, prefix string) (reconcileReport, error) {
+	report := reconcileReport{Missing: []missingObject{}, Orphans: []string{}, Unknown: []string{}}
+
+	keys, err := a.store.List(ctx, prefix)
+	if err != nil {
+		return report, fmt.Errorf("couldn't list objects: %w", err)
+	}
+	report.Objects = len(keys)
+
+	referenced := map[string]bool{}
+	videoIDs := map[string]bool{}
+	err = a.db.EachVideoOfAllUsers(func(video database.Video) error {
+		videoIDs[video.ID.String()] = true
+		if !inDefaultStore(video) {
+			return nil
+		}
+		report.Videos++
+		keys, unknown := a.videoKeys(video)
+		report.Unknown = append(report.Unknown, unknown...)
+		for _, key := range keys {
+			referenced[key] = true
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			_, err := a.store.Head(ctx, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				report.Missing = append(report.Missing, missingObject{VideoID: video.ID, Key: key})
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't check %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	deletions, err := a.db.GetPendingDeletions(math.MaxInt32)
+	if err != nil {
+		return report, fmt.Errorf("couldn't get pending deletions: %w", err)
+	}
+	var deletingPrefixes []string
+	for _, deletion := range deletions {
+		if deletion.OrganizationID.Valid || deletion.Region != "" {
+			continue
+		}
+		switch deletion.Kind {
+		case database.DeletionObject:
+			referenced[deletion.Key] = true
+		case database.DeletionPrefix:
+			deletingPrefixes = append(deletingPrefixes, deletion.Key)
+		}
+	}
+
+	for _, key := range keys {
+		if referenced[key] || underVideo(key, videoIDs) || hasAnyPrefix(key, deletingPrefixes) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, key)
+	}
+	sort.Strings(report.Orphans)
+	return report, nil
+}
+
+func underVideo(key string, videoIDs map[string]bool) bool {
+	segments := strings.Split(key, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		if videoIDs[segment] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func runReconcile(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "only look at keys under this prefix")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	report, err := a.reconcile(ctx, *prefix)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Printf("%d objects, %d videos\n", report.Objects, report.Videos)
+	fmt.Printf("%d missing objects:\n", len(report.Missing))
+	for _, missing := range report.Missing {
+		fmt.Printf("  %s  (video %s)\n", missing.Key, missing.VideoID)
+	}
+	fmt.Printf("%d orphaned objects:\n", len(report.Orphans))
+	for _, key := range report.Orphans {
+		fmt.Printf("  %s\n", key)
+	}
+	if len(report.Unknown) > 0 {
+		fmt.Printf("%d URLs not under %s:\n", len(report.Unknown), a.baseURL)
+		for _, url := range report.Unknown {
+			fmt.Printf("  %s\n", url)
+		}
+	}
+	return nil
+}
+
+// runPurgeOrphans deletes what reconcile reports as orphaned. Objects newer
+// than -min-age are kept, as they may belong to an upload that hasn't
+// reached the database yet.
+func runPurgeOrphans(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("purge-orphans", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "only look at keys under this prefix")
+	minAge := flags.Duration("min-age", 24*time.Hour, "keep orphans modified more recently than this")
+	dryRun := flags.Bool("dry-run", false, "list what would be deleted without deleting it")
+	flags.Parse(args)
+
+	report, err := a.reconcile(ctx, *prefix)
+	if err != nil {
+		return err
+	}
+	if len(report.Unknown) > 0 {
+		return fmt.Errorf("%d video URLs aren't under %s, so their objects would look orphaned; run reconcile to list them", len(report.Unknown), a.baseURL)
+	}
+
+	cutoff := time.Now().Add(-*minAge)
+	var deleted, kept, failed int
+	for _, key := range report.Orphans {
+		info, err := a.store.Head(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't check %s: %v\n", key, err)
+			failed++
+			continue
+		}
+		if info.LastModified.After(cutoff) {
+			kept++
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would delete %s\n", key)
+			deleted++
+			continue
+		}
+		if err := a.store.Delete(ctx, key); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't delete %s: %v\n", key, err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted %s\n", key)
+		deleted++
+	}
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	fmt.Printf("%s %d orphans, kept %d newer than %s\n", verb, deleted, kept, *minAge)
+	if failed > 0 {
+		return fmt.Errorf("%d orphans couldn't be deleted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runRekeyObjects moves the files of every stored video from the keys an
+// earlier OBJECT_KEY_LAYOUT gave them to the ones the current layout does:
+// each object is copied, the video pointed at the copy, and the original
+// deleted. Quarantined uploads stay where they are. It should run while
+// the server is stopped, and can be run again to pick up videos that
+// failed.
+func runRekeyObjects(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("rekey-objects", flag.ExitOnError)
+	from := flags.String("from", "", "the OBJECT_KEY_LAYOUT the objects were stored under")
+	dryRun := flags.Bool("dry-run", false, "list the moves without making them")
+	flags.Parse(args)
+
+	fromLayout, err := storage.ParseKeyLayout(*from)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if fromLayout == a.keyLayout {
+		return errors.New("-from is the layout in use, there's nothing to move")
+	}
+
+	var videos, objects, skipped, failed int
+	err = a.db.EachStoredVideo(func(video database.Video) error {
+		target, ok, err := a.targetFor(video)
+		if err == nil && !ok {
+			skipped++
+			return nil
+		}
+		var n int
+		if err == nil {
+			n, err = a.rekeyVideo(ctx, target, video, fromLayout, *dryRun)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't re-key video %s: %v\n", video.ID, err)
+			failed++
+			return nil
+		}
+		if n > 0 {
+			videos++
+			objects += n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "moved"
+	if *dryRun {
+		verb = "would move"
+	}
+	fmt.Printf("%s %d objects of %d videos, skipped %d videos in organizations' own buckets\n", verb, objects, videos, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d videos couldn't be re-keyed", failed)
+	}
+	return nil
+}
+
+// rekeyVideo moves the files of one video and returns how many it moved.
+// If anything fails, the copies made so far are deleted and the video keeps
+// its old keys.
+func (a *admin) rekeyVideo(ctx context.Context, target bucketTarget, video database.Video, from storage.KeyLayout, dryRun bool) (moved int, err error) {
+	oldPrefix := from.Key(video.UserID, "")
+	newPrefix := a.keyLayout.Key(video.UserID, "")
+
+	var copies, originals []database.CreatePendingDeletionParams
+	defer func() {
+		if err != nil && len(copies) > 0 {
+			deletions, releaseErr := a.db.ReleaseObjectRefs(copies)
+			if releaseErr != nil {
+				fmt.Fprintf(os.Stderr, "couldn't release copies of video %s: %v\n", video.ID, releaseErr)
+				return
+			}
+			a.deleteObjects(ctx, target, deletions)
+		}
+	}()
+	object := func(key string) database.CreatePendingDeletionParams {
+		return database.CreatePendingDeletionParams{
+			Kind:           database.DeletionObject,
+			Key:            key,
+			OrganizationID: video.OrganizationID,
+			Region:         video.StorageRegion,
+		}
+	}
+
+	// move copies the object at url to its new key and returns the new
+	// URL, or url itself if the object isn't under the old layout.
+	// rewrite, if set, changes the contents on the way.
+	move := func(url string, rewrite func(string) string) (string, error) {
+		key, ok := target.keyFromURL(url)
+		if !ok || !strings.HasPrefix(key, oldPrefix) || (newPrefix != "" && strings.HasPrefix(key, newPrefix)) {
+			return url, nil
+		}
+		newKey := newPrefix + strings.TrimPrefix(key, oldPrefix)
+		if newKey == key {
+			return url, nil
+		}
+		moved++
+		if dryRun {
+			fmt.Printf("%s: %s -> %s\n", video.ID, key, newKey)
+			return target.objectURL(newKey), nil
+		}
+
+		// content-addressed objects can be shared by several videos, and
+		// the first of them to move already copied it
+		refs, err := a.db.ObjectRefCount(video.OrganizationID, video.StorageRegion, key)
+		if err != nil {
+			return "", err
+		}
+		if refs > 0 {
+			shared, err := a.db.AcquireObjectRef(video.OrganizationID, video.StorageRegion, newKey)
+			if err != nil {
+				return "", err
+			}
+			copies = append(copies, object(newKey))
+			if shared {
+				if _, err := target.store.Head(ctx, newKey); err == nil {
+					originals = append(originals, object(key))
+					return target.objectURL(newKey), nil
+				} else if !errors.Is(err, storage.ErrNotFound) {
+					return "", err
+				}
+			}
+		}
+
+		if err := copyToKey(ctx, target.store, key, newKey, rewrite); err != nil {
+			return "", fmt.Errorf("couldn't copy %s: %w", key, err)
+		}
+		if refs == 0 {
+			copies = append(copies, object(newKey))
+		}
+		originals = append(originals, object(key))
+		return target.objectURL(newKey), nil
+	}
+	moveOptional := func(url *string, rewrite func(string) string) (*string, error) {
+		if url == nil {
+			return nil, nil
+		}
+		newURL, err := move(*url, rewrite)
+		return &newURL, err
+	}
+
+	if video.VideoURL, err = moveOptional(video.VideoURL, nil); err != nil {
+		return 0, err
+	}
+	renditions := make(database.Renditions, len(video.Renditions))
+	for i, rendition := range video.Renditions {
+		if rendition.URL, err = move(rendition.URL, nil); err != nil {
+			return 0, err
+		}
+		renditions[i] = rendition
+	}
+	video.Renditions = renditions
+	if video.PreviewURL, err = moveOptional(video.PreviewURL, nil); err != nil {
+		return 0, err
+	}
+	// the sprite index has the sprite's URL in it
+	oldSpriteURL := video.SpriteURL
+	if video.SpriteURL, err = moveOptional(video.SpriteURL, nil); err != nil {
+		return 0, err
+	}
+	var rewriteSprite func(string) string
+	if oldSpriteURL != nil && *oldSpriteURL != *video.SpriteURL {
+		rewriteSprite = func(vtt string) string {
+			return strings.ReplaceAll(vtt, *oldSpriteURL, *video.SpriteURL)
+		}
+	}
+	if video.SpriteVTTURL, err = moveOptional(video.SpriteVTTURL, rewriteSprite); err != nil {
+		return 0, err
+	}
+	captions := make(database.Captions, len(video.Captions))
+	for i, caption := range video.Captions {
+		if caption.URL, err = move(caption.URL, nil); err != nil {
+			return 0, err
+		}
+		captions[i] = caption
+	}
+	video.Captions = captions
+	if video.AudioURL, err = moveOptional(video.AudioURL, nil); err != nil {
+		return 0, err
+	}
+
+	if dryRun || moved == 0 {
+		return moved, nil
+	}
+	deletions, err := a.db.MoveVideoObjects(video, originals)
+	if err != nil {
+		return 0, err
+	}
+	// anything that fails here stays queued and is retried by the server
+	if failed := a.deleteObjects(ctx, target, deletions); failed > 0 {
+		fmt.Fprintf(os.Stderr, "video %s re-keyed, %d old objects queued for deletion\n", video.ID, failed)
+	}
+	return moved, nil
+}
+
+// deleteObjects deletes queued objects in target that nothing refers to
+// anymore, dropping them from the queue, and returns how many are still
+// queued
+func (a *admin) deleteObjects(ctx context.Context, target bucketTarget, deletions []database.PendingDeletion) int {
+	failed := 0
+	for _, d := range deletions {
+		// another video may have picked a shared object up again
+		referenced, err := a.db.ObjectIsReferenced(d.OrganizationID, d.Region, d.Key)
+		if err == nil && !referenced {
+			err = target.store.Delete(ctx, d.Key)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "couldn't delete %s: %v\n", d.Key, err)
+			if err := a.db.MarkPendingDeletionFailed(d.ID, err.Error()); err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't record failed deletion %d: %v\n", d.ID, err)
+			}
+			continue
+		}
+		if err := a.db.DeletePendingDeletion(d.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't remove pending deletion %d: %v\n", d.ID, err)
+		}
+	}
+	return failed
+}
+
+// copyToKey copies the object at key to newKey in the same store with the
+// same content type, through here since stores have no copy in common
+func copyToKey(ctx context.Context, store storage.ObjectStore, key, newKey string, rewrite func(string) string) error {
+	info, err := store.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if rewrite == nil {
+		if streamer, ok := store.(storage.StreamPutter); ok {
+			return streamer.PutStream(ctx, newKey, body, info.ContentType)
+		}
+		return store.Put(ctx, newKey, body, info.ContentType)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, newKey, strings.NewReader(rewrite(string(data))), info.ContentType)
+}
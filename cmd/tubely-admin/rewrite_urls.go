@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runRewriteURLs moves stored URLs from one base URL to another, for a new
+// CDN domain or bucket. Sprite VTT files name their sprite by its full URL,
+// so those are rewritten in the store too; they're read from the current
+// default store, which is expected to be where the URLs now point.
+func runRewriteURLs(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("rewrite-urls", flag.ExitOnError)
+	from := flags.String("from", "", "the base URL to replace, like https://old.cloudfront.net (required)")
+	to := flags.String("to", a.baseURL, "the base URL to replace it with")
+	dryRun := flags.Bool("dry-run", false, "list the videos that would change without changing them")
+	flags.Parse(args)
+
+	fromPrefix := strings.TrimSuffix(*from, "/") + "/"
+	toPrefix := strings.TrimSuffix(*to, "/") + "/"
+	if fromPrefix == "/" || toPrefix == "/" {
+		return errors.New("-from and -to are required")
+	}
+	if fromPrefix == toPrefix {
+		return errors.New("-from and -to are the same")
+	}
+
+	var videos, vtts, failed int
+	err := a.db.EachVideoOfAllUsers(func(video database.Video) error {
+		if !videoHasURLPrefix(video, fromPrefix) {
+			return nil
+		}
+		videos++
+		if *dryRun {
+			fmt.Printf("would rewrite video %s\n", video.ID)
+			return nil
+		}
+		if video.SpriteVTTURL == nil {
+			return nil
+		}
+		key, ok := strings.CutPrefix(*video.SpriteVTTURL, fromPrefix)
+		if !ok {
+			return nil
+		}
+		if err := a.rewriteVTT(ctx, key, fromPrefix, toPrefix); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't rewrite %s of video %s: %v\n", key, video.ID, err)
+			failed++
+			return nil
+		}
+		vtts++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("would rewrite %d videos\n", videos)
+		return nil
+	}
+
+	changed, err := a.db.RewriteVideoURLs(fromPrefix, toPrefix)
+	if err != nil {
+		return fmt.Errorf("couldn't rewrite URLs: %w", err)
+	}
+	fmt.Printf("rewrote the URLs of %d videos and %d sprite VTT files\n", changed, vtts)
+	if failed > 0 {
+		return fmt.Errorf("%d sprite VTT files couldn't be rewritten", failed)
+	}
+	return nil
+}
+
+func (a *admin) rewriteVTT(ctx context.Context, key, from, to string) error {
+	body, err := a.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	vtt, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	rewritten := strings.ReplaceAll(string(vtt), from, to)
+	if rewritten == string(vtt) {
+		return nil
+	}
+	return a.store.Put(ctx, key, strings.NewReader(rewritten), "text/vtt")
+}
+
+func videoHasURLPrefix(video database.Video, prefix string) bool {
+	urls := []*string{
+		video.VideoURL,
+		video.ThumbnailURL,
+		video.ThumbnailSmallURL,
+		video.PreviewURL,
+		video.SpriteURL,
+		video.SpriteVTTURL,
+		video.AudioURL,
+	}
+	for _, url := range urls {
+		if url != nil && strings.HasPrefix(*url, prefix) {
+			return true
+		}
+	}
+	for _, rendition := range video.Renditions {
+		if strings.HasPrefix(rendition.URL, prefix) {
+			return true
+		}
+	}
+	for _, caption := range video.Captions {
+		if strings.HasPrefix(caption.URL, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runRecomputeUsage sets the stored sizes of videos and their renditions to
+// what the store reports, which quotas and usage reports are based on.
+// Files the store doesn't have are left as recorded; reconcile lists them.
+func runRecomputeUsage(ctx context.Context, a *admin, args []string) error {
+	flags := flag.NewFlagSet("recompute-usage", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list the videos that would change without changing them")
+	flags.Parse(args)
+
+	var checked, changed, failed int
+	err := a.db.EachStoredVideo(func(video database.Video) error {
+		if !inDefaultStore(video) {
+			return nil
+		}
+		checked++
+
+		var size int64
+		if video.SizeBytes != nil {
+			size = *video.SizeBytes
+		}
+		newSize := size
+		if key, ok := a.keyFromURL(*video.VideoURL); ok {
+			info, err := a.store.Head(ctx, key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't check %s of video %s: %v\n", key, video.ID, err)
+				failed++
+			} else {
+				newSize = info.Size
+			}
+		}
+		renditions := slices.Clone(video.Renditions)
+		renditionsChanged := false
+		for i, rendition := range renditions {
+			key, ok := a.keyFromURL(rendition.URL)
+			if !ok {
+				continue
+			}
+			info, err := a.store.Head(ctx, key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't check %s of video %s: %v\n", key, video.ID, err)
+				failed++
+				continue
+			}
+			if info.Size != rendition.SizeBytes {
+				renditions[i].SizeBytes = info.Size
+				renditionsChanged = true
+			}
+		}
+
+		if newSize == size && video.SizeBytes != nil && !renditionsChanged {
+			return nil
+		}
+		changed++
+		if *dryRun {
+			fmt.Printf("would update video %s: %d -> %d bytes\n", video.ID, size, newSize)
+			return nil
+		}
+		if err := a.db.UpdateVideoStoredSizes(video.ID, newSize, renditions); err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+		fmt.Printf("updated video %s: %d -> %d bytes\n", video.ID, size, newSize)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("checked %d videos, %s %d\n", checked, verb, changed)
+	if failed > 0 {
+		return fmt.Errorf("%d files couldn't be checked", failed)
+	}
+	return nil
+}
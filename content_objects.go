@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// contentKey names a video object after its SHA-256, so byte-identical
+// videos end up at the same key and are stored once
+func contentKey(prefix, sha256 string) string {
+	return prefix + sha256 + ".mp4"
+}
+
+// putContentObject takes a reference to the content-addressed object at key
+// and uploads the file at path there, unless the object is already stored.
+// It returns the file's size. The reference is released if the upload
+// fails.
+func (cfg *apiConfig) putContentObject(ctx context.Context, target storeTarget, organizationID uuid.NullUUID, region, key, path, sha256 string, progress func(read int64)) (int64, error) {
+	shared, err := cfg.db.AcquireObjectRef(organizationID, region, key)
+	if err != nil {
+		return 0, err
+	}
+	if shared {
+		// the other reference may belong to an upload that hasn't finished
+		// storing it yet, in which case this one stores it too
+		info, err := target.store.Head(ctx, key)
+		if err == nil {
+			// the object may be marked as trashed with the video that
+			// stored it
+			if tagger, ok := target.store.(storage.Tagger); ok && cfg.lifecycle.ExpireTrash {
+				if err := tagger.SetTags(ctx, key, map[string]string{storage.KindTag: string(objectOriginal)}); err != nil {
+					log.Printf("Couldn't clear trash tag of %s: %v", key, err)
+				}
+			}
+			progress(info.Size)
+			return info.Size, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			cfg.releaseContentObject(organizationID, region, key)
+			return 0, err
+		}
+	}
+
+	size, err := putVerifiedFile(ctx, target, key, path, "video/mp4", sha256, progress)
+	if err != nil {
+		cfg.releaseContentObject(organizationID, region, key)
+		return 0, err
+	}
+	return size, nil
+}
+
+// releaseContentObject gives up a reference taken by putContentObject,
+// deleting the object if nothing else refers to it
+func (cfg *apiConfig) releaseContentObject(organizationID uuid.NullUUID, region, key string) {
+	deletions, err := cfg.db.ReleaseObjectRefs([]database.CreatePendingDeletionParams{{
+		Kind:           database.DeletionObject,
+		Key:            key,
+		OrganizationID: organizationID,
+		Region:         region,
+	}})
+	if err != nil {
+		log.Printf("Couldn't release reference to %s: %v", key, err)
+		return
+	}
+	cfg.processPendingDeletions(context.Background(), deletions)
+}
+
+// setVideoFile points the video at its newly stored file and releases the
+// file it replaces, with the renditions, preview and sprite made from it
+func (cfg *apiConfig) setVideoFile(ctx context.Context, videoID uuid.UUID, videoURL string) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return err
+	}
+
+	urls := []*string{video.VideoURL, video.PreviewURL, video.SpriteURL, video.SpriteVTTURL}
+	for i := range video.Renditions {
+		urls = append(urls, &video.Renditions[i].URL)
+	}
+	replaced := []database.CreatePendingDeletionParams{}
+	for _, url := range urls {
+		if url == nil {
+			continue
+		}
+		if key, ok := target.keyFromURL(*url); ok {
+			replaced = append(replaced, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionObject,
+				Key:            key,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+	}
+
+	deletions, err := cfg.db.UpdateVideoURL(videoID, videoURL, replaced)
+	if err != nil {
+		return err
+	}
+	// anything that fails here stays queued and is retried in the background
+	if failed := cfg.processPendingDeletions(ctx, deletions); failed > 0 {
+		log.Printf("Video %s replaced, %d old objects queued for retry", videoID, failed)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// providers bill storage and transfer in binary gigabytes
+	bytesPerGB = 1 << 30
+	// the admin report prices delivery from the views of the last month
+	costViewWindow       = 30 * 24 * time.Hour
+	defaultCostTopVideos = 20
+	maxCostTopVideos     = 500
+)
+
+type storagePricing struct {
+	storagePerGBMonth float64
+	deliveryPerGB     float64
+	// monthlyViews is how many full views a month a new video's estimate
+	// assumes
+	monthlyViews int
+}
+
+// costEstimate is what a video costs a month: storing its files, and
+// sending its main file in full for every view. Viewers who stop early or
+// watch a rendition cost less, so delivery is an upper bound.
+type costEstimate struct {
+	StoredBytes    int64   `json:"stored_bytes"`
+	MonthlyViews   int64   `json:"monthly_views"`
+	DeliveredBytes int64   `json:"delivered_bytes"`
+	StorageUSD     float64 `json:"storage_usd"`
+	DeliveryUSD    float64 `json:"delivery_usd"`
+	TotalUSD       float64 `json:"total_usd"`
+	// Measured is false while some sizes are guesses because the files
+	// haven't been made yet
+	Measured bool `json:"measured"`
+}
+
+func (p storagePricing) estimate(storedBytes, viewBytes, views int64, measured bool) costEstimate {
+	delivered := viewBytes * views
+	storage := float64(storedBytes) / bytesPerGB * p.storagePerGBMonth
+	delivery := float64(delivered) / bytesPerGB * p.deliveryPerGB
+	return costEstimate{
+		StoredBytes:    storedBytes,
+		MonthlyViews:   views,
+		DeliveredBytes: delivered,
+		StorageUSD:     storage,
+		DeliveryUSD:    delivery,
+		TotalUSD:       storage + delivery,
+		Measured:       measured,
+	}
+}
+
+// plannedCost estimates a video's cost from its upload, before it's been
+// processed. Each rendition the ladder will make is guessed to be the
+// upload scaled down by its share of the pixels.
+func (cfg *apiConfig) plannedCost(size int64, probe *FFProbeOutput) costEstimate {
+	stored := size
+	if probe != nil {
+		if stream, ok := probe.videoStream(); ok {
+			width, height := stream.displaySize()
+			short := float64(min(width, height))
+			for _, spec := range cfg.renditionsFor(*probe) {
+				scale := float64(spec.height) / short
+				stored += int64(float64(size) * scale * scale)
+			}
+		}
+	}
+	return cfg.pricing.estimate(stored, size, int64(cfg.pricing.monthlyViews), false)
+}
+
+// videoCost is the cost of a processed video, from the sizes of its files
+func (cfg *apiConfig) videoCost(video database.Video) costEstimate {
+	var size int64
+	if video.SizeBytes != nil {
+		size = *video.SizeBytes
+	}
+	stored := size
+	for _, rendition := range video.Renditions {
+		stored += rendition.SizeBytes
+	}
+	return cfg.pricing.estimate(stored, size, int64(cfg.pricing.monthlyViews), true)
+}
+
+// handlerAdminStorageCosts totals what every stored video costs a month at
+// the configured prices, with delivery from last month's actual views, and
+// lists the most expensive videos
+func (cfg *apiConfig) handlerAdminStorageCosts(w http.ResponseWriter, r *http.Request) {
+	type videoCosts struct {
+		database.VideoStorageUsage
+		StorageUSD  float64 `json:"storage_usd"`
+		DeliveryUSD float64 `json:"delivery_usd"`
+		TotalUSD    float64 `json:"total_usd"`
+	}
+	type response struct {
+		StoragePricePerGBMonth float64      `json:"storage_price_per_gb_month"`
+		DeliveryPricePerGB     float64      `json:"delivery_price_per_gb"`
+		ViewsSince             time.Time    `json:"views_since"`
+		Videos                 int          `json:"videos"`
+		StoredBytes            int64        `json:"stored_bytes"`
+		Views                  int64        `json:"views"`
+		DeliveredBytes         int64        `json:"delivered_bytes"`
+		StorageUSD             float64      `json:"storage_usd"`
+		DeliveryUSD            float64      `json:"delivery_usd"`
+		TotalUSD               float64      `json:"total_usd"`
+		TopVideos              []videoCosts `json:"top_videos"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	limit := defaultCostTopVideos
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxCostTopVideos)
+	}
+
+	since := time.Now().UTC().Add(-costViewWindow)
+	usage, err := cfg.db.GetVideoStorageUsage(since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+
+	resp := response{
+		StoragePricePerGBMonth: cfg.pricing.storagePerGBMonth,
+		DeliveryPricePerGB:     cfg.pricing.deliveryPerGB,
+		ViewsSince:             since,
+		Videos:                 len(usage),
+		TopVideos:              []videoCosts{},
+	}
+	for _, u := range usage {
+		cost := cfg.pricing.estimate(u.StoredBytes, u.VideoBytes, u.Views, true)
+		resp.StoredBytes += cost.StoredBytes
+		resp.Views += u.Views
+		resp.DeliveredBytes += cost.DeliveredBytes
+		resp.StorageUSD += cost.StorageUSD
+		resp.DeliveryUSD += cost.DeliveryUSD
+		resp.TotalUSD += cost.TotalUSD
+		resp.TopVideos = append(resp.TopVideos, videoCosts{
+			VideoStorageUsage: u,
+			StorageUSD:        cost.StorageUSD,
+			DeliveryUSD:       cost.DeliveryUSD,
+			TotalUSD:          cost.TotalUSD,
+		})
+	}
+	slices.SortFunc(resp.TopVideos, func(a, b videoCosts) int {
+		return cmp.Compare(b.TotalUSD, a.TotalUSD)
+	})
+	if len(resp.TopVideos) > limit {
+		resp.TopVideos = resp.TopVideos[:limit]
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	deletionRetryInterval = 5 * time.Minute
+	deletionRetryBatch    = 100
+	// how often videos with a delete_at in the past are looked for
+	scheduledDeletionInterval = time.Minute
+	scheduledDeletionBatch    = 50
+)
+
+func hlsPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("hls/%s/", videoID)
+}
+
+func (cfg apiConfig) localAssetPath(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, fmt.Sprintf("http://localhost:%s/assets/", cfg.port))
+	if !ok || !filepath.IsLocal(name) {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, name), true
+}
+
+// videoObjects lists everything stored for a video outside the database
+func (cfg *apiConfig) videoObjects(video database.Video) ([]database.CreatePendingDeletionParams, error) {
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []database.CreatePendingDeletionParams{}
+	for _, prefix := range []string{
+		hlsPrefix(video.ID),
+		renditionPrefix(video.ID),
+		previewPrefix(video.ID),
+		spritePrefix(video.ID),
+		quarantinePrefix(video.ID),
+		captionPrefix(video.ID),
+		audioPrefix(video.ID),
+	} {
+		for _, key := range cfg.keyLayout.Prefixes(video.UserID, prefix) {
+			objects = append(objects, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionPrefix,
+				Key:            key,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+	}
+	if video.VideoURL != nil {
+		if key, ok := target.keyFromURL(*video.VideoURL); ok {
+			objects = append(objects, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionObject,
+				Key:            key,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+	}
+	// thumbnails always live in the default store or the assets dir
+	for _, thumbnailURL := range []*string{video.ThumbnailURL, video.ThumbnailSmallURL} {
+		if thumbnailURL == nil {
+			continue
+		}
+		if key, ok := cfg.defaultStore().keyFromURL(*thumbnailURL); ok {
+			objects = append(objects, database.CreatePendingDeletionParams{Kind: database.DeletionObject, Key: key})
+		} else if path, ok := cfg.localAssetPath(*thumbnailURL); ok {
+			objects = append(objects, database.CreatePendingDeletionParams{Kind: database.DeletionLocalFile, Key: path})
+		}
+	}
+	return objects, nil
+}
+
+// deleteVideo removes a video and everything stored for it. It fails with
+// database.ErrLegalHold, and deletes nothing, if the video is under legal
+// hold.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	objects, err := cfg.videoObjects(video)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve video storage: %w", err)
+	}
+
+	deletions, err := cfg.db.DeleteVideoWithObjects(video.ID, objects)
+	if err != nil {
+		return err
+	}
+
+	// anything that fails here stays queued and is retried in the background
+	if failed := cfg.processPendingDeletions(ctx, deletions); failed > 0 {
+		log.Printf("Video %s deleted, %d objects queued for retry", video.ID, failed)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) deleteObject(ctx context.Context, d database.PendingDeletion) error {
+	if d.Kind == database.DeletionLocalFile {
+		err := os.Remove(d.Key)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	target, err := cfg.storeFor(d.OrganizationID, d.Region)
+	if err != nil {
+		return err
+	}
+	switch d.Kind {
+	case database.DeletionObject:
+		// a new upload of the same content may have picked a shared object
+		// up again while its deletion was queued
+		referenced, err := cfg.db.ObjectIsReferenced(d.OrganizationID, d.Region, d.Key)
+		if err != nil || referenced {
+			return err
+		}
+		return target.store.Delete(ctx, d.Key)
+	case database.DeletionPrefix:
+		keys, err := target.store.List(ctx, d.Key)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := target.store.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown deletion kind %q", d.Kind)
+	}
+}
+
+// processPendingDeletions tries to delete each object, dropping it from the
+// queue on success and recording the error otherwise. It returns how many
+// deletions are still pending.
+func (cfg *apiConfig) processPendingDeletions(ctx context.Context, deletions []database.PendingDeletion) int {
+	failed := 0
+	for _, d := range deletions {
+		if err := cfg.deleteObject(ctx, d); err != nil {
+			failed++
+			log.Printf("Couldn't delete %s %q (attempt %d): %v", d.Kind, d.Key, d.Attempts+1, err)
+			if err := cfg.db.MarkPendingDeletionFailed(d.ID, err.Error()); err != nil {
+				log.Printf("Couldn't record failed deletion %d: %v", d.ID, err)
+			}
+			continue
+		}
+		if err := cfg.db.DeletePendingDeletion(d.ID); err != nil {
+			log.Printf("Couldn't remove pending deletion %d: %v", d.ID, err)
+		}
+	}
+	return failed
+}
+
+func (cfg *apiConfig) runDeletionRetrier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deletions, err := cfg.db.GetPendingDeletions(deletionRetryBatch)
+		if err != nil {
+			log.Printf("Couldn't load pending deletions: %v", err)
+			continue
+		}
+		if len(deletions) == 0 {
+			continue
+		}
+		failed := cfg.processPendingDeletions(ctx, deletions)
+		log.Printf("Retried %d pending deletions, %d still failing", len(deletions), failed)
+	}
+}
+
+// runScheduledDeletions deletes videos once their delete_at has passed.
+// Videos under legal hold are skipped until the hold is released.
+func (cfg *apiConfig) runScheduledDeletions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		videos, err := cfg.db.GetVideosDueForDeletion(time.Now(), scheduledDeletionBatch)
+		if err != nil {
+			log.Printf("Couldn't load videos due for deletion: %v", err)
+			continue
+		}
+		for _, video := range videos {
+			// a hold placed since the query is still honored here
+			if err := cfg.deleteVideo(ctx, video); err != nil {
+				log.Printf("Couldn't delete video %s scheduled for %s: %v", video.ID, video.DeleteAt, err)
+				continue
+			}
+			log.Printf("Deleted video %s as scheduled", video.ID)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space isn't available on this platform")
+}
+
+func diskUsage(path string) (float64, error) {
+	return 0, errors.New("disk usage isn't available on this platform")
+}
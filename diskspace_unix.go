@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// freeDiskSpace returns the bytes available to this process on the
+// filesystem holding path
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// diskUsage returns the fraction of the filesystem holding path that's in
+// use, counting the blocks reserved for root as used
+func diskUsage(path string) (float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	if stat.Blocks == 0 {
+		return 0, nil
+	}
+	return 1 - float64(stat.Bavail)/float64(stat.Blocks), nil
+}
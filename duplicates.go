@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// checkDuplicateUpload warns the uploader when they've uploaded the exact
+// same file before. It's only a warning: re-uploading on purpose, say to
+// publish a copy with another title, is fine.
+func (cfg *apiConfig) checkDuplicateUpload(job *uploadJob) {
+	duplicate, err := cfg.db.FindVideoByUploadSHA256(job.UserID, job.SHA256, job.VideoID)
+	if err != nil {
+		log.Printf("Couldn't look for duplicates of video %s: %v", job.VideoID, err)
+		return
+	}
+	if duplicate.ID == uuid.Nil {
+		return
+	}
+	warning := fmt.Sprintf("This file was already uploaded as %q (%s)", duplicate.Title, duplicate.ID)
+	cfg.recordJobEvent(job, stageScreening, database.EventDuplicateUpload, warning)
+	job.addWarning(warning)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const maxEmbedDomains = 50
+
+// parseEmbedDomain cleans up an entry of an embed allowlist: a host name,
+// which only matches itself, or *.host, which matches every subdomain of
+// host
+func parseEmbedDomain(s string) (string, error) {
+	domain := strings.ToLower(strings.TrimSpace(s))
+	host := strings.TrimPrefix(domain, "*.")
+	if len(host) == 0 || len(host) > 253 {
+		return "", fmt.Errorf("%q isn't a domain", s)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("%q isn't a domain", s)
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-') {
+				return "", fmt.Errorf("%q isn't a domain", s)
+			}
+		}
+	}
+	return domain, nil
+}
+
+// embedDomainMatches reports whether host is allowed by an allowlist entry
+func embedDomainMatches(domain, host string) bool {
+	if parent, ok := strings.CutPrefix(domain, "*."); ok {
+		return strings.HasSuffix(host, "."+parent)
+	}
+	return host == domain
+}
+
+// requestSite is the host of the page a request came from, by its Origin or
+// else its Referer, or "" if it has neither
+func requestSite(r *http.Request) string {
+	for _, header := range []string{"Origin", "Referer"} {
+		value := r.Header.Get(header)
+		if value == "" || value == "null" {
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.Hostname() != "" {
+			return strings.ToLower(u.Hostname())
+		}
+	}
+	return ""
+}
+
+// embedAllowed reports whether r may play the video: it came from a site on
+// the video's allowlist, or from Tubely itself, or the allowlist is empty.
+// Requests that say nothing of where they came from are let through;
+// framing on other sites is still refused by frameAncestors.
+func (cfg *apiConfig) embedAllowed(r *http.Request, video database.Video) bool {
+	if len(video.EmbedDomains) == 0 {
+		return true
+	}
+	site := requestSite(r)
+	if site == "" || cfg.isOwnSite(r, site) {
+		return true
+	}
+	return slices.ContainsFunc(video.EmbedDomains, func(domain string) bool {
+		return embedDomainMatches(domain, site)
+	})
+}
+
+func (cfg *apiConfig) isOwnSite(r *http.Request, site string) bool {
+	if u, err := url.Parse(cfg.publicBaseURL); err == nil && strings.EqualFold(u.Hostname(), site) {
+		return true
+	}
+	host := r.Host
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	return strings.EqualFold(host, site)
+}
+
+// frameAncestors is the Content-Security-Policy that keeps browsers from
+// showing the embed page in a frame on sites off the video's allowlist
+func frameAncestors(video database.Video) string {
+	if len(video.EmbedDomains) == 0 {
+		return ""
+	}
+	return "frame-ancestors 'self' " + strings.Join(video.EmbedDomains, " ")
+}
+
+// handlerVideoEmbedDomains sets the sites a video can be embedded on; an
+// empty list allows any site
+func (cfg *apiConfig) handlerVideoEmbedDomains(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Domains []string `json:"domains"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Domains) > maxEmbedDomains {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A video can have at most %d embed domains", maxEmbedDomains), nil)
+		return
+	}
+	domains := database.EmbedDomains{}
+	for _, d := range params.Domains {
+		domain, err := parseEmbedDomain(d)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	if err := cfg.db.SetVideoEmbedDomains(video.ID, domains); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// the blocklist is for operators handling takedown requests: uploads whose
+// content matches an entry are rejected or quarantined during screening
+
+func (cfg *apiConfig) handlerBlocklistList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	hashType := database.BlocklistHashType(r.URL.Query().Get("hash_type"))
+	if hashType != "" && hashType != database.BlocklistSHA256 && hashType != database.BlocklistPerceptual {
+		respondWithError(w, http.StatusBadRequest, "hash_type must be sha256 or perceptual", nil)
+		return
+	}
+
+	entries, err := cfg.db.GetBlocklistEntries(hashType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve blocklist", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, entries, completeList(len(entries)))
+}
+
+func (cfg *apiConfig) handlerBlocklistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		HashType database.BlocklistHashType `json:"hash_type"`
+		Hash     string                     `json:"hash"`
+		Action   database.BlocklistAction   `json:"action"`
+		Reason   string                     `json:"reason"`
+	}
+
+	userID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.HashType == "" {
+		params.HashType = database.BlocklistSHA256
+	}
+	if !validBlocklistHash(params.HashType, params.Hash) {
+		respondWithError(w, http.StatusBadRequest, "hash must be a lowercase hex SHA-256 digest, or 16 hex digits with hash_type perceptual", nil)
+		return
+	}
+	if params.Action == "" {
+		params.Action = database.BlocklistReject
+	}
+	if params.Action != database.BlocklistReject && params.Action != database.BlocklistQuarantine {
+		respondWithError(w, http.StatusBadRequest, "action must be reject or quarantine", nil)
+		return
+	}
+
+	existing, err := cfg.db.FindBlocklistEntry(params.HashType, params.Hash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check blocklist", err)
+		return
+	}
+	if existing.ID != uuid.Nil {
+		respondWithError(w, http.StatusConflict, "Hash is already on the blocklist", nil)
+		return
+	}
+
+	entry, err := cfg.db.CreateBlocklistEntry(database.CreateBlocklistEntryParams{
+		HashType:  params.HashType,
+		Hash:      params.Hash,
+		Action:    params.Action,
+		Reason:    params.Reason,
+		CreatedBy: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create blocklist entry", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, entry)
+}
+
+func (cfg *apiConfig) handlerBlocklistDelete(w http.ResponseWriter, r *http.Request) {
+	entryID, err := uuid.Parse(r.PathValue("entryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid blocklist entry ID", err)
+		return
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	entry, err := cfg.db.GetBlocklistEntry(entryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get blocklist entry", err)
+		return
+	}
+	if entry.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find blocklist entry", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteBlocklistEntry(entryID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete blocklist entry", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	defaultFailuresLimit = 50
+	maxFailuresLimit     = 500
+)
+
+func (cfg *apiConfig) handlerAdminFailures(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.UploadFailureSummary
+		Failures []database.VideoEvent `json:"failures"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := database.UploadFailureFilter{
+		Stage:      query.Get("stage"),
+		ErrorClass: query.Get("error_class"),
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 time", err)
+			return
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "until must be an RFC 3339 time", err)
+			return
+		}
+		filter.Until = t
+	}
+	limit := defaultFailuresLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxFailuresLimit)
+	}
+
+	summary, err := cfg.db.GetUploadFailureSummary(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't summarize failures", err)
+		return
+	}
+	failures, err := cfg.db.GetUploadFailures(filter, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve failures", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadFailureSummary: summary,
+		Failures:             failures,
+	})
+}
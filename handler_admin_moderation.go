@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerAdminVideos lists every user's videos, published or not, taking
+// the same query as GET /api/videos. owner narrows it down to one user.
+func (cfg *apiConfig) handlerAdminVideos(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	params, err := parseListVideosParams(r.URL.Query(), uuid.Nil)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.AllOwners = true
+	cfg.respondWithVideoPage(w, r, params)
+}
+
+// handlerAdminVideoDelete deletes any user's video for good, skipping the
+// trash. A legal hold still wins.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	err = cfg.deleteVideo(r.Context(), video)
+	if errors.Is(err, database.ErrLegalHold) {
+		respondWithError(w, http.StatusConflict, "This video is under legal hold and can't be deleted", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	log.Printf("Admin %s deleted video %s of user %s", adminID, video.ID, video.UserID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminVideoModeration records a reviewer's decision on a video
+// the content moderator held back. Pending and flagged videos can be found
+// with GET /api/admin/videos?moderation=.
+func (cfg *apiConfig) handlerAdminVideoModeration(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Status database.ModerationStatus `json:"status"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Status != database.ModerationApproved && params.Status != database.ModerationFlagged {
+		respondWithError(w, http.StatusBadRequest, "Status must be approved or flagged", nil)
+		return
+	}
+
+	found, err := cfg.db.SetVideoModeration(videoID, params.Status, nil, uuid.NullUUID{UUID: adminID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update moderation status", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	cfg.recordVideoEvent(videoID, "", database.EventModerationReview, fmt.Sprintf("%s by admin %s", params.Status, adminID))
+	log.Printf("Admin %s marked video %s %s", adminID, videoID, params.Status)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerAdminUsers lists the users, or with suspended=true only the
+// suspended ones
+func (cfg *apiConfig) handlerAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	accounts, err := cfg.db.ListUserAccounts(r.URL.Query().Get("suspended") == "true")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+	cfg.respondWithList(w, r, accounts, completeList(len(accounts)))
+}
+
+// handlerAdminUserSuspension suspends or reinstates a user. Suspended users
+// can't sign in or use their tokens and API keys; their videos stay as
+// they are.
+func (cfg *apiConfig) handlerAdminUserSuspension(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Suspended bool   `json:"suspended"`
+		Reason    string `json:"reason"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	reason := strings.TrimSpace(params.Reason)
+	if params.Suspended && reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required to suspend a user", nil)
+		return
+	}
+	if params.Suspended && userID == adminID {
+		respondWithError(w, http.StatusBadRequest, "You can't suspend yourself", nil)
+		return
+	}
+
+	var found bool
+	if params.Suspended {
+		found, err = cfg.db.SuspendUser(userID, reason)
+	} else {
+		found, err = cfg.db.UnsuspendUser(userID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if params.Suspended {
+		log.Printf("Admin %s suspended user %s: %s", adminID, userID, reason)
+	} else {
+		log.Printf("Admin %s reinstated user %s", adminID, userID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
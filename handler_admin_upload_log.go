@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// handlerAdminUploadLog streams the ffmpeg output of an upload job as
+// server-sent events: one "log" event per line, numbered so a reconnecting
+// client can send Last-Event-ID and carry on, then "done" once the job ends.
+func (cfg *apiConfig) handlerAdminUploadLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return
+	}
+	job, ok := cfg.jobs.get(uploadID)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", nil)
+		return
+	}
+
+	next := 0
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		n, err := strconv.Atoi(lastID)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID header", err)
+			return
+		}
+		next = n + 1
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming isn't supported", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		// grab the channel before reading the log so no line is missed
+		changed := job.watch()
+		lines, first, finished := job.logSince(next)
+
+		if first > next {
+			if _, err := fmt.Fprintf(w, ": %d lines dropped\n\n", first-next); err != nil {
+				return
+			}
+		}
+		for i, line := range lines {
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", first+i, line); err != nil {
+				return
+			}
+		}
+		next = first + len(lines)
+		if finished {
+			writeSSE(w, "done", job.snapshot().Status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// API keys are managed with a JWT only, so a leaked key can't mint more keys
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string       `json:"name"`
+		Scopes []auth.Scope `json:"scopes"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever shown here, it can't be retrieved later
+		Key string `json:"key"`
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	scopes, err := validateScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	apiKey, key, err := cfg.createAPIKey(userID, params.Name, scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+// createAPIKey saves a new key for userID and returns it along with the key
+// itself, which isn't stored
+func (cfg *apiConfig) createAPIKey(userID uuid.UUID, name string, scopes []string) (database.APIKey, string, error) {
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		return database.APIKey{}, "", err
+	}
+
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      name,
+		KeyHash:   auth.HashAPIKey(key),
+		KeyPrefix: key[:len(auth.APIKeyPrefix)+8],
+		Scopes:    scopes,
+	})
+	if err != nil {
+		return database.APIKey{}, "", err
+	}
+	return apiKey, key, nil
+}
+
+func validateScopes(requested []auth.Scope) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("At least one scope is required")
+	}
+	scopes := []string{}
+	for _, scope := range requested {
+		if !auth.ValidScope(scope) {
+			return nil, fmt.Errorf("Unknown scope %q", scope)
+		}
+		if scope == auth.ScopeAdmin {
+			return nil, errors.New("API keys can't have the admin scope")
+		}
+		scopes = append(scopes, string(scope))
+	}
+	return scopes, nil
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, keys, completeList(len(keys)))
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	keyIDString := r.PathValue("keyID")
+	keyID, err := uuid.Parse(keyIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find API key", nil)
+		return
+	}
+
+	if err := cfg.db.RevokeAPIKey(keyID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
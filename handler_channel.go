@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerChannelVideos(w http.ResponseWriter, r *http.Request) {
+	userIDString := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	videos, err := cfg.db.GetChannelVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	if requester, ok := cfg.requestUserID(r); ok && requester == userID {
+		cfg.respondWithList(w, r, videos, completeList(len(videos)))
+		return
+	}
+	public := make([]publicVideo, len(videos))
+	for i, video := range videos {
+		public[i] = publicView(video)
+	}
+	cfg.respondWithList(w, r, public, completeList(len(public)))
+}
+
+func (cfg *apiConfig) handlerVideoPin(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	if !video.Pinned {
+		pinned, err := cfg.db.PinVideo(video.ID, video.UserID, cfg.maxPinnedVideos)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't pin video", err)
+			return
+		}
+		if !pinned {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("You can only pin %d videos", cfg.maxPinnedVideos), nil)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoUnpin(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.UnpinVideo(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unpin video", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerChannelOrder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for _, id := range params.VideoIDs {
+		if seen[id] {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video %s is listed more than once", id), nil)
+			return
+		}
+		seen[id] = true
+
+		video, err := cfg.db.GetVideo(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video %s isn't one of your videos", id), nil)
+			return
+		}
+	}
+
+	if err := cfg.db.SetChannelOrder(userID, params.VideoIDs); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save channel order", err)
+		return
+	}
+
+	videos, err := cfg.db.GetChannelVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, videos, completeList(len(videos)))
+}
+
+// getOwnedVideo loads the video in the path and checks the caller owns it,
+// writing the error response itself if not
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request, scope auth.Scope) (database.Video, bool) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
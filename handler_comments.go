@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCommentLength       = 2000
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+)
+
+func (cfg *apiConfig) handlerCommentCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID, video, ok := cfg.getViewableVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	body := strings.TrimSpace(params.Body)
+	if body == "" {
+		respondWithError(w, http.StatusBadRequest, "Comment can't be empty", nil)
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Comments can be at most %d characters", maxCommentLength), nil)
+		return
+	}
+
+	if cfg.commentUserLimit.Enabled() {
+		if !cfg.allowRequest(w, r, "comments:user:"+userID.String(), cfg.commentUserLimit, "Too many comments, try again later") {
+			return
+		}
+	}
+
+	comment, err := cfg.db.CreateComment(video.ID, userID, body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+// handlerCommentsList returns a page of a video's comments, newest first.
+// Anyone who can see the video can read them.
+func (cfg *apiConfig) handlerCommentsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultCommentPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxCommentPageSize)
+	}
+	var after *database.CommentCursor
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodeCommentCursor(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+		after = &cursor
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	viewerID, _ := cfg.requestUserID(r)
+	if err != nil || !video.VisibleTo(viewerID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+
+	comments, more, err := cfg.db.ListComments(video.ID, after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+		return
+	}
+
+	page := pagination{Total: &video.CommentCount}
+	if more {
+		cursor, err := encodeCommentCursor(comments[len(comments)-1].Cursor())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't make cursor", err)
+			return
+		}
+		page.NextCursor = &cursor
+	}
+	cfg.respondWithList(w, r, comments, page)
+}
+
+// handlerCommentDelete soft deletes a comment. Commenters can delete their
+// own, and owners any on their videos.
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	comment, err := cfg.db.GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+	if comment.ID == uuid.Nil || comment.VideoID != videoID || comment.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return
+	}
+	if comment.UserID != userID {
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can only delete your own comments or comments on your videos", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.DeleteComment(comment.ID, userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func encodeCommentCursor(cursor database.CommentCursor) (string, error) {
+	dat, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+func decodeCommentCursor(s string) (database.CommentCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.CommentCursor{}, err
+	}
+	var cursor database.CommentCursor
+	err = json.Unmarshal(dat, &cursor)
+	return cursor, err
+}
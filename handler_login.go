@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -39,36 +41,47 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	if !cfg.checkNotSuspended(w, user.ID) {
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.issueTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create tokens", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
+// issueTokens creates an access JWT and a saved refresh token for a user who
+// has just signed in
+func (cfg *apiConfig) issueTokens(userID uuid.UUID) (accessToken, refreshToken string, err error) {
+	accessToken, err = auth.MakeJWT(
+		userID,
 		cfg.jwtSecret,
 		time.Hour*24*30,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	refreshToken, err = auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
+		UserID:    userID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
 	}
-
-	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
+	return accessToken, refreshToken, nil
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerOrganizationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(params.Name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email"`
+		Role  database.OrganizationRole `json:"role"`
+	}
+
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Role == "" {
+		params.Role = database.OrganizationRoleMember
+	}
+	if params.Role != database.OrganizationRoleMember && params.Role != database.OrganizationRoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "Role must be \"member\" or \"admin\"", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+
+	member, err := cfg.db.AddOrganizationMember(orgID, user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add member", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+func (cfg *apiConfig) handlerOrganizationStorageGet(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	st, err := cfg.db.GetOrganizationStorage(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage config", err)
+		return
+	}
+	if st.Bucket == "" {
+		respondWithError(w, http.StatusNotFound, "Organization uses the default bucket", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, st)
+}
+
+func (cfg *apiConfig) handlerOrganizationStorageSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Bucket          string `json:"bucket"`
+		Region          string `json:"region"`
+		Endpoint        string `json:"endpoint"`
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+	}
+
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if cfg.secretBox == nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Organization storage isn't enabled on this server", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Bucket == "" || params.Region == "" || params.AccessKeyID == "" || params.SecretAccessKey == "" {
+		respondWithError(w, http.StatusBadRequest, "Bucket, region, access_key_id and secret_access_key are required", nil)
+		return
+	}
+
+	encrypted, err := cfg.secretBox.Seal(params.SecretAccessKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encrypt credentials", err)
+		return
+	}
+
+	st, err := cfg.db.UpsertOrganizationStorage(database.OrganizationStorage{
+		OrganizationID:           orgID,
+		Bucket:                   params.Bucket,
+		Region:                   params.Region,
+		Endpoint:                 params.Endpoint,
+		AccessKeyID:              params.AccessKeyID,
+		SecretAccessKeyEncrypted: encrypted,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save storage config", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, st)
+}
+
+// handlerOrganizationStorageRegionSet picks the region the organization's
+// new videos are stored in when an upload doesn't ask for one
+func (cfg *apiConfig) handlerOrganizationStorageRegionSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Region string `json:"region"`
+	}
+
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	region, ok := cfg.resolveStorageRegion(params.Region)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown storage region %q", params.Region), nil)
+		return
+	}
+
+	if err := cfg.db.SetOrganizationStorageRegion(orgID, region); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set storage region", err)
+		return
+	}
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// requireOrganizationAdmin checks the caller is an admin of the organization
+// in the path, writing the error response itself if not
+func (cfg *apiConfig) requireOrganizationAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	orgIDString := r.PathValue("orgID")
+	orgID, err := uuid.Parse(orgIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return uuid.Nil, false
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	member, err := cfg.db.GetOrganizationMember(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return uuid.Nil, false
+	}
+	if member.Role != database.OrganizationRoleAdmin {
+		respondWithError(w, http.StatusForbidden, "You aren't an admin of this organization", nil)
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
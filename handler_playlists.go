@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPlaylistsPerUser = 200
+	maxPlaylistVideos   = 1000
+)
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	title, err := sanitizeVideoText("title", params.Title, maxTitleLength, false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if title == "" {
+		respondWithError(w, http.StatusBadRequest, "title can't be empty", nil)
+		return
+	}
+	description, err := sanitizeVideoText("description", params.Description, maxDescriptionLength, true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist, created, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{
+		UserID:      userID,
+		Title:       title,
+		Description: description,
+	}, maxPlaylistsPerUser)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+	if !created {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can't have more than %d playlists", maxPlaylistsPerUser), nil)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+	playlists, err := cfg.db.GetPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+	cfg.respondWithList(w, r, playlists, completeList(len(playlists)))
+}
+
+// handlerPlaylistGet returns a playlist with a page of its videos. Anyone
+// can look at a playlist, but other people's videos in it only show once
+// they're published.
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Playlist
+		VideoCount int64                   `json:"video_count"`
+		Videos     []database.PlaylistItem `json:"videos"`
+		NextCursor *string                 `json:"next_cursor"`
+	}
+
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+	viewerID, _ := cfg.requestUserID(r)
+
+	query := r.URL.Query()
+	limit := defaultVideoPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxVideoPageSize)
+	}
+	after := -1
+	if s := query.Get("cursor"); s != "" {
+		after, err = decodePlaylistCursor(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", err)
+			return
+		}
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+	items, more, err := cfg.db.ListPlaylistVideos(playlist.ID, viewerID, after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlist videos", err)
+		return
+	}
+	count, err := cfg.db.CountPlaylistVideos(playlist.ID, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count playlist videos", err)
+		return
+	}
+
+	resp := response{Playlist: playlist, VideoCount: count, Videos: items}
+	if more {
+		cursor := encodePlaylistCursor(items[len(items)-1].Position)
+		w.Header().Set("X-Next-Cursor", cursor)
+		resp.NextCursor = &cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerPlaylistUpdate changes the title and description; fields left out
+// of the body stay as they are
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title == nil && params.Description == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update, set title or description", nil)
+		return
+	}
+	if params.Title != nil {
+		title, err := sanitizeVideoText("title", *params.Title, maxTitleLength, false)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if title == "" {
+			respondWithError(w, http.StatusBadRequest, "title can't be empty", nil)
+			return
+		}
+		params.Title = &title
+	}
+	if params.Description != nil {
+		description, err := sanitizeVideoText("description", *params.Description, maxDescriptionLength, true)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		params.Description = &description
+	}
+
+	if err := cfg.db.UpdatePlaylist(playlist.ID, params.Title, params.Description); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	playlist, err := cfg.db.GetPlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	if _, err := cfg.db.DeletePlaylist(playlist.UserID, playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideoAdd puts one of the caller's videos, or anyone's
+// published video, in the playlist, at the end unless a position is given
+func (cfg *apiConfig) handlerPlaylistVideoAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Position != nil && *params.Position < 0 {
+		respondWithError(w, http.StatusBadRequest, "position can't be negative", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil || !video.VisibleTo(playlist.UserID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+
+	err = cfg.db.AddPlaylistVideo(playlist.ID, video.ID, params.Position, maxPlaylistVideos)
+	if errors.Is(err, database.ErrVideoInPlaylist) {
+		respondWithError(w, http.StatusConflict, "The video is already in this playlist", nil)
+		return
+	}
+	if errors.Is(err, database.ErrPlaylistFull) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Playlists can't have more than %d videos", maxPlaylistVideos), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	removed, err := cfg.db.RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "The video isn't in this playlist", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistOrder reorders a playlist. The body lists every video in
+// it, in the new order.
+func (cfg *apiConfig) handlerPlaylistOrder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range params.VideoIDs {
+		if seen[id] {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video %s is listed more than once", id), nil)
+			return
+		}
+		seen[id] = true
+	}
+
+	err := cfg.db.SetPlaylistOrder(playlist.ID, params.VideoIDs)
+	if errors.Is(err, database.ErrPlaylistMismatch) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist once", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save playlist order", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnedPlaylist loads the playlist in the path and checks the caller
+// owns it, writing the error response itself if not
+func (cfg *apiConfig) getOwnedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+func encodePlaylistCursor(position int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(position)))
+}
+
+func decodePlaylistCursor(s string) (int, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(dat))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 1000
+)
+
+// avatars are cropped square and stored at these sizes
+var (
+	avatarLarge = image.Pt(400, 400)
+	avatarSmall = image.Pt(96, 96)
+)
+
+// handlerProfileGet returns a user's public profile. Suspended users look
+// like they don't exist, as their channel is empty anyway.
+func (cfg *apiConfig) handlerProfileGet(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	if profile.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (cfg *apiConfig) handlerProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.DisplayName != nil && utf8.RuneCountInString(*params.DisplayName) > maxDisplayNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Display name can be at most %d characters", maxDisplayNameLength), nil)
+		return
+	}
+	if params.Bio != nil && utf8.RuneCountInString(*params.Bio) > maxBioLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bio can be at most %d characters", maxBioLength), nil)
+		return
+	}
+
+	if err := cfg.db.UpdateProfile(userID, params.DisplayName, params.Bio); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+	cfg.respondWithProfile(w, userID)
+}
+
+// handlerAvatarUpload sets the caller's avatar, going through the same
+// checks, scan and re-encoding as thumbnails
+func (cfg *apiConfig) handlerAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	data, ok := cfg.readImageUpload(w, r, "avatar")
+	if !ok {
+		return
+	}
+	if !cfg.scanAvatar(w, r, data) {
+		return
+	}
+
+	img, err := decodeThumbnail(data)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+	img = cropToAspect(img, avatarLarge)
+
+	name := randomImageName()
+	url, err := cfg.writeImage(r.Context(), resizeToFit(img, avatarLarge), name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+	smallURL, err := cfg.writeImage(r.Context(), resizeToFit(img, avatarSmall), name+"-small")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+
+	if err := cfg.db.SetAvatar(userID, &url, &smallURL); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+	cfg.respondWithProfile(w, userID)
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.SetAvatar(userID, nil, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+	cfg.respondWithProfile(w, userID)
+}
+
+// scanAvatar turns away avatars the malware scanner flags. There's no video
+// to quarantine them under, so they're only refused.
+func (cfg *apiConfig) scanAvatar(w http.ResponseWriter, r *http.Request, data []byte) bool {
+	result, err := cfg.scanBody(r.Context(), bytes.NewReader(data))
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't scan avatar, try again later", err)
+		return false
+	}
+	if result != nil && result.Status == database.ScanInfected {
+		respondWithError(w, http.StatusUnprocessableEntity, "The avatar failed the malware scan", &malwareFound{threat: result.Threat})
+		return false
+	}
+	return true
+}
+
+// respondWithProfile answers with the caller's profile as others see it.
+// A suspended caller can't get this far, so it's never missing.
+func (cfg *apiConfig) respondWithProfile(w http.ResponseWriter, userID uuid.UUID) {
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webpush"
+)
+
+// Push subscriptions belong to a browser, so like API keys they're managed
+// with a JWT only
+
+// handlerPushPublicKey returns the key browsers pass to
+// PushManager.subscribe as applicationServerKey
+func (cfg *apiConfig) handlerPushPublicKey(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		PublicKey string `json:"public_key"`
+	}
+	if cfg.webPush == nil {
+		respondWithError(w, http.StatusNotFound, "Push notifications aren't enabled", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{PublicKey: cfg.webPush.PublicKey()})
+}
+
+// handlerPushSubscribe saves the browser's subscription, taking the JSON of
+// a PushSubscription as is
+func (cfg *apiConfig) handlerPushSubscribe(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+
+	if cfg.webPush == nil {
+		respondWithError(w, http.StatusNotFound, "Push notifications aren't enabled", nil)
+		return
+	}
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	sub := webpush.Subscription{
+		Endpoint: params.Endpoint,
+		P256dh:   params.Keys.P256dh,
+		Auth:     params.Keys.Auth,
+	}
+	if err := sub.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err := cfg.db.SavePushSubscription(database.CreatePushSubscriptionParams{
+		Endpoint: sub.Endpoint,
+		UserID:   userID,
+		P256dh:   sub.P256dh,
+		Auth:     sub.Auth,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save push subscription", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPushUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Endpoint string `json:"endpoint"`
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	deleted, err := cfg.db.DeletePushSubscription(userID, params.Endpoint)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete push subscription", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Push subscription not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxWatchLaterVideos = 1000
+
+type reactionResponse struct {
+	LikeCount    *int64                `json:"like_count,omitempty"`
+	DislikeCount *int64                `json:"dislike_count,omitempty"`
+	Viewer       *database.ViewerState `json:"viewer"`
+}
+
+// handlerReactionSet likes or dislikes a video the caller can see,
+// replacing any reaction they had
+func (cfg *apiConfig) handlerReactionSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reaction database.Reaction `json:"reaction"`
+	}
+
+	userID, video, ok := cfg.getViewableVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Reaction != database.ReactionLike && params.Reaction != database.ReactionDislike {
+		respondWithError(w, http.StatusBadRequest, "reaction must be like or dislike", nil)
+		return
+	}
+
+	if err := cfg.db.SetReaction(video.ID, userID, &params.Reaction); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save reaction", err)
+		return
+	}
+	cfg.respondWithReaction(w, userID, video.ID)
+}
+
+func (cfg *apiConfig) handlerReactionDelete(w http.ResponseWriter, r *http.Request) {
+	userID, video, ok := cfg.getViewableVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.SetReaction(video.ID, userID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove reaction", err)
+		return
+	}
+	cfg.respondWithReaction(w, userID, video.ID)
+}
+
+// respondWithReaction answers with the video's new counts, as far as the
+// caller may see them, and their own state
+func (cfg *apiConfig) respondWithReaction(w http.ResponseWriter, userID, videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	state, err := cfg.db.GetViewerState(userID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	view := publicView(video)
+	if video.UserID == userID {
+		view = ownerView(video)
+	}
+	respondWithJSON(w, http.StatusOK, reactionResponse{
+		LikeCount:    view.LikeCount,
+		DislikeCount: view.DislikeCount,
+		Viewer:       &state,
+	})
+}
+
+// watchLaterItem is a video in the caller's watch later list, with others'
+// stats only if they made them public
+type watchLaterItem struct {
+	AddedAt time.Time   `json:"added_at"`
+	Video   publicVideo `json:"video"`
+}
+
+// handlerWatchLaterList returns the caller's watch later list, leaving out
+// videos that have since been hidden from them
+func (cfg *apiConfig) handlerWatchLaterList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	saved, err := cfg.db.GetWatchLater(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve watch later list", err)
+		return
+	}
+	items := make([]watchLaterItem, len(saved))
+	for i, s := range saved {
+		items[i] = watchLaterItem{AddedAt: s.AddedAt, Video: publicView(s.Video)}
+		if s.Video.UserID == userID {
+			items[i].Video = ownerView(s.Video)
+		}
+	}
+	cfg.respondWithList(w, r, items, completeList(len(items)))
+}
+
+func (cfg *apiConfig) handlerWatchLaterAdd(w http.ResponseWriter, r *http.Request) {
+	userID, video, ok := cfg.getViewableVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.AddWatchLater(userID, video.ID, maxWatchLaterVideos)
+	if errors.Is(err, database.ErrWatchLaterFull) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Watch later can't have more than %d videos", maxWatchLaterVideos), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to watch later", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWatchLaterRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	removed, err := cfg.db.RemoveWatchLater(userID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video from watch later", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "The video isn't in your watch later list", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getViewableVideo authenticates the caller for scope and loads the video
+// in the path if they can see it, writing the error response itself if not
+func (cfg *apiConfig) getViewableVideo(w http.ResponseWriter, r *http.Request, scope auth.Scope) (uuid.UUID, database.Video, bool) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return uuid.Nil, database.Video{}, false
+	}
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return uuid.Nil, database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || !video.VisibleTo(userID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return uuid.Nil, database.Video{}, false
+	}
+	return userID, video, true
+}
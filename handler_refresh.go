@@ -23,6 +23,13 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if !cfg.checkNotSuspended(w, user.ID) {
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Service accounts are managed by organization admins with a JWT. The accounts
+// themselves only ever hold API keys, so they can't reach any of the
+// JWT-only endpoints.
+
+func (cfg *apiConfig) handlerServiceAccountCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name        string       `json:"name"`
+		VideoPrefix string       `json:"video_prefix"`
+		Scopes      []auth.Scope `json:"scopes"`
+	}
+
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+	scopes, err := validateScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	hashedPassword, err := auth.MakeUnusablePasswordHash()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	account, err := cfg.db.CreateServiceAccount(database.CreateServiceAccountParams{
+		OrganizationID: orgID,
+		Name:           params.Name,
+		VideoPrefix:    params.VideoPrefix,
+		Scopes:         scopes,
+		PasswordHash:   hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create service account", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, account)
+}
+
+func (cfg *apiConfig) handlerServiceAccountsList(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	accounts, err := cfg.db.GetServiceAccounts(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve service accounts", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, accounts, completeList(len(accounts)))
+}
+
+func (cfg *apiConfig) handlerServiceAccountDisable(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.getOrganizationServiceAccount(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DisableServiceAccount(account.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable service account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerServiceAccountKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever shown here, it can't be retrieved later
+		Key string `json:"key"`
+	}
+
+	account, ok := cfg.getOrganizationServiceAccount(w, r)
+	if !ok {
+		return
+	}
+	if account.DisabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Service account is disabled", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+
+	// keys carry the account's scopes, so narrowing the account narrows them
+	apiKey, key, err := cfg.createAPIKey(account.UserID, params.Name, account.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerServiceAccountKeysList(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.getOrganizationServiceAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(account.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, keys, completeList(len(keys)))
+}
+
+func (cfg *apiConfig) handlerServiceAccountKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.getOrganizationServiceAccount(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.UserID != account.UserID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find API key", nil)
+		return
+	}
+
+	if err := cfg.db.RevokeAPIKey(keyID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOrganizationServiceAccount loads the service account in the path after
+// checking the caller is an admin of its organization, writing the error
+// response itself if not
+func (cfg *apiConfig) getOrganizationServiceAccount(w http.ResponseWriter, r *http.Request) (database.ServiceAccount, bool) {
+	orgID, ok := cfg.requireOrganizationAdmin(w, r)
+	if !ok {
+		return database.ServiceAccount{}, false
+	}
+
+	accountID, err := uuid.Parse(r.PathValue("accountID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid service account ID", err)
+		return database.ServiceAccount{}, false
+	}
+	account, err := cfg.db.GetServiceAccount(accountID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get service account", err)
+		return database.ServiceAccount{}, false
+	}
+	if account.OrganizationID != orgID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find service account", nil)
+		return database.ServiceAccount{}, false
+	}
+	return account, true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sso"
+	"github.com/google/uuid"
+)
+
+// the cookie carrying state and the PKCE verifier between login and callback
+const ssoCookieName = "tubely_sso"
+
+var errSSOEmailNotVerified = errors.New("the provider didn't return a verified email")
+
+func (cfg *apiConfig) handlerSSOProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range cfg.ssoProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cfg.respondWithList(w, r, names, completeList(len(names)))
+}
+
+func (cfg *apiConfig) handlerSSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.ssoProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+
+	state, err := sso.NewState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	verifier, err := sso.NewState()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookieName,
+		Value:    state + "." + verifier,
+		Path:     "/api/auth/" + provider.Name,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, verifier), http.StatusFound)
+}
+
+// handlerSSOCallback finishes a provider login and hands the web app the same
+// tokens a password login gets, in the URL fragment so they never reach a
+// server log
+func (cfg *apiConfig) handlerSSOCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.ssoProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+
+	cookie, err := r.Cookie(ssoCookieName)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired, please try again", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   ssoCookieName,
+		Path:   "/api/auth/" + provider.Name,
+		MaxAge: -1,
+	})
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("Login was refused: %s", providerErr), nil)
+		return
+	}
+	state, verifier, found := strings.Cut(cookie.Value, ".")
+	if !found || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login state doesn't match, please try again", nil)
+		return
+	}
+
+	accessToken, err := provider.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete login", err)
+		return
+	}
+	identity, err := provider.Identity(r.Context(), accessToken)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete login", err)
+		return
+	}
+
+	user, err := cfg.ssoUser(provider.Name, identity)
+	if errors.Is(err, errSSOEmailNotVerified) {
+		respondWithError(w, http.StatusForbidden, "Your account needs a verified email address", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign in", err)
+		return
+	}
+	if !cfg.checkNotSuspended(w, user.ID) {
+		return
+	}
+
+	token, refreshToken, err := cfg.issueTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create tokens", err)
+		return
+	}
+
+	fragment := url.Values{
+		"token":         {token},
+		"refresh_token": {refreshToken},
+	}
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+// ssoUser finds the user behind a provider identity. The first time an
+// identity is seen it's linked to the user with the same email, or a new
+// user without a usable password, but only if the provider has verified the
+// address.
+func (cfg *apiConfig) ssoUser(providerName string, identity sso.Identity) (*database.User, error) {
+	linked, err := cfg.db.GetUserIdentity(providerName, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if linked.Subject != "" {
+		user, err := cfg.db.GetUser(linked.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("user %s linked to %s identity is gone", linked.UserID, providerName)
+		}
+		return user, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errSSOEmailNotVerified
+	}
+
+	existing, err := cfg.db.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	user := &existing
+	if existing.ID == uuid.Nil {
+		hashedPassword, err := auth.MakeUnusablePasswordHash()
+		if err != nil {
+			return nil, err
+		}
+		user, err = cfg.db.CreateUser(database.CreateUserParams{
+			Email:    identity.Email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = cfg.db.CreateUserIdentity(providerName, identity.Subject, user.ID, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
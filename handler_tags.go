@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxTagLength     = 40
+	maxTagsPerVideo  = 20
+	defaultTagsLimit = 10
+	maxTagsLimit     = 50
+)
+
+// normalizeTag lowercases a tag and collapses its whitespace so "Go  Lang"
+// and "go lang" are the same tag
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" {
+		return "", errors.New("tags can't be empty")
+	}
+	if utf8.RuneCountInString(tag) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+			return "", fmt.Errorf("tag %q can only contain letters, digits, spaces, - and _", tag)
+		}
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes each tag and drops duplicates
+func normalizeTags(raw []string) ([]string, error) {
+	tags := make([]string, 0, len(raw))
+	for _, tag := range raw {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !video.VisibleTo(userID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	tags, err := cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	cfg.respondWithList(w, r, tags, completeList(len(tags)))
+}
+
+func (cfg *apiConfig) handlerVideoTagsSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tags, err := normalizeTags(params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(tags) > maxTagsPerVideo {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A video can have at most %d tags", maxTagsPerVideo), nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoTags(video.ID, tags); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save tags", err)
+		return
+	}
+
+	tags, err = cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	cfg.respondWithList(w, r, tags, completeList(len(tags)))
+}
+
+// handlerTagsSearch autocompletes tags, most used first
+func (cfg *apiConfig) handlerTagsSearch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := strings.ToLower(strings.Join(strings.Fields(query.Get("prefix")), " "))
+	if utf8.RuneCountInString(prefix) > maxTagLength {
+		cfg.respondWithList(w, r, []database.TagCount{}, completeList(0))
+		return
+	}
+	limit := defaultTagsLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxTagsLimit)
+	}
+
+	tags, err := cfg.db.SearchTags(userID, prefix, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search tags", err)
+		return
+	}
+	// only the top matches are returned, so there's no total
+	cfg.respondWithList(w, r, tags, pagination{})
+}
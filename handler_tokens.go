@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+const (
+	defaultScopedTokenTTL = time.Hour
+	// as long as the access tokens sign-in hands out
+	maxScopedTokenTTL = 30 * 24 * time.Hour
+)
+
+// handlerScopedTokenCreate mints a JWT that can only be used for the given
+// scopes, to hand to something that needs to do one job, like a thumbnail
+// generator. A scoped token can mint narrower tokens but never broader ones.
+func (cfg *apiConfig) handlerScopedTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Scopes           []auth.Scope `json:"scopes"`
+		ExpiresInSeconds int          `json:"expires_in_seconds"`
+	}
+	type response struct {
+		Token     string       `json:"token"`
+		Scopes    []auth.Scope `json:"scopes"`
+		ExpiresAt time.Time    `json:"expires_at"`
+	}
+
+	if p, ok := cfg.requestPrincipal(r); ok && p.viaAPIKey() {
+		respondWithError(w, http.StatusForbidden, "Tokens can't be made with an API key", nil)
+		return
+	}
+	caller, ok := cfg.requireJWT(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+		if !caller.allows(scope) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Token doesn't have the %s scope", scope), nil)
+			return
+		}
+	}
+	ttl := defaultScopedTokenTTL
+	if params.ExpiresInSeconds != 0 {
+		ttl = time.Duration(params.ExpiresInSeconds) * time.Second
+		if ttl <= 0 || ttl > maxScopedTokenTTL {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(maxScopedTokenTTL.Seconds())), nil)
+			return
+		}
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+	scoped, err := auth.MakeJWT(caller.UserID, cfg.jwtSecret, ttl, params.Scopes...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     scoped,
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+}
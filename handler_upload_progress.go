@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// how often an idle progress stream sends a comment so proxies keep it open
+const progressKeepAlive = 15 * time.Second
+
+type uploadProgressEvent struct {
+	UploadID     uuid.UUID   `json:"upload_id"`
+	Status       jobStatus   `json:"status"`
+	Stage        uploadStage `json:"stage,omitempty"`
+	StagePercent int         `json:"stage_percent"`
+	Percent      int         `json:"percent"`
+	Error        string      `json:"error,omitempty"`
+}
+
+func (cfg *apiConfig) handlerUploadProgress(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.getOwnedUploadJob(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming isn't supported", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+
+	var last uploadProgressEvent
+	for {
+		// grab the channel before the snapshot so no change is missed
+		changed := job.watch()
+		snapshot := job.snapshot()
+
+		event := progressEventFromSnapshot(snapshot)
+		if event != last {
+			name := "progress"
+			if snapshot.FinishedAt != nil {
+				name = "done"
+			}
+			if err := writeSSE(w, name, event); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = event
+		}
+		if snapshot.FinishedAt != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func progressEventFromSnapshot(s uploadJobSnapshot) uploadProgressEvent {
+	event := uploadProgressEvent{
+		UploadID: s.ID,
+		Status:   s.Status,
+		Percent:  s.Percent,
+		Error:    s.Error,
+	}
+	// report the stage being worked on, or the last one that got anywhere
+	for _, stage := range s.Stages {
+		if stage.Status == jobStatusRunning {
+			event.Stage = stage.Name
+			event.StagePercent = stage.Progress
+			break
+		}
+		if stage.Status != jobStatusQueued && stage.Status != jobStatusSkipped {
+			event.Stage = stage.Name
+			event.StagePercent = stage.Progress
+		}
+	}
+	return event
+}
+
+func writeSSE(w http.ResponseWriter, event string, payload interface{}) error {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, dat)
+	return err
+}
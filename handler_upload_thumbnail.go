@@ -1,123 +1,180 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"image"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.pathVideoID(r)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeThumbnailsWrite)
+	if !ok {
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	slog.DebugContext(r.Context(), "uploading thumbnail", "video_id", videoID, "user_id", userID)
 
 	// TODO: implement the upload here
 
-	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
+	data, ok := cfg.readImageUpload(w, r, "thumbnail")
+	if !ok {
 		return
 	}
 
-	file, header, err := r.FormFile("thumbnail")
+	videoMetaData, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithError(w, http.StatusNotFound, "video not found", err)
 		return
 	}
-	defer file.Close()
 
-	contentType := header.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+	if videoMetaData.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "user is not video owner", err)
 		return
 	}
 
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Only JPEG and PNG images are allowed", nil)
+	scanned, ok := cfg.scanThumbnail(w, r, videoMetaData, data)
+	if !ok {
 		return
 	}
 
-	parts := strings.Split(mediaType, "/")
-	extension := parts[1]
-
-	randomBytes := make([]byte, 32)
-	rand.Read(randomBytes)
-	encoded := base64.RawURLEncoding.EncodeToString(randomBytes)
+	// re-encoding also drops the EXIF data, which can hold a location
+	img, err := decodeThumbnail(data)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+	if cfg.thumbnail.crop {
+		img = cropToAspect(img, thumbnailLarge)
+	}
 
-	filePath := filepath.Join(cfg.assetsRoot, fmt.Sprintf("%s.%s", encoded, extension))
+	name := randomImageName()
+	sizes := []struct {
+		name string
+		size image.Point
+		url  **string
+	}{
+		{name, thumbnailLarge, &videoMetaData.ThumbnailURL},
+		{name + "-small", thumbnailSmall, &videoMetaData.ThumbnailSmallURL},
+	}
+	for _, s := range sizes {
+		url, err := cfg.writeImage(r.Context(), resizeToFit(img, s.size), s.name)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+			return
+		}
+		*s.url = &url
+	}
 
-	err = os.MkdirAll(cfg.assetsRoot, 0755)
+	err = cfg.db.UpdateVideo(videoMetaData)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't create assets directory", err)
+		respondWithError(w, http.StatusInternalServerError, "couldn't update video", err)
+		return
+	}
+	if err := cfg.db.UpdateThumbnailScan(videoMetaData.ID, scanned); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't update video", err)
 		return
 	}
+	videoMetaData.ThumbnailScan = scanned
+
+	slog.DebugContext(r.Context(), "updated thumbnail", "video_id", videoMetaData.ID, "url", *videoMetaData.ThumbnailURL)
+	cfg.recordVideoEvent(videoMetaData.ID, "", database.EventThumbnailUpdated, "")
+	cfg.sendWebhookEvent(videoMetaData.ID, webhookThumbnail, nil)
+
+	respondWithJSON(w, http.StatusOK, videoMetaData)
+}
 
-	// Then create the file
-	newFile, err := os.Create(filePath)
+// readImageUpload reads the image in the form file field, checking its size,
+// declared type and contents, and writes the error response itself if
+// anything is wrong
+func (cfg *apiConfig) readImageUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, bool) {
+	limit := cfg.uploadLimits[mediaKindImage]
+	if !limitUploadBody(w, r, limit) {
+		return nil, false
+	}
+	err := r.ParseMultipartForm(limit.multipartMemory)
+	if respondIfTooLarge(w, err) {
+		return nil, false
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "video could not be created", err)
-		return
+		respondWithError(w, http.StatusBadRequest, "Unable to parse multipart form", err)
+		return nil, false
 	}
-	defer newFile.Close()
 
-	_, err = io.Copy(newFile, file)
+	file, header, err := r.FormFile(field)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't copy file", err)
-		return
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return nil, false
 	}
+	defer file.Close()
 
-	videoMetaData, err := cfg.db.GetVideo(videoID)
+	contentType := header.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "video not found", err)
-		return
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+		return nil, false
 	}
 
-	if videoMetaData.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "user is not video owner", err)
-		return
+	imageType, ok := cfg.mediaTypes.lookup(mediaType, mediaKindImage)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Only %s images are allowed", cfg.mediaTypes.describe(mediaKindImage)), nil)
+		return nil, false
 	}
 
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s.%s", cfg.port, encoded, extension)
-	videoMetaData.ThumbnailURL = &thumbnailURL
+	sniffed, err := readSniffHeader(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+		return nil, false
+	}
+	if err := imageType.validate(sniffed); err != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return nil, false
+	}
 
-	err = cfg.db.UpdateVideo(videoMetaData)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't update video", err)
-		return
+		respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+		return nil, false
 	}
+	return data, true
+}
 
-	// Add debug logging here
-	fmt.Printf("After update - Video ID: %s\n", videoMetaData.ID)
-	fmt.Printf("Thumbnail URL: %v\n", *videoMetaData.ThumbnailURL)
-	fmt.Printf("Updated video metadata: %+v\n", videoMetaData)
+// randomImageName makes an unguessable name for a new set of image files
+func randomImageName() string {
+	randomBytes := make([]byte, 32)
+	rand.Read(randomBytes)
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
 
-	respondWithJSON(w, http.StatusOK, videoMetaData)
+// writeImage encodes img in the thumbnail format, saves it to the assets
+// directory as name and returns its URL
+func (cfg *apiConfig) writeImage(ctx context.Context, img image.Image, name string) (string, error) {
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		return "", err
+	}
+	dat, err := encodeThumbnail(ctx, cfg.media, img, cfg.thumbnail)
+	if err != nil {
+		return "", err
+	}
+	fileName := fmt.Sprintf("%s.%s", name, cfg.thumbnail.format.extension())
+	if err := os.WriteFile(filepath.Join(cfg.assetsRoot, fileName), dat, 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName), nil
 }
@@ -1,216 +1,1041 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"log/slog"
 	"math"
-	"mime"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
 	"github.com/google/uuid"
 )
 
+type FFProbeStream struct {
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	Channels     int    `json:"channels"`
+	// phones record in sensor orientation and say how to turn the picture
+	// with a display matrix, or a rotate tag in older files
+	Tags struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// rotation returns how many degrees clockwise the picture is turned for
+// display, from 0 to 359
+func (s FFProbeStream) rotation() int {
+	degrees := 0
+	found := false
+	for _, sideData := range s.SideDataList {
+		if sideData.Rotation != 0 {
+			// the display matrix angle is counterclockwise
+			degrees = -int(math.Round(sideData.Rotation))
+			found = true
+			break
+		}
+	}
+	if !found && s.Tags.Rotate != "" {
+		degrees, _ = strconv.Atoi(s.Tags.Rotate)
+	}
+	return ((degrees % 360) + 360) % 360
+}
+
+// displaySize is the width and height the video is shown at, which are
+// swapped from the coded size when it's turned on its side
+func (s FFProbeStream) displaySize() (int, int) {
+	if s.rotation()%180 == 90 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
+
 type FFProbeOutput struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
+	Streams []FFProbeStream `json:"streams"`
+	Format  struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+var errUnsupportedVideo = errors.New("unsupported video")
+
+// codecs that can be copied into an MP4 container as-is
+var (
+	mp4VideoCodecs = map[string]bool{"h264": true, "hevc": true, "av1": true, "mpeg4": true}
+	mp4AudioCodecs = map[string]bool{"aac": true, "mp3": true, "alac": true, "ac3": true, "eac3": true}
+)
+
+type receivedUpload struct {
+	video    database.Video
+	job      *uploadJob
+	tempPath string
+	// streamed is set instead of tempPath when the video went straight to
+	// the object store
+	streamed *streamedUpload
+}
+
+// uploadedVideo is the video a finished upload made, with what it'll cost
+type uploadedVideo struct {
+	database.Video
+	CostEstimate costEstimate `json:"cost_estimate"`
 }
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.receiveVideoUpload(w, r)
+	if !ok {
+		return
+	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	// during a blackout window the upload is queued like a v2 upload instead
+	// of holding the request open until it closes
+	if _, deferred := cfg.uploadBlackoutUntil(time.Now()); deferred {
+		respondWithJSON(w, http.StatusAccepted, cfg.processUploadInBackground(upload))
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	defer os.Remove(upload.tempPath)
+	job := upload.job
+
+	video, err := cfg.processReceivedUpload(r.Context(), upload)
+	if errors.Is(err, errUnsupportedVideo) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return
+	}
+	if errors.Is(err, errBlockedContent) {
+		respondWithError(w, http.StatusUnavailableForLegalReasons, "This video can't be uploaded", err)
+		return
+	}
+	if errors.Is(err, errMalware) {
+		respondWithError(w, http.StatusUnprocessableEntity, "The video failed the malware scan", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process video", err)
 		return
 	}
 
-	videoIDString := r.PathValue("videoID")
-	uuid, err := uuid.Parse(videoIDString)
+	for _, warning := range job.snapshot().Warnings {
+		w.Header().Add("X-Upload-Warning", warning)
+	}
+	respondWithJSON(w, http.StatusOK, uploadedVideo{
+		Video:        video,
+		CostEstimate: cfg.videoCost(video),
+	})
+}
+
+// receiveVideoUpload authenticates the request, checks ownership, registers
+// the upload job and copies the uploaded video to a temp file. It writes the
+// error response itself and returns false if anything goes wrong; the caller
+// must remove the temp file.
+func (cfg *apiConfig) receiveVideoUpload(w http.ResponseWriter, r *http.Request) (receivedUpload, bool) {
+	if cfg.jobs.isClosed() {
+		w.Header().Set("Retry-After", "30")
+		respondWithError(w, http.StatusServiceUnavailable, "The server is restarting, try again shortly", nil)
+		return receivedUpload{}, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return receivedUpload{}, false
+	}
+
+	videoID, err := cfg.pathVideoID(r)
 	if err != nil {
 		http.Error(w, "invalid videoID", http.StatusBadRequest)
-		return
+		return receivedUpload{}, false
 	}
 
-	videoMetaData, err := cfg.db.GetVideo(uuid)
+	videoMetaData, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "video not found", err)
-		return
+		return receivedUpload{}, false
 	}
 
-	// Add debug line here
-	fmt.Printf("Debug: videoMetaData.UserID = %v, userID = %v\n", videoMetaData.UserID, userID)
-
 	if videoMetaData.UserID != userID {
 		respondWithError(w, http.StatusUnauthorized, "user is not video owner", err)
-		return
+		return receivedUpload{}, false
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
-	err = r.ParseMultipartForm(1 << 30)
-	if err != nil {
-		http.Error(w, "unable to parse form data", http.StatusBadRequest)
-		return
+	// clients that want to follow the receiving stage pick the upload ID
+	// themselves so they can open the progress stream while the body is sent
+	uploadID := uuid.New()
+	if header := r.Header.Get("X-Upload-ID"); header != "" {
+		uploadID, err = uuid.Parse(header)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid X-Upload-ID header", err)
+			return receivedUpload{}, false
+		}
 	}
 
-	file, fileHeader, err := r.FormFile("video") // Assuming "video" is the form key
-	if err != nil {
-		http.Error(w, "unable to extract video file from form data", http.StatusBadRequest)
-		return
+	limit := cfg.uploadLimits[mediaKindVideo]
+	if !limitUploadBody(w, r, limit) {
+		return receivedUpload{}, false
+	}
+	if err := cfg.checkUploadSpace(r.ContentLength); err != nil {
+		respondWithInsufficientDisk(w, err)
+		return receivedUpload{}, false
 	}
-	defer file.Close()
 
-	contentType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
-	if err != nil {
-		http.Error(w, "invalid Content-Type header", http.StatusBadRequest)
-		return
+	videoMetaData, ok = cfg.pickStorageRegion(w, r, videoMetaData)
+	if !ok {
+		return receivedUpload{}, false
 	}
 
-	// Check if it's an MP4
-	if contentType != "video/mp4" {
-		http.Error(w, "only MP4 videos are accepted", http.StatusBadRequest)
-		return
+	job, ok := cfg.startUploadJob(r.Context(), uploadID, videoMetaData, userID, r.ContentLength)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Upload ID is already in use", nil)
+		return receivedUpload{}, false
+	}
+	received := false
+	receiveErr := errors.New("upload wasn't received")
+	defer func() {
+		if !received {
+			cfg.metrics.uploads.Inc(string(jobStatusFailed))
+			job.finish(jobStatusFailed, receiveErr)
+		}
+	}()
+
+	body := newProgressReader(r.Body, func(read int64) {
+		job.setProgress(stageReceiving, read, r.ContentLength)
+	})
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	// passthrough stores uploads before they could be scanned, and keeps no
+	// copy to take frames from for moderation
+	if cfg.uploadPassthrough && cfg.scanner == nil && cfg.moderator == nil {
+		upload, ok := cfg.receivePassthroughUpload(w, r, videoMetaData, job)
+		received = ok
+		return upload, ok
+	}
+	part, ok := cfg.openVideoPart(w, r)
+	if !ok {
+		return receivedUpload{}, false
 	}
 
 	// Create temporary file
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	tempFile, err := os.CreateTemp(cfg.tempDir, "tubely-upload-*"+part.videoType.Extension)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "couldn't create temp file", err)
-		return
+		return receivedUpload{}, false
 	}
-	// Important: defer cleanup in LIFO order
-	// Remove should happen after Close
-	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
-	// Copy uploaded file to temp file
-	_, err = io.Copy(tempFile, file)
+	// Copy uploaded file to temp file, hashing it on the way
+	hash := sha256.New()
+	_, span := tracing.Start(r.Context(), "copy upload to temp file", tracing.KindInternal)
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), newContextReader(r.Context(), part.file))
+	span.SetAttributes(tracing.Int64("upload.size", size))
+	span.End(err)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't copy to temp file", err)
-		return
+		os.Remove(tempFile.Name())
+		if respondIfTooLarge(w, err) {
+			receiveErr = err
+			return receivedUpload{}, false
+		}
+		// a body that ends early is almost always a dropped connection, so
+		// keep the numbers around for whoever debugs the failed upload
+		if m, ok := checkUploadLength(lengthSourceBody, r.ContentLength, body.read); ok && body.read < m.Declared {
+			cfg.lengthMismatch(job, m)
+			receiveErr = m
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+		return receivedUpload{}, false
+	}
+
+	// the rest of the form follows the video, read it before comparing
+	// against Content-Length
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		os.Remove(tempFile.Name())
+		respondWithError(w, http.StatusBadRequest, "Couldn't read request body", err)
+		return receivedUpload{}, false
+	}
+	if m, ok := checkUploadLength(lengthSourceBody, r.ContentLength, body.read); ok && cfg.lengthMismatch(job, m) {
+		os.Remove(tempFile.Name())
+		receiveErr = m
+		respondWithError(w, http.StatusBadRequest, "Upload size doesn't match Content-Length", m)
+		return receivedUpload{}, false
+	}
+	if m, ok := checkUploadLength(lengthSourcePart, partContentLength(part.Header), size); ok && cfg.lengthMismatch(job, m) {
+		os.Remove(tempFile.Name())
+		receiveErr = m
+		respondWithError(w, http.StatusBadRequest, "Video size doesn't match the part's Content-Length", m)
+		return receivedUpload{}, false
 	}
 
-	// Reset file pointer to beginning for subsequent reads
-	_, err = tempFile.Seek(0, io.SeekStart)
+	job.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	job.Filename = cleanFilename(part.FileName())
+	job.received(size)
+	received = true
+
+	return receivedUpload{
+		video:    videoMetaData,
+		job:      job,
+		tempPath: tempFile.Name(),
+	}, true
+}
+
+// startUploadJob registers a job for an upload that's about to be received,
+// skipping the stages this server isn't configured to run. It returns false
+// if the upload ID is already taken.
+func (cfg *apiConfig) startUploadJob(ctx context.Context, id uuid.UUID, video database.Video, userID uuid.UUID, size int64) (*uploadJob, bool) {
+	job := newUploadJob(id, video, userID, size)
+	job.RequestID = requestIDFrom(ctx)
+	job.Trace = tracing.SpanContextFrom(ctx)
+	if cfg.scanner == nil {
+		job.setStage(stageScanning, jobStatusSkipped)
+	}
+	if cfg.blankVideoMode == blankVideoOff {
+		job.setStage(stageAnalyzing, jobStatusSkipped)
+	}
+	if len(cfg.renditionLadder) == 0 {
+		job.setStage(stageRenditions, jobStatusSkipped)
+	}
+	if cfg.previewFormat == previewOff {
+		job.setStage(stagePreview, jobStatusSkipped)
+	}
+	if cfg.spriteInterval <= 0 {
+		job.setStage(stageSprites, jobStatusSkipped)
+	}
+	if cfg.moderator == nil {
+		job.setStage(stageModeration, jobStatusSkipped)
+	}
+	return job, cfg.jobs.add(job)
+}
+
+// processVideoUpload runs the probe, analysis, faststart and upload stages for a
+// received file and returns the updated video.
+func (cfg *apiConfig) processVideoUpload(ctx context.Context, job *uploadJob, tempFilePath string) (database.Video, error) {
+	release, err := cfg.waitForUploadWindow(ctx, job)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't reset temp file pointer", err)
-		return
+		return database.Video{}, cfg.finishJob(ctx, job, err)
 	}
+	defer release()
 
-	// Get aspect ratio
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	job.start()
+	ctx = withUploadJob(ctx, job)
+	if err := cfg.db.UpdateVideoProcessingStatus(job.VideoID, database.ProcessingStatusProcessing); err != nil {
+		slog.WarnContext(ctx, "Couldn't mark video as processing", "error", err)
+	}
+
+	// nothing else opens an upload before it's scanned
+	err = cfg.runStage(job, stageScanning, func() error {
+		return cfg.scanUpload(ctx, job, tempFilePath)
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't determine aspect ratio", err)
-		return
+		return database.Video{}, cfg.finishJob(ctx, job, err)
 	}
 
 	// Determine prefix based on aspect ratio
 	var prefix string
-	switch aspectRatio {
-	case "16:9":
-		prefix = "landscape/"
-	case "9:16":
-		prefix = "portrait/"
-	default:
-		prefix = "other/"
+	var probe FFProbeOutput
+	err = cfg.runStage(job, stageProbing, func() error {
+		var err error
+		probe, err = probeVideo(ctx, cfg.media, tempFilePath)
+		if err != nil {
+			return fmt.Errorf("couldn't probe video: %w", err)
+		}
+		prefix, err = probe.storagePrefix()
+		return err
+	})
+	if err != nil {
+		return database.Video{}, cfg.finishJob(ctx, job, err)
 	}
+	job.setCostEstimate(cfg.plannedCost(job.Size, &probe))
 
-	// Generate random hex for filename
-	randomHex := make([]byte, 16)
-	_, err = rand.Read(randomHex)
+	// uploads on the blocklist go no further; quarantined ones are kept
+	// aside for review first
+	err = cfg.runStage(job, stageScreening, func() error {
+		if job.SHA256 == "" {
+			sum, err := fileSHA256(tempFilePath)
+			if err != nil {
+				return fmt.Errorf("couldn't hash upload: %w", err)
+			}
+			job.SHA256 = sum
+		}
+		err := cfg.screenUpload(ctx, tempFilePath, job.SHA256, probe.duration())
+		var match *blocklistMatch
+		if !errors.As(err, &match) {
+			if err == nil {
+				cfg.checkDuplicateUpload(job)
+			}
+			return err
+		}
+		message := match.Error()
+		if match.entry.Action == database.BlocklistQuarantine {
+			key, qerr := cfg.quarantineUpload(ctx, job, tempFilePath)
+			if qerr != nil {
+				err = fmt.Errorf("couldn't quarantine upload: %v: %w", qerr, err)
+			} else {
+				message += ", quarantined at " + key
+			}
+		}
+		cfg.recordJobEvent(job, stageScreening, database.EventContentBlocked, message)
+		return err
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't generate random hex", err)
-		return
+		return database.Video{}, cfg.finishJob(ctx, job, err)
+	}
+
+	// a failed analysis is recorded but doesn't stop the upload
+	if cfg.blankVideoMode != blankVideoOff {
+		cfg.runStage(job, stageAnalyzing, func() error {
+			report, err := detectBlankVideo(ctx, cfg.media, tempFilePath)
+			if err != nil {
+				return err
+			}
+			for _, warning := range report.warnings() {
+				cfg.recordJobEvent(job, stageAnalyzing, database.EventContentFlagged, warning)
+				if cfg.blankVideoMode == blankVideoWarn {
+					job.addWarning(warning)
+				}
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return database.Video{}, cfg.finishJob(ctx, job, ctx.Err())
+		}
 	}
-	key := prefix + fmt.Sprintf("%x.mp4", randomHex)
 
 	// processing step
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	var processedFilePath string
+	var stored FFProbeOutput
+	err = cfg.runStage(job, stageProcessing, func() error {
+		// streams that MP4 can hold are only remuxed, everything else is
+		// transcoded to H.264/AAC. Transcoding applies any rotation, so
+		// rotated videos are transcoded too when it should be baked in.
+		transcode := !probe.mp4Compatible() || (cfg.bakeVideoRotation && probe.rotation() != 0)
+		processedFilePath, err = processVideoForFastStart(ctx, cfg.media, tempFilePath, transcode, func(done time.Duration) {
+			job.setProgress(stageProcessing, int64(done), int64(probe.duration()))
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't convert video to MP4: %w", err)
+		}
+		// describe the file we're storing, which may have been transcoded
+		stored, err = probeVideo(ctx, cfg.media, processedFilePath)
+		if err != nil {
+			return fmt.Errorf("couldn't probe processed video: %w", err)
+		}
+		return nil
+	})
+	if processedFilePath != "" {
+		defer os.Remove(processedFilePath) // Clean up the processed file when done
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't process video for fast start", err)
-		return
+		return database.Video{}, cfg.finishJob(ctx, job, err)
 	}
+	cfg.saveMediaReport(ctx, job, tempFilePath, processedFilePath)
 
-	processedFile, err := os.Open(processedFilePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't open processed video", err)
-		return
+	// renditions step
+	var renditions []renderedRendition
+	defer func() {
+		for _, rendition := range renditions {
+			os.Remove(rendition.path)
+		}
+	}()
+	if len(cfg.renditionLadder) > 0 {
+		err = cfg.runStage(job, stageRenditions, func() error {
+			stream, _ := stored.videoStream()
+			width, height := stream.displaySize()
+			specs := cfg.renditionsFor(stored)
+			duration := int64(stored.duration())
+			for i, spec := range specs {
+				path, err := transcodeRendition(ctx, cfg.media, processedFilePath, spec, height > width, func(done time.Duration) {
+					job.setProgress(stageRenditions, int64(i)*duration+int64(done), int64(len(specs))*duration)
+				})
+				if err != nil {
+					return fmt.Errorf("couldn't transcode %s rendition: %w", spec.name, err)
+				}
+				renditions = append(renditions, renderedRendition{spec: spec, path: path})
+
+				probe, err := probeVideo(ctx, cfg.media, path)
+				if err != nil {
+					return fmt.Errorf("couldn't probe %s rendition: %w", spec.name, err)
+				}
+				if stream, ok := probe.videoStream(); ok {
+					renditions[i].width, renditions[i].height = stream.displaySize()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return database.Video{}, cfg.finishJob(ctx, job, err)
+		}
+	}
+
+	// a failed preview is recorded but doesn't stop the upload
+	var previewPath string
+	if cfg.previewFormat != previewOff {
+		cfg.runStage(job, stagePreview, func() error {
+			duration := stored.duration()
+			path, err := generatePreview(ctx, cfg.media, processedFilePath, duration, cfg.previewFormat, func(done time.Duration) {
+				job.setProgress(stagePreview, int64(done), int64(min(duration, previewDuration)))
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't generate preview: %w", err)
+			}
+			previewPath = path
+			return nil
+		})
+		if previewPath != "" {
+			defer os.Remove(previewPath)
+		}
+		if ctx.Err() != nil {
+			return database.Video{}, cfg.finishJob(ctx, job, ctx.Err())
+		}
 	}
-	defer os.Remove(processedFilePath) // Clean up the processed file when done
-	defer processedFile.Close()
 
-	// Upload to S3
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key), // Use the key with prefix
-		Body:        processedFile,
-		ContentType: aws.String("video/mp4"),
+	// the seek-bar sprite is optional too
+	var sprite spriteSheet
+	if cfg.spriteInterval > 0 {
+		cfg.runStage(job, stageSprites, func() error {
+			duration := stored.duration()
+			sheet, err := generateSpriteSheet(ctx, cfg.media, processedFilePath, duration, cfg.spriteInterval, func(done time.Duration) {
+				job.setProgress(stageSprites, int64(done), int64(duration))
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't generate sprite sheet: %w", err)
+			}
+			sprite = sheet
+			return nil
+		})
+		if sprite.path != "" {
+			defer os.Remove(sprite.path)
+		}
+		if ctx.Err() != nil {
+			return database.Video{}, cfg.finishJob(ctx, job, ctx.Err())
+		}
+	}
+
+	// moderation keeps the video hidden until it's approved, even when the
+	// moderator fails
+	if cfg.moderator != nil {
+		cfg.runStage(job, stageModeration, func() error {
+			return cfg.moderateUpload(ctx, job, processedFilePath, stored.duration())
+		})
+		if ctx.Err() != nil {
+			return database.Video{}, cfg.finishJob(ctx, job, ctx.Err())
+		}
+	}
+
+	err = cfg.runStage(job, stageUploading, func() (err error) {
+		// identical videos share one object named after their checksum
+		storedSHA256, err := fileSHA256(processedFilePath)
+		if err != nil {
+			return fmt.Errorf("couldn't hash processed video: %w", err)
+		}
+		key := cfg.keyLayout.Key(job.UserID, contentKey(prefix, storedSHA256))
+
+		// progress covers the video, its renditions, the preview and the sprite
+		uploads := append([]string{processedFilePath}, renditionPaths(renditions)...)
+		if previewPath != "" {
+			uploads = append(uploads, previewPath)
+		}
+		if sprite.path != "" {
+			uploads = append(uploads, sprite.path)
+		}
+		var total int64
+		for _, path := range uploads {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("couldn't stat processed video: %w", err)
+			}
+			total += info.Size()
+		}
+		var sent int64
+		progress := func(read int64) {
+			job.setProgress(stageUploading, sent+read, total)
+		}
+
+		target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+		if err != nil {
+			return fmt.Errorf("couldn't resolve object store: %w", err)
+		}
+		// if the upload fails or is canceled part way, nothing will point at
+		// what's been stored so far
+		var partial []string
+		defer func() {
+			if err != nil {
+				deletePartialObjects(target, partial)
+			}
+		}()
+
+		// Upload to the object store, unless another video already did
+		size, err := cfg.putContentObject(cfg.videoPutContext(ctx, objectOriginal), target, job.OrganizationID, job.StorageRegion, key, processedFilePath, storedSHA256, progress)
+		if err != nil {
+			return fmt.Errorf("couldn't upload to object store: %w", err)
+		}
+		// the reference is the video's once its URL points at the object
+		defer func() {
+			if err != nil {
+				cfg.releaseContentObject(job.OrganizationID, job.StorageRegion, key)
+			}
+		}()
+		sent += size
+
+		uploaded := database.Renditions{}
+		for _, rendition := range renditions {
+			key, err := newVideoKey(cfg.keyLayout.Key(job.UserID, renditionPrefix(job.VideoID)) + rendition.spec.name + "-")
+			if err != nil {
+				return err
+			}
+			renditionSize, err := putFile(cfg.videoPutContext(ctx, objectRendition), target, key, rendition.path, "video/mp4", progress)
+			if err != nil {
+				return fmt.Errorf("couldn't upload %s rendition to object store: %w", rendition.spec.name, err)
+			}
+			partial = append(partial, key)
+			sent += renditionSize
+			uploaded = append(uploaded, database.Rendition{
+				Name:      rendition.spec.name,
+				Width:     rendition.width,
+				Height:    rendition.height,
+				URL:       target.objectURL(key),
+				SizeBytes: renditionSize,
+			})
+		}
+
+		var previewURL *string
+		if previewPath != "" {
+			key, err := newPreviewKey(job.VideoID, cfg.previewFormat)
+			if err != nil {
+				return err
+			}
+			key = cfg.keyLayout.Key(job.UserID, key)
+			previewSize, err := putFile(ctx, target, key, previewPath, cfg.previewFormat.contentType(), progress)
+			if err != nil {
+				return fmt.Errorf("couldn't upload preview to object store: %w", err)
+			}
+			partial = append(partial, key)
+			sent += previewSize
+			url := target.objectURL(key)
+			previewURL = &url
+		}
+
+		var spriteURLs database.SpriteURLs
+		if sprite.path != "" {
+			spriteURLs, err = uploadSpriteSheet(ctx, target, cfg.keyLayout.Key(job.UserID, spritePrefix(job.VideoID)), sprite, stored.duration(), progress)
+			if err != nil {
+				return err
+			}
+			for _, url := range []*string{spriteURLs.Sprite, spriteURLs.VTT} {
+				if key, ok := target.keyFromURL(*url); ok {
+					partial = append(partial, key)
+				}
+			}
+		}
+
+		if err = waitForObject(ctx, target, key, size); err != nil {
+			return fmt.Errorf("stored video isn't readable: %w", err)
+		}
+		if cfg.verifyStoredPlayback {
+			keys := []string{key}
+			for _, rendition := range uploaded {
+				if key, ok := target.keyFromURL(rendition.URL); ok {
+					keys = append(keys, key)
+				}
+			}
+			if err = cfg.verifyPlayback(ctx, target, keys, stored.duration()); err != nil {
+				return err
+			}
+		}
+		videoURL := target.objectURL(key)
+		slog.DebugContext(ctx, "stored video", "url", videoURL, "size", size)
+
+		// Update video URL in database
+		err = cfg.setVideoFile(ctx, job.VideoID, videoURL)
+		if err != nil {
+			return fmt.Errorf("couldn't update video URL: %w", err)
+		}
+		err = cfg.db.UpdateVideoMediaInfo(job.VideoID, stored.mediaInfo(size))
+		if err != nil {
+			return fmt.Errorf("couldn't update video media info: %w", err)
+		}
+		err = cfg.db.UpdateVideoChecksums(job.VideoID, storedSHA256, job.SHA256)
+		if err != nil {
+			return fmt.Errorf("couldn't update video checksums: %w", err)
+		}
+		err = cfg.db.UpdateVideoOriginalFilename(job.VideoID, job.Filename)
+		if err != nil {
+			return fmt.Errorf("couldn't update video filename: %w", err)
+		}
+		err = cfg.db.UpdateVideoRenditions(job.VideoID, uploaded)
+		if err != nil {
+			return fmt.Errorf("couldn't update video renditions: %w", err)
+		}
+		err = cfg.db.UpdateVideoPreviewURL(job.VideoID, previewURL)
+		if err != nil {
+			return fmt.Errorf("couldn't update video preview: %w", err)
+		}
+		err = cfg.db.UpdateVideoSpriteURLs(job.VideoID, spriteURLs)
+		if err != nil {
+			return fmt.Errorf("couldn't update video sprite: %w", err)
+		}
+		return nil
 	})
+	if err != nil {
+		return database.Video{}, cfg.finishJob(ctx, job, err)
+	}
 
+	// Get the updated video
+	video, err := cfg.db.GetVideo(job.VideoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't upload to S3", err)
-		return
+		return database.Video{}, cfg.finishJob(ctx, job, fmt.Errorf("couldn't get updated video: %w", err))
 	}
+	job.setCostEstimate(cfg.videoCost(video))
 
-	// Create comma-delimited string of bucket and key
-	videoURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
-	fmt.Printf("Debug: videoURL = %s\n", videoURL)
+	cfg.finishJob(ctx, job, nil)
+	return video, nil
+}
+
+func (cfg *apiConfig) runStage(job *uploadJob, stage uploadStage, fn func() error) error {
+	job.setStage(stage, jobStatusRunning)
+	cfg.recordJobEvent(job, stage, database.EventStageStarted, "")
 
-	// Update video URL in database
-	err = cfg.db.UpdateVideoURL(uuid, videoURL)
+	start := time.Now()
+	err := fn()
+	cfg.metrics.stageDuration.Observe(time.Since(start).Seconds(), string(stage), result(err))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't update video URL", err)
-		return
+		job.setStage(stage, jobStatusFailed)
+		cfg.recordJobFailure(job, stage, database.EventStageFailed, err)
+		return &stageError{stage: stage, err: err}
 	}
 
-	// Get the updated video
-	video, err := cfg.db.GetVideo(uuid)
+	job.setStage(stage, jobStatusCompleted)
+	cfg.recordJobEvent(job, stage, database.EventStageCompleted, "")
+	return nil
+}
+
+// finishJob records the outcome of a job and passes err through so callers
+// can return it directly.
+func (cfg *apiConfig) finishJob(ctx context.Context, job *uploadJob, err error) error {
+	status := database.ProcessingStatusFailed
+	defer func() {
+		if err := cfg.db.FinishVideoProcessing(job.VideoID, status); err != nil {
+			slog.WarnContext(withUploadJob(ctx, job), "Couldn't update processing status of video", "error", err)
+		}
+		// sent once the status is saved, so the payload has it
+		switch status {
+		case database.ProcessingStatusReady:
+			cfg.sendWebhookEvent(job.VideoID, webhookVideoReady, nil)
+		case database.ProcessingStatusFailed, database.ProcessingStatusQuarantined:
+			cfg.sendWebhookEvent(job.VideoID, webhookVideoFailed, err)
+		}
+	}()
+
+	cfg.metrics.uploadSize.Observe(float64(job.Size))
+	switch {
+	case err == nil:
+		cfg.metrics.uploads.Inc(string(jobStatusCompleted))
+		status = database.ProcessingStatusReady
+		elapsed := job.finish(jobStatusCompleted, nil)
+		cfg.jobs.recordThroughput(job.Size, elapsed)
+		cfg.recordJobEvent(job, "", database.EventUploadCompleted, "")
+		cfg.notifyUploadFinished(job, database.EventUploadCompleted, elapsed, nil)
+	case ctx.Err() != nil:
+		cfg.metrics.uploads.Inc(string(jobStatusCanceled))
+		status = database.ProcessingStatusAwaitingUpload
+		job.finish(jobStatusCanceled, err)
+		cfg.recordJobEvent(job, "", database.EventUploadCanceled, err.Error())
+	default:
+		cfg.metrics.uploads.Inc(string(jobStatusFailed))
+		var match *blocklistMatch
+		if errors.As(err, &match) && match.entry.Action == database.BlocklistQuarantine {
+			status = database.ProcessingStatusQuarantined
+		}
+		if errors.Is(err, errMalware) && cfg.scanAction == scanQuarantine {
+			status = database.ProcessingStatusQuarantined
+		}
+		elapsed := job.finish(jobStatusFailed, err)
+		var stage uploadStage
+		var se *stageError
+		if errors.As(err, &se) {
+			stage = se.stage
+		}
+		cfg.recordJobFailure(job, stage, database.EventUploadFailed, err)
+		cfg.notifyUploadFinished(job, database.EventUploadFailed, elapsed, err)
+	}
+	return err
+}
+
+func (cfg *apiConfig) recordJobEvent(job *uploadJob, stage uploadStage, eventType database.EventType, message string) {
+	err := cfg.db.CreateVideoEvent(database.CreateVideoEventParams{
+		VideoID:  job.VideoID,
+		UploadID: uuid.NullUUID{UUID: job.ID, Valid: true},
+		Stage:    string(stage),
+		Type:     eventType,
+		Message:  message,
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "couldn't get updated video", err)
-		return
+		log.Printf("Couldn't record %s event for upload %s: %v", eventType, job.ID, err)
 	}
+}
 
-	respondWithJSON(w, http.StatusOK, video)
+func (cfg *apiConfig) recordJobFailure(job *uploadJob, stage uploadStage, eventType database.EventType, jobErr error) {
+	err := cfg.db.CreateVideoEvent(database.CreateVideoEventParams{
+		VideoID:    job.VideoID,
+		UploadID:   uuid.NullUUID{UUID: job.ID, Valid: true},
+		Stage:      string(stage),
+		Type:       eventType,
+		Message:    jobErr.Error(),
+		ErrorClass: classifyError(stage, jobErr),
+	})
+	if err != nil {
+		log.Printf("Couldn't record %s event for upload %s: %v", eventType, job.ID, err)
+	}
+}
+
+// stageError remembers which pipeline stage an error came from
+type stageError struct {
+	stage uploadStage
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
 
+// classifyError buckets a pipeline error so the failures dashboard can spot
+// one cause hitting many uploads
+func classifyError(stage uploadStage, err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, errUnsupportedVideo):
+		return "unsupported_video"
+	case errors.Is(err, errBlockedContent):
+		return "blocked_content"
+	case errors.Is(err, errMalware):
+		return "malware"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, storage.ErrCircuitOpen):
+		return "storage_unavailable"
+	case errors.Is(err, errUnplayableOutput):
+		return "unplayable_output"
+	case errors.Is(err, exec.ErrNotFound):
+		return "tool_missing"
+	case errors.As(err, &exitErr):
+		// ffprobe or ffmpeg exited with an error
+		return "tool_failed"
+	case stage == stageUploading:
+		return "storage"
+	default:
+		return "internal"
+	}
+}
+
+func newVideoKey(prefix string) (string, error) {
+	// Generate random hex for filename
+	randomHex := make([]byte, 16)
+	_, err := rand.Read(randomHex)
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate random hex: %w", err)
+	}
+	return prefix + fmt.Sprintf("%x.mp4", randomHex), nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	// Your code here
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-	err := cmd.Run()
+// malformed files can make ffprobe hang or print megabytes of stream data,
+// so it gets a deadline and its output is capped
+const (
+	ffprobeTimeout   = 30 * time.Second
+	ffprobeMaxOutput = 1 << 20
+)
+
+func probeVideo(ctx context.Context, runner *media.Runner, filePath string) (FFProbeOutput, error) {
+	// only ask for the fields FFProbeOutput uses
+	args := []string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "stream=codec_type,codec_name,width,height,avg_frame_rate,channels:stream_tags=rotate:stream_side_data=rotation:format=format_name,duration,bit_rate",
+		filePath,
+	}
+	stdout := &cappedBuffer{limit: ffprobeMaxOutput}
+	err := runner.FFprobe(ctx, args, media.Options{Stdout: stdout, Timeout: ffprobeTimeout})
+	if stdout.exceeded {
+		return FFProbeOutput{}, fmt.Errorf("ffprobe output exceeded %d bytes", ffprobeMaxOutput)
+	}
 	if err != nil {
-		return "", err
+		return FFProbeOutput{}, err
 	}
 
 	var data FFProbeOutput
 	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
-		return "", err
+		return FFProbeOutput{}, err
+	}
+	return data, nil
+}
+
+// cappedBuffer fails writes once it holds limit bytes; exec then closes the
+// pipe, so a runaway process gets EPIPE instead of filling memory. The buffer
+// isn't embedded so io.Copy can't go around Write through its ReadFrom.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		b.exceeded = true
+		return 0, errors.New("output limit exceeded")
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// storagePrefix checks the video can be stored and returns the key prefix
+// for its aspect ratio
+func (p FFProbeOutput) storagePrefix() (string, error) {
+	if !p.supportedContainer() {
+		return "", fmt.Errorf("%w: container %q isn't supported", errUnsupportedVideo, p.Format.FormatName)
 	}
+	aspectRatio, err := p.aspectRatio()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedVideo, err)
+	}
+	switch aspectRatio {
+	case "16:9":
+		return "landscape/", nil
+	case "9:16":
+		return "portrait/", nil
+	default:
+		return "other/", nil
+	}
+}
 
-	if len(data.Streams) == 0 {
-		return "", fmt.Errorf("no streams found")
+func (p FFProbeOutput) videoStream() (FFProbeStream, bool) {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "video" {
+			return stream, true
+		}
 	}
+	return FFProbeStream{}, false
+}
 
-	width := data.Streams[0].Width
-	height := data.Streams[0].Height
+func (p FFProbeOutput) supportedContainer() bool {
+	for _, name := range strings.Split(p.Format.FormatName, ",") {
+		if slices.Contains(supportedContainers, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// duration returns the container's duration, or 0 if ffprobe didn't report one
+func (p FFProbeOutput) duration() time.Duration {
+	seconds, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// mediaInfo summarizes the first video and audio streams of a file of the
+// given size
+func (p FFProbeOutput) mediaInfo(size int64) database.MediaInfo {
+	info := database.MediaInfo{
+		SizeBytes:       size,
+		DurationSeconds: p.duration().Seconds(),
+	}
+	info.BitRate, _ = strconv.ParseInt(p.Format.BitRate, 10, 64)
+	if stream, ok := p.videoStream(); ok {
+		info.VideoCodec = stream.CodecName
+		info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+	}
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			info.AudioCodec = stream.CodecName
+			info.AudioChannels = stream.Channels
+			break
+		}
+	}
+	return info
+}
+
+// parseFrameRate reads ffprobe's "30000/1001" style rates, returning 0 when
+// the rate is unknown ("0/0")
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	if !found {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return math.Round(n/d*1000) / 1000
+}
+
+// rotation is the display rotation of the video stream
+func (p FFProbeOutput) rotation() int {
+	stream, ok := p.videoStream()
+	if !ok {
+		return 0
+	}
+	return stream.rotation()
+}
+
+func (p FFProbeOutput) mp4Compatible() bool {
+	for _, stream := range p.Streams {
+		switch stream.CodecType {
+		case "video":
+			if !mp4VideoCodecs[stream.CodecName] {
+				return false
+			}
+		case "audio":
+			if !mp4AudioCodecs[stream.CodecName] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p FFProbeOutput) aspectRatio() (string, error) {
+	stream, ok := p.videoStream()
+	if !ok {
+		return "", fmt.Errorf("no video stream found")
+	}
+
+	width, height := stream.displaySize()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("video stream has no dimensions")
+	}
 
 	ratio := float64(width) / float64(height)
 
@@ -231,7 +1056,9 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	return "other", nil
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+// processVideoForFastStart remuxes or transcodes filePath into a faststart MP4,
+// calling progress with how much of the video ffmpeg has written so far
+func processVideoForFastStart(ctx context.Context, runner *media.Runner, filePath string, transcode bool, progress func(done time.Duration)) (string, error) {
 	// Get the file's extension
 	ext := filepath.Ext(filePath)
 
@@ -241,21 +1068,78 @@ func processVideoForFastStart(filePath string) (string, error) {
 	// Append '.processing' before the extension
 	outputFilePath := base + ".processing" + ext
 
-	// Create the ffmpeg command
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", filePath, // Input file
-		"-c", "copy", // Copy codec
-		"-movflags", "faststart", // Fast start flag
-		"-f", "mp4", // Output format
-		outputFilePath, // Output file path
-	)
-
-	// Run the command and capture any errors
-	if err := cmd.Run(); err != nil {
+	// Create the ffmpeg command around the codec arguments, which copy the
+	// streams unless they can't go in an MP4
+	buildArgs := func(codecArgs []string) []string {
+		args := []string{
+			"-i", filePath, // Input file
+			"-map", "0:v:0", "-map", "0:a:0?", // First video and audio stream, other containers may carry more
+		}
+		args = append(args, codecArgs...)
+		return append(args,
+			"-movflags", "faststart", // Fast start flag
+			"-progress", "pipe:1", "-nostats", // Machine readable progress on stdout
+			"-f", "mp4", // Output format
+			outputFilePath, // Output file path
+		)
+	}
+	var err error
+	if transcode {
+		err = runH264Encode(ctx, runner, 23, outputFilePath, func(videoArgs []string) []string {
+			return buildArgs(append(videoArgs, "-c:a", "aac", "-b:a", "128k"))
+		}, progress)
+	} else {
+		err = runFFmpeg(ctx, runner, buildArgs([]string{"-c", "copy"}), progress)
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
 		return "", err // Return the error if the command fails
 	}
 
 	// Return the constructed file path
 	return outputFilePath, nil
 }
+
+// runH264Encode runs the ffmpeg command buildArgs makes around the video
+// codec arguments, encoding with the H.264 encoder picked at startup. A
+// hardware encoder that fails, say on a size or pixel format it doesn't
+// support, is retried with libx264 rather than failing the video.
+func runH264Encode(ctx context.Context, runner *media.Runner, crf int, outputFilePath string, buildArgs func(videoArgs []string) []string, progress func(done time.Duration)) error {
+	encoder := runner.H264Encoder()
+	err := runFFmpeg(ctx, runner, buildArgs(media.H264Args(encoder, crf)), progress)
+	if err == nil || encoder == media.EncoderLibx264 || ctx.Err() != nil {
+		return err
+	}
+	slog.WarnContext(ctx, "Hardware encode failed, retrying with libx264", "encoder", encoder, "output", outputFilePath, "error", err)
+	os.Remove(outputFilePath)
+	progress(0)
+	return runFFmpeg(ctx, runner, buildArgs(media.H264Args(media.EncoderLibx264, crf)), progress)
+}
+
+// runFFmpeg runs ffmpeg with args, which must include "-progress pipe:1", and
+// calls progress with how much of the video it has written so far
+func runFFmpeg(ctx context.Context, runner *media.Runner, args []string, progress func(done time.Duration)) error {
+	stdout, progressWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			// out_time_us is the position of the output written so far
+			value, ok := strings.CutPrefix(scanner.Text(), "out_time_us=")
+			if !ok {
+				continue
+			}
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				progress(time.Duration(us) * time.Microsecond)
+			}
+		}
+		// keep ffmpeg from blocking on a line too long for the scanner
+		io.Copy(io.Discard, stdout)
+	}()
+
+	err := runner.FFmpeg(ctx, args, media.Options{Stdout: progressWriter, Stderr: ffmpegLogFrom(ctx)})
+	progressWriter.Close()
+	<-done
+	return err
+}
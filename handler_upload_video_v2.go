@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/google/uuid"
+)
+
+type uploadLinks struct {
+	Status   string `json:"status"`
+	Cancel   string `json:"cancel"`
+	Events   string `json:"events"`
+	Progress string `json:"progress"`
+}
+
+type uploadResult struct {
+	Video                 database.Video  `json:"video"`
+	UploadID              uuid.UUID       `json:"upload_id"`
+	Plan                  []jobStageState `json:"plan"`
+	EstimatedCompletionAt *time.Time      `json:"estimated_completion_at"`
+	// CostEstimate is rough until processing has looked at the video; the
+	// status endpoint has the refined one
+	CostEstimate costEstimate `json:"cost_estimate"`
+	Links        uploadLinks  `json:"links"`
+}
+
+func (cfg *apiConfig) handlerUploadVideoV2(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.receiveVideoUpload(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, cfg.processUploadInBackground(upload))
+}
+
+// processUploadInBackground starts processing a received upload and returns
+// where the client can follow it. It takes over removing the temp file.
+func (cfg *apiConfig) processUploadInBackground(upload receivedUpload) uploadResult {
+	job := upload.job
+
+	// the job outlives the request, so it gets its own context that the
+	// cancel endpoint can stop
+	ctx := tracing.ContextWithRemoteParent(withRequestID(context.Background(), job.RequestID), job.Trace)
+	ctx, cancel := context.WithCancel(ctx)
+	job.setCancel(cancel)
+	planned := cfg.plannedCost(job.Size, nil)
+	job.setCostEstimate(planned)
+	go func() {
+		defer cancel()
+		defer os.Remove(upload.tempPath)
+		if _, err := cfg.processReceivedUpload(ctx, upload); err != nil {
+			slog.WarnContext(withUploadJob(ctx, job), "Upload failed", "error", err)
+		}
+	}()
+
+	result := uploadResult{
+		Video:        upload.video,
+		UploadID:     job.ID,
+		Plan:         job.snapshot().Stages,
+		CostEstimate: planned,
+		Links: uploadLinks{
+			Status:   fmt.Sprintf("/api/v2/uploads/%s", job.ID),
+			Cancel:   fmt.Sprintf("/api/v2/uploads/%s/cancel", job.ID),
+			Events:   fmt.Sprintf("/api/v2/videos/%s/events", job.VideoID),
+			Progress: fmt.Sprintf("/api/uploads/%s/progress", job.ID),
+		},
+	}
+	if d, ok := cfg.jobs.estimate(job.Size); ok {
+		start := time.Now().UTC()
+		if until, deferred := cfg.uploadBlackoutUntil(start); deferred {
+			start = until.UTC()
+		}
+		estimate := start.Add(d)
+		result.EstimatedCompletionAt = &estimate
+	}
+	return result
+}
+
+func (cfg *apiConfig) handlerUploadStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.getOwnedUploadJob(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job.snapshot())
+}
+
+func (cfg *apiConfig) handlerUploadCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.getOwnedUploadJob(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	if job.snapshot().FinishedAt != nil {
+		respondWithError(w, http.StatusConflict, "Upload has already finished", nil)
+		return
+	}
+	job.requestCancel()
+
+	respondWithJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func (cfg *apiConfig) getOwnedUploadJob(w http.ResponseWriter, r *http.Request, scope auth.Scope) (*uploadJob, bool) {
+	uploadIDString := r.PathValue("uploadID")
+	uploadID, err := uuid.Parse(uploadIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return nil, false
+	}
+
+	userID, ok := cfg.authenticate(w, r, scope)
+	if !ok {
+		return nil, false
+	}
+
+	job, ok := cfg.jobs.get(uploadID)
+	if !ok || job.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", nil)
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// the range exported when from isn't given
+const defaultAnalyticsExportRange = 30 * 24 * time.Hour
+
+var analyticsCSVHeader = []string{"type", "time", "viewer", "signed_in", "position_seconds"}
+
+type analyticsExportRow struct {
+	Type            database.AnalyticsEventType `json:"type"`
+	Time            time.Time                   `json:"time"`
+	Viewer          string                      `json:"viewer"`
+	SignedIn        bool                        `json:"signed_in"`
+	PositionSeconds *float64                    `json:"position_seconds,omitempty"`
+}
+
+// handlerVideoAnalyticsExport streams the raw views and heartbeats of one
+// of the caller's videos from from until to, as NDJSON or CSV. Like the
+// video export, an error after the headers can only cut it short.
+func (cfg *apiConfig) handlerVideoAnalyticsExport(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "format must be ndjson or csv", nil)
+		return
+	}
+	to := time.Now().UTC()
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "to must be an RFC 3339 time", err)
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-defaultAnalyticsExportRange)
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "from must be an RFC 3339 time", err)
+			return
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		respondWithError(w, http.StatusBadRequest, "from must be before to", nil)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	filename := "analytics-" + video.ID.String() + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		err = cfg.exportAnalyticsCSV(w, video.ID, from, to, flush)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = cfg.exportAnalyticsNDJSON(w, video.ID, from, to, flush)
+	}
+	if err != nil {
+		log.Printf("Analytics export of video %s failed: %v", video.ID, err)
+	}
+}
+
+func (cfg *apiConfig) exportAnalyticsCSV(w io.Writer, videoID uuid.UUID, from, to time.Time, flush func()) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(analyticsCSVHeader); err != nil {
+		return err
+	}
+
+	n := 0
+	err := cfg.db.EachVideoAnalyticsEvent(videoID, from, to, func(event database.AnalyticsEvent) error {
+		row := cfg.analyticsExportRow(videoID, event)
+		position := ""
+		if row.PositionSeconds != nil {
+			position = strconv.FormatFloat(*row.PositionSeconds, 'f', -1, 64)
+		}
+		if err := cw.Write([]string{
+			string(row.Type),
+			row.Time.Format(time.RFC3339),
+			row.Viewer,
+			strconv.FormatBool(row.SignedIn),
+			position,
+		}); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			cw.Flush()
+			flush()
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func (cfg *apiConfig) exportAnalyticsNDJSON(w io.Writer, videoID uuid.UUID, from, to time.Time, flush func()) error {
+	encoder := json.NewEncoder(w)
+	n := 0
+	return cfg.db.EachVideoAnalyticsEvent(videoID, from, to, func(event database.AnalyticsEvent) error {
+		if err := encoder.Encode(cfg.analyticsExportRow(videoID, event)); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+}
+
+func (cfg *apiConfig) analyticsExportRow(videoID uuid.UUID, event database.AnalyticsEvent) analyticsExportRow {
+	return analyticsExportRow{
+		Type:            event.Type,
+		Time:            event.CreatedAt,
+		Viewer:          cfg.analyticsViewer(videoID, event.Viewer),
+		SignedIn:        event.SignedIn,
+		PositionSeconds: event.PositionSeconds,
+	}
+}
+
+// analyticsViewer stands in for a viewer in exports. Creators can tell
+// viewers apart and follow one across views and heartbeats, but can't
+// learn their accounts or match them up between videos.
+func (cfg *apiConfig) analyticsViewer(videoID uuid.UUID, viewer string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	mac.Write(videoID[:])
+	mac.Write([]byte(viewer))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// hotStorageClass is where unarchived videos go back to, unless their kind
+// of file has a storage class of its own
+const hotStorageClass = "STANDARD"
+
+// setVideoStorageClass moves a video's file and renditions to another
+// storage class, or back to their hot ones for hotStorageClass. A file
+// shared with other videos is only ever moved back, since those videos may
+// still be listed.
+func (cfg *apiConfig) setVideoStorageClass(ctx context.Context, video database.Video, storageClass string) error {
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return err
+	}
+	setter, ok := target.store.(storage.StorageClassSetter)
+	if !ok {
+		return nil
+	}
+
+	urls := map[string]objectKind{}
+	if video.VideoURL != nil {
+		urls[*video.VideoURL] = objectOriginal
+	}
+	for _, rendition := range video.Renditions {
+		urls[rendition.URL] = objectRendition
+	}
+
+	var errs []error
+	for url, kind := range urls {
+		key, ok := target.keyFromURL(url)
+		if !ok {
+			continue
+		}
+		if storageClass != hotStorageClass {
+			refs, err := cfg.db.ObjectRefCount(video.OrganizationID, video.StorageRegion, key)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if refs > 1 {
+				continue
+			}
+		}
+		class := storageClass
+		if class == hotStorageClass {
+			class = cfg.hotStorageClassFor(kind)
+		}
+		if err := setter.SetStorageClass(ctx, key, class); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handlerVideoArchive hides a video from every listing and feed and moves
+// its files to cheaper storage, keeping everything so it can be unarchived
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoArchived(w, r, true)
+}
+
+func (cfg *apiConfig) handlerVideoUnarchive(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoArchived(w, r, false)
+}
+
+func (cfg *apiConfig) setVideoArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusConflict, "The video is in the trash", nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoArchived(video.ID, archived); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	// the video is archived either way; a file left in the wrong class only
+	// costs a little more or a little less until the next change
+	storageClass := hotStorageClass
+	if archived {
+		storageClass = cfg.archiveStorageClass
+	}
+	if storageClass != "" && (archived != (video.ArchivedAt != nil)) {
+		if err := cfg.setVideoStorageClass(r.Context(), video, storageClass); err != nil {
+			log.Printf("Couldn't move files of video %s to %s: %v", video.ID, storageClass, err)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideosArchived(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	videos, err := cfg.db.GetArchivedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve archived videos", err)
+		return
+	}
+	cfg.respondWithList(w, r, videos, completeList(len(videos)))
+}
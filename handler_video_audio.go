@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoAudioExtract stores the audio of an uploaded video on its own,
+// replacing any audio extracted before
+func (cfg *apiConfig) handlerVideoAudioExtract(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Format audioFormat `json:"format"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	// the body is optional, an empty one extracts MP3
+	params := parameters{Format: audioMP3}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Format != audioMP3 && params.Format != audioAAC {
+		respondWithError(w, http.StatusBadRequest, "format must be mp3 or aac", nil)
+		return
+	}
+
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "The video hasn't been uploaded yet", nil)
+		return
+	}
+	// older uploads have no media info, ffmpeg finds out for those
+	if video.AudioCodec == nil && video.VideoCodec != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "The video has no audio track", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	videoKey, ok := target.keyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "The video isn't in this server's storage", nil)
+		return
+	}
+
+	sourcePath, err := getFile(r.Context(), cfg.tempDir, target, videoKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(sourcePath)
+
+	audioPath, err := extractAudio(r.Context(), cfg.media, sourcePath, params.Format, stringOrEmpty(video.AudioCodec))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract audio", err)
+		return
+	}
+	defer os.Remove(audioPath)
+
+	key, err := newAudioKey(video.ID, params.Format)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate audio key", err)
+		return
+	}
+	key = cfg.keyLayout.Key(video.UserID, key)
+	if _, err := putFile(r.Context(), target, key, audioPath, params.Format.contentType(), func(int64) {}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload audio to object store", err)
+		return
+	}
+
+	var replaced []database.CreatePendingDeletionParams
+	if video.AudioURL != nil {
+		if oldKey, ok := target.keyFromURL(*video.AudioURL); ok {
+			replaced = append(replaced, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionObject,
+				Key:            oldKey,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+	}
+	deletions, err := cfg.db.UpdateVideoAudioURL(video.ID, target.objectURL(key), replaced)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if failed := cfg.processPendingDeletions(r.Context(), deletions); failed > 0 {
+		log.Printf("Audio of video %s replaced, %d objects queued for retry", video.ID, failed)
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxBatchVideos = 500
+
+const (
+	batchUpdated   = "updated"
+	batchUnchanged = "unchanged"
+	batchFailed    = "failed"
+	// skipped items were fine but weren't applied because others failed
+	batchSkipped = "skipped"
+)
+
+type batchItemResult struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Indexable *bool     `json:"indexable,omitempty"`
+}
+
+// handlerVideosBatchUpdate edits the tags and indexing of many videos at
+// once. The videos are picked by ID or by a search query, and the patch is
+// applied to all of them or, if any of them can't take it, to none.
+func (cfg *apiConfig) handlerVideosBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	type filter struct {
+		IDs   []uuid.UUID `json:"ids"`
+		Query string      `json:"query"`
+	}
+	type patch struct {
+		AddTags    []string `json:"add_tags"`
+		RemoveTags []string `json:"remove_tags"`
+		Indexable  *bool    `json:"indexable"`
+	}
+	type parameters struct {
+		Filter filter `json:"filter"`
+		Patch  patch  `json:"patch"`
+	}
+	type response struct {
+		Applied bool              `json:"applied"`
+		Results []batchItemResult `json:"results"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	addTags, err := normalizeTags(params.Patch.AddTags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	removeTags, err := normalizeTags(params.Patch.RemoveTags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(addTags) == 0 && len(removeTags) == 0 && params.Patch.Indexable == nil {
+		respondWithError(w, http.StatusBadRequest, "patch has nothing to change", nil)
+		return
+	}
+
+	query := strings.TrimSpace(params.Filter.Query)
+	if (len(params.Filter.IDs) == 0) == (query == "") {
+		respondWithError(w, http.StatusBadRequest, "filter needs either ids or query", nil)
+		return
+	}
+
+	var videos []database.Video
+	results := []batchItemResult{}
+	if query != "" {
+		if len(query) > maxSearchQueryLength {
+			respondWithError(w, http.StatusBadRequest, "query is too long", nil)
+			return
+		}
+		matches, more, err := cfg.db.SearchVideos(database.SearchVideosParams{
+			ViewerID:  userID,
+			OwnerOnly: true,
+			Query:     query,
+			Limit:     maxBatchVideos,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+			return
+		}
+		if more {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("query matches more than %d videos", maxBatchVideos), nil)
+			return
+		}
+		videos = matches
+	} else {
+		var ids []uuid.UUID
+		for _, id := range params.Filter.IDs {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > maxBatchVideos {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A batch can have at most %d videos", maxBatchVideos), nil)
+			return
+		}
+		for _, id := range ids {
+			video, err := cfg.db.GetVideo(id)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+				return
+			}
+			switch {
+			case video.ID == uuid.Nil:
+				results = append(results, batchItemResult{VideoID: id, Status: batchFailed, Error: "video not found"})
+			case video.UserID != userID:
+				results = append(results, batchItemResult{VideoID: id, Status: batchFailed, Error: "you don't own this video"})
+			default:
+				videos = append(videos, video)
+			}
+		}
+	}
+
+	failed := len(results) > 0
+	var updates []database.VideoBatchUpdate
+	for _, video := range videos {
+		current, err := cfg.db.GetVideoTags(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+			return
+		}
+		tags := slices.DeleteFunc(slices.Clone(current), func(tag string) bool {
+			return slices.Contains(removeTags, tag)
+		})
+		for _, tag := range addTags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		slices.Sort(tags)
+
+		result := batchItemResult{VideoID: video.ID, Tags: tags, Indexable: &video.Indexable}
+		if len(tags) > maxTagsPerVideo {
+			result.Status = batchFailed
+			result.Error = fmt.Sprintf("a video can have at most %d tags", maxTagsPerVideo)
+			results = append(results, result)
+			failed = true
+			continue
+		}
+
+		update := database.VideoBatchUpdate{VideoID: video.ID}
+		if !slices.Equal(tags, current) {
+			update.Tags = tags
+		}
+		if indexable := params.Patch.Indexable; indexable != nil && *indexable != video.Indexable {
+			update.Indexable = indexable
+			result.Indexable = indexable
+		}
+		result.Status = batchUnchanged
+		if update.Tags != nil || update.Indexable != nil {
+			result.Status = batchUpdated
+			updates = append(updates, update)
+		}
+		results = append(results, result)
+	}
+
+	if failed {
+		for i := range results {
+			if results[i].Status != batchFailed {
+				results[i].Status = batchSkipped
+			}
+		}
+		respondWithJSON(w, http.StatusUnprocessableEntity, response{Applied: false, Results: results})
+		return
+	}
+
+	if err := cfg.db.UpdateVideosBatch(updates); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update videos", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Applied: true, Results: results})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerVideoCaptionsUpload adds an SRT or WebVTT caption track, replacing
+// any track the video already has for that language. Tracks are always
+// stored as WebVTT, which is what browsers play.
+func (cfg *apiConfig) handlerVideoCaptionsUpload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionBytes+1<<10)
+	if err := r.ParseMultipartForm(maxCaptionBytes); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form, captions are limited to 2 MB", err)
+		return
+	}
+	language, ok := normalizeLanguage(r.FormValue("language"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "language must be a language tag like en or pt-BR", nil)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = language
+	}
+
+	file, _, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse captions file", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read captions file", err)
+		return
+	}
+	vtt, err := captionsToWebVTT(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid captions: "+err.Error(), err)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	randomHex := make([]byte, 16)
+	if _, err := rand.Read(randomHex); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate caption key", err)
+		return
+	}
+	key := fmt.Sprintf("%s%s-%x.vtt", cfg.keyLayout.Key(video.UserID, captionPrefix(video.ID)), language, randomHex)
+	if err := target.store.Put(r.Context(), key, strings.NewReader(vtt), "text/vtt"); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload captions to object store", err)
+		return
+	}
+
+	caption := database.Caption{
+		Language:  language,
+		Label:     label,
+		URL:       target.objectURL(key),
+		UpdatedAt: time.Now().UTC(),
+	}
+	captions, removed := withoutCaption(target, video, language)
+	captions = append(captions, caption)
+	if !cfg.saveCaptions(w, r, video, captions, removed) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, caption)
+}
+
+func (cfg *apiConfig) handlerVideoCaptionsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, video.Captions, completeList(len(video.Captions)))
+}
+
+func (cfg *apiConfig) handlerVideoCaptionsDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+	language, ok := normalizeLanguage(r.PathValue("language"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid language", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	captions, removed := withoutCaption(target, video, language)
+	if len(captions) == len(video.Captions) {
+		respondWithError(w, http.StatusNotFound, "The video has no captions in that language", nil)
+		return
+	}
+	if !cfg.saveCaptions(w, r, video, captions, removed) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withoutCaption returns the video's captions minus the track for language,
+// and the stored file of that track if it had one
+func withoutCaption(target storeTarget, video database.Video, language string) (database.Captions, []database.CreatePendingDeletionParams) {
+	var removed []database.CreatePendingDeletionParams
+	captions := slices.DeleteFunc(slices.Clone(video.Captions), func(c database.Caption) bool {
+		if c.Language != language {
+			return false
+		}
+		if key, ok := target.keyFromURL(c.URL); ok {
+			removed = append(removed, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionObject,
+				Key:            key,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+		return true
+	})
+	return captions, removed
+}
+
+// saveCaptions stores the new caption list and deletes the files of removed
+// tracks, writing the error response itself if saving fails
+func (cfg *apiConfig) saveCaptions(w http.ResponseWriter, r *http.Request, video database.Video, captions database.Captions, removed []database.CreatePendingDeletionParams) bool {
+	deletions, err := cfg.db.UpdateVideoCaptions(video.ID, captions, removed)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update captions", err)
+		return false
+	}
+	// anything that fails here stays queued and is retried in the background
+	if failed := cfg.processPendingDeletions(r.Context(), deletions); failed > 0 {
+		log.Printf("Captions of video %s updated, %d objects queued for retry", video.ID, failed)
+	}
+	return true
+}
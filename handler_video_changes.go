@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultChangePageSize = 100
+	maxChangePageSize     = 500
+	changePruneInterval   = 24 * time.Hour
+)
+
+// videoChange is a change feed entry with the video as it is now, which is
+// null once the video has been deleted
+type videoChange struct {
+	database.VideoChange
+	Video *database.Video `json:"video"`
+}
+
+// handlerVideoChanges lets clients keep a copy of the caller's videos in
+// sync: each call returns what changed after since, oldest first, and the
+// cursor to pass as since next time. A client starting out either reads the
+// whole feed or takes since=now and then lists the videos.
+func (cfg *apiConfig) handlerVideoChanges(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Changes    []videoChange `json:"changes"`
+		NextCursor string        `json:"next_cursor"`
+		HasMore    bool          `json:"has_more"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var since int64
+	switch cursor := query.Get("since"); cursor {
+	case "":
+	case "now":
+		latest, err := cfg.db.LatestVideoChangeID()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get changes", err)
+			return
+		}
+		since = latest
+	default:
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "since must be a cursor from an earlier response, or now", err)
+			return
+		}
+		since = n
+	}
+	limit := defaultChangePageSize
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		limit = min(n, maxChangePageSize)
+	}
+
+	changes, more, err := cfg.db.GetVideoChanges(userID, since, limit)
+	if errors.Is(err, database.ErrChangesPruned) {
+		respondWithError(w, http.StatusGone, "The cursor is too old, list your videos again and follow the feed from since=now", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get changes", err)
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.VideoID)
+	}
+	videos, err := cfg.db.GetVideosByID(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get changed videos", err)
+		return
+	}
+
+	resp := response{
+		Changes:    make([]videoChange, 0, len(changes)),
+		NextCursor: strconv.FormatInt(since, 10),
+		HasMore:    more,
+	}
+	for _, change := range changes {
+		entry := videoChange{VideoChange: change}
+		if video, ok := videos[change.VideoID]; ok {
+			entry.Video = &video
+		}
+		resp.Changes = append(resp.Changes, entry)
+		resp.NextCursor = strconv.FormatInt(change.ID, 10)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// runChangePruner deletes change feed entries older than
+// CHANGE_FEED_RETENTION. Clients further behind than that get a 410 and
+// start over.
+func (cfg *apiConfig) runChangePruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruned, err := cfg.db.DeleteVideoChangesBefore(time.Now().Add(-cfg.changeFeedRetention))
+		if err != nil {
+			log.Printf("Couldn't prune the change feed: %v", err)
+			continue
+		}
+		if pruned > 0 {
+			log.Printf("Pruned %d change feed entries", pruned)
+		}
+	}
+}
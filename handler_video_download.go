@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// longest original filename kept, in bytes, which is what most filesystems
+// allow
+const maxFilenameBytes = 255
+
+// handlerVideoDownload sends the owner the stored video file as an
+// attachment named after the file they uploaded. Stores that can presign a
+// download are redirected to, the rest are streamed through the server.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "The video is in the trash", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been uploaded yet", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	key, ok := target.keyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Video URL doesn't point at the object store", nil)
+		return
+	}
+	file, err := checkVideoFile(r.Context(), target, video)
+	if err != nil {
+		log.Printf("Couldn't check file of video %s: %v", video.ID, err)
+	} else if file != nil {
+		respondWithUnplayableFile(w, file)
+		return
+	}
+
+	disposition := attachmentDisposition(downloadFilename(video, key))
+
+	if presigner, ok := target.store.(storage.DownloadPresigner); ok {
+		url, err := presigner.PresignDownload(r.Context(), key, disposition, cfg.playbackURLTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign download URL", err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	info, err := target.store.Head(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	body, err := target.store.Get(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Type", info.ContentType)
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		// lets interrupted downloads resume with a range request
+		http.ServeContent(w, r, "", info.LastModified, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Couldn't stream download of video %s: %v", video.ID, err)
+	}
+}
+
+// cleanFilename reduces a client-supplied filename to something safe to
+// store and hand back: its last path element, valid UTF-8 without control
+// characters, and no longer than maxFilenameBytes. It may return "".
+func cleanFilename(name string) string {
+	// some browsers send the full path, with either kind of separator
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.ToValidUTF8(name, "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	for len(name) > maxFilenameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// downloadFilename is the name a video is saved under: the uploaded file's,
+// or the title's when it's unknown, with the stored file's extension since
+// processing may have changed the container
+func downloadFilename(video database.Video, key string) string {
+	name := video.Title
+	if video.OriginalFilename != nil {
+		name = strings.TrimSuffix(*video.OriginalFilename, path.Ext(*video.OriginalFilename))
+	}
+	name = cleanFilename(name)
+	if name == "" {
+		name = video.ID.String()
+	}
+	ext := path.Ext(key)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	return name + ext
+}
+
+// attachmentDisposition builds a Content-Disposition that saves the
+// response as name. Clients that understand RFC 6266 use the UTF-8
+// filename*; the rest get an ASCII approximation.
+func attachmentDisposition(name string) string {
+	var fallback, encoded strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback.String(), encoded.String())
+}
+
+// isAttrChar reports whether b can appear unescaped in an RFC 5987 value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoEventsGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's events", nil)
+		return
+	}
+
+	events, err := cfg.db.GetVideoEvents(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve video events", err)
+		return
+	}
+
+	cfg.respondWithList(w, r, events, completeList(len(events)))
+}
+
+// recordVideoEvent records an event that isn't part of an upload job
+func (cfg *apiConfig) recordVideoEvent(videoID uuid.UUID, stage uploadStage, eventType database.EventType, message string) {
+	err := cfg.db.CreateVideoEvent(database.CreateVideoEventParams{
+		VideoID: videoID,
+		Stage:   string(stage),
+		Type:    eventType,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("Couldn't record %s event for video %s: %v", eventType, videoID, err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// how many rows go out between flushes
+const exportFlushEvery = 100
+
+var exportCSVHeader = []string{
+	"id",
+	"created_at",
+	"updated_at",
+	"title",
+	"description",
+	"organization_id",
+	"video_url",
+	"thumbnail_url",
+	"size_bytes",
+	"duration_seconds",
+	"video_codec",
+	"audio_codec",
+	"bit_rate",
+	"frame_rate",
+	"audio_channels",
+	"link_status",
+	"link_checked_at",
+	"pinned",
+	"sort_index",
+}
+
+// handlerVideosExport streams every video the caller owns as CSV or JSON.
+// Rows are written as they're read, so once the headers are out an error
+// can only cut the download short.
+func (cfg *apiConfig) handlerVideosExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "format must be csv or json", nil)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="videos.`+format+`"`)
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		err = cfg.exportVideosCSV(w, userID, flush)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = cfg.exportVideosJSON(w, userID, flush)
+	}
+	if err != nil {
+		log.Printf("Video export for user %s failed: %v", userID, err)
+	}
+}
+
+func (cfg *apiConfig) exportVideosCSV(w io.Writer, userID uuid.UUID, flush func()) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportCSVHeader); err != nil {
+		return err
+	}
+
+	n := 0
+	err := cfg.db.EachVideo(userID, func(video database.Video) error {
+		if err := cw.Write(videoCSVRecord(video)); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			cw.Flush()
+			flush()
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func (cfg *apiConfig) exportVideosJSON(w io.Writer, userID uuid.UUID, flush func()) error {
+	if _, err := w.Write([]byte("[")); err != nil {
+		return err
+	}
+
+	n := 0
+	err := cfg.db.EachVideo(userID, func(video database.Video) error {
+		dat, err := json.Marshal(video)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(dat); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushEvery == 0 {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write([]byte("]\n"))
+	return err
+}
+
+func videoCSVRecord(video database.Video) []string {
+	orgID := ""
+	if video.OrganizationID.Valid {
+		orgID = video.OrganizationID.UUID.String()
+	}
+	sizeBytes := ""
+	if video.SizeBytes != nil {
+		sizeBytes = strconv.FormatInt(*video.SizeBytes, 10)
+	}
+	duration := ""
+	if video.DurationSeconds != nil {
+		duration = strconv.FormatFloat(*video.DurationSeconds, 'f', -1, 64)
+	}
+	bitRate := ""
+	if video.BitRate != nil {
+		bitRate = strconv.FormatInt(*video.BitRate, 10)
+	}
+	frameRate := ""
+	if video.FrameRate != nil {
+		frameRate = strconv.FormatFloat(*video.FrameRate, 'f', -1, 64)
+	}
+	audioChannels := ""
+	if video.AudioChannels != nil {
+		audioChannels = strconv.Itoa(*video.AudioChannels)
+	}
+	linkCheckedAt := ""
+	if video.LinkCheckedAt != nil {
+		linkCheckedAt = video.LinkCheckedAt.Format(time.RFC3339)
+	}
+	sortIndex := ""
+	if video.SortIndex != nil {
+		sortIndex = strconv.Itoa(*video.SortIndex)
+	}
+
+	return []string{
+		video.ID.String(),
+		video.CreatedAt.Format(time.RFC3339),
+		video.UpdatedAt.Format(time.RFC3339),
+		video.Title,
+		video.Description,
+		orgID,
+		stringOrEmpty(video.VideoURL),
+		stringOrEmpty(video.ThumbnailURL),
+		sizeBytes,
+		duration,
+		stringOrEmpty(video.VideoCodec),
+		stringOrEmpty(video.AudioCodec),
+		bitRate,
+		frameRate,
+		audioChannels,
+		string(video.LinkStatus),
+		linkCheckedAt,
+		strconv.FormatBool(video.Pinned),
+		sortIndex,
+	}
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// players send the playback position this often while a video is playing,
+// so each heartbeat stands for this many seconds watched
+const heartbeatIntervalSeconds = 5
+
+const (
+	defaultHeatmapBuckets = 100
+	maxHeatmapBuckets     = 1000
+	maxSessionIDLength    = 64
+)
+
+// handlerVideoHeartbeat records where in a video a player is. Anyone who
+// can watch the video can send them, signed in or not.
+func (cfg *apiConfig) handlerVideoHeartbeat(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID       string  `json:"session_id"`
+		PositionSeconds float64 `json:"position_seconds"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.SessionID == "" || len(params.SessionID) > maxSessionIDLength {
+		respondWithError(w, http.StatusBadRequest, "session_id must be 1 to 64 characters", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !video.VisibleTo(uuid.Nil) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if params.PositionSeconds < 0 || math.IsNaN(params.PositionSeconds) ||
+		(video.DurationSeconds != nil && params.PositionSeconds > *video.DurationSeconds+heartbeatIntervalSeconds) {
+		respondWithError(w, http.StatusBadRequest, "position_seconds is outside the video", nil)
+		return
+	}
+
+	var userID uuid.NullUUID
+	if id, ok := cfg.requestUserID(r); ok {
+		userID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	err = cfg.db.CreateVideoHeartbeat(database.CreateVideoHeartbeatParams{
+		VideoID:         video.ID,
+		SessionID:       params.SessionID,
+		UserID:          userID,
+		PositionSeconds: params.PositionSeconds,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record heartbeat", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type heatmapBucket struct {
+	StartPercent float64 `json:"start_percent"`
+	EndPercent   float64 `json:"end_percent"`
+	StartSeconds float64 `json:"start_seconds"`
+	// Viewers is how many sessions watched any of the bucket
+	Viewers int64 `json:"viewers"`
+	// Retention is the share of all sessions that watched the bucket; a dip
+	// means viewers skip it
+	Retention float64 `json:"retention"`
+	// Plays is roughly how many times the bucket was played through, and
+	// RewatchRate how many times each viewer played it on average
+	Plays       float64 `json:"plays"`
+	RewatchRate float64 `json:"rewatch_rate"`
+}
+
+func (cfg *apiConfig) handlerVideoHeatmap(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		DurationSeconds float64         `json:"duration_seconds"`
+		Sessions        int64           `json:"sessions"`
+		Buckets         []heatmapBucket `json:"buckets"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+	if video.DurationSeconds == nil || *video.DurationSeconds <= 0 {
+		respondWithError(w, http.StatusConflict, "The video's duration isn't known", nil)
+		return
+	}
+	duration := *video.DurationSeconds
+
+	buckets := defaultHeatmapBuckets
+	if b := r.URL.Query().Get("buckets"); b != "" {
+		n, err := strconv.Atoi(b)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "buckets must be a positive integer", err)
+			return
+		}
+		buckets = min(n, maxHeatmapBuckets)
+	}
+	// a bucket shorter than the heartbeat interval would be hit or missed
+	// depending on when the heartbeats happened to land
+	buckets = max(1, min(buckets, int(duration/heartbeatIntervalSeconds)))
+
+	counts, sessions, err := cfg.db.GetVideoHeatmap(video.ID, duration, buckets)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build heatmap", err)
+		return
+	}
+
+	// every bucket is listed so skipped parts show up as zeros
+	bucketSeconds := duration / float64(buckets)
+	heatmap := make([]heatmapBucket, buckets)
+	for i := range heatmap {
+		heatmap[i] = heatmapBucket{
+			StartPercent: roundTo(float64(i)*100/float64(buckets), 2),
+			EndPercent:   roundTo(float64(i+1)*100/float64(buckets), 2),
+			StartSeconds: roundTo(float64(i)*bucketSeconds, 3),
+		}
+	}
+	for _, count := range counts {
+		bucket := &heatmap[count.Bucket]
+		bucket.Viewers = count.Sessions
+		bucket.Plays = roundTo(float64(count.Heartbeats)*heartbeatIntervalSeconds/bucketSeconds, 2)
+		if sessions > 0 {
+			bucket.Retention = roundTo(float64(count.Sessions)/float64(sessions), 3)
+		}
+		if count.Sessions > 0 {
+			bucket.RewatchRate = roundTo(bucket.Plays/float64(count.Sessions), 2)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		DurationSeconds: duration,
+		Sessions:        sessions,
+		Buckets:         heatmap,
+	})
+}
+
+func roundTo(f float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(f*scale) / scale
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// the sitemap protocol's limit on URLs per file
+	maxSitemapURLs = 50000
+	maxFeedItems   = 50
+)
+
+func (cfg *apiConfig) handlerVideoIndexing(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Indexable *bool `json:"indexable"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Indexable == nil {
+		respondWithError(w, http.StatusBadRequest, "indexable is required", nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoIndexable(video.ID, *params.Indexable); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{- if not .Indexable}}
+<meta name="robots" content="noindex, nofollow">
+{{- end}}
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.PageURL}}">
+{{- if .ThumbnailURL}}
+<meta property="og:image" content="{{.ThumbnailURL}}">
+{{- end}}
+<style>html, body { margin: 0; height: 100%; background: #000; } video { width: 100%; height: 100%; }</style>
+</head>
+<body>
+<video src="{{.VideoURL}}" {{- if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}} controls playsinline></video>
+<script>
+(() => {
+  const video = document.querySelector('video');
+  const session = crypto.randomUUID();
+  video.addEventListener('play', () => {
+    fetch({{.ViewURL}}, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ session_id: session }),
+    }).catch(() => {});
+  }, { once: true });
+  setInterval(() => {
+    if (video.paused || video.ended) return;
+    fetch({{.HeartbeatURL}}, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ session_id: session, position_seconds: video.currentTime }),
+    }).catch(() => {});
+  }, {{.HeartbeatInterval}} * 1000);
+})();
+</script>
+</body>
+</html>
+`))
+
+// handlerVideoEmbed serves a bare player page for a published video, at
+// both /embed and /watch
+func (cfg *apiConfig) handlerVideoEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	now := time.Now().UTC()
+	video = cfg.startDuePremiere(r.Context(), video, now)
+	if !video.VisibleTo(uuid.Nil) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if !cfg.embedAllowed(r, video) {
+		respondWithError(w, http.StatusForbidden, "This video can't be embedded on this site", nil)
+		return
+	}
+
+	videoURL, err := cfg.signedPlaybackURL(r.Context(), video, now.Add(cfg.playbackURLTTL))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	// people who open a live premiere join it where it's at
+	if status, offset := premiereState(video, now); status == premiereLive {
+		videoURL += fmt.Sprintf("#t=%.1f", offset.Seconds())
+	}
+
+	if !video.Indexable {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	}
+	if policy := frameAncestors(video); policy != "" {
+		w.Header().Set("Content-Security-Policy", policy)
+	}
+	// the page holds a signed URL, so it mustn't outlive it in a cache
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = embedTemplate.Execute(w, struct {
+		Title             string
+		Description       string
+		Indexable         bool
+		PageURL           string
+		VideoURL          string
+		ThumbnailURL      string
+		HeartbeatURL      string
+		ViewURL           string
+		HeartbeatInterval int
+	}{
+		Title:             video.Title,
+		Description:       video.Description,
+		Indexable:         video.Indexable,
+		PageURL:           cfg.watchURL(video),
+		VideoURL:          videoURL,
+		ThumbnailURL:      stringOrEmpty(video.ThumbnailURL),
+		HeartbeatURL:      "/api/videos/" + video.ID.String() + "/heartbeat",
+		ViewURL:           "/api/videos/" + video.ID.String() + "/view",
+		HeartbeatInterval: heartbeatIntervalSeconds,
+	})
+	if err != nil {
+		log.Printf("Couldn't render embed page for video %s: %v", video.ID, err)
+	}
+}
+
+func (cfg *apiConfig) handlerSitemap(w http.ResponseWriter, r *http.Request) {
+	type sitemapURL struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	}
+	type urlset struct {
+		XMLName xml.Name     `xml:"urlset"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		URLs    []sitemapURL `xml:"url"`
+	}
+
+	videos, err := cfg.db.GetIndexableVideos(uuid.Nil, maxSitemapURLs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	sitemap := urlset{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, video := range videos {
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{
+			Loc:     cfg.watchURL(video),
+			LastMod: video.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	respondWithXML(w, "application/xml; charset=utf-8", sitemap)
+}
+
+// handlerChannelFeed is an RSS feed of a user's latest indexable videos
+func (cfg *apiConfig) handlerChannelFeed(w http.ResponseWriter, r *http.Request) {
+	type enclosure struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	}
+	type item struct {
+		Title       string     `xml:"title"`
+		Link        string     `xml:"link"`
+		Description string     `xml:"description"`
+		GUID        string     `xml:"guid"`
+		PubDate     string     `xml:"pubDate"`
+		Enclosure   *enclosure `xml:"enclosure,omitempty"`
+	}
+	type channel struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		Items       []item `xml:"item"`
+	}
+	type rss struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Channel channel  `xml:"channel"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetIndexableVideos(userID, maxFeedItems)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	feed := rss{
+		Version: "2.0",
+		Channel: channel{
+			Title:       "Tubely videos",
+			Link:        cfg.publicBaseURL + "/api/users/" + userID.String() + "/videos",
+			Description: "Latest videos on this Tubely channel",
+		},
+	}
+	for _, video := range videos {
+		link := cfg.watchURL(video)
+		it := item{
+			Title:       video.Title,
+			Link:        link,
+			Description: video.Description,
+			GUID:        link,
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+		}
+		if video.ThumbnailURL != nil {
+			thumbnailType := mime.TypeByExtension(path.Ext(*video.ThumbnailURL))
+			if thumbnailType == "" {
+				thumbnailType = "image/jpeg"
+			}
+			it.Enclosure = &enclosure{URL: *video.ThumbnailURL, Type: thumbnailType}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, it)
+	}
+	respondWithXML(w, "application/rss+xml; charset=utf-8", feed)
+}
+
+// watchURL is the public page of the video; /watch serves the same player
+// page as /embed under a link meant for sharing
+func (cfg *apiConfig) watchURL(video database.Video) string {
+	return cfg.publicBaseURL + "/watch/" + videoRef(video)
+}
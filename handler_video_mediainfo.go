@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// handlerVideoMediaInfo returns the ffprobe analysis of one of the user's
+// videos, taken when it was processed
+func (cfg *apiConfig) handlerVideoMediaInfo(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+	cfg.respondWithMediaReport(w, video.ID)
+}
+
+// handlerAdminVideoMediaInfo is handlerVideoMediaInfo for any video, so
+// support can look into a file without asking for it
+func (cfg *apiConfig) handlerAdminVideoMediaInfo(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	cfg.respondWithMediaReport(w, video.ID)
+}
+
+func (cfg *apiConfig) respondWithMediaReport(w http.ResponseWriter, videoID uuid.UUID) {
+	report, err := cfg.db.GetMediaReport(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media report", err)
+		return
+	}
+	if report.VideoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "The video has no media report", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -14,27 +21,54 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosWrite)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	// service accounts can only create videos in their organization, titled
+	// with their prefix
+	account, err := cfg.db.GetServiceAccountByUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up service account", err)
+		return
+	}
+	if account.ID != uuid.Nil {
+		if !params.OrganizationID.Valid {
+			params.OrganizationID = uuid.NullUUID{UUID: account.OrganizationID, Valid: true}
+		}
+		if params.OrganizationID.UUID != account.OrganizationID {
+			respondWithError(w, http.StatusForbidden, "Service accounts can only create videos in their organization", nil)
+			return
+		}
+		if !strings.HasPrefix(params.Title, account.VideoPrefix) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Video titles must start with %q", account.VideoPrefix), nil)
+			return
+		}
+	}
+
+	if params.OrganizationID.Valid {
+		member, err := cfg.db.GetOrganizationMember(params.OrganizationID.UUID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+			return
+		}
+		if member.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You aren't a member of this organization", nil)
+			return
+		}
+	}
+
+	video, err := cfg.createVideo(params.CreateVideoParams, nil)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
@@ -43,22 +77,110 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
-func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 5000
+)
+
+// sanitizeVideoText replaces invalid UTF-8 and drops control characters,
+// keeping line breaks and tabs only where multiline is set, then checks the
+// length in characters
+func sanitizeVideoText(field, text string, maxLength int, multiline bool) (string, error) {
+	text = strings.ToValidUTF8(text, "\uFFFD")
+	text = strings.Map(func(r rune) rune {
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > maxLength {
+		return "", fmt.Errorf("%s can't be longer than %d characters", field, maxLength)
+	}
+	return text, nil
+}
+
+// handlerVideoMetaUpdate changes the title and description; fields left out
+// of the body stay as they are
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		PublicStats *bool   `json:"public_stats"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
+	if params.Title == nil && params.Description == nil && params.PublicStats == nil {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update, set title, description or public_stats", nil)
+		return
+	}
+
+	if params.Title != nil {
+		title, err := sanitizeVideoText("title", *params.Title, maxTitleLength, false)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		if title == "" {
+			respondWithError(w, http.StatusBadRequest, "title can't be empty", nil)
+			return
+		}
+
+		// service accounts keep to their prefix when renaming too
+		account, err := cfg.db.GetServiceAccountByUser(video.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't look up service account", err)
+			return
+		}
+		if account.ID != uuid.Nil && !strings.HasPrefix(title, account.VideoPrefix) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("Video titles must start with %q", account.VideoPrefix), nil)
+			return
+		}
+		params.Title = &title
+	}
+	if params.Description != nil {
+		description, err := sanitizeVideoText("description", *params.Description, maxDescriptionLength, true)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		params.Description = &description
+	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	if err := cfg.db.UpdateVideoMetadata(video.ID, params.Title, params.Description, params.PublicStats); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
+	videoID, err := cfg.pathVideoID(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosDelete)
+	if !ok {
 		return
 	}
 
@@ -72,7 +194,19 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	// videos go to the trash first unless the caller asks for them to be
+	// gone for good, or they're in the trash already
+	if r.URL.Query().Get("permanent") == "true" || video.DeletedAt != nil {
+		err = cfg.deleteVideo(r.Context(), video)
+	} else if err = cfg.db.TrashVideo(video.ID); err == nil {
+		if err := cfg.tagTrashedVideo(r.Context(), video, true); err != nil {
+			log.Printf("Couldn't tag files of trashed video %s: %v", video.ID, err)
+		}
+	}
+	if errors.Is(err, database.ErrLegalHold) {
+		respondWithError(w, http.StatusConflict, "This video is under legal hold and can't be deleted", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -82,8 +216,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 }
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	videoID, err := cfg.pathVideoID(r)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
 		return
@@ -94,27 +227,160 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "The video is in the trash", nil)
+		return
+	}
+	video = cfg.startDuePremiere(r.Context(), video, time.Now())
+	userID, ok := cfg.requestUserID(r)
+	if !video.VisibleTo(userID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	isOwner := ok && userID == video.UserID
 
-	respondWithJSON(w, http.StatusOK, video)
+	view := publicView(video)
+	if isOwner {
+		view = ownerView(video)
+	}
+	if ok {
+		state, err := cfg.db.GetViewerState(userID, video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		view.Viewer = &state
+	}
+	respondWithJSON(w, http.StatusOK, view)
+}
+
+// publicVideo is a video as people other than its owner see it, with its
+// stats left out unless the owner made them public
+type publicVideo struct {
+	database.Video
+	ViewCount    *int64 `json:"view_count,omitempty"`
+	LikeCount    *int64 `json:"like_count,omitempty"`
+	DislikeCount *int64 `json:"dislike_count,omitempty"`
+	// the rest of the video's private fields are hidden behind these, which
+	// are only set for the owner. OriginalFilename is the name of a file on
+	// the owner's computer.
+	OriginalFilename *string `json:"original_filename,omitempty"`
+	// SHA256 and UploadSHA256 would let anyone check the video against a
+	// file they have
+	SHA256       *string `json:"sha256,omitempty"`
+	UploadSHA256 *string `json:"upload_sha256,omitempty"`
+	// VideoScan and ThumbnailScan are internal malware scan results
+	VideoScan        *database.ScanResult       `json:"video_scan,omitempty"`
+	ThumbnailScan    *database.ScanResult       `json:"thumbnail_scan,omitempty"`
+	ModerationLabels *database.ModerationLabels `json:"moderation_labels,omitempty"`
+	// LegalHold and DeleteAt would tell others the video is under legal
+	// hold or about to go
+	LegalHold *bool      `json:"legal_hold,omitempty"`
+	DeleteAt  *time.Time `json:"delete_at,omitempty"`
+	// Viewer is the signed in caller's reaction and watch later state
+	Viewer *database.ViewerState `json:"viewer,omitempty"`
+}
+
+func publicView(video database.Video) publicVideo {
+	view := publicVideo{Video: video}
+	if video.PublicStats {
+		view.ViewCount = &video.ViewCount
+		view.LikeCount = &video.LikeCount
+		view.DislikeCount = &video.DislikeCount
+	}
+	return view
+}
+
+// ownerView is a video with all its stats, as its owner sees it
+func ownerView(video database.Video) publicVideo {
+	return publicVideo{
+		Video:            video,
+		ViewCount:        &video.ViewCount,
+		LikeCount:        &video.LikeCount,
+		DislikeCount:     &video.DislikeCount,
+		OriginalFilename: video.OriginalFilename,
+		SHA256:           video.SHA256,
+		UploadSHA256:     video.UploadSHA256,
+		VideoScan:        video.VideoScan,
+		ThumbnailScan:    video.ThumbnailScan,
+		ModerationLabels: &video.ModerationLabels,
+		LegalHold:        &video.LegalHold,
+		DeleteAt:         video.DeleteAt,
+	}
+}
+
+// viewsFor is each of videos as viewerID may see it
+func viewsFor(videos []database.Video, viewerID uuid.UUID) []publicVideo {
+	views := make([]publicVideo, len(videos))
+	for i, video := range videos {
+		views[i] = publicView(video)
+		if video.UserID == viewerID {
+			views[i] = ownerView(video)
+		}
+	}
+	return views
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	// without query parameters this keeps returning every video the caller
+	// owns, which is what the web app expects
+	if len(r.URL.Query()) == 0 {
+		videos, err := cfg.db.GetVideos(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		cfg.respondWithList(w, r, videos, completeList(len(videos)))
+		return
+	}
+
+	params, err := parseListVideosParams(r.URL.Query(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	// the order, and the cursor, would give away view counts that aren't
+	// public
+	if params.Sort == database.VideoSortViews && params.OwnerID != userID {
+		respondWithError(w, http.StatusBadRequest, "sort=views is only allowed on your own videos", nil)
+		return
+	}
+	cfg.respondWithVideoPage(w, r, params)
+}
 
-	videos, err := cfg.db.GetVideos(userID)
+// respondWithVideoPage responds with the page of videos params asks for,
+// as the viewer may see them unless it's for operators
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, params database.ListVideosParams) {
+	videos, next, err := cfg.db.ListVideos(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	total, err := cfg.db.CountVideos(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count videos", err)
+		return
+	}
+
+	page := pagination{Total: &total}
+	if next != nil {
+		cursor, err := encodeVideoCursor(*next)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create cursor", err)
+			return
+		}
+		w.Header().Set("X-Next-Cursor", cursor)
+		page.NextCursor = &cursor
+	}
+	if params.AllOwners {
+		cfg.respondWithList(w, r, videos, page)
+		return
+	}
+	cfg.respondWithList(w, r, viewsFor(videos, params.ViewerID), page)
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVideoPlay(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't play this video", nil)
+		return
+	}
+	if video.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "The video is in the trash", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been uploaded yet", nil)
+		return
+	}
+	if !cfg.embedAllowed(r, video) {
+		respondWithError(w, http.StatusForbidden, "This video can't be played on this site", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	file, err := checkVideoFile(r.Context(), target, video)
+	if err != nil {
+		// the file is almost always fine, so a failed check doesn't stop
+		// playback
+		log.Printf("Couldn't check file of video %s: %v", video.ID, err)
+	} else if file != nil {
+		respondWithUnplayableFile(w, file)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(cfg.playbackURLTTL)
+	url, err := cfg.signedPlaybackURL(r.Context(), video, expiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       url,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// signedPlaybackURL signs the CloudFront URL when a key pair is configured and
+// falls back to presigning the object directly otherwise. Organization
+// buckets aren't behind our distribution, so they're always presigned.
+func (cfg *apiConfig) signedPlaybackURL(ctx context.Context, video database.Video, expiresAt time.Time) (string, error) {
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return "", err
+	}
+	if cfg.urlSigner != nil && target.isDefault {
+		return cfg.urlSigner.Sign(*video.VideoURL, expiresAt)
+	}
+
+	key, ok := target.keyFromURL(*video.VideoURL)
+	if !ok {
+		return "", errors.New("video URL doesn't point at the object store")
+	}
+	return target.store.PresignGet(ctx, key, time.Until(expiresAt))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// handlerVideoRestore takes a video out of the trash, or if it isn't
+// trashed, starts restoring a video file that's been moved to archive
+// storage, so it can be played again once the restore is done
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+	if video.DeletedAt != nil {
+		cfg.restoreTrashedVideo(w, video)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "The video hasn't been uploaded yet", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	file, err := checkVideoFile(r.Context(), target, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video file", err)
+		return
+	}
+	switch {
+	case file == nil:
+		respondWithError(w, http.StatusConflict, "The video isn't archived", nil)
+		return
+	case file.Status == fileMissing:
+		respondWithUnplayableFile(w, file)
+		return
+	case file.Status == fileRestoring:
+		file.Error = ""
+		respondWithJSON(w, http.StatusAccepted, file)
+		return
+	}
+
+	file, err = cfg.startVideoRestore(r.Context(), target, video, file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start restore", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, file)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoScheduleDeletion lets an owner have a video deleted at a
+// later time; a null delete_at cancels it. A legal hold still wins when
+// the time comes.
+func (cfg *apiConfig) handlerVideoScheduleDeletion(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DeleteAt *time.Time `json:"delete_at"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosDelete)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.DeleteAt != nil && !params.DeleteAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "delete_at must be in the future", nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoDeleteAt(video.ID, params.DeleteAt); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule deletion", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoLegalHold places or releases a legal hold. Only admins can,
+// and while it's held nothing deletes the video: not its owner, not a
+// scheduled deletion.
+func (cfg *apiConfig) handlerVideoLegalHold(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		LegalHold bool   `json:"legal_hold"`
+		Reason    string `json:"reason"`
+	}
+	type response struct {
+		VideoID   uuid.UUID  `json:"video_id"`
+		LegalHold bool       `json:"legal_hold"`
+		Reason    *string    `json:"reason"`
+		DeleteAt  *time.Time `json:"delete_at"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	adminID, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var reason *string
+	if trimmed := strings.TrimSpace(params.Reason); trimmed != "" {
+		reason = &trimmed
+	}
+	if params.LegalHold && reason == nil {
+		respondWithError(w, http.StatusBadRequest, "A reason is required to place a legal hold", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	if err := cfg.db.SetVideoLegalHold(video.ID, params.LegalHold, reason); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update legal hold", err)
+		return
+	}
+	// held files must outlive the trash's lifecycle rule
+	if params.LegalHold && video.DeletedAt != nil {
+		if err := cfg.tagTrashedVideo(r.Context(), video, false); err != nil {
+			log.Printf("Couldn't untag files of held video %s: %v", video.ID, err)
+		}
+	}
+	message := fmt.Sprintf("released by admin %s", adminID)
+	if params.LegalHold {
+		message = fmt.Sprintf("placed by admin %s: %s", adminID, *reason)
+	}
+	cfg.recordVideoEvent(video.ID, "", database.EventLegalHold, message)
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		VideoID:   video.ID,
+		LegalHold: video.LegalHold,
+		Reason:    video.LegalHoldReason,
+		DeleteAt:  video.DeleteAt,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const maxSearchQueryLength = 200
+
+// handlerVideosSearch ranks relevance, so pages are by offset rather than the
+// cursor GET /api/videos uses
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		respondWithError(w, http.StatusBadRequest, "q is required", nil)
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		respondWithError(w, http.StatusBadRequest, "q is too long", nil)
+		return
+	}
+
+	params := database.SearchVideosParams{
+		ViewerID: userID,
+		Query:    q,
+		Limit:    defaultVideoPageSize,
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		params.Limit = min(n, maxVideoPageSize)
+	}
+	// the envelope's next_cursor is the offset, so accept it under either name
+	offset := query.Get("offset")
+	if offset == "" {
+		offset = query.Get("cursor")
+	}
+	if offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative integer", err)
+			return
+		}
+		params.Offset = n
+	}
+
+	videos, more, err := cfg.db.SearchVideos(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	// ranking every match just to count them isn't worth it, so search
+	// results have no total; the cursor is the next offset
+	var page pagination
+	if more {
+		next := strconv.Itoa(params.Offset + len(videos))
+		w.Header().Set("X-Next-Offset", next)
+		page.NextCursor = &next
+	}
+	cfg.respondWithList(w, r, viewsFor(videos, userID), page)
+}
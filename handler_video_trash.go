@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// trashedVideo is a video in the trash and when it'll be purged
+type trashedVideo struct {
+	database.Video
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+func (cfg *apiConfig) handlerVideosTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	videos, err := cfg.db.GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+	trashed := make([]trashedVideo, 0, len(videos))
+	for _, video := range videos {
+		trashed = append(trashed, trashedVideo{Video: video, PurgeAt: cfg.trashExpiresAt(*video.DeletedAt)})
+	}
+	cfg.respondWithList(w, r, trashed, completeList(len(trashed)))
+}
+
+// restoreTrashedVideo takes a video out of the trash, as long as it's still
+// within the retention window
+func (cfg *apiConfig) restoreTrashedVideo(w http.ResponseWriter, video database.Video) {
+	if time.Now().After(cfg.trashExpiresAt(*video.DeletedAt)) {
+		respondWithError(w, http.StatusGone, "The video has been in the trash too long to be restored", nil)
+		return
+	}
+	if err := cfg.db.RestoreTrashedVideo(video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	if err := cfg.tagTrashedVideo(context.Background(), video, false); err != nil {
+		log.Printf("Couldn't untag files of restored video %s: %v", video.ID, err)
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get restored video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
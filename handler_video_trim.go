@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoTrim cuts the part of a video between start and end into a new
+// video of the same owner, which then goes through the upload pipeline like
+// any other upload. The source video is left as it is.
+func (cfg *apiConfig) handlerVideoTrim(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Start *trimTimestamp `json:"start"`
+		End   *trimTimestamp `json:"end"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Start == nil || params.End == nil {
+		respondWithError(w, http.StatusBadRequest, "start and end are required", nil)
+		return
+	}
+	start, end := time.Duration(*params.Start), time.Duration(*params.End)
+	if start >= end {
+		respondWithError(w, http.StatusBadRequest, "start must be before end", nil)
+		return
+	}
+
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "The video hasn't been uploaded yet", nil)
+		return
+	}
+	if video.DurationSeconds != nil && end > time.Duration(*video.DurationSeconds*float64(time.Second)) {
+		respondWithError(w, http.StatusBadRequest, "end is past the end of the video", nil)
+		return
+	}
+
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video storage", err)
+		return
+	}
+	videoKey, ok := target.keyFromURL(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "The video isn't in this server's storage", nil)
+		return
+	}
+
+	sourcePath, err := getFile(r.Context(), cfg.tempDir, target, videoKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(sourcePath)
+
+	trimmedPath, err := trimVideo(r.Context(), cfg.media, sourcePath, start, end)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't trim video", err)
+		return
+	}
+	info, err := os.Stat(trimmedPath)
+	if err != nil {
+		os.Remove(trimmedPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read trimmed video", err)
+		return
+	}
+
+	trimmed, err := cfg.createVideo(database.CreateVideoParams{
+		Title:          video.Title + " (trimmed)",
+		Description:    video.Description,
+		UserID:         video.UserID,
+		OrganizationID: video.OrganizationID,
+	}, &video.ID)
+	if err != nil {
+		os.Remove(trimmedPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+
+	job, ok := cfg.startUploadJob(r.Context(), uuid.New(), trimmed, video.UserID, info.Size())
+	if !ok {
+		os.Remove(trimmedPath)
+		respondWithError(w, http.StatusConflict, "Upload ID is already in use", nil)
+		return
+	}
+	job.received(info.Size())
+
+	respondWithJSON(w, http.StatusAccepted, cfg.processUploadInBackground(receivedUpload{
+		video:    trimmed,
+		job:      job,
+		tempPath: trimmedPath,
+	}))
+}
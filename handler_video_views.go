@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// a viewer is counted at most once per video in this long, so reloads
+	// and replays don't inflate the count
+	viewDedupWindow = 6 * time.Hour
+	maxStatsBuckets = 1000
+)
+
+// handlerVideoView counts a view of a published video. Signed in viewers
+// are told apart by account, anyone else by the session_id their player
+// makes up.
+func (cfg *apiConfig) handlerVideoView(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID string `json:"session_id"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	if len(params.SessionID) > maxSessionIDLength {
+		respondWithError(w, http.StatusBadRequest, "session_id can't be longer than 64 characters", nil)
+		return
+	}
+
+	var userID uuid.NullUUID
+	viewer := "session:" + params.SessionID
+	if id, ok := cfg.requestUserID(r); ok {
+		userID = uuid.NullUUID{UUID: id, Valid: true}
+		viewer = "user:" + id.String()
+	} else if params.SessionID == "" {
+		respondWithError(w, http.StatusBadRequest, "session_id is required when not signed in", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !video.VisibleTo(uuid.Nil) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	if _, err := cfg.db.RecordVideoView(video.ID, viewer, userID, time.Now().Add(-viewDedupWindow)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type viewStatsBucket struct {
+	Start   time.Time `json:"start"`
+	Views   int64     `json:"views"`
+	Viewers int64     `json:"viewers"`
+}
+
+// handlerVideoStats returns a video's views over time for its owner, by
+// hour or by day, with every bucket in the range listed
+func (cfg *apiConfig) handlerVideoStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID   uuid.UUID               `json:"video_id"`
+		ViewCount int64                   `json:"view_count"`
+		Bucket    database.ViewBucketSize `json:"bucket"`
+		From      time.Time               `json:"from"`
+		To        time.Time               `json:"to"`
+		Buckets   []viewStatsBucket       `json:"buckets"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	size := database.ViewBucketSize(query.Get("bucket"))
+	var step, defaultRange time.Duration
+	switch size {
+	case "", database.ViewBucketDay:
+		size, step, defaultRange = database.ViewBucketDay, 24*time.Hour, 30*24*time.Hour
+	case database.ViewBucketHour:
+		step, defaultRange = time.Hour, 48*time.Hour
+	default:
+		respondWithError(w, http.StatusBadRequest, "bucket must be hour or day", nil)
+		return
+	}
+
+	to := time.Now().UTC()
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "to must be an RFC 3339 time", err)
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-defaultRange)
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "from must be an RFC 3339 time", err)
+			return
+		}
+		from = t.UTC()
+	}
+	// buckets are whole hours or days in UTC
+	from = from.Truncate(step)
+	if !from.Before(to) {
+		respondWithError(w, http.StatusBadRequest, "from must be before to", nil)
+		return
+	}
+	if to.Sub(from)/step >= maxStatsBuckets {
+		respondWithError(w, http.StatusBadRequest, "The range has too many buckets, use a shorter one or bigger buckets", errors.New("too many buckets"))
+		return
+	}
+
+	counts, err := cfg.db.GetVideoViewCounts(video.ID, size, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count views", err)
+		return
+	}
+
+	// every bucket is listed so quiet periods show up as zeros
+	buckets := []viewStatsBucket{}
+	next := 0
+	for start := from; start.Before(to); start = start.Add(step) {
+		bucket := viewStatsBucket{Start: start}
+		if next < len(counts) && counts[next].Start.Equal(start) {
+			bucket.Views, bucket.Viewers = counts[next].Views, counts[next].Viewers
+			next++
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		VideoID:   video.ID,
+		ViewCount: video.ViewCount,
+		Bucket:    size,
+		From:      from,
+		To:        to,
+		Buckets:   buckets,
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Webhooks are managed with a JWT only, like API keys, so a leaked key
+// can't be used to have events sent somewhere else
+
+const webhookDeliveriesLimit = 100
+
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string         `json:"url"`
+		Events []webhookEvent `json:"events"`
+	}
+	type response struct {
+		database.Webhook
+		// Secret is only ever shown here, receivers check signatures with it
+		Secret string `json:"secret"`
+	}
+
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := cfg.validateWebhookURL(params.URL); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required", nil)
+		return
+	}
+	events := []string{}
+	for _, event := range params.Events {
+		if !slices.Contains(webhookEvents, event) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q", event), nil)
+			return
+		}
+		if !slices.Contains(events, string(event)) {
+			events = append(events, string(event))
+		}
+	}
+
+	existing, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can have at most %d webhooks", maxWebhooksPerUser), nil)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+		return
+	}
+	webhook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    params.URL,
+		Events: events,
+		Secret: hex.EncodeToString(secret),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response{
+		Webhook: webhook,
+		Secret:  webhook.Secret,
+	})
+}
+
+// validateWebhookURL only takes https URLs, or http ones in development
+func (cfg *apiConfig) validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%q isn't a URL", rawURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("webhook URLs can't have credentials")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && cfg.platform == "dev") {
+		return fmt.Errorf("webhook URLs must use https")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	cfg.respondWithList(w, r, webhooks, completeList(len(webhooks)))
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return
+	}
+	deleted, err := cfg.db.DeleteWebhook(userID, webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookDeliveries lists the events the webhook's endpoint hasn't
+// taken yet, and the ones it never took
+func (cfg *apiConfig) handlerWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return
+	}
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook", err)
+		return
+	}
+	if webhook.ID == uuid.Nil || webhook.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+	deliveries, err := cfg.db.GetWebhookDeliveries(webhookID, webhookDeliveriesLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook deliveries", err)
+		return
+	}
+	cfg.respondWithList(w, r, deliveries, completeList(len(deliveries)))
+}
+
+// handlerWebhookReplay delivers the webhook's events again from since on,
+// for an endpoint that missed them. Deliveries go out in the order the
+// events happened, a batch per request; a batch is continued by passing
+// the last_event_id of the previous one as after.
+func (cfg *apiConfig) handlerWebhookReplay(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateJWT(w, r)
+	if !ok {
+		return
+	}
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return
+	}
+	query := r.URL.Query()
+	since, err := time.Parse(time.RFC3339, query.Get("since"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 time", err)
+		return
+	}
+	var afterID int64
+	if s := query.Get("after"); s != "" {
+		afterID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || afterID < 0 {
+			respondWithError(w, http.StatusBadRequest, "after must be an event ID", err)
+			return
+		}
+	}
+
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook", err)
+		return
+	}
+	if webhook.ID == uuid.Nil || webhook.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+	replay, err := cfg.replayWebhookEvents(webhook, since, afterID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't replay webhook events", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, replay)
+}
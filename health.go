@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// healthCheckTimeout bounds each dependency check, so a hung one fails the
+// probe instead of outlasting it
+const healthCheckTimeout = 5 * time.Second
+
+type healthCheck struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// localChecks are the dependencies on this machine: if they fail the server
+// can't work until it's restarted or fixed
+func (cfg *apiConfig) localChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{
+		"database": cfg.db.Ping,
+		"ffmpeg": func(context.Context) error {
+			_, err := cfg.media.LookPath("ffmpeg")
+			return err
+		},
+		"ffprobe": func(context.Context) error {
+			_, err := cfg.media.LookPath("ffprobe")
+			return err
+		},
+		"assets_dir": func(context.Context) error {
+			return storage.CheckWritable(cfg.assetsRoot)
+		},
+		"temp_dir": func(context.Context) error {
+			return storage.CheckWritable(cfg.tempDir)
+		},
+	}
+}
+
+// storageChecks reach the default store and every storage region.
+// Organizations' own buckets are theirs to keep working and aren't checked.
+func (cfg *apiConfig) storageChecks() map[string]func(context.Context) error {
+	checks := map[string]func(context.Context) error{}
+	if checker, ok := cfg.store.(storage.Checker); ok {
+		checks["storage"] = checker.Check
+	}
+	for name, region := range cfg.storageRegions {
+		checks["storage_region:"+name] = region.store.Check
+	}
+	return checks
+}
+
+// runHealthChecks runs the checks at once and responds 200 if all of them
+// pass and 503 otherwise, with the result of each
+func runHealthChecks(w http.ResponseWriter, r *http.Request, checks map[string]func(context.Context) error) {
+	resp := healthResponse{Status: "ok", Checks: map[string]healthCheck{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check(ctx)
+			result := healthCheck{Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = result
+			if err != nil {
+				resp.Status = "error"
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, resp)
+}
+
+// handlerHealthz is the liveness probe. It leaves out storage, since
+// restarting the server doesn't help when S3 is down.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	runHealthChecks(w, r, cfg.localChecks())
+}
+
+// handlerReadyz is the readiness probe, checking storage along with
+// everything the liveness probe does
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := cfg.localChecks()
+	for name, check := range cfg.storageChecks() {
+		checks[name] = check
+	}
+	if pinger, ok := cfg.scanner.(scan.Pinger); ok {
+		checks["malware_scanner"] = pinger.Ping
+	}
+	runHealthChecks(w, r, checks)
+}
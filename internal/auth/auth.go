@@ -2,10 +2,12 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -30,59 +32,126 @@ func HashPassword(password string) (string, error) {
 	return string(dat), nil
 }
 
+// MakeUnusablePasswordHash hashes a random password nobody is told, for users
+// who sign in some other way
+func MakeUnusablePasswordHash() (string, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return "", err
+	}
+	return HashPassword(hex.EncodeToString(password))
+}
+
 func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// Claims is what a validated JWT says about its bearer
+type Claims struct {
+	UserID uuid.UUID
+	// Scopes restrict what the token can be used for. A token without any
+	// can do anything its user can.
+	Scopes []Scope
+}
+
+// Allows reports whether the token can be used for something that needs
+// scope
+func (c Claims) Allows(scope Scope) bool {
+	return len(c.Scopes) == 0 || HasScope(c.Scopes, scope)
+}
+
+// ErrRestrictedToken is returned by ValidateJWT for a token with scopes
+var ErrRestrictedToken = errors.New("token is restricted to some scopes")
+
+// tokenClaims carries scopes space separated, like OAuth's scope claim
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
+}
+
+// MakeJWT issues an access token for userID, restricted to scopes if any
+// are given
 func MakeJWT(
 	userID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
+	scopes ...Scope,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Scope: strings.Join(names, " "),
 	})
 	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+// ParseJWT validates an access token and returns its claims, scoped or not.
+// Callers have to check the scopes themselves.
+func ParseJWT(tokenString, tokenSecret string) (Claims, error) {
+	claimsStruct := tokenClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return Claims{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return Claims{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+	claims := Claims{UserID: id}
+	for _, name := range strings.Fields(claimsStruct.Scope) {
+		claims.Scopes = append(claims.Scopes, Scope(name))
+	}
+	return claims, nil
+}
+
+// ValidateJWT returns the user an unrestricted access token is for. Scoped
+// tokens are refused, so they only work where their scopes are checked.
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(claims.Scopes) > 0 {
+		return uuid.Nil, ErrRestrictedToken
 	}
-	return id, nil
+	return claims.UserID, nil
 }
 
+// GetBearerToken returns the bearer token from the Authorization header,
+// falling back to an X-API-Key header for clients that send a raw API key
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
+		if apiKey := headers.Get("X-API-Key"); apiKey != "" {
+			return apiKey, nil
+		}
 		return "", ErrNoAuthHeaderIncluded
 	}
 	splitAuth := strings.Split(authHeader, " ")
@@ -114,3 +183,71 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// APIKeyPrefix starts every API key so they can be told apart from JWTs
+const APIKeyPrefix = "tbk_"
+
+type Scope string
+
+const (
+	ScopeVideosRead   Scope = "videos:read"
+	ScopeVideosWrite  Scope = "videos:write"
+	ScopeVideosDelete Scope = "videos:delete"
+	// ScopeThumbnailsWrite only allows setting thumbnails, which
+	// videos:write allows too
+	ScopeThumbnailsWrite Scope = "thumbnails:write"
+	// ScopeProfileWrite allows changing the caller's public profile
+	ScopeProfileWrite Scope = "profile:write"
+	// ScopeAdmin lets a token reach the admin endpoints, which still check
+	// its user is an admin
+	ScopeAdmin Scope = "admin"
+)
+
+var validScopes = map[Scope]bool{
+	ScopeVideosRead:      true,
+	ScopeVideosWrite:     true,
+	ScopeVideosDelete:    true,
+	ScopeThumbnailsWrite: true,
+	ScopeProfileWrite:    true,
+	ScopeAdmin:           true,
+}
+
+// impliedScopes are the narrower scopes a scope includes
+var impliedScopes = map[Scope][]Scope{
+	ScopeVideosWrite: {ScopeThumbnailsWrite},
+}
+
+func ValidScope(scope Scope) bool {
+	return validScopes[scope]
+}
+
+// HasScope reports whether granted includes scope, directly or through a
+// broader scope
+func HasScope[S ~string](granted []S, scope Scope) bool {
+	for _, g := range granted {
+		if Scope(g) == scope || slices.Contains(impliedScopes[Scope(g)], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(key), nil
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashAPIKey hashes a key for storage. Keys are random enough that a fast
+// hash is fine, and it has to be deterministic so keys can be looked up.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
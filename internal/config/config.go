@@ -0,0 +1,216 @@
+// Package config reads the server's settings from the environment and an
+// optional config file. Problems are collected instead of stopping at the
+// first one, so a bad deploy reports every missing or invalid value at once.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Loader looks settings up by name. Values in the environment win over the
+// config file, and an empty value counts as unset.
+type Loader struct {
+	lookupEnv func(string) (string, bool)
+	file      map[string]string
+	fileName  string
+	// every setting that was asked for, to catch typos in the config file
+	known    map[string]bool
+	problems []string
+}
+
+// NewLoader reads settings from lookupEnv, usually os.LookupEnv, and then
+// from fileName, a file of KEY=value lines like .env, unless it's empty
+func NewLoader(lookupEnv func(string) (string, bool), fileName string) (*Loader, error) {
+	l := &Loader{
+		lookupEnv: lookupEnv,
+		file:      map[string]string{},
+		fileName:  fileName,
+		known:     map[string]bool{},
+	}
+	if fileName != "" {
+		file, err := godotenv.Read(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read config file: %w", err)
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+// Lookup returns a setting's raw value and whether it's set at all, even to
+// an empty string
+func (l *Loader) Lookup(name string) (string, bool) {
+	l.known[name] = true
+	if value, ok := l.lookupEnv(name); ok {
+		return value, true
+	}
+	value, ok := l.file[name]
+	return value, ok
+}
+
+// Getenv returns a setting or "", for packages that read their own settings
+// through a getenv function
+func (l *Loader) Getenv(name string) string {
+	value, _ := l.Lookup(name)
+	return value
+}
+
+func (l *Loader) String(name, def string) string {
+	if value := l.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Required returns a setting, recording a problem if it isn't set
+func (l *Loader) Required(name string) string {
+	value := l.Getenv(name)
+	if value == "" {
+		l.Failf("%s is not set", name)
+	}
+	return value
+}
+
+func (l *Loader) Bool(name string, def bool) bool {
+	value := l.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.Failf("%s must be true or false, got %q", name, value)
+		return def
+	}
+	return b
+}
+
+// Int returns a setting that must be an integer from min to max
+func (l *Loader) Int(name string, def, min, max int) int {
+	value := l.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		l.Failf("%s must be %s, got %q", name, describeRange(min, max), value)
+		return def
+	}
+	return n
+}
+
+// Float returns a setting that must be a number of at least min
+func (l *Loader) Float(name string, def, min float64) float64 {
+	value := l.Getenv(name)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < min {
+		l.Failf("%s must be a number of at least %g, got %q", name, min, value)
+		return def
+	}
+	return f
+}
+
+// Duration returns a setting that must be a duration like "90s" or "2h",
+// and at least min
+func (l *Loader) Duration(name string, def, min time.Duration) time.Duration {
+	value := l.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < min {
+		switch {
+		case min == 0:
+			l.Failf("%s must be a non-negative duration, got %q", name, value)
+		case min == time.Nanosecond:
+			l.Failf("%s must be a positive duration, got %q", name, value)
+		default:
+			l.Failf("%s must be a duration of at least %s, got %q", name, min, value)
+		}
+		return def
+	}
+	return d
+}
+
+// OneOf returns a setting that must be one of allowed
+func (l *Loader) OneOf(name, def string, allowed ...string) string {
+	value := l.Getenv(name)
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	quoted := make([]string, len(allowed))
+	for i, a := range allowed {
+		quoted[i] = strconv.Quote(a)
+	}
+	l.Failf("%s must be %s, got %q", name, joinOr(quoted), value)
+	return def
+}
+
+// Invalid records that a setting couldn't be parsed by the caller
+func (l *Loader) Invalid(name string, err error) {
+	l.Failf("%s is invalid: %v", name, err)
+}
+
+// Fail records a problem whose message already names the settings involved
+func (l *Loader) Fail(err error) {
+	l.problems = append(l.problems, err.Error())
+}
+
+func (l *Loader) Failf(format string, args ...any) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+// Err lists every problem found so far, along with config file entries no
+// setting was read from, or returns nil if there are none
+func (l *Loader) Err() error {
+	problems := append([]string(nil), l.problems...)
+	var unknown []string
+	for name := range l.file {
+		if !l.known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("%s in %s isn't a setting", name, l.fileName))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
+
+func describeRange(min, max int) string {
+	switch {
+	case max == math.MaxInt && min == 0:
+		return "a non-negative integer"
+	case max == math.MaxInt && min == 1:
+		return "a positive integer"
+	case max == math.MaxInt:
+		return fmt.Sprintf("an integer of at least %d", min)
+	default:
+		return fmt.Sprintf("between %d and %d", min, max)
+	}
+}
+
+func joinOr(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AnalyticsEventType string
+
+const (
+	AnalyticsView      AnalyticsEventType = "view"
+	AnalyticsHeartbeat AnalyticsEventType = "heartbeat"
+)
+
+// AnalyticsEvent is a counted view or a heartbeat of a video. Viewer is
+// "user:" and the account's ID for signed in viewers, or "session:" and
+// the player's session ID, the same for both kinds of event.
+type AnalyticsEvent struct {
+	Type            AnalyticsEventType
+	ID              int64
+	CreatedAt       time.Time
+	Viewer          string
+	SignedIn        bool
+	PositionSeconds *float64
+}
+
+// EachVideoAnalyticsEvent calls fn with a video's views and heartbeats
+// from from until to, including to's second, oldest first. It reads a page
+// at a time so fn can be slow without holding the database.
+func (c Client) EachVideoAnalyticsEvent(videoID uuid.UUID, from, to time.Time, fn func(AnalyticsEvent) error) error {
+	const pageSize = 1000
+	query := `
+	SELECT type, id, created_at, viewer, signed_in, position_seconds
+	FROM (
+		SELECT 'view' AS type, id, created_at, viewer,
+			user_id IS NOT NULL AS signed_in, NULL AS position_seconds
+		FROM video_views
+		WHERE video_id = ? AND created_at >= ? AND created_at <= ?
+		UNION ALL
+		SELECT 'heartbeat', id, created_at,
+			CASE WHEN user_id IS NOT NULL THEN 'user:' || user_id ELSE 'session:' || session_id END,
+			user_id IS NOT NULL, position_seconds
+		FROM video_heartbeats
+		WHERE video_id = ? AND created_at >= ? AND created_at <= ?
+	)
+	WHERE (created_at, type, id) > (?, ?, ?)
+	ORDER BY created_at, type, id
+	LIMIT ?
+	`
+	fromText, toText := from.UTC().Format(sqliteTime), to.UTC().Format(sqliteTime)
+
+	afterCreatedAt, afterType, afterID := "", "", int64(0)
+	for {
+		rows, err := c.db.Query(query,
+			videoID, fromText, toText,
+			videoID, fromText, toText,
+			afterCreatedAt, afterType, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+		page := []AnalyticsEvent{}
+		for rows.Next() {
+			var event AnalyticsEvent
+			var position sql.NullFloat64
+			if err := rows.Scan(&event.Type, &event.ID, &event.CreatedAt, &event.Viewer, &event.SignedIn, &position); err != nil {
+				rows.Close()
+				return err
+			}
+			if position.Valid {
+				event.PositionSeconds = &position.Float64
+			}
+			page = append(page, event)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, event := range page {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterType, afterID = last.CreatedAt.UTC().Format(sqliteTime), string(last.Type), last.ID
+	}
+}
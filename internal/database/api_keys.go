@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// KeyHash is never sent to clients; KeyPrefix is enough to recognise a key
+	KeyHash   string   `json:"-"`
+	KeyPrefix string   `json:"key_prefix"`
+	Scopes    []string `json:"scopes"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		last_used_at,
+		revoked_at,
+		user_id,
+		name,
+		key_hash,
+		key_prefix,
+		scopes
+`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.UserID,
+		&key.Name,
+		&key.KeyHash,
+		&key.KeyPrefix,
+		&scopes,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	return key, nil
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		key_hash,
+		key_prefix,
+		scopes
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.Name, params.KeyHash, params.KeyPrefix, strings.Join(params.Scopes, ","))
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+
+	key, err := scanAPIKey(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) MarkAPIKeyUsed(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetVideoArchived archives or unarchives a video
+func (c Client) SetVideoArchived(id uuid.UUID, archived bool) error {
+	query := `
+	UPDATE videos
+	SET archived_at = CASE WHEN ? THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, archived, id)
+	return err
+}
+
+// GetArchivedVideos returns a user's archived videos, most recently
+// archived first
+func (c Client) GetArchivedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND archived_at IS NOT NULL AND deleted_at IS NULL
+	ORDER BY archived_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
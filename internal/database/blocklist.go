@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type BlocklistHashType string
+
+const (
+	// BlocklistSHA256 matches the exact bytes of an upload
+	BlocklistSHA256 BlocklistHashType = "sha256"
+	// BlocklistPerceptual matches a 64-bit difference hash of a frame, so
+	// re-encoded copies are caught too
+	BlocklistPerceptual BlocklistHashType = "perceptual"
+)
+
+type BlocklistAction string
+
+const (
+	BlocklistReject     BlocklistAction = "reject"
+	BlocklistQuarantine BlocklistAction = "quarantine"
+)
+
+type BlocklistEntry struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateBlocklistEntryParams
+}
+
+type CreateBlocklistEntryParams struct {
+	HashType  BlocklistHashType `json:"hash_type"`
+	Hash      string            `json:"hash"`
+	Action    BlocklistAction   `json:"action"`
+	Reason    string            `json:"reason"`
+	CreatedBy uuid.UUID         `json:"created_by"`
+}
+
+const blocklistColumns = `
+		id,
+		created_at,
+		hash_type,
+		hash,
+		action,
+		reason,
+		created_by
+`
+
+func scanBlocklistEntry(row rowScanner) (BlocklistEntry, error) {
+	var entry BlocklistEntry
+	err := row.Scan(
+		&entry.ID,
+		&entry.CreatedAt,
+		&entry.HashType,
+		&entry.Hash,
+		&entry.Action,
+		&entry.Reason,
+		&entry.CreatedBy,
+	)
+	return entry, err
+}
+
+func (c Client) CreateBlocklistEntry(params CreateBlocklistEntryParams) (BlocklistEntry, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO content_blocklist (
+		id,
+		created_at,
+		hash_type,
+		hash,
+		action,
+		reason,
+		created_by
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.HashType, params.Hash, params.Action, params.Reason, params.CreatedBy)
+	if err != nil {
+		return BlocklistEntry{}, err
+	}
+
+	return c.GetBlocklistEntry(id)
+}
+
+func (c Client) GetBlocklistEntry(id uuid.UUID) (BlocklistEntry, error) {
+	query := `
+	SELECT` + blocklistColumns + `
+	FROM content_blocklist
+	WHERE id = ?
+	`
+
+	entry, err := scanBlocklistEntry(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BlocklistEntry{}, nil
+		}
+		return BlocklistEntry{}, err
+	}
+	return entry, nil
+}
+
+// FindBlocklistEntry returns the entry for an exact hash, or a zero entry if
+// the hash isn't listed
+func (c Client) FindBlocklistEntry(hashType BlocklistHashType, hash string) (BlocklistEntry, error) {
+	query := `
+	SELECT` + blocklistColumns + `
+	FROM content_blocklist
+	WHERE hash_type = ? AND hash = ?
+	`
+
+	entry, err := scanBlocklistEntry(c.db.QueryRow(query, hashType, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BlocklistEntry{}, nil
+		}
+		return BlocklistEntry{}, err
+	}
+	return entry, nil
+}
+
+// GetBlocklistEntries lists every entry, or only those of hashType if it
+// isn't empty, newest first
+func (c Client) GetBlocklistEntries(hashType BlocklistHashType) ([]BlocklistEntry, error) {
+	query := `
+	SELECT` + blocklistColumns + `
+	FROM content_blocklist
+	WHERE ? = '' OR hash_type = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, hashType, hashType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []BlocklistEntry{}
+	for rows.Next() {
+		entry, err := scanBlocklistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (c Client) DeleteBlocklistEntry(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM content_blocklist WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Caption is a WebVTT caption track for one language
+type Caption struct {
+	Language  string    `json:"language"`
+	Label     string    `json:"label"`
+	URL       string    `json:"url"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Captions is stored as a JSON array in the videos table
+type Captions []Caption
+
+func (c *Captions) Scan(src any) error {
+	*c = Captions{}
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	case []byte:
+		return json.Unmarshal(src, c)
+	default:
+		return fmt.Errorf("can't scan %T into captions", src)
+	}
+}
+
+func (c Captions) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	dat, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// UpdateVideoCaptions replaces the caption tracks of a video and queues the
+// files of tracks that were replaced or removed for deletion, in a single
+// transaction
+func (c Client) UpdateVideoCaptions(videoID uuid.UUID, captions Captions, removed []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET captions = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, captions, videoID); err != nil {
+		return nil, err
+	}
+	deletions, err := insertPendingDeletions(tx, removed)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+)
+
+// VideoChange is an entry in a user's change feed. IDs only ever grow and
+// SQLite lets one writer commit at a time, so once a reader has seen an ID
+// no change with a smaller one can appear.
+type VideoChange struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	Type      ChangeType `json:"type"`
+}
+
+// ErrChangesPruned is returned for a cursor older than the changes that are
+// still kept
+var ErrChangesPruned = errors.New("changes since the cursor have been pruned")
+
+// migrateChanges records every write to a video in video_changes with
+// triggers, so nothing that updates a video can forget to
+func (c *Client) migrateChanges() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS video_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		type TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_changes_user ON video_changes(user_id, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	triggers := []string{`
+	CREATE TRIGGER IF NOT EXISTS video_changes_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO video_changes (video_id, user_id, type) VALUES (new.id, new.user_id, 'created');
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS video_changes_update AFTER UPDATE ON videos
+	-- view counts change on every play and aren't worth syncing
+	WHEN old.view_count = new.view_count BEGIN
+		INSERT INTO video_changes (video_id, user_id, type) VALUES (new.id, new.user_id, 'updated');
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS video_changes_delete AFTER DELETE ON videos BEGIN
+		INSERT INTO video_changes (video_id, user_id, type) VALUES (old.id, old.user_id, 'deleted');
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS video_changes_tag_insert AFTER INSERT ON video_tags BEGIN
+		INSERT INTO video_changes (video_id, user_id, type)
+		SELECT id, user_id, 'updated' FROM videos WHERE id = new.video_id;
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS video_changes_tag_delete AFTER DELETE ON video_tags BEGIN
+		INSERT INTO video_changes (video_id, user_id, type)
+		SELECT id, user_id, 'updated' FROM videos WHERE id = old.video_id;
+	END
+	`}
+	for _, trigger := range triggers {
+		if _, err := c.db.Exec(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetVideoChanges returns up to limit changes to a user's videos after the
+// change with ID since, oldest first, and whether there are more. It fails
+// with ErrChangesPruned if some of them are gone.
+func (c Client) GetVideoChanges(userID uuid.UUID, since int64, limit int) ([]VideoChange, bool, error) {
+	oldest, err := c.oldestVideoChangeID()
+	if err != nil {
+		return nil, false, err
+	}
+	if since+1 < oldest {
+		return nil, false, ErrChangesPruned
+	}
+
+	query := `
+	SELECT id, created_at, video_id, type
+	FROM video_changes
+	WHERE user_id = ? AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, since, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	changes := []VideoChange{}
+	for rows.Next() {
+		var change VideoChange
+		if err := rows.Scan(&change.ID, &change.CreatedAt, &change.VideoID, &change.Type); err != nil {
+			return nil, false, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+	return changes, more, nil
+}
+
+// oldestVideoChangeID is the ID of the oldest change still kept, or the next
+// one to be made if they've all been pruned
+func (c Client) oldestVideoChangeID() (int64, error) {
+	var oldest sql.NullInt64
+	if err := c.db.QueryRow("SELECT MIN(id) FROM video_changes").Scan(&oldest); err != nil {
+		return 0, err
+	}
+	if oldest.Valid {
+		return oldest.Int64, nil
+	}
+	var last int64
+	err := c.db.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'video_changes'").Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 1, nil
+	}
+	return last + 1, err
+}
+
+// LatestVideoChangeID is the ID of the newest change to anyone's videos,
+// for a client to start following the feed from now
+func (c Client) LatestVideoChangeID() (int64, error) {
+	oldest, err := c.oldestVideoChangeID()
+	if err != nil {
+		return 0, err
+	}
+	var latest sql.NullInt64
+	if err := c.db.QueryRow("SELECT MAX(id) FROM video_changes").Scan(&latest); err != nil {
+		return 0, err
+	}
+	if !latest.Valid {
+		return oldest - 1, nil
+	}
+	return latest.Int64, nil
+}
+
+// DeleteVideoChangesBefore prunes changes made before cutoff
+func (c Client) DeleteVideoChangesBefore(cutoff time.Time) (int64, error) {
+	result, err := c.db.Exec("DELETE FROM video_changes WHERE created_at < ?", cutoff.UTC().Format(sqliteTime))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetVideosByID returns the videos with the given IDs that still exist
+func (c Client) GetVideosByID(ids []uuid.UUID) (map[uuid.UUID]Video, error) {
+	videos := map[uuid.UUID]Video{}
+	if len(ids) == 0 {
+		return videos, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos[video.ID] = video
+	}
+	return videos, rows.Err()
+}
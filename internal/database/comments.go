@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Comment struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Body      string    `json:"body"`
+	// AuthorName and AuthorAvatarURL are from the commenter's profile
+	AuthorName      string  `json:"author_name"`
+	AuthorAvatarURL *string `json:"author_avatar_url"`
+	// DeletedAt is set once the commenter or the video's owner deletes the
+	// comment. Deleted comments are kept but left out of listings.
+	DeletedAt *time.Time    `json:"deleted_at"`
+	DeletedBy uuid.NullUUID `json:"-"`
+	// seq is the row's rowid, which only grows
+	seq int64
+}
+
+// CommentCursor is where a page of comments ends, to start the next one
+// after. Seq orders comments exactly, where created_at only has seconds.
+type CommentCursor struct {
+	Seq int64 `json:"seq"`
+}
+
+const commentColumns = `
+		comments.id,
+		comments.created_at,
+		comments.video_id,
+		comments.user_id,
+		comments.body,
+		users.display_name,
+		users.avatar_small_url,
+		comments.deleted_at,
+		comments.deleted_by,
+		comments.rowid
+`
+
+func scanComment(row rowScanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.VideoID,
+		&comment.UserID,
+		&comment.Body,
+		&comment.AuthorName,
+		&comment.AuthorAvatarURL,
+		&comment.DeletedAt,
+		&comment.DeletedBy,
+		&comment.seq,
+	)
+	return comment, err
+}
+
+// CreateComment adds a comment to a video and counts it on the video
+func (c Client) CreateComment(videoID, userID uuid.UUID, body string) (Comment, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Comment{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	query := `
+	INSERT INTO comments (id, created_at, video_id, user_id, body)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	if _, err := tx.Exec(query, id, videoID, userID, body); err != nil {
+		return Comment{}, err
+	}
+	if err := countComments(tx, videoID); err != nil {
+		return Comment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Comment{}, err
+	}
+	return c.GetComment(id)
+}
+
+// GetComment returns the comment, deleted or not, or a zero Comment if
+// there's none
+func (c Client) GetComment(id uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments
+	JOIN users ON users.id = comments.user_id
+	WHERE comments.id = ?
+	`
+	comment, err := scanComment(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, nil
+	}
+	return comment, err
+}
+
+// DeleteComment marks the comment deleted by deletedBy and takes it off the
+// video's count. Deleting it again changes nothing.
+func (c Client) DeleteComment(id, deletedBy uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var videoID uuid.UUID
+	query := `
+	UPDATE comments
+	SET deleted_at = CURRENT_TIMESTAMP,
+		deleted_by = ?
+	WHERE id = ? AND deleted_at IS NULL
+	RETURNING video_id
+	`
+	err = tx.QueryRow(query, deletedBy, id).Scan(&videoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := countComments(tx, videoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func countComments(tx *sql.Tx, videoID uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET comment_count = (SELECT COUNT(*) FROM comments WHERE video_id = videos.id AND deleted_at IS NULL)
+	WHERE id = ?
+	`
+	_, err := tx.Exec(query, videoID)
+	return err
+}
+
+// ListComments returns a page of a video's comments, newest first, starting
+// after the cursor if there is one, and whether there's another page
+func (c Client) ListComments(videoID uuid.UUID, after *CommentCursor, limit int) ([]Comment, bool, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments
+	JOIN users ON users.id = comments.user_id
+	WHERE comments.video_id = ? AND comments.deleted_at IS NULL
+		AND (? OR comments.rowid < ?)
+	ORDER BY comments.rowid DESC
+	LIMIT ?
+	`
+	var afterSeq int64
+	if after != nil {
+		afterSeq = after.Seq
+	}
+	// fetch one extra row to know whether there's another page
+	rows, err := c.db.Query(query, videoID, after == nil, afterSeq, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(comments) <= limit {
+		return comments, false, nil
+	}
+	return comments[:limit], true, nil
+}
+
+// Cursor is where the comment falls in listings
+func (c Comment) Cursor() CommentCursor {
+	return CommentCursor{Seq: c.seq}
+}
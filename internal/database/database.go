@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -9,6 +10,8 @@ import (
 
 type Client struct {
 	db *sql.DB
+	// fts is set when SQLite was built with FTS5 and videos_fts exists
+	fts bool
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +19,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -25,6 +28,12 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// Ping checks the database answers queries
+func (c Client) Ping(ctx context.Context) error {
+	var one int
+	return c.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,18 +81,688 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	videoEventTable := `
+	CREATE TABLE IF NOT EXISTS video_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		upload_id TEXT,
+		stage TEXT NOT NULL,
+		type TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoEventTable)
+	if err != nil {
+		return err
+	}
+
+	pendingDeletionTable := `
+	CREATE TABLE IF NOT EXISTS pending_deletions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		kind TEXT NOT NULL,
+		key TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT ''
+	);
+	`
+	_, err = c.db.Exec(pendingDeletionTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	organizationMemberTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(organization_id, user_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationMemberTable)
+	if err != nil {
+		return err
+	}
+
+	organizationStorageTable := `
+	CREATE TABLE IF NOT EXISTS organization_storage (
+		organization_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		bucket TEXT NOT NULL,
+		region TEXT NOT NULL,
+		endpoint TEXT NOT NULL DEFAULT '',
+		access_key_id TEXT NOT NULL,
+		secret_access_key_encrypted TEXT NOT NULL,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);
+	`
+	_, err = c.db.Exec(organizationStorageTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentitiesTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		PRIMARY KEY(provider, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(userIdentitiesTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagsTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY(video_id, tag),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_tags_tag ON video_tags(tag);
+	`
+	_, err = c.db.Exec(videoTagsTable)
+	if err != nil {
+		return err
+	}
+
+	videoHeartbeatsTable := `
+	CREATE TABLE IF NOT EXISTS video_heartbeats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		user_id TEXT,
+		position_seconds REAL NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_heartbeats_video ON video_heartbeats(video_id, created_at);
+	`
+	_, err = c.db.Exec(videoHeartbeatsTable)
+	if err != nil {
+		return err
+	}
+
+	videoViewsTable := `
+	CREATE TABLE IF NOT EXISTS video_views (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		viewer TEXT NOT NULL,
+		user_id TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_views_viewer ON video_views(video_id, viewer, created_at);
+	CREATE INDEX IF NOT EXISTS idx_video_views_video ON video_views(video_id, created_at);
+	`
+	_, err = c.db.Exec(videoViewsTable)
+	if err != nil {
+		return err
+	}
+
+	serviceAccountsTable := `
+	CREATE TABLE IF NOT EXISTS service_accounts (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		organization_id TEXT NOT NULL,
+		user_id TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		video_prefix TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL,
+		disabled_at TIMESTAMP,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(serviceAccountsTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeysTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		key_prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeysTable)
+	if err != nil {
+		return err
+	}
+
+	blocklistTable := `
+	CREATE TABLE IF NOT EXISTS content_blocklist (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		hash_type TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		action TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL,
+		UNIQUE(hash_type, hash),
+		FOREIGN KEY(created_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(blocklistTable)
+	if err != nil {
+		return err
+	}
+
+	mediaReportsTable := `
+	CREATE TABLE IF NOT EXISTS media_reports (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		source TEXT NOT NULL,
+		stored TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(mediaReportsTable)
+	if err != nil {
+		return err
+	}
+
+	objectRefsTable := `
+	CREATE TABLE IF NOT EXISTS object_refs (
+		organization_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		refcount INTEGER NOT NULL,
+		PRIMARY KEY(organization_id, key)
+	);
+	`
+	_, err = c.db.Exec(objectRefsTable)
+	if err != nil {
+		return err
+	}
+
+	pushSubscriptionsTable := `
+	CREATE TABLE IF NOT EXISTS push_subscriptions (
+		endpoint TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		p256dh TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user ON push_subscriptions(user_id);
+	`
+	_, err = c.db.Exec(pushSubscriptionsTable)
+	if err != nil {
+		return err
+	}
+
+	webhooksTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		failed_at TIMESTAMP,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE failed_at IS NULL;
+	`
+	_, err = c.db.Exec(webhooksTable)
+	if err != nil {
+		return err
+	}
+
+	playlistsTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_playlists_user ON playlists(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_playlist_videos_position ON playlist_videos(playlist_id, position);
+	CREATE INDEX IF NOT EXISTS idx_playlist_videos_video ON playlist_videos(video_id);
+	`
+	_, err = c.db.Exec(playlistsTable)
+	if err != nil {
+		return err
+	}
+
+	reactionsTable := `
+	CREATE TABLE IF NOT EXISTS video_reactions (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		reaction TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE TABLE IF NOT EXISTS watch_later (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(user_id, video_id),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_watch_later_added ON watch_later(user_id, added_at);
+	CREATE INDEX IF NOT EXISTS idx_watch_later_video ON watch_later(video_id);
+	`
+	_, err = c.db.Exec(reactionsTable)
+	if err != nil {
+		return err
+	}
+
+	commentsTable := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		deleted_at TIMESTAMP,
+		deleted_by TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_comments_video ON comments(video_id);
+	`
+	_, err = c.db.Exec(commentsTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "organization_id", "TEXT REFERENCES organizations(id)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("pending_deletions", "organization_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("pending_deletions", "region", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "link_status", "TEXT NOT NULL DEFAULT 'unchecked'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "link_checked_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "pinned", "BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "sort_index", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("video_events", "error_class", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "size_bytes", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "duration_seconds", "REAL")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "view_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "processing_status", "TEXT NOT NULL DEFAULT 'awaiting_upload'")
+	if err != nil {
+		return err
+	}
+	// videos uploaded before processing_status existed
+	_, err = c.db.Exec("UPDATE videos SET processing_status = 'ready' WHERE video_url IS NOT NULL AND processing_status = 'awaiting_upload'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "indexable", "BOOLEAN NOT NULL DEFAULT TRUE")
+	if err != nil {
+		return err
+	}
+	mediaColumns := []struct{ name, definition string }{
+		{"video_codec", "TEXT"},
+		{"audio_codec", "TEXT"},
+		{"bit_rate", "INTEGER"},
+		{"frame_rate", "REAL"},
+		{"audio_channels", "INTEGER"},
+	}
+	for _, column := range mediaColumns {
+		if err := c.addColumnIfMissing("videos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	err = c.addColumnIfMissing("videos", "renditions", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "thumbnail_small_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "preview_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"sprite_url", "sprite_vtt_url"} {
+		if err := c.addColumnIfMissing("videos", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	err = c.addColumnIfMissing("videos", "captions", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "audio_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	retentionColumns := []struct{ name, definition string }{
+		{"delete_at", "TIMESTAMP"},
+		{"legal_hold", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"legal_hold_reason", "TEXT"},
+	}
+	for _, column := range retentionColumns {
+		if err := c.addColumnIfMissing("videos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	err = c.addColumnIfMissing("videos", "source_video_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "restore_requested_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"sha256", "upload_sha256"} {
+		if err := c.addColumnIfMissing("videos", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS idx_videos_upload_sha256 ON videos(user_id, upload_sha256)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "deleted_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "storage_region", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("organizations", "storage_region", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "archived_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "short_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	// NULLs don't collide, so videos without a short ID are fine
+	_, err = c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_videos_short_id ON videos(short_id)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "processing_status_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	// the last update is the best guess for videos from before the column
+	_, err = c.db.Exec("UPDATE videos SET processing_status_at = updated_at WHERE processing_status_at IS NULL")
+	if err != nil {
+		return err
+	}
+	premiereColumns := []struct{ name, definition string }{
+		{"premiere_at", "TIMESTAMP"},
+		{"premiere_pending", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	}
+	for _, column := range premiereColumns {
+		if err := c.addColumnIfMissing("videos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := c.addColumnIfMissing("videos", "original_filename", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("videos", "public_stats", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("videos", "embed_domains", "TEXT"); err != nil {
+		return err
+	}
+	for _, column := range []string{"video_scan", "thumbnail_scan"} {
+		if err := c.addColumnIfMissing("videos", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	moderationColumns := []struct{ name, definition string }{
+		{"moderation_status", "TEXT NOT NULL DEFAULT 'approved'"},
+		{"moderation_labels", "TEXT"},
+		{"moderated_at", "TIMESTAMP"},
+		{"moderated_by", "TEXT"},
+	}
+	for _, column := range moderationColumns {
+		if err := c.addColumnIfMissing("videos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := c.addColumnIfMissing("users", "suspended_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("users", "suspension_reason", "TEXT"); err != nil {
+		return err
+	}
+	for _, column := range []string{"like_count", "dislike_count", "comment_count"} {
+		if err := c.addColumnIfMissing("videos", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	profileColumns := []struct{ name, definition string }{
+		{"display_name", "TEXT NOT NULL DEFAULT ''"},
+		{"bio", "TEXT NOT NULL DEFAULT ''"},
+		{"avatar_url", "TEXT"},
+		{"avatar_small_url", "TEXT"},
+	}
+	for _, column := range profileColumns {
+		if err := c.addColumnIfMissing("users", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := c.migrateChanges(); err != nil {
+		return err
+	}
+	return c.migrateSearch()
+}
+
+// addColumnIfMissing lets autoMigrate extend tables that already exist in
+// older databases, since SQLite has no ADD COLUMN IF NOT EXISTS
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	exists, err := c.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (c *Client) hasColumn(table, column string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_heartbeats"); err != nil {
+		return fmt.Errorf("failed to reset table video_heartbeats: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_views"); err != nil {
+		return fmt.Errorf("failed to reset table video_views: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_reactions"); err != nil {
+		return fmt.Errorf("failed to reset table video_reactions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM watch_later"); err != nil {
+		return fmt.Errorf("failed to reset table watch_later: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM comments"); err != nil {
+		return fmt.Errorf("failed to reset table comments: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM media_reports"); err != nil {
+		return fmt.Errorf("failed to reset table media_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM service_accounts"); err != nil {
+		return fmt.Errorf("failed to reset table service_accounts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_storage"); err != nil {
+		return fmt.Errorf("failed to reset table organization_storage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM content_blocklist"); err != nil {
+		return fmt.Errorf("failed to reset table content_blocklist: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM push_subscriptions"); err != nil {
+		return fmt.Errorf("failed to reset table push_subscriptions: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM object_refs"); err != nil {
+		return fmt.Errorf("failed to reset table object_refs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM pending_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table pending_deletions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_events"); err != nil {
+		return fmt.Errorf("failed to reset table video_events: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	// the delete trigger records a change for every video removed above
+	if _, err := c.db.Exec("DELETE FROM video_changes"); err != nil {
+		return fmt.Errorf("failed to reset table video_changes: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// EmbedDomains are the sites a video may be embedded on, stored as a JSON
+// array in the videos table. Empty allows any site.
+type EmbedDomains []string
+
+func (d *EmbedDomains) Scan(src any) error {
+	*d = EmbedDomains{}
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), d)
+	case []byte:
+		return json.Unmarshal(src, d)
+	default:
+		return fmt.Errorf("can't scan %T into embed domains", src)
+	}
+}
+
+func (d EmbedDomains) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	dat, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (c Client) SetVideoEmbedDomains(videoID uuid.UUID, domains EmbedDomains) error {
+	query := `
+	UPDATE videos
+	SET embed_domains = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, domains, videoID)
+	return err
+}
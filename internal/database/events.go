@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventStageStarted      EventType = "stage_started"
+	EventStageCompleted    EventType = "stage_completed"
+	EventStageFailed       EventType = "stage_failed"
+	EventUploadCompleted   EventType = "upload_completed"
+	EventUploadFailed      EventType = "upload_failed"
+	EventUploadCanceled    EventType = "upload_canceled"
+	EventContentFlagged    EventType = "content_flagged"
+	EventLinkBroken        EventType = "link_broken"
+	EventLengthMismatch    EventType = "length_mismatch"
+	EventContentBlocked    EventType = "content_blocked"
+	EventLegalHold         EventType = "legal_hold"
+	EventDuplicateUpload   EventType = "duplicate_upload"
+	EventProcessingExpired EventType = "processing_expired"
+	EventPremiereStarted   EventType = "premiere_started"
+	EventModerationReview  EventType = "moderation_review"
+	EventUploadReceived    EventType = "upload_received"
+	EventThumbnailUpdated  EventType = "thumbnail_updated"
+)
+
+type VideoEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateVideoEventParams
+}
+
+type CreateVideoEventParams struct {
+	VideoID  uuid.UUID     `json:"video_id"`
+	UploadID uuid.NullUUID `json:"upload_id"`
+	Stage    string        `json:"stage"`
+	Type     EventType     `json:"type"`
+	Message  string        `json:"message"`
+	// ErrorClass groups failure events by cause, it's empty for other events
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+func (c Client) CreateVideoEvent(params CreateVideoEventParams) error {
+	query := `
+	INSERT INTO video_events (
+		video_id,
+		upload_id,
+		stage,
+		type,
+		message,
+		error_class,
+		created_at
+	) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.UploadID, params.Stage, params.Type, params.Message, params.ErrorClass)
+	return err
+}
+
+const videoEventColumns = `
+		id,
+		created_at,
+		video_id,
+		upload_id,
+		stage,
+		type,
+		message,
+		error_class
+`
+
+func scanVideoEvents(rows *sql.Rows) ([]VideoEvent, error) {
+	events := []VideoEvent{}
+	for rows.Next() {
+		var event VideoEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.VideoID,
+			&event.UploadID,
+			&event.Stage,
+			&event.Type,
+			&event.Message,
+			&event.ErrorClass,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (c Client) GetVideoEvents(videoID uuid.UUID) ([]VideoEvent, error) {
+	query := `
+	SELECT` + videoEventColumns + `
+	FROM video_events
+	WHERE video_id = ?
+	ORDER BY id ASC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideoEvents(rows)
+}
+
+// GetUserVideoEvents returns the events of the given types for every video
+// of userID, oldest first, from since on and after the event afterID
+func (c Client) GetUserVideoEvents(userID uuid.UUID, types []EventType, since time.Time, afterID int64, limit int) ([]VideoEvent, error) {
+	if len(types) == 0 {
+		return []VideoEvent{}, nil
+	}
+	query := `
+	SELECT` + videoEventColumns + `
+	FROM video_events
+	WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)
+		AND type IN (?` + strings.Repeat(", ?", len(types)-1) + `)
+		AND created_at >= ?
+		AND id > ?
+	ORDER BY id ASC
+	LIMIT ?
+	`
+	args := []any{userID}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(args, since.UTC().Format(sqliteTime), afterID, limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideoEvents(rows)
+}
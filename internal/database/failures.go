@@ -0,0 +1,128 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// UploadFailureFilter narrows down upload_failed events; zero fields match
+// everything
+type UploadFailureFilter struct {
+	Stage      string
+	ErrorClass string
+	Since      time.Time
+	Until      time.Time
+}
+
+type FailureCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type UploadFailureSummary struct {
+	Total        int            `json:"total"`
+	ByStage      []FailureCount `json:"by_stage"`
+	ByErrorClass []FailureCount `json:"by_error_class"`
+	TopMessages  []FailureCount `json:"top_messages"`
+}
+
+// sqliteTime matches how CURRENT_TIMESTAMP is stored so times compare as text
+const sqliteTime = "2006-01-02 15:04:05"
+
+func (f UploadFailureFilter) where() (string, []any) {
+	conditions := []string{"type = ?"}
+	args := []any{EventUploadFailed}
+	if f.Stage != "" {
+		conditions = append(conditions, "stage = ?")
+		args = append(args, f.Stage)
+	}
+	if f.ErrorClass != "" {
+		conditions = append(conditions, "error_class = ?")
+		args = append(args, f.ErrorClass)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(sqliteTime))
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.Until.UTC().Format(sqliteTime))
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetUploadFailures returns the newest failed uploads matching filter
+func (c Client) GetUploadFailures(filter UploadFailureFilter, limit int) ([]VideoEvent, error) {
+	where, args := filter.where()
+	query := `
+	SELECT` + videoEventColumns + `
+	FROM video_events` + where + `
+	ORDER BY id DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideoEvents(rows)
+}
+
+// GetUploadFailureSummary counts failed uploads matching filter by stage, by
+// error class and by message
+func (c Client) GetUploadFailureSummary(filter UploadFailureFilter) (UploadFailureSummary, error) {
+	where, args := filter.where()
+
+	summary := UploadFailureSummary{}
+	err := c.db.QueryRow("SELECT COUNT(*) FROM video_events"+where, args...).Scan(&summary.Total)
+	if err != nil {
+		return UploadFailureSummary{}, err
+	}
+
+	// column names come from this list, never from the request
+	groups := []struct {
+		column string
+		limit  int
+		dest   *[]FailureCount
+	}{
+		{"stage", -1, &summary.ByStage},
+		{"error_class", -1, &summary.ByErrorClass},
+		{"message", 10, &summary.TopMessages},
+	}
+	for _, g := range groups {
+		query := `
+		SELECT ` + g.column + `, COUNT(*) AS n
+		FROM video_events` + where + `
+		GROUP BY ` + g.column + `
+		ORDER BY n DESC
+		LIMIT ?
+		`
+		counts, err := c.queryFailureCounts(query, append(args, g.limit)...)
+		if err != nil {
+			return UploadFailureSummary{}, err
+		}
+		*g.dest = counts
+	}
+
+	return summary, nil
+}
+
+func (c Client) queryFailureCounts(query string, args ...any) ([]FailureCount, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FailureCount{}
+	for rows.Next() {
+		var count FailureCount
+		if err := rows.Scan(&count.Key, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
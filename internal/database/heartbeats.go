@@ -0,0 +1,66 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+type CreateVideoHeartbeatParams struct {
+	VideoID         uuid.UUID
+	SessionID       string
+	UserID          uuid.NullUUID
+	PositionSeconds float64
+}
+
+// HeatmapCount is how many heartbeats, from how many sessions, landed in one
+// bucket of a video
+type HeatmapCount struct {
+	Bucket     int
+	Heartbeats int64
+	Sessions   int64
+}
+
+func (c Client) CreateVideoHeartbeat(params CreateVideoHeartbeatParams) error {
+	query := `
+	INSERT INTO video_heartbeats (video_id, session_id, user_id, position_seconds)
+	VALUES (?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.SessionID, params.UserID, params.PositionSeconds)
+	return err
+}
+
+// GetVideoHeatmap splits a video of the given duration into equal buckets
+// and counts the heartbeats in each, skipping empty buckets. It also returns
+// how many sessions sent any heartbeat.
+func (c Client) GetVideoHeatmap(videoID uuid.UUID, durationSeconds float64, buckets int) ([]HeatmapCount, int64, error) {
+	var sessions int64
+	err := c.db.QueryRow("SELECT COUNT(DISTINCT session_id) FROM video_heartbeats WHERE video_id = ?", videoID).Scan(&sessions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// positions at or past the end go in the last bucket
+	query := `
+	SELECT MIN(CAST(position_seconds * ? / ? AS INTEGER), ? - 1) AS bucket,
+		COUNT(*),
+		COUNT(DISTINCT session_id)
+	FROM video_heartbeats
+	WHERE video_id = ?
+	GROUP BY bucket
+	ORDER BY bucket
+	`
+	rows, err := c.db.Query(query, buckets, durationSeconds, buckets, videoID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	counts := []HeatmapCount{}
+	for rows.Next() {
+		var count HeatmapCount
+		if err := rows.Scan(&count.Bucket, &count.Heartbeats, &count.Sessions); err != nil {
+			return nil, 0, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, sessions, rows.Err()
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// EachVideoOfAllUsers is EachVideo over every user's videos, trashed and
+// archived ones included
+func (c Client) EachVideoOfAllUsers(fn func(Video) error) error {
+	return c.eachVideo("TRUE", nil, fn)
+}
+
+// videoURLColumns hold one URL each; videoURLListColumns hold JSON arrays
+// of objects with a url field
+var (
+	videoURLColumns = []string{
+		"video_url",
+		"thumbnail_url",
+		"thumbnail_small_url",
+		"preview_url",
+		"sprite_url",
+		"sprite_vtt_url",
+		"audio_url",
+	}
+	videoURLListColumns = []string{"renditions", "captions"}
+)
+
+// RewriteVideoURLs replaces the prefix from with to in every URL stored for
+// a video, renditions and captions included, and returns how many videos
+// changed
+func (c Client) RewriteVideoURLs(from, to string) (int64, error) {
+	if from == "" {
+		return 0, fmt.Errorf("no prefix to rewrite")
+	}
+	sets := []string{}
+	matches := []string{}
+	for _, column := range videoURLColumns {
+		sets = append(sets, fmt.Sprintf("%[1]s = CASE WHEN substr(%[1]s, 1, length(?1)) = ?1 THEN ?2 || substr(%[1]s, length(?1) + 1) ELSE %[1]s END", column))
+		matches = append(matches, fmt.Sprintf("substr(%s, 1, length(?1)) = ?1", column))
+	}
+	// inside the JSON a URL always follows the quote that opens it
+	for _, column := range videoURLListColumns {
+		sets = append(sets, fmt.Sprintf(`%[1]s = replace(%[1]s, '"' || ?1, '"' || ?2)`, column))
+		matches = append(matches, fmt.Sprintf(`instr(%s, '"' || ?1) > 0`, column))
+	}
+	query := `
+	UPDATE videos
+	SET ` + strings.Join(sets, ",\n\t\t") + `
+	WHERE ` + strings.Join(matches, " OR ")
+
+	result, err := c.db.Exec(query, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateVideoStoredSizes records the sizes of a video's main file and its
+// renditions as the store reports them
+func (c Client) UpdateVideoStoredSizes(videoID uuid.UUID, sizeBytes int64, renditions Renditions) error {
+	query := `
+	UPDATE videos
+	SET size_bytes = ?,
+		renditions = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, sizeBytes, renditions, videoID)
+	return err
+}
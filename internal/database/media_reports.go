@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaReport is the full ffprobe analysis of a video, taken while it was
+// processed. Source describes the file as uploaded, Stored the file that
+// was kept, which may have been remuxed or transcoded.
+type MediaReport struct {
+	VideoID   uuid.UUID       `json:"video_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Source    json.RawMessage `json:"source"`
+	Stored    json.RawMessage `json:"stored"`
+}
+
+// SaveMediaReport stores the report of a video, replacing the one of any
+// earlier upload
+func (c Client) SaveMediaReport(videoID uuid.UUID, source, stored json.RawMessage) error {
+	query := `
+	INSERT INTO media_reports (video_id, created_at, source, stored)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		created_at = excluded.created_at,
+		source = excluded.source,
+		stored = excluded.stored
+	`
+	_, err := c.db.Exec(query, videoID, string(source), string(stored))
+	return err
+}
+
+// GetMediaReport returns a zero MediaReport if the video has none, like
+// videos processed before reports were kept
+func (c Client) GetMediaReport(videoID uuid.UUID) (MediaReport, error) {
+	query := `
+	SELECT video_id, created_at, source, stored
+	FROM media_reports
+	WHERE video_id = ?
+	`
+	var report MediaReport
+	var source, stored string
+	err := c.db.QueryRow(query, videoID).Scan(&report.VideoID, &report.CreatedAt, &source, &stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MediaReport{}, nil
+	}
+	if err != nil {
+		return MediaReport{}, err
+	}
+	report.Source = json.RawMessage(source)
+	report.Stored = json.RawMessage(stored)
+	return report, nil
+}
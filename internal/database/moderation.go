@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type ModerationStatus string
+
+const (
+	// ModerationPending videos are waiting for the content moderator, or
+	// for a reviewer after the moderator failed
+	ModerationPending ModerationStatus = "pending"
+	// ModerationApproved is the default, for videos uploaded without a
+	// content moderator too
+	ModerationApproved ModerationStatus = "approved"
+	// ModerationFlagged videos are kept from everyone but their owner
+	// until a reviewer approves them
+	ModerationFlagged ModerationStatus = "flagged"
+)
+
+// ModerationLabel is a kind of unsafe content found in a video, with how
+// confident the moderator was of it, from 0 to 100
+type ModerationLabel struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ModerationLabels is stored as a JSON array in the videos table
+type ModerationLabels []ModerationLabel
+
+func (l *ModerationLabels) Scan(src any) error {
+	*l = ModerationLabels{}
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), l)
+	case []byte:
+		return json.Unmarshal(src, l)
+	default:
+		return fmt.Errorf("can't scan %T into moderation labels", src)
+	}
+}
+
+func (l ModerationLabels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// SetVideoModerationPending hides a video until it's been moderated,
+// clearing the previous upload's result
+func (c Client) SetVideoModerationPending(videoID uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET moderation_status = ?,
+		moderation_labels = NULL,
+		moderated_at = NULL,
+		moderated_by = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, ModerationPending, videoID)
+	return err
+}
+
+// SetVideoModeration records a moderation decision. reviewerID is the
+// admin who made it, or null when the content moderator did; the labels
+// the moderator found are kept when a reviewer passes nil.
+func (c Client) SetVideoModeration(videoID uuid.UUID, status ModerationStatus, labels ModerationLabels, reviewerID uuid.NullUUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET moderation_status = ?,
+		moderation_labels = COALESCE(?, moderation_labels),
+		moderated_at = CURRENT_TIMESTAMP,
+		moderated_by = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	result, err := c.db.Exec(query, status, labels, reviewerID, videoID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Objects stored under a content hash can back several videos. Each video
+// holds a reference, and the object is only deleted once the last one is
+// released. Objects without a reference row belong to a single video.
+
+// refScope is the organization column of object_refs, empty for the
+// default store, since NULLs never match in a primary key. Objects in a
+// storage region other than the default are scoped to it with an @, so the
+// same key in two buckets is counted separately.
+func refScope(organizationID uuid.NullUUID, region string) string {
+	scope := ""
+	if organizationID.Valid {
+		scope = organizationID.UUID.String()
+	}
+	if region != "" {
+		scope += "@" + region
+	}
+	return scope
+}
+
+// AcquireObjectRef takes a reference to the object at key and reports
+// whether someone else already held one, in which case the object may not
+// need storing again
+func (c Client) AcquireObjectRef(organizationID uuid.NullUUID, region, key string) (bool, error) {
+	query := `
+	INSERT INTO object_refs (organization_id, key, refcount)
+	VALUES (?, ?, 1)
+	ON CONFLICT(organization_id, key) DO UPDATE SET refcount = refcount + 1
+	RETURNING refcount
+	`
+	var refcount int
+	if err := c.db.QueryRow(query, refScope(organizationID, region), key).Scan(&refcount); err != nil {
+		return false, err
+	}
+	return refcount > 1, nil
+}
+
+// ObjectIsReferenced reports whether a video still holds a reference to
+// the object at key
+func (c Client) ObjectIsReferenced(organizationID uuid.NullUUID, region, key string) (bool, error) {
+	query := `
+	SELECT refcount FROM object_refs
+	WHERE organization_id = ? AND key = ?
+	`
+	var refcount int
+	err := c.db.QueryRow(query, refScope(organizationID, region), key).Scan(&refcount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return refcount > 0, err
+}
+
+// ObjectRefCount is how many videos hold a reference to the object at key,
+// 0 for objects that belong to a single video
+func (c Client) ObjectRefCount(organizationID uuid.NullUUID, region, key string) (int, error) {
+	query := `
+	SELECT refcount FROM object_refs
+	WHERE organization_id = ? AND key = ?
+	`
+	var refcount int
+	err := c.db.QueryRow(query, refScope(organizationID, region), key).Scan(&refcount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return refcount, err
+}
+
+// releaseObjectRef drops a reference to the object at key and reports
+// whether the object can be deleted: either that was the last reference,
+// or it never had any
+func releaseObjectRef(tx *sql.Tx, organizationID uuid.NullUUID, region, key string) (bool, error) {
+	query := `
+	UPDATE object_refs SET refcount = refcount - 1
+	WHERE organization_id = ? AND key = ?
+	RETURNING refcount
+	`
+	var refcount int
+	err := tx.QueryRow(query, refScope(organizationID, region), key).Scan(&refcount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if refcount > 0 {
+		return false, nil
+	}
+	_, err = tx.Exec("DELETE FROM object_refs WHERE organization_id = ? AND key = ?", refScope(organizationID, region), key)
+	return err == nil, err
+}
+
+// ReleaseObjectRefs drops a reference to each object and queues the ones
+// nothing refers to anymore for deletion
+func (c Client) ReleaseObjectRefs(objects []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deletions, err := insertPendingDeletions(tx, objects)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
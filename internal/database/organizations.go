@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrganizationRole string
+
+const (
+	OrganizationRoleAdmin  OrganizationRole = "admin"
+	OrganizationRoleMember OrganizationRole = "member"
+)
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+	// StorageRegion is where the organization's videos are stored unless an
+	// upload picks another region
+	StorageRegion string `json:"storage_region"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	UserID         uuid.UUID        `json:"user_id"`
+	Role           OrganizationRole `json:"role"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+type OrganizationStorage struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Bucket         string    `json:"bucket"`
+	Region         string    `json:"region"`
+	Endpoint       string    `json:"endpoint"`
+	AccessKeyID    string    `json:"access_key_id"`
+	// SecretAccessKeyEncrypted is never sent to clients
+	SecretAccessKeyEncrypted string `json:"-"`
+}
+
+// CreateOrganization creates the organization and makes owner its first admin
+func (c Client) CreateOrganization(name string, owner uuid.UUID) (Organization, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO organizations (id, created_at, updated_at, name)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`
+	if _, err := tx.Exec(query, id, name); err != nil {
+		return Organization{}, err
+	}
+
+	query = `
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	if _, err := tx.Exec(query, id, owner, OrganizationRoleAdmin); err != nil {
+		return Organization{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+	return c.GetOrganization(id)
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+	SELECT id, created_at, updated_at, name, storage_region
+	FROM organizations
+	WHERE id = ?
+	`
+	var org Organization
+	err := c.db.QueryRow(query, id).Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name, &org.StorageRegion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, nil
+		}
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+func (c Client) SetOrganizationStorageRegion(id uuid.UUID, region string) error {
+	query := `
+	UPDATE organizations
+	SET storage_region = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, region, id)
+	return err
+}
+
+func (c Client) AddOrganizationMember(orgID, userID uuid.UUID, role OrganizationRole) (OrganizationMember, error) {
+	query := `
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (organization_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	if _, err := c.db.Exec(query, orgID, userID, role); err != nil {
+		return OrganizationMember{}, err
+	}
+	return c.GetOrganizationMember(orgID, userID)
+}
+
+// GetOrganizationMember returns a zero OrganizationMember if the user doesn't
+// belong to the organization
+func (c Client) GetOrganizationMember(orgID, userID uuid.UUID) (OrganizationMember, error) {
+	query := `
+	SELECT organization_id, user_id, role, created_at
+	FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	var member OrganizationMember
+	err := c.db.QueryRow(query, orgID, userID).
+		Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrganizationMember{}, nil
+		}
+		return OrganizationMember{}, err
+	}
+	return member, nil
+}
+
+func (c Client) UpsertOrganizationStorage(params OrganizationStorage) (OrganizationStorage, error) {
+	query := `
+	INSERT INTO organization_storage (
+		organization_id,
+		created_at,
+		updated_at,
+		bucket,
+		region,
+		endpoint,
+		access_key_id,
+		secret_access_key_encrypted
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	ON CONFLICT (organization_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		bucket = excluded.bucket,
+		region = excluded.region,
+		endpoint = excluded.endpoint,
+		access_key_id = excluded.access_key_id,
+		secret_access_key_encrypted = excluded.secret_access_key_encrypted
+	`
+	_, err := c.db.Exec(
+		query,
+		params.OrganizationID,
+		params.Bucket,
+		params.Region,
+		params.Endpoint,
+		params.AccessKeyID,
+		params.SecretAccessKeyEncrypted,
+	)
+	if err != nil {
+		return OrganizationStorage{}, err
+	}
+	return c.GetOrganizationStorage(params.OrganizationID)
+}
+
+// GetOrganizationStorage returns a zero OrganizationStorage if the
+// organization uses the default bucket
+func (c Client) GetOrganizationStorage(orgID uuid.UUID) (OrganizationStorage, error) {
+	query := `
+	SELECT
+		organization_id,
+		created_at,
+		updated_at,
+		bucket,
+		region,
+		endpoint,
+		access_key_id,
+		secret_access_key_encrypted
+	FROM organization_storage
+	WHERE organization_id = ?
+	`
+	var st OrganizationStorage
+	err := c.db.QueryRow(query, orgID).Scan(
+		&st.OrganizationID,
+		&st.CreatedAt,
+		&st.UpdatedAt,
+		&st.Bucket,
+		&st.Region,
+		&st.Endpoint,
+		&st.AccessKeyID,
+		&st.SecretAccessKeyEncrypted,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrganizationStorage{}, nil
+		}
+		return OrganizationStorage{}, err
+	}
+	return st, nil
+}
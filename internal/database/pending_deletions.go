@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeletionKind string
+
+const (
+	// DeletionObject is a single key in the object store
+	DeletionObject DeletionKind = "object"
+	// DeletionPrefix is every key in the object store under a prefix
+	DeletionPrefix DeletionKind = "prefix"
+	// DeletionLocalFile is a path on the local filesystem
+	DeletionLocalFile DeletionKind = "local_file"
+)
+
+type PendingDeletion struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatePendingDeletionParams
+}
+
+type CreatePendingDeletionParams struct {
+	Kind DeletionKind `json:"kind"`
+	Key  string       `json:"key"`
+	// OrganizationID routes object deletions to the organization's bucket
+	OrganizationID uuid.NullUUID `json:"organization_id"`
+	// Region is the storage region the object is in, empty for the default
+	Region string `json:"region"`
+}
+
+// DeleteVideoWithObjects removes the video row and queues the given objects
+// for deletion in a single transaction, so a crash between the two can't
+// orphan anything.
+func (c Client) DeleteVideoWithObjects(id uuid.UUID, objects []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkLegalHold(tx, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM video_events WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM playlist_videos WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM video_heartbeats WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM video_views WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM video_reactions WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM watch_later WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM comments WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM media_reports WHERE video_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM videos WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+
+	deletions, err := insertPendingDeletions(tx, objects)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+func insertPendingDeletions(tx *sql.Tx, objects []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	query := `
+	INSERT INTO pending_deletions (
+		kind,
+		key,
+		organization_id,
+		region,
+		attempts,
+		last_error,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, 0, '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	deletions := make([]PendingDeletion, 0, len(objects))
+	for _, obj := range objects {
+		// shared objects are only deleted with their last reference
+		if obj.Kind == DeletionObject {
+			unused, err := releaseObjectRef(tx, obj.OrganizationID, obj.Region, obj.Key)
+			if err != nil {
+				return nil, err
+			}
+			if !unused {
+				continue
+			}
+		}
+		res, err := tx.Exec(query, obj.Kind, obj.Key, obj.OrganizationID, obj.Region)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, PendingDeletion{
+			ID:                          id,
+			CreatePendingDeletionParams: obj,
+		})
+	}
+	return deletions, nil
+}
+
+func (c Client) GetPendingDeletions(limit int) ([]PendingDeletion, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		attempts,
+		last_error,
+		kind,
+		key,
+		organization_id,
+		region
+	FROM pending_deletions
+	ORDER BY updated_at ASC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []PendingDeletion{}
+	for rows.Next() {
+		var d PendingDeletion
+		if err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.Attempts,
+			&d.LastError,
+			&d.Kind,
+			&d.Key,
+			&d.OrganizationID,
+			&d.Region,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+
+	return deletions, rows.Err()
+}
+
+func (c Client) DeletePendingDeletion(id int64) error {
+	query := `
+	DELETE FROM pending_deletions
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) MarkPendingDeletionFailed(id int64, lastError string) error {
+	query := `
+	UPDATE pending_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, lastError, id)
+	return err
+}
@@ -0,0 +1,330 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPlaylistFull     = errors.New("playlist is full")
+	ErrVideoInPlaylist  = errors.New("video is already in the playlist")
+	ErrPlaylistMismatch = errors.New("videos don't match the playlist")
+)
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+}
+
+// PlaylistItem is a video at its place in a playlist, counted from 0
+type PlaylistItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Video    Video     `json:"video"`
+}
+
+const playlistColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		title,
+		description
+`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.UserID,
+		&playlist.Title,
+		&playlist.Description,
+	)
+	return playlist, err
+}
+
+// CreatePlaylist creates a playlist unless its owner already has limit of
+// them, in which case it returns false
+func (c Client) CreatePlaylist(params CreatePlaylistParams, limit int) (Playlist, bool, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (id, created_at, updated_at, user_id, title, description)
+	SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?
+	WHERE (SELECT COUNT(*) FROM playlists WHERE user_id = ?) < ?
+	`
+	res, err := c.db.Exec(query, id, params.UserID, params.Title, params.Description, params.UserID, limit)
+	if err != nil {
+		return Playlist{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return Playlist{}, false, err
+	}
+	playlist, err := c.GetPlaylist(id)
+	return playlist, true, err
+}
+
+// GetPlaylist returns the playlist, or a zero Playlist if there's none
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE id = ?
+	`
+	playlist, err := scanPlaylist(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Playlist{}, nil
+	}
+	return playlist, err
+}
+
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at, id
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+// UpdatePlaylist changes the title and description, leaving either as it
+// is when nil
+func (c Client) UpdatePlaylist(id uuid.UUID, title, description *string) error {
+	query := `
+	UPDATE playlists
+	SET title = COALESCE(?, title),
+		description = COALESCE(?, description),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, title, description, id)
+	return err
+}
+
+// DeletePlaylist removes the playlist if it belongs to userID and reports
+// whether there was one. The videos in it are left alone.
+func (c Client) DeletePlaylist(userID, id uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM playlists WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM playlist_videos WHERE playlist_id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// AddPlaylistVideo puts a video in a playlist at position, moving the ones
+// from there on down, or at the end when position is nil or past it.
+// Positions stay 0 to the number of videos minus one.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID, position *int, limit int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	var present bool
+	err = tx.QueryRow(`
+	SELECT COUNT(*), COALESCE(SUM(video_id = ?), 0) > 0
+	FROM playlist_videos
+	WHERE playlist_id = ?
+	`, videoID, playlistID).Scan(&count, &present)
+	if err != nil {
+		return err
+	}
+	if present {
+		return ErrVideoInPlaylist
+	}
+	if count >= limit {
+		return ErrPlaylistFull
+	}
+
+	at := count
+	if position != nil && *position < count {
+		at = max(*position, 0)
+		if _, err := tx.Exec("UPDATE playlist_videos SET position = position + 1 WHERE playlist_id = ? AND position >= ?", playlistID, at); err != nil {
+			return err
+		}
+	}
+	query := `
+	INSERT INTO playlist_videos (playlist_id, video_id, position, added_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	if _, err := tx.Exec(query, playlistID, videoID, at); err != nil {
+		return err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemovePlaylistVideo takes a video out of a playlist, moving the ones
+// after it up, and reports whether it was in it
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRow("SELECT position FROM playlist_videos WHERE playlist_id = ? AND video_id = ?", playlistID, videoID).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ?", playlistID, videoID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE playlist_videos SET position = position - 1 WHERE playlist_id = ? AND position > ?", playlistID, position); err != nil {
+		return false, err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SetPlaylistOrder puts the playlist's videos in the order of videoIDs,
+// which must list each of them once. Otherwise it returns
+// ErrPlaylistMismatch and nothing changes.
+func (c Client) SetPlaylistOrder(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM playlist_videos WHERE playlist_id = ?", playlistID).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(videoIDs) {
+		return ErrPlaylistMismatch
+	}
+	for i, id := range videoIDs {
+		res, err := tx.Exec("UPDATE playlist_videos SET position = ? WHERE playlist_id = ? AND video_id = ?", i, playlistID, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrPlaylistMismatch
+		}
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func touchPlaylist(tx *sql.Tx, playlistID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID)
+	return err
+}
+
+// ListPlaylistVideos returns a page of the playlist's videos that viewerID
+// can see, in order from after the position after, and whether there's
+// another page
+func (c Client) ListPlaylistVideos(playlistID, viewerID uuid.UUID, after, limit int) ([]PlaylistItem, bool, error) {
+	query := `
+	SELECT playlist_videos.position, playlist_videos.added_at,` + videoColumns + `
+	FROM playlist_videos
+	JOIN videos ON videos.id = playlist_videos.video_id
+	WHERE playlist_id = ? AND position > ? AND ` + visibleVideo + `
+	ORDER BY position
+	LIMIT ?
+	`
+	// fetch one extra row to know whether there's another page
+	rows, err := c.db.Query(query, playlistID, after, viewerID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	items := []PlaylistItem{}
+	for rows.Next() {
+		var item PlaylistItem
+		item.Video, err = scanVideo(leadingColumns{rows, []any{&item.Position, &item.AddedAt}})
+		if err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(items) <= limit {
+		return items, false, nil
+	}
+	return items[:limit], true, nil
+}
+
+// CountPlaylistVideos counts the playlist's videos viewerID can see
+func (c Client) CountPlaylistVideos(playlistID, viewerID uuid.UUID) (int64, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM playlist_videos
+	JOIN videos ON videos.id = playlist_videos.video_id
+	WHERE playlist_id = ? AND ` + visibleVideo
+	var count int64
+	err := c.db.QueryRow(query, playlistID, viewerID).Scan(&count)
+	return count, err
+}
+
+// leadingColumns scans columns selected before a video's into dest
+type leadingColumns struct {
+	row  rowScanner
+	dest []any
+}
+
+func (l leadingColumns) Scan(dest ...any) error {
+	return l.row.Scan(append(l.dest, dest...)...)
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SetVideoPremiere schedules the video's premiere and hides it until then,
+// or cancels the premiere and shows it again when premiereAt is nil
+func (c Client) SetVideoPremiere(videoID uuid.UUID, premiereAt *time.Time) error {
+	var value any
+	if premiereAt != nil {
+		value = premiereAt.UTC().Format(sqliteTime)
+	}
+	query := `
+	UPDATE videos
+	SET premiere_at = ?, premiere_pending = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, value, premiereAt != nil, videoID)
+	return err
+}
+
+// GetDuePremieres returns up to limit videos whose premiere time has passed
+// but that are still hidden
+func (c Client) GetDuePremieres(now time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE premiere_pending AND premiere_at <= ?
+	ORDER BY premiere_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, now.UTC().Format(sqliteTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// StartPremiere makes a video whose premiere is due visible. It returns
+// false if the premiere had already started or was canceled or moved.
+func (c Client) StartPremiere(videoID uuid.UUID, now time.Time) (bool, error) {
+	query := `
+	UPDATE videos
+	SET premiere_pending = FALSE, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND premiere_pending AND premiere_at <= ?
+	`
+	result, err := c.db.Exec(query, videoID, now.UTC().Format(sqliteTime))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Profile is what anyone can see about a user, without their email
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      *string   `json:"avatar_url"`
+	AvatarSmallURL *string   `json:"avatar_small_url"`
+	VideoCount     int64     `json:"video_count"`
+}
+
+// GetProfile returns the user's profile with a count of their published
+// videos, or a zero Profile if there's no such user or they're suspended
+func (c Client) GetProfile(userID uuid.UUID) (Profile, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		display_name,
+		bio,
+		avatar_url,
+		avatar_small_url,
+		(SELECT COUNT(*) FROM videos WHERE user_id = users.id AND ` + publishedVideo + ` AND ` + listedVideo + `)
+	FROM users
+	WHERE id = ? AND suspended_at IS NULL
+	`
+	var profile Profile
+	err := c.db.QueryRow(query, userID).Scan(
+		&profile.ID,
+		&profile.CreatedAt,
+		&profile.DisplayName,
+		&profile.Bio,
+		&profile.AvatarURL,
+		&profile.AvatarSmallURL,
+		&profile.VideoCount,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, nil
+	}
+	return profile, err
+}
+
+// UpdateProfile changes the display name and bio, leaving either as it is
+// when nil
+func (c Client) UpdateProfile(userID uuid.UUID, displayName, bio *string) error {
+	query := `
+	UPDATE users
+	SET display_name = COALESCE(?, display_name),
+		bio = COALESCE(?, bio),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, displayName, bio, userID)
+	return err
+}
+
+// SetAvatar replaces the user's avatar URLs, nil removing the avatar
+func (c Client) SetAvatar(userID uuid.UUID, url, smallURL *string) error {
+	query := `
+	UPDATE users
+	SET avatar_url = ?,
+		avatar_small_url = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, url, smallURL, userID)
+	return err
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PushSubscription is a browser's Web Push endpoint and the keys messages
+// to it are encrypted with
+type PushSubscription struct {
+	Endpoint  string    `json:"endpoint"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	P256dh    string    `json:"p256dh"`
+	Auth      string    `json:"auth"`
+}
+
+type CreatePushSubscriptionParams struct {
+	Endpoint string
+	UserID   uuid.UUID
+	P256dh   string
+	Auth     string
+}
+
+// SavePushSubscription stores a subscription, replacing the keys and owner
+// if the browser subscribed before
+func (c Client) SavePushSubscription(params CreatePushSubscriptionParams) error {
+	query := `
+	INSERT INTO push_subscriptions (endpoint, created_at, user_id, p256dh, auth)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	ON CONFLICT(endpoint) DO UPDATE SET
+		user_id = excluded.user_id,
+		p256dh = excluded.p256dh,
+		auth = excluded.auth
+	`
+	_, err := c.db.Exec(query, params.Endpoint, params.UserID, params.P256dh, params.Auth)
+	return err
+}
+
+func (c Client) GetPushSubscriptions(userID uuid.UUID) ([]PushSubscription, error) {
+	query := `
+	SELECT endpoint, created_at, user_id, p256dh, auth
+	FROM push_subscriptions
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []PushSubscription{}
+	for rows.Next() {
+		var s PushSubscription
+		if err := rows.Scan(&s.Endpoint, &s.CreatedAt, &s.UserID, &s.P256dh, &s.Auth); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeletePushSubscription removes the subscription at endpoint if it belongs
+// to userID and reports whether there was one
+func (c Client) DeletePushSubscription(userID uuid.UUID, endpoint string) (bool, error) {
+	query := `
+	DELETE FROM push_subscriptions
+	WHERE user_id = ? AND endpoint = ?
+	`
+	result, err := c.db.Exec(query, userID, endpoint)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
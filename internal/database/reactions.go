@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrWatchLaterFull = errors.New("watch later list is full")
+
+type Reaction string
+
+const (
+	ReactionLike    Reaction = "like"
+	ReactionDislike Reaction = "dislike"
+)
+
+// ViewerState is how a signed in user has marked a video
+type ViewerState struct {
+	Reaction   *Reaction `json:"reaction"`
+	WatchLater bool      `json:"watch_later"`
+}
+
+// WatchLaterItem is a video in a watch later list
+type WatchLaterItem struct {
+	AddedAt time.Time `json:"added_at"`
+	Video   Video     `json:"video"`
+}
+
+// SetReaction records the user's like or dislike of a video, replacing the
+// one they had, or takes it back when reaction is nil. The video's counts
+// are recounted in the same transaction.
+func (c Client) SetReaction(videoID, userID uuid.UUID, reaction *Reaction) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if reaction == nil {
+		_, err = tx.Exec("DELETE FROM video_reactions WHERE video_id = ? AND user_id = ?", videoID, userID)
+	} else {
+		query := `
+		INSERT INTO video_reactions (video_id, user_id, reaction, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(video_id, user_id) DO UPDATE SET
+			reaction = excluded.reaction,
+			created_at = excluded.created_at
+		WHERE reaction != excluded.reaction
+		`
+		_, err = tx.Exec(query, videoID, userID, *reaction)
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE videos
+	SET like_count = (SELECT COUNT(*) FROM video_reactions WHERE video_id = videos.id AND reaction = 'like'),
+		dislike_count = (SELECT COUNT(*) FROM video_reactions WHERE video_id = videos.id AND reaction = 'dislike')
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, videoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetViewerState returns the user's reaction to a video and whether it's in
+// their watch later list
+func (c Client) GetViewerState(userID, videoID uuid.UUID) (ViewerState, error) {
+	query := `
+	SELECT
+		(SELECT reaction FROM video_reactions WHERE video_id = ? AND user_id = ?),
+		EXISTS (SELECT 1 FROM watch_later WHERE user_id = ? AND video_id = ?)
+	`
+	var state ViewerState
+	var reaction sql.NullString
+	err := c.db.QueryRow(query, videoID, userID, userID, videoID).Scan(&reaction, &state.WatchLater)
+	if err != nil {
+		return ViewerState{}, err
+	}
+	if reaction.Valid {
+		r := Reaction(reaction.String)
+		state.Reaction = &r
+	}
+	return state, nil
+}
+
+// AddWatchLater puts a video at the top of the user's watch later list,
+// returning ErrWatchLaterFull if it already has limit videos. Adding one
+// that's already there leaves it where it is.
+func (c Client) AddWatchLater(userID, videoID uuid.UUID, limit int) error {
+	query := `
+	INSERT INTO watch_later (user_id, video_id, added_at)
+	SELECT ?, ?, CURRENT_TIMESTAMP
+	WHERE (SELECT COUNT(*) FROM watch_later WHERE user_id = ?) < ?
+		OR EXISTS (SELECT 1 FROM watch_later WHERE user_id = ? AND video_id = ?)
+	ON CONFLICT(user_id, video_id) DO NOTHING
+	`
+	res, err := c.db.Exec(query, userID, videoID, userID, limit, userID, videoID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var present bool
+		err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM watch_later WHERE user_id = ? AND video_id = ?)", userID, videoID).Scan(&present)
+		if err != nil {
+			return err
+		}
+		if !present {
+			return ErrWatchLaterFull
+		}
+	}
+	return nil
+}
+
+// RemoveWatchLater takes a video out of the user's watch later list and
+// reports whether it was in it
+func (c Client) RemoveWatchLater(userID, videoID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM watch_later WHERE user_id = ? AND video_id = ?", userID, videoID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetWatchLater returns the videos in the user's watch later list they can
+// still see, most recently added first
+func (c Client) GetWatchLater(userID uuid.UUID) ([]WatchLaterItem, error) {
+	query := `
+	SELECT saved.added_at,` + videoColumns + `
+	FROM (SELECT video_id, added_at FROM watch_later WHERE user_id = ?) AS saved
+	JOIN videos ON videos.id = saved.video_id
+	WHERE ` + visibleVideo + `
+	ORDER BY saved.added_at DESC, saved.video_id
+	`
+	rows, err := c.db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []WatchLaterItem{}
+	for rows.Next() {
+		var item WatchLaterItem
+		item.Video, err = scanVideo(leadingColumns{rows, []any{&item.AddedAt}})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Rendition is a lower resolution copy of a video, transcoded from the
+// stored upload
+type Rendition struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	URL       string `json:"url"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// Renditions is stored as a JSON array in the videos table
+type Renditions []Rendition
+
+func (r *Renditions) Scan(src any) error {
+	*r = Renditions{}
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), r)
+	case []byte:
+		return json.Unmarshal(src, r)
+	default:
+		return fmt.Errorf("can't scan %T into renditions", src)
+	}
+}
+
+func (r Renditions) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+	dat, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// UpdateVideoRenditions replaces the renditions of a video, clearing them
+// when renditions is empty
+func (c Client) UpdateVideoRenditions(videoID uuid.UUID, renditions Renditions) error {
+	query := `
+	UPDATE videos
+	SET renditions = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, renditions, videoID)
+	return err
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrLegalHold is returned by every path that deletes a video when the video
+// is under legal hold
+var ErrLegalHold = errors.New("video is under legal hold")
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func checkLegalHold(q queryRower, videoID uuid.UUID) error {
+	var held bool
+	err := q.QueryRow("SELECT legal_hold FROM videos WHERE id = ?", videoID).Scan(&held)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+	return nil
+}
+
+// SetVideoDeleteAt schedules the video for deletion, or cancels the
+// scheduled deletion when deleteAt is nil
+func (c Client) SetVideoDeleteAt(videoID uuid.UUID, deleteAt *time.Time) error {
+	var value any
+	if deleteAt != nil {
+		value = deleteAt.UTC().Format(sqliteTime)
+	}
+	query := `
+	UPDATE videos
+	SET delete_at = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, value, videoID)
+	return err
+}
+
+// SetVideoLegalHold places or releases a legal hold; the reason is cleared
+// on release
+func (c Client) SetVideoLegalHold(videoID uuid.UUID, hold bool, reason *string) error {
+	if !hold {
+		reason = nil
+	}
+	query := `
+	UPDATE videos
+	SET legal_hold = ?, legal_hold_reason = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hold, reason, videoID)
+	return err
+}
+
+// GetVideosDueForDeletion returns up to limit videos whose scheduled
+// deletion time has passed, leaving out those under legal hold
+func (c Client) GetVideosDueForDeletion(now time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE delete_at IS NOT NULL AND delete_at <= ? AND NOT legal_hold
+	ORDER BY delete_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, now.UTC().Format(sqliteTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
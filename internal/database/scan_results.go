@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ScanStatus string
+
+const (
+	ScanClean    ScanStatus = "clean"
+	ScanInfected ScanStatus = "infected"
+)
+
+// ScanResult is the malware scan of an uploaded file, stored as JSON in the
+// videos table
+type ScanResult struct {
+	Status ScanStatus `json:"status"`
+	// Threat names what was found in an infected file
+	Threat    string    `json:"threat,omitempty"`
+	Scanner   string    `json:"scanner"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+func (s ScanResult) Value() (driver.Value, error) {
+	dat, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// scanResultColumn scans a nullable scan result column into a pointer
+type scanResultColumn struct {
+	dest **ScanResult
+}
+
+func (c scanResultColumn) Scan(src any) error {
+	*c.dest = nil
+	var dat []byte
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		dat = []byte(src)
+	case []byte:
+		dat = src
+	default:
+		return fmt.Errorf("can't scan %T into a scan result", src)
+	}
+	var result ScanResult
+	if err := json.Unmarshal(dat, &result); err != nil {
+		return err
+	}
+	*c.dest = &result
+	return nil
+}
+
+// UpdateVideoScan records the scan of the video's uploaded file; nil clears
+// it for an upload that wasn't scanned
+func (c Client) UpdateVideoScan(videoID uuid.UUID, result *ScanResult) error {
+	query := `
+	UPDATE videos
+	SET video_scan = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, result, videoID)
+	return err
+}
+
+// UpdateThumbnailScan records the scan of the video's uploaded thumbnail;
+// nil clears it for a thumbnail that wasn't scanned
+func (c Client) UpdateThumbnailScan(videoID uuid.UUID, result *ScanResult) error {
+	query := `
+	UPDATE videos
+	SET thumbnail_scan = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, result, videoID)
+	return err
+}
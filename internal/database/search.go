@@ -0,0 +1,187 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// migrateSearch sets up the videos_fts index when SQLite has FTS5 (build with
+// -tags sqlite_fts5). Without it search falls back to LIKE matching.
+func (c *Client) migrateSearch() error {
+	// the index predates tags, rebuild it with them
+	_, err := c.db.Exec("SELECT 1 FROM videos_fts LIMIT 0")
+	if err == nil {
+		hasTags, err := c.hasColumn("videos_fts", "tags")
+		if err != nil {
+			return err
+		}
+		if !hasTags {
+			if _, err := c.db.Exec("DROP TRIGGER IF EXISTS videos_fts_insert; DROP TABLE videos_fts"); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = c.db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+		video_id UNINDEXED,
+		title,
+		description,
+		tags,
+		tokenize = 'porter unicode61'
+	)
+	`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			return nil
+		}
+		return err
+	}
+
+	const videoTags = "(SELECT COALESCE(group_concat(tag, ' '), '') FROM video_tags WHERE video_id = %s)"
+	triggers := []string{`
+	CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts (video_id, title, description, tags) VALUES (new.id, new.title, new.description, '');
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+		UPDATE videos_fts SET title = new.title, description = new.description WHERE video_id = old.id;
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_delete AFTER DELETE ON videos BEGIN
+		DELETE FROM videos_fts WHERE video_id = old.id;
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_tag_insert AFTER INSERT ON video_tags BEGIN
+		UPDATE videos_fts SET tags = ` + fmt.Sprintf(videoTags, "new.video_id") + ` WHERE video_id = new.video_id;
+	END
+	`, `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_tag_delete AFTER DELETE ON video_tags BEGIN
+		UPDATE videos_fts SET tags = ` + fmt.Sprintf(videoTags, "old.video_id") + ` WHERE video_id = old.video_id;
+	END
+	`}
+	for _, trigger := range triggers {
+		if _, err := c.db.Exec(trigger); err != nil {
+			return err
+		}
+	}
+
+	// index videos created before the table existed
+	_, err = c.db.Exec(`
+	INSERT INTO videos_fts (video_id, title, description, tags)
+	SELECT id, title, description, ` + fmt.Sprintf(videoTags, "videos.id") + ` FROM videos
+	WHERE id NOT IN (SELECT video_id FROM videos_fts)
+	`)
+	if err != nil {
+		return err
+	}
+	c.fts = true
+	return nil
+}
+
+type SearchVideosParams struct {
+	// ViewerID sees all of their own matches and other people's published ones
+	ViewerID uuid.UUID
+	// OwnerOnly leaves out other people's videos
+	OwnerOnly bool
+	Query     string
+	Limit     int
+	Offset    int
+}
+
+// SearchVideos returns a page of videos whose title, description or tags
+// match every word in the query, best match first, and whether there are more
+func (c Client) SearchVideos(params SearchVideosParams) ([]Video, bool, error) {
+	terms := strings.Fields(params.Query)
+	if len(terms) == 0 {
+		return []Video{}, false, nil
+	}
+
+	visible := "(user_id = ? OR (" + publishedVideo + ")) AND " + listedVideo
+	if params.OwnerOnly {
+		visible = "user_id = ? AND " + listedVideo
+	}
+
+	var query string
+	var args []any
+	if c.fts {
+		query = `
+		WITH hits AS (
+			SELECT video_id, bm25(videos_fts, 0, 10, 1, 5) AS score
+			FROM videos_fts
+			WHERE videos_fts MATCH ?
+		)
+		SELECT` + videoColumns + `
+		FROM videos
+		JOIN hits ON hits.video_id = videos.id
+		WHERE ` + visible + `
+		ORDER BY hits.score, created_at DESC
+		LIMIT ? OFFSET ?
+		`
+		args = []any{ftsQuery(terms), params.ViewerID}
+	} else {
+		conditions := []string{visible}
+		args = []any{params.ViewerID}
+		scores := []string{}
+		scoreArgs := []any{}
+		for _, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			conditions = append(conditions, `(title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\'
+				OR id IN (SELECT video_id FROM video_tags WHERE tag LIKE ? ESCAPE '\'))`)
+			args = append(args, pattern, pattern, pattern)
+			scores = append(scores, `(title LIKE ? ESCAPE '\')`)
+			scoreArgs = append(scoreArgs, pattern)
+		}
+		// rank by how many of the words are in the title
+		query = `
+		SELECT` + videoColumns + `
+		FROM videos
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + strings.Join(scores, " + ") + ` DESC, created_at DESC
+		LIMIT ? OFFSET ?
+		`
+		args = append(args, scoreArgs...)
+	}
+	// fetch one extra row to know whether there's another page
+	args = append(args, params.Limit+1, params.Offset)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't search videos: %w", err)
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(videos) <= params.Limit {
+		return videos, false, nil
+	}
+	return videos[:params.Limit], true, nil
+}
+
+// ftsQuery quotes each term so user input can't use FTS5 query syntax. The
+// last term matches as a prefix so partly typed words still find something.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	quoted[len(quoted)-1] += "*"
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
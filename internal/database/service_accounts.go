@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ServiceAccount is a non-human principal belonging to an organization. It
+// acts through a user row of its own that has no usable password, so videos it
+// creates are owned like anyone else's, and it only signs in with API keys.
+type ServiceAccount struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	CreateServiceAccountParams
+}
+
+type CreateServiceAccountParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Name           string    `json:"name"`
+	// VideoPrefix, if set, is what the titles of videos the account creates
+	// must start with
+	VideoPrefix string   `json:"video_prefix"`
+	Scopes      []string `json:"scopes"`
+	// PasswordHash is for the account's user row and is never stored here
+	PasswordHash string `json:"-"`
+}
+
+const serviceAccountColumns = `
+		id,
+		created_at,
+		disabled_at,
+		organization_id,
+		user_id,
+		name,
+		video_prefix,
+		scopes
+`
+
+func scanServiceAccount(row rowScanner) (ServiceAccount, error) {
+	var account ServiceAccount
+	var scopes string
+	err := row.Scan(
+		&account.ID,
+		&account.CreatedAt,
+		&account.DisabledAt,
+		&account.OrganizationID,
+		&account.UserID,
+		&account.Name,
+		&account.VideoPrefix,
+		&scopes,
+	)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	account.Scopes = strings.Split(scopes, ",")
+	return account, nil
+}
+
+// CreateServiceAccount creates the account along with its user and makes that
+// user a member of the organization
+func (c Client) CreateServiceAccount(params CreateServiceAccountParams) (ServiceAccount, error) {
+	id := uuid.New()
+	userID := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	defer tx.Rollback()
+
+	// .invalid can never be a real mailbox, so nothing can sign in as or
+	// link to this user by email
+	email := fmt.Sprintf("%s@service-accounts.invalid", id)
+	query := `
+	INSERT INTO users (id, created_at, updated_at, email, password)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	`
+	if _, err := tx.Exec(query, userID.String(), email, params.PasswordHash); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	query = `
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	if _, err := tx.Exec(query, params.OrganizationID, userID, OrganizationRoleMember); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	query = `
+	INSERT INTO service_accounts (
+		id,
+		created_at,
+		organization_id,
+		user_id,
+		name,
+		video_prefix,
+		scopes
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, id, params.OrganizationID, userID, params.Name, params.VideoPrefix, strings.Join(params.Scopes, ","))
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ServiceAccount{}, err
+	}
+	return c.GetServiceAccount(id)
+}
+
+func (c Client) GetServiceAccount(id uuid.UUID) (ServiceAccount, error) {
+	query := `
+	SELECT` + serviceAccountColumns + `
+	FROM service_accounts
+	WHERE id = ?
+	`
+
+	account, err := scanServiceAccount(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ServiceAccount{}, nil
+		}
+		return ServiceAccount{}, err
+	}
+	return account, nil
+}
+
+// GetServiceAccountByUser returns a zero ServiceAccount when userID is a person
+func (c Client) GetServiceAccountByUser(userID uuid.UUID) (ServiceAccount, error) {
+	query := `
+	SELECT` + serviceAccountColumns + `
+	FROM service_accounts
+	WHERE user_id = ?
+	`
+
+	account, err := scanServiceAccount(c.db.QueryRow(query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ServiceAccount{}, nil
+		}
+		return ServiceAccount{}, err
+	}
+	return account, nil
+}
+
+func (c Client) GetServiceAccounts(orgID uuid.UUID) ([]ServiceAccount, error) {
+	query := `
+	SELECT` + serviceAccountColumns + `
+	FROM service_accounts
+	WHERE organization_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// DisableServiceAccount disables the account and revokes all of its keys
+func (c Client) DisableServiceAccount(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE service_accounts
+	SET disabled_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND disabled_at IS NULL
+	`
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE user_id = (SELECT user_id FROM service_accounts WHERE id = ?) AND revoked_at IS NULL
+	`
+	if _, err := tx.Exec(query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoStorageUsage is how much of the object store a video's files take up
+// and how many times it was viewed over a period
+type VideoStorageUsage struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Title   string    `json:"title"`
+	// VideoBytes is the main file; StoredBytes adds the renditions
+	VideoBytes  int64 `json:"video_bytes"`
+	StoredBytes int64 `json:"stored_bytes"`
+	Views       int64 `json:"views"`
+}
+
+// GetVideoStorageUsage returns the usage of every video with a stored file,
+// trashed ones included, counting views since viewsSince. Videos sharing an
+// identical file each count it in full.
+func (c Client) GetVideoStorageUsage(viewsSince time.Time) ([]VideoStorageUsage, error) {
+	query := `
+	SELECT
+		v.id,
+		v.user_id,
+		v.title,
+		COALESCE(v.size_bytes, 0),
+		COALESCE(v.size_bytes, 0) + COALESCE((
+			SELECT SUM(json_extract(r.value, '$.size_bytes')) FROM json_each(v.renditions) r
+		), 0),
+		(SELECT COUNT(*) FROM video_views vv WHERE vv.video_id = v.id AND vv.created_at >= ?)
+	FROM videos v
+	WHERE v.video_url IS NOT NULL
+	`
+	rows, err := c.db.Query(query, viewsSince.UTC().Format(sqliteTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []VideoStorageUsage{}
+	for rows.Next() {
+		var u VideoStorageUsage
+		if err := rows.Scan(&u.VideoID, &u.UserID, &u.Title, &u.VideoBytes, &u.StoredBytes, &u.Views); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetVideosStuckSince returns up to limit videos that have been failed or
+// processing since before cutoff and have nothing playable, leaving out those
+// in the trash or under legal hold
+func (c Client) GetVideosStuckSince(cutoff time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE processing_status IN ('failed', 'processing')
+		AND processing_status_at <= ?
+		AND video_url IS NULL
+		AND deleted_at IS NULL
+		AND NOT legal_hold
+	ORDER BY processing_status_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, cutoff.UTC().Format(sqliteTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// ExpireStuckVideo marks a video failed_expired and queues the given objects
+// for deletion in a single transaction. It returns false, and queues nothing,
+// if the video has moved on since it was found stuck, like a new upload
+// starting.
+func (c Client) ExpireStuckVideo(id uuid.UUID, objects []CreatePendingDeletionParams) ([]PendingDeletion, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET processing_status = 'failed_expired',
+		processing_status_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND processing_status IN ('failed', 'processing') AND video_url IS NULL
+	`
+	res, err := tx.Exec(query, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, false, err
+	}
+
+	deletions, err := insertPendingDeletions(tx, objects)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return deletions, true, nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserAccount is a user as operators see them
+type UserAccount struct {
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Email            string     `json:"email"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+	SuspensionReason *string    `json:"suspension_reason"`
+	VideoCount       int64      `json:"video_count"`
+}
+
+// ListUserAccounts returns every user, or only the suspended ones, oldest
+// first
+func (c Client) ListUserAccounts(suspendedOnly bool) ([]UserAccount, error) {
+	query := `
+	SELECT
+		u.id,
+		u.created_at,
+		u.email,
+		u.suspended_at,
+		u.suspension_reason,
+		(SELECT COUNT(*) FROM videos v WHERE v.user_id = u.id)
+	FROM users u
+	WHERE NOT ? OR u.suspended_at IS NOT NULL
+	ORDER BY u.created_at
+	`
+	rows, err := c.db.Query(query, suspendedOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []UserAccount{}
+	for rows.Next() {
+		var a UserAccount
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.Email, &a.SuspendedAt, &a.SuspensionReason, &a.VideoCount); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// SuspendUser suspends the user, keeping the time and reason of an earlier
+// suspension that's still in place. It reports whether the user exists.
+func (c Client) SuspendUser(id uuid.UUID, reason string) (bool, error) {
+	query := `
+	UPDATE users
+	SET
+		suspended_at = COALESCE(suspended_at, CURRENT_TIMESTAMP),
+		suspension_reason = COALESCE(suspension_reason, ?),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	result, err := c.db.Exec(query, reason, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UnsuspendUser lifts a suspension and reports whether the user exists
+func (c Client) UnsuspendUser(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE users
+	SET
+		suspended_at = NULL,
+		suspension_reason = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	result, err := c.db.Exec(query, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) IsUserSuspended(id uuid.UUID) (bool, error) {
+	query := `
+	SELECT suspended_at IS NOT NULL
+	FROM users
+	WHERE id = ?
+	`
+	var suspended bool
+	err := c.db.QueryRow(query, id.String()).Scan(&suspended)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return suspended, err
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+type TagCount struct {
+	Tag    string `json:"tag"`
+	Videos int    `json:"videos"`
+}
+
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	rows, err := c.db.Query("SELECT tag FROM video_tags WHERE video_id = ? ORDER BY tag", videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetVideoTags replaces a video's tags
+func (c Client) SetVideoTags(videoID uuid.UUID, tags []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO video_tags (video_id, tag) VALUES (?, ?)", videoID, tag); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE videos SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", videoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SearchTags returns the most used tags starting with prefix, counting the
+// videos viewerID can see
+func (c Client) SearchTags(viewerID uuid.UUID, prefix string, limit int) ([]TagCount, error) {
+	query := `
+	SELECT video_tags.tag, COUNT(*) AS n
+	FROM video_tags
+	JOIN videos ON videos.id = video_tags.video_id
+	WHERE video_tags.tag LIKE ? ESCAPE '\'
+		AND (videos.user_id = ? OR (videos.video_url IS NOT NULL AND NOT videos.premiere_pending AND videos.moderation_status = 'approved'))
+	GROUP BY video_tags.tag
+	ORDER BY n DESC, video_tags.tag
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, escapeLike(prefix)+"%", viewerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Videos); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
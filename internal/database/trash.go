@@ -0,0 +1,89 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash, where it's hidden until it's
+// restored or purged. It fails with ErrLegalHold if the video is under
+// legal hold, like deleting it would.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	if err := checkLegalHold(c.db, id); err != nil {
+		return err
+	}
+	query := `
+	UPDATE videos
+	SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// RestoreTrashedVideo takes a video back out of the trash
+func (c Client) RestoreTrashedVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// GetTrashedVideos returns a user's videos in the trash, most recently
+// trashed first
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetVideosTrashedBefore returns up to limit videos that went in the trash
+// before cutoff, leaving out those under legal hold
+func (c Client) GetVideosTrashedBefore(cutoff time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at <= ? AND NOT legal_hold
+	ORDER BY deleted_at
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, cutoff.UTC().Format(sqliteTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a user to an account at an external login provider
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+}
+
+func (c Client) GetUserIdentity(provider, subject string) (UserIdentity, error) {
+	query := `
+	SELECT provider, subject, created_at, user_id, email
+	FROM user_identities
+	WHERE provider = ? AND subject = ?
+	`
+	var identity UserIdentity
+	err := c.db.QueryRow(query, provider, subject).Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.CreatedAt,
+		&identity.UserID,
+		&identity.Email,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserIdentity{}, nil
+		}
+		return UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (c Client) CreateUserIdentity(provider, subject string, userID uuid.UUID, email string) error {
+	query := `
+	INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+	VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, provider, subject, userID, email)
+	return err
+}
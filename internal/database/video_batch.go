@@ -0,0 +1,44 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoBatchUpdate is the new state of one video in a batch; nil fields are
+// left as they are
+type VideoBatchUpdate struct {
+	VideoID   uuid.UUID
+	Tags      []string
+	Indexable *bool
+}
+
+// UpdateVideosBatch applies every update or, if any fails, none of them
+func (c Client) UpdateVideosBatch(updates []VideoBatchUpdate) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, update := range updates {
+		if update.Tags != nil {
+			if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", update.VideoID); err != nil {
+				return err
+			}
+			for _, tag := range update.Tags {
+				if _, err := tx.Exec("INSERT OR IGNORE INTO video_tags (video_id, tag) VALUES (?, ?)", update.VideoID, tag); err != nil {
+					return err
+				}
+			}
+		}
+		if update.Indexable != nil {
+			if _, err := tx.Exec("UPDATE videos SET indexable = ? WHERE id = ?", *update.Indexable, update.VideoID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("UPDATE videos SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", update.VideoID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortViews     VideoSort = "views"
+)
+
+var videoSortColumns = map[VideoSort]string{
+	VideoSortCreatedAt: "created_at",
+	VideoSortTitle:     "title",
+	VideoSortViews:     "view_count",
+}
+
+func ValidVideoSort(sort VideoSort) bool {
+	_, ok := videoSortColumns[sort]
+	return ok
+}
+
+// VideoCursor marks the last video of a page: its value in the sort column,
+// as text, and its ID to break ties
+type VideoCursor struct {
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+type ListVideosParams struct {
+	// ViewerID is who's asking; other owners' videos are only listed once
+	// they're published
+	ViewerID uuid.UUID
+	OwnerID  uuid.UUID
+	// AllOwners lists everyone's videos, published or not, for operators;
+	// OwnerID then only narrows it down when it's set
+	AllOwners   bool
+	AspectRatio string
+	Status      ProcessingStatus
+	Moderation  ModerationStatus
+	Tag         string
+	Sort        VideoSort
+	Descending  bool
+	After       *VideoCursor
+	Limit       int
+}
+
+// filter returns the WHERE conditions for everything but the cursor
+func (params ListVideosParams) filter() ([]string, []any) {
+	conditions := []string{listedVideo}
+	args := []any{}
+	if !params.AllOwners || params.OwnerID != uuid.Nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, params.OwnerID)
+	}
+	if !params.AllOwners && params.OwnerID != params.ViewerID {
+		conditions = append(conditions, publishedVideo)
+	}
+	if params.AspectRatio != "" {
+		// the aspect ratio is the first part of the object key
+		conditions = append(conditions, "video_url LIKE ?")
+		args = append(args, "%/"+params.AspectRatio+"/%")
+	}
+	if params.Status != "" {
+		conditions = append(conditions, "processing_status = ?")
+		args = append(args, params.Status)
+	}
+	if params.Moderation != "" {
+		conditions = append(conditions, "moderation_status = ?")
+		args = append(args, params.Moderation)
+	}
+
+	if params.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT video_id FROM video_tags WHERE tag = ?)")
+		args = append(args, params.Tag)
+	}
+	return conditions, args
+}
+
+// CountVideos counts the videos matching params across all pages
+func (c Client) CountVideos(params ListVideosParams) (int64, error) {
+	conditions, args := params.filter()
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ")
+	var count int64
+	err := c.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// ListVideos returns a page of videos and the cursor for the next page, which
+// is nil on the last one
+func (c Client) ListVideos(params ListVideosParams) ([]Video, *VideoCursor, error) {
+	column, ok := videoSortColumns[params.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+
+	conditions, args := params.filter()
+
+	direction, comparison := "ASC", ">"
+	if params.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if params.After != nil {
+		var value any = params.After.Value
+		if params.Sort == VideoSortViews {
+			views, err := strconv.ParseInt(params.After.Value, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid cursor: %w", err)
+			}
+			value = views
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison))
+		args = append(args, value, params.After.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + column + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	// fetch one extra row to know whether there's another page
+	rows, err := c.db.Query(query, append(args, params.Limit+1)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(videos) <= params.Limit {
+		return videos, nil, nil
+	}
+	videos = videos[:params.Limit]
+	last := videos[len(videos)-1]
+	next := &VideoCursor{ID: last.ID}
+	switch params.Sort {
+	case VideoSortCreatedAt:
+		next.Value = last.CreatedAt.UTC().Format(sqliteTime)
+	case VideoSortTitle:
+		next.Value = last.Title
+	case VideoSortViews:
+		next.Value = strconv.FormatInt(last.ViewCount, 10)
+	}
+	return videos, next, nil
+}
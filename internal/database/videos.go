@@ -3,29 +3,158 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type LinkStatus string
+
+const (
+	LinkStatusUnchecked LinkStatus = "unchecked"
+	LinkStatusOK        LinkStatus = "ok"
+	LinkStatusBroken    LinkStatus = "broken"
+)
+
+type ProcessingStatus string
+
+const (
+	ProcessingStatusAwaitingUpload ProcessingStatus = "awaiting_upload"
+	ProcessingStatusProcessing     ProcessingStatus = "processing"
+	ProcessingStatusReady          ProcessingStatus = "ready"
+	ProcessingStatusFailed         ProcessingStatus = "failed"
+	// the upload matched the content blocklist and is held for review
+	ProcessingStatusQuarantined ProcessingStatus = "quarantined"
+	// processing failed or never finished, and the video was given up on
+	// and its partial files removed
+	ProcessingStatusExpired ProcessingStatus = "failed_expired"
+)
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// ThumbnailSmallURL is a 320x180 version for lists and grids
+	ThumbnailSmallURL *string    `json:"thumbnail_small_url"`
+	VideoURL          *string    `json:"video_url"`
+	LinkStatus        LinkStatus `json:"link_status"`
+	LinkCheckedAt     *time.Time `json:"link_checked_at"`
+	Pinned            bool       `json:"pinned"`
+	SortIndex         *int       `json:"sort_index"`
+	// size and duration of the stored video, unknown for older uploads
+	SizeBytes       *int64   `json:"size_bytes"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	VideoCodec      *string  `json:"video_codec"`
+	AudioCodec      *string  `json:"audio_codec"`
+	BitRate         *int64   `json:"bit_rate"`
+	FrameRate       *float64 `json:"frame_rate"`
+	AudioChannels   *int     `json:"audio_channels"`
+	ViewCount       int64    `json:"view_count"`
+	// LikeCount and DislikeCount are kept up to date with the reactions,
+	// and shown to others like ViewCount
+	LikeCount    int64 `json:"like_count"`
+	DislikeCount int64 `json:"dislike_count"`
+	// CommentCount counts the comments that haven't been deleted
+	CommentCount     int64            `json:"comment_count"`
+	ProcessingStatus ProcessingStatus `json:"processing_status"`
+	// Indexable videos go in the sitemap and RSS feed and may be indexed by
+	// search engines
+	Indexable bool `json:"indexable"`
+	// Renditions are the lower resolution copies from the rendition ladder
+	Renditions Renditions `json:"renditions"`
+	// PreviewURL is a short looping animation for hover previews
+	PreviewURL *string `json:"preview_url"`
+	// SpriteURL is a sheet of seek-bar thumbnails that SpriteVTTURL maps
+	// to time ranges
+	SpriteURL    *string  `json:"sprite_url"`
+	SpriteVTTURL *string  `json:"sprite_vtt_url"`
+	Captions     Captions `json:"captions"`
+	// AudioURL is the audio track on its own, for listening without video
+	AudioURL *string `json:"audio_url"`
+	// DeleteAt is when the video is due to be deleted automatically
+	DeleteAt *time.Time `json:"delete_at"`
+	// LegalHold keeps the video from being deleted by anyone, including its
+	// owner and DeleteAt, until an admin releases it. The reason is only
+	// shown to admins.
+	LegalHold       bool    `json:"legal_hold"`
+	LegalHoldReason *string `json:"-"`
+	// SourceVideoID is the video this one was cut from, if any
+	SourceVideoID *uuid.UUID `json:"source_video_id"`
+	// RestoreRequestedAt is when a restore of the archived video file was
+	// last started from here
+	RestoreRequestedAt *time.Time `json:"restore_requested_at"`
+	// SHA256 is the hex checksum of the stored video file, for clients to
+	// check downloads against. UploadSHA256 is that of the file as it was
+	// uploaded, before it was remuxed.
+	SHA256       *string `json:"sha256"`
+	UploadSHA256 *string `json:"upload_sha256"`
+	// DeletedAt is when the video was moved to the trash. Trashed videos
+	// are left out of listings until they're restored or purged.
+	DeletedAt *time.Time `json:"deleted_at"`
+	// ArchivedAt is when the owner archived the video. Archived videos are
+	// kept, but left out of listings and feeds until they're unarchived.
+	ArchivedAt *time.Time `json:"archived_at"`
+	// StorageRegion is the named bucket the video's files are in, empty for
+	// the default one
+	StorageRegion string `json:"storage_region"`
+	// ShortID is a short alias for ID used in links, set on videos created
+	// while short IDs are turned on
+	ShortID *string `json:"short_id"`
+	// PremiereAt is when the video premieres. Until then PremierePending is
+	// set and only its owner can see it.
+	PremiereAt      *time.Time `json:"premiere_at"`
+	PremierePending bool       `json:"premiere_pending"`
+	// OriginalFilename is the name the stored file was uploaded with,
+	// cleaned up, for downloads to be saved under
+	OriginalFilename *string `json:"original_filename"`
+	// PublicStats shows ViewCount to people other than the owner
+	PublicStats bool `json:"public_stats"`
+	// EmbedDomains limits the sites the video can be embedded on
+	EmbedDomains EmbedDomains `json:"embed_domains"`
+	// VideoScan and ThumbnailScan are the malware scans of the uploaded
+	// files, nil when they weren't scanned
+	VideoScan     *ScanResult `json:"video_scan"`
+	ThumbnailScan *ScanResult `json:"thumbnail_scan"`
+	// ModerationStatus gates who can see the video: only its owner until
+	// it's approved. ModeratedBy is the admin who reviewed it, null when
+	// the content moderator decided.
+	ModerationStatus ModerationStatus `json:"moderation_status"`
+	ModerationLabels ModerationLabels `json:"moderation_labels"`
+	ModeratedAt      *time.Time       `json:"moderated_at"`
+	ModeratedBy      uuid.NullUUID    `json:"moderated_by"`
 	CreateVideoParams
 }
 
+// Published reports whether people other than the owner can see the video
+func (v Video) Published() bool {
+	return v.VideoURL != nil && !v.PremierePending && v.ModerationStatus == ModerationApproved
+}
+
+// VisibleTo reports whether the viewer, uuid.Nil for someone signed out,
+// can see the video: its owner until it's trashed, and everyone else while
+// it's published and not archived
+func (v Video) VisibleTo(viewerID uuid.UUID) bool {
+	if v.ID == uuid.Nil || v.DeletedAt != nil {
+		return false
+	}
+	if viewerID != uuid.Nil && v.UserID == viewerID {
+		return true
+	}
+	return v.Published() && v.ArchivedAt == nil
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title          string        `json:"title"`
+	Description    string        `json:"description"`
+	UserID         uuid.UUID     `json:"user_id"`
+	OrganizationID uuid.NullUUID `json:"organization_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// videoColumns is the column list every video query selects, in the order
+// scanVideo expects them
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,9 +162,140 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		organization_id,
+		link_status,
+		link_checked_at,
+		pinned,
+		sort_index,
+		size_bytes,
+		duration_seconds,
+		video_codec,
+		audio_codec,
+		bit_rate,
+		frame_rate,
+		audio_channels,
+		view_count,
+		processing_status,
+		indexable,
+		renditions,
+		thumbnail_small_url,
+		preview_url,
+		sprite_url,
+		sprite_vtt_url,
+		captions,
+		audio_url,
+		delete_at,
+		legal_hold,
+		legal_hold_reason,
+		source_video_id,
+		restore_requested_at,
+		sha256,
+		upload_sha256,
+		deleted_at,
+		storage_region,
+		archived_at,
+		short_id,
+		premiere_at,
+		premiere_pending,
+		original_filename,
+		public_stats,
+		embed_domains,
+		video_scan,
+		thumbnail_scan,
+		moderation_status,
+		moderation_labels,
+		moderated_at,
+		moderated_by,
+		like_count,
+		dislike_count,
+		comment_count
+`
+
+// listedVideo is the condition for videos that show up in listings,
+// leaving out those in the trash or archived
+const listedVideo = "deleted_at IS NULL AND archived_at IS NULL"
+
+// publishedVideo is the condition for videos people other than their owner
+// can see: uploaded, premiered if a premiere was scheduled, and approved
+const publishedVideo = "video_url IS NOT NULL AND NOT premiere_pending AND moderation_status = 'approved'"
+
+// visibleVideo is the condition for the listed videos a viewer, bound to
+// its placeholder, can see: their own, and other people's once they're
+// published
+const visibleVideo = "(user_id = ? OR (" + publishedVideo + ")) AND " + listedVideo
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.OrganizationID,
+		&video.LinkStatus,
+		&video.LinkCheckedAt,
+		&video.Pinned,
+		&video.SortIndex,
+		&video.SizeBytes,
+		&video.DurationSeconds,
+		&video.VideoCodec,
+		&video.AudioCodec,
+		&video.BitRate,
+		&video.FrameRate,
+		&video.AudioChannels,
+		&video.ViewCount,
+		&video.ProcessingStatus,
+		&video.Indexable,
+		&video.Renditions,
+		&video.ThumbnailSmallURL,
+		&video.PreviewURL,
+		&video.SpriteURL,
+		&video.SpriteVTTURL,
+		&video.Captions,
+		&video.AudioURL,
+		&video.DeleteAt,
+		&video.LegalHold,
+		&video.LegalHoldReason,
+		&video.SourceVideoID,
+		&video.RestoreRequestedAt,
+		&video.SHA256,
+		&video.UploadSHA256,
+		&video.DeletedAt,
+		&video.StorageRegion,
+		&video.ArchivedAt,
+		&video.ShortID,
+		&video.PremiereAt,
+		&video.PremierePending,
+		&video.OriginalFilename,
+		&video.PublicStats,
+		&video.EmbedDomains,
+		scanResultColumn{&video.VideoScan},
+		scanResultColumn{&video.ThumbnailScan},
+		&video.ModerationStatus,
+		&video.ModerationLabels,
+		&video.ModeratedAt,
+		&video.ModeratedBy,
+		&video.LikeCount,
+		&video.DislikeCount,
+		&video.CommentCount,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND ` + listedVideo + `
 	ORDER BY created_at DESC
 	`
 
@@ -47,17 +307,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -66,8 +317,36 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	return videos, nil
 }
 
-func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+// ErrShortIDTaken is returned when a new video's short ID is already used
+// by another one
+var ErrShortIDTaken = errors.New("short ID is already taken")
+
+// CreateVideo creates a video, giving it shortID as well as its UUID unless
+// shortID is empty
+func (c Client) CreateVideo(params CreateVideoParams, shortID string) (Video, error) {
+	return c.createVideo(params, nil, shortID)
+}
+
+// CreateDerivedVideo creates a video made from the video sourceID, like a
+// trimmed copy of it. It's stored in the same region as the source.
+func (c Client) CreateDerivedVideo(params CreateVideoParams, sourceID uuid.UUID, shortID string) (Video, error) {
+	return c.createVideo(params, &sourceID, shortID)
+}
+
+// SetVideoStorageRegion records which region the video's files go to
+func (c Client) SetVideoStorageRegion(videoID uuid.UUID, region string) error {
+	query := `
+	UPDATE videos
+	SET storage_region = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, region, videoID)
+	return err
+}
+
+func (c Client) createVideo(params CreateVideoParams, sourceID *uuid.UUID, shortID string) (Video, error) {
 	id := uuid.New()
+	// nothing is inserted if another video has the short ID
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,42 +354,59 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		organization_id,
+		source_video_id,
+		storage_region,
+		short_id
+	) SELECT
+		?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?,
+		COALESCE((SELECT storage_region FROM videos WHERE id = ?), ''),
+		NULLIF(?, '')
+	WHERE ? = '' OR NOT EXISTS (SELECT 1 FROM videos WHERE short_id = ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	result, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.OrganizationID, sourceID, sourceID, shortID, shortID, shortID)
+	if err != nil {
+		return Video{}, err
+	}
+	inserted, err := result.RowsAffected()
 	if err != nil {
 		return Video{}, err
 	}
+	if inserted == 0 {
+		return Video{}, ErrShortIDTaken
+	}
 
 	return c.GetVideo(id)
 }
 
+// ResolveVideoID turns ref, a video's UUID or its short ID, into the UUID.
+// It returns uuid.Nil if no video has the short ID.
+func (c Client) ResolveVideoID(ref string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		return id, nil
+	}
+	query := `
+	SELECT id
+	FROM videos
+	WHERE short_id = ?
+	`
+	var id uuid.UUID
+	err := c.db.QueryRow(query, ref).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return id, err
+}
+
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -128,6 +424,7 @@ func (c Client) UpdateVideo(video Video) error {
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_small_url = ?,
 		video_url = ?,
 		user_id = ?
 	WHERE id = ?
@@ -138,6 +435,7 @@ func (c Client) UpdateVideo(video Video) error {
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		&video.ThumbnailSmallURL,
 		&video.VideoURL,
 		video.UserID,
 		video.ID,
@@ -145,21 +443,481 @@ func (c Client) UpdateVideo(video Video) error {
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// UpdateVideoMetadata changes the title and description, leaving either
+// as it is when nil
+func (c Client) UpdateVideoMetadata(videoID uuid.UUID, title, description *string, publicStats *bool) error {
+	query := `
+	UPDATE videos
+	SET title = COALESCE(?, title),
+		description = COALESCE(?, description),
+		public_stats = COALESCE(?, public_stats),
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, title, description, publicStats, videoID)
+	return err
+}
+
+// UpdateVideoURL points the video at a newly stored file, clearing the
+// renditions, preview and sprite made from the one before, and releases
+// the replaced objects, queueing the ones nothing refers to anymore for
+// deletion, in a single transaction
+func (c Client) UpdateVideoURL(videoID uuid.UUID, videoURL string, replaced []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET video_url = ?,
+		renditions = ?,
+		preview_url = NULL,
+		sprite_url = NULL,
+		sprite_vtt_url = NULL
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, &videoURL, Renditions{}, videoID); err != nil {
+		return nil, err
+	}
+	deletions, err := insertPendingDeletions(tx, replaced)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// UpdateVideoChecksums records the SHA-256 of the stored video file and of
+// the upload it was made from
+func (c Client) UpdateVideoChecksums(videoID uuid.UUID, sha256, uploadSHA256 string) error {
+	query := `
+	UPDATE videos
+	SET sha256 = ?, upload_sha256 = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, sha256, uploadSHA256, videoID)
+	return err
+}
+
+// UpdateVideoOriginalFilename records the name the video file was uploaded
+// with; an empty name clears it
+func (c Client) UpdateVideoOriginalFilename(videoID uuid.UUID, filename string) error {
+	query := `
+	UPDATE videos
+	SET original_filename = ?
+	WHERE id = ?
+	`
+	var name *string
+	if filename != "" {
+		name = &filename
+	}
+	_, err := c.db.Exec(query, name, videoID)
+	return err
+}
+
+// FindVideoByUploadSHA256 returns another of the user's videos made from an
+// identical upload, or a zero Video if there isn't one
+func (c Client) FindVideoByUploadSHA256(userID uuid.UUID, uploadSHA256 string, excludeID uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND upload_sha256 = ? AND id != ? AND deleted_at IS NULL
+	ORDER BY created_at
+	LIMIT 1
+	`
+	video, err := scanVideo(c.db.QueryRow(query, userID, uploadSHA256, excludeID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, nil
+	}
+	return video, err
+}
+
+// MediaInfo describes a stored video file. Zero values are saved as unknown.
+type MediaInfo struct {
+	SizeBytes       int64
+	DurationSeconds float64
+	VideoCodec      string
+	AudioCodec      string
+	BitRate         int64
+	FrameRate       float64
+	AudioChannels   int
+}
+
+func (c Client) UpdateVideoPreviewURL(videoID uuid.UUID, previewURL *string) error {
+	query := `
+	UPDATE videos
+	SET preview_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, previewURL, videoID)
+	return err
+}
+
+func (c Client) SetVideoRestoreRequestedAt(videoID uuid.UUID, at time.Time) error {
+	query := `
+	UPDATE videos
+	SET restore_requested_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, at.UTC().Format(sqliteTime), videoID)
+	return err
+}
+
+// UpdateVideoAudioURL sets the extracted audio of a video and queues the
+// file of the audio it replaces for deletion, in a single transaction
+func (c Client) UpdateVideoAudioURL(videoID uuid.UUID, audioURL string, replaced []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET audio_url = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, audioURL, videoID); err != nil {
+		return nil, err
+	}
+	deletions, err := insertPendingDeletions(tx, replaced)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// SpriteURLs locate a seek-bar sprite sheet and its WebVTT index
+type SpriteURLs struct {
+	Sprite *string
+	VTT    *string
+}
+
+func (c Client) UpdateVideoSpriteURLs(videoID uuid.UUID, urls SpriteURLs) error {
+	query := `
+	UPDATE videos
+	SET sprite_url = ?, sprite_vtt_url = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, urls.Sprite, urls.VTT, videoID)
+	return err
+}
+
+// MoveVideoObjects points a video at the copies of its files in video and
+// releases the objects they were copied from, queueing the ones nothing
+// refers to anymore for deletion, in a single transaction
+func (c Client) MoveVideoObjects(video Video, moved []CreatePendingDeletionParams) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET video_url = ?,
+		renditions = ?,
+		preview_url = ?,
+		sprite_url = ?,
+		sprite_vtt_url = ?,
+		captions = ?,
+		audio_url = ?
+	WHERE id = ?
+	`
+	if _, err := tx.Exec(query, video.VideoURL, video.Renditions, video.PreviewURL, video.SpriteURL, video.SpriteVTTURL, video.Captions, video.AudioURL, video.ID); err != nil {
+		return nil, err
+	}
+	deletions, err := insertPendingDeletions(tx, moved)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+func (c Client) UpdateVideoMediaInfo(videoID uuid.UUID, info MediaInfo) error {
+	query := `
+	UPDATE videos
+	SET size_bytes = ?,
+		duration_seconds = NULLIF(?, 0),
+		video_codec = NULLIF(?, ''),
+		audio_codec = NULLIF(?, ''),
+		bit_rate = NULLIF(?, 0),
+		frame_rate = NULLIF(?, 0),
+		audio_channels = NULLIF(?, 0)
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query,
+		info.SizeBytes,
+		info.DurationSeconds,
+		info.VideoCodec,
+		info.AudioCodec,
+		info.BitRate,
+		info.FrameRate,
+		info.AudioChannels,
+		videoID,
+	)
+	return err
+}
+
+// EachVideo calls fn for each of a user's videos, oldest first, stopping at
+// the first error fn returns. Videos are read a page at a time and no query
+// stays open while fn runs, so a slow consumer doesn't hold up writers.
+func (c Client) EachVideo(userID uuid.UUID, fn func(Video) error) error {
+	return c.eachVideo("user_id = ? AND deleted_at IS NULL", []any{userID}, fn)
+}
+
+// EachStoredVideo is EachVideo over every user's videos that have a stored
+// file, trashed ones included
+func (c Client) EachStoredVideo(fn func(Video) error) error {
+	return c.eachVideo("video_url IS NOT NULL", nil, fn)
+}
+
+func (c Client) eachVideo(where string, args []any, fn func(Video) error) error {
+	const pageSize = 200
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + where + ` AND (created_at, id) > (?, ?)
+	ORDER BY created_at, id
+	LIMIT ?
+	`
+
+	// compare as text in the format CURRENT_TIMESTAMP stored
+	afterCreatedAt, afterID := "", ""
+	for {
+		rows, err := c.db.Query(query, append(args, afterCreatedAt, afterID, pageSize)...)
+		if err != nil {
+			return err
+		}
+		page := []Video{}
+		for rows.Next() {
+			video, err := scanVideo(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, video)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, video := range page {
+			if err := fn(video); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterID = last.CreatedAt.UTC().Format(sqliteTime), last.ID.String()
+	}
+}
+
+func (c Client) UpdateVideoProcessingStatus(videoID uuid.UUID, status ProcessingStatus) error {
+	_, err := c.db.Exec("UPDATE videos SET processing_status = ?, processing_status_at = CURRENT_TIMESTAMP WHERE id = ?", status, videoID)
+	return err
+}
+
+// FinishVideoProcessing sets the status a finished upload leaves the video
+// in. A video that still has an earlier upload stays ready whatever happened.
+func (c Client) FinishVideoProcessing(videoID uuid.UUID, status ProcessingStatus) error {
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET processing_status = CASE WHEN video_url IS NULL THEN ? ELSE 'ready' END,
+		processing_status_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.db.Exec(query, status, videoID)
 	return err
 }
 
-func (c Client) UpdateVideoURL(videoID uuid.UUID, videoURL string) error {
+// ResetInterruptedProcessing clears the processing status of uploads that
+// were running when the server last stopped, since their jobs died with it
+func (c Client) ResetInterruptedProcessing() error {
 	query := `
-    UPDATE videos
-    SET video_url = ?
-    WHERE id = ?
-    `
-	_, err := c.db.Exec(query, &videoURL, videoID)
+	UPDATE videos
+	SET processing_status = CASE WHEN video_url IS NULL THEN 'failed' ELSE 'ready' END
+	WHERE processing_status = 'processing'
+	`
+	_, err := c.db.Exec(query)
 	return err
 }
+
+// GetVideosForLinkCheck returns a random sample of videos that have a video
+// or thumbnail URL
+func (c Client) GetVideosForLinkCheck(limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE (video_url IS NOT NULL OR thumbnail_url IS NOT NULL) AND deleted_at IS NULL
+	ORDER BY RANDOM()
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+func (c Client) UpdateVideoLinkStatus(videoID uuid.UUID, status LinkStatus) error {
+	query := `
+	UPDATE videos
+	SET link_status = ?, link_checked_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, videoID)
+	return err
+}
+
+// GetChannelVideos returns a user's published videos the way their channel
+// shows them: pinned first, then by the custom order, then newest first
+func (c Client) GetChannelVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND ` + publishedVideo + ` AND ` + listedVideo + `
+	ORDER BY pinned DESC, sort_index IS NULL, sort_index, created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// PinVideo pins a video unless its owner already has limit pinned videos, in
+// which case it returns false. The check and the update are one statement so
+// concurrent pins can't go over the limit.
+func (c Client) PinVideo(videoID, userID uuid.UUID, limit int) (bool, error) {
+	query := `
+	UPDATE videos
+	SET pinned = TRUE, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ?
+		AND (SELECT COUNT(*) FROM videos WHERE user_id = ? AND pinned) < ?
+	`
+	res, err := c.db.Exec(query, videoID, userID, userID, limit)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c Client) UnpinVideo(videoID uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET pinned = FALSE, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
+
+// SetChannelOrder gives videoIDs sort indexes in the order they're listed.
+// The user's other videos lose their index and fall back to newest first.
+func (c Client) SetChannelOrder(userID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE videos SET sort_index = NULL WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for i, id := range videoIDs {
+		res, err := tx.Exec("UPDATE videos SET sort_index = ? WHERE id = ? AND user_id = ?", i, id, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("video %s doesn't belong to user %s", id, userID)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (c Client) SetVideoIndexable(id uuid.UUID, indexable bool) error {
+	query := `
+	UPDATE videos
+	SET indexable = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, indexable, id)
+	return err
+}
+
+// GetIndexableVideos returns published, indexable videos, most recently
+// updated first. A zero userID means every user's.
+func (c Client) GetIndexableVideos(userID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + publishedVideo + ` AND indexable AND ` + listedVideo + ` AND (? = ? OR user_id = ?)
+	ORDER BY updated_at DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, userID, uuid.Nil, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
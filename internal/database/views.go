@@ -0,0 +1,92 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ViewBucketSize string
+
+const (
+	ViewBucketHour ViewBucketSize = "hour"
+	ViewBucketDay  ViewBucketSize = "day"
+)
+
+var viewBucketFormats = map[ViewBucketSize]string{
+	ViewBucketHour: "%Y-%m-%d %H:00:00",
+	ViewBucketDay:  "%Y-%m-%d 00:00:00",
+}
+
+// ViewCount is how many views, from how many viewers, a video got in the
+// bucket starting at Start
+type ViewCount struct {
+	Start   time.Time
+	Views   int64
+	Viewers int64
+}
+
+// RecordVideoView counts a view by viewer, unless the same viewer was
+// already counted for the video after since, and reports whether it was
+// counted. The check and the insert are one statement so a viewer sending
+// two at once is still counted once.
+func (c Client) RecordVideoView(videoID uuid.UUID, viewer string, userID uuid.NullUUID, since time.Time) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO video_views (video_id, viewer, user_id, created_at)
+	SELECT ?, ?, ?, CURRENT_TIMESTAMP
+	WHERE NOT EXISTS (
+		SELECT 1 FROM video_views
+		WHERE video_id = ? AND viewer = ? AND created_at > ?
+	)
+	`
+	res, err := tx.Exec(query, videoID, viewer, userID, videoID, viewer, since.UTC().Format(sqliteTime))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE videos SET view_count = view_count + 1 WHERE id = ?", videoID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetVideoViewCounts counts a video's views from from until to, including
+// to's second, in buckets of the given size, skipping buckets without views
+func (c Client) GetVideoViewCounts(videoID uuid.UUID, size ViewBucketSize, from, to time.Time) ([]ViewCount, error) {
+	query := `
+	SELECT strftime(?, created_at) AS bucket, COUNT(*), COUNT(DISTINCT viewer)
+	FROM video_views
+	WHERE video_id = ? AND created_at >= ? AND created_at <= ?
+	GROUP BY bucket
+	ORDER BY bucket
+	`
+	rows, err := c.db.Query(query, viewBucketFormats[size], videoID, from.UTC().Format(sqliteTime), to.UTC().Format(sqliteTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ViewCount{}
+	for rows.Next() {
+		var bucket string
+		var count ViewCount
+		if err := rows.Scan(&bucket, &count.Views, &count.Viewers); err != nil {
+			return nil, err
+		}
+		count.Start, err = time.Parse(sqliteTime, bucket)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
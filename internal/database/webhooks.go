@@ -0,0 +1,301 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	Events []string  `json:"events"`
+	// Secret signs deliveries; clients only see it when the webhook is
+	// created
+	Secret string `json:"-"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		user_id,
+		url,
+		events,
+		secret
+`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&events,
+		&webhook.Secret,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook.Events = strings.Split(events, ",")
+	return webhook, nil
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		user_id,
+		url,
+		events,
+		secret
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.URL, strings.Join(params.Events, ","), params.Secret)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return c.GetWebhook(id)
+}
+
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, nil
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes the webhook and its queued deliveries if it belongs
+// to userID and reports whether there was one
+func (c Client) DeleteWebhook(userID, id uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// WebhookDelivery is an event on its way to a webhook. Deliveries are
+// removed once the endpoint takes them; ones that ran out of attempts are
+// kept with FailedAt set so the owner can see what was lost.
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	WebhookID     uuid.UUID       `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error"`
+	FailedAt      *time.Time      `json:"failed_at"`
+}
+
+const webhookDeliveryColumns = `
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event,
+		payload,
+		attempts,
+		next_attempt_at,
+		last_error,
+		failed_at
+`
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload string
+		if err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.WebhookID,
+			&d.Event,
+			&payload,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&d.LastError,
+			&d.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CreateWebhookDeliveries queues an event for each of the webhooks. The
+// caller attempts them right away; retries start at retryAt.
+func (c Client) CreateWebhookDeliveries(webhookIDs []uuid.UUID, event string, payload []byte, retryAt time.Time) ([]WebhookDelivery, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO webhook_deliveries (
+		webhook_id,
+		event,
+		payload,
+		attempts,
+		next_attempt_at,
+		last_error,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, 0, ?, '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	deliveries := make([]WebhookDelivery, 0, len(webhookIDs))
+	for _, webhookID := range webhookIDs {
+		res, err := tx.Exec(query, webhookID, event, string(payload), retryAt.UTC().Format(sqliteTime))
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			ID:            id,
+			WebhookID:     webhookID,
+			Event:         event,
+			Payload:       json.RawMessage(payload),
+			NextAttemptAt: retryAt,
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDueWebhookDeliveries returns deliveries still being tried whose next
+// attempt is due by now
+func (c Client) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE failed_at IS NULL AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, now.UTC().Format(sqliteTime), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWebhookDeliveries(rows)
+}
+
+// GetWebhookDeliveries returns the webhook's undelivered events, newest
+// first
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY id DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWebhookDeliveries(rows)
+}
+
+func (c Client) DeleteWebhookDelivery(id int64) error {
+	query := `
+	DELETE FROM webhook_deliveries
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+// MarkWebhookDeliveryFailed records a failed attempt and when to try again,
+// or with a zero nextAttemptAt gives up on the delivery
+func (c Client) MarkWebhookDeliveryFailed(id int64, lastError string, nextAttemptAt time.Time) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		next_attempt_at = ?,
+		failed_at = CASE WHEN ? THEN CURRENT_TIMESTAMP ELSE NULL END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	giveUp := nextAttemptAt.IsZero()
+	next := nextAttemptAt.UTC().Format(sqliteTime)
+	if giveUp {
+		next = time.Now().UTC().Format(sqliteTime)
+	}
+	_, err := c.db.Exec(query, lastError, next, giveUp, id)
+	return err
+}
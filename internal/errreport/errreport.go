@@ -0,0 +1,76 @@
+// Package errreport sends panics to an error tracker. Reporter is shaped
+// after Sentry's CaptureException, and a Sentry client that needs nothing
+// but the DSN is included.
+package errreport
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Frame is one call in a stack trace
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Event is a panic or error to report, with the request it happened in
+type Event struct {
+	Err   error
+	Stack []Frame
+	// Tags are indexed by the tracker for search, like request_id and route
+	Tags map[string]string
+	// Method and URL are set for events in a request
+	Method string
+	URL    string
+}
+
+type Reporter interface {
+	// Report sends the event without blocking for long; failures are only
+	// logged
+	Report(ctx context.Context, event Event)
+}
+
+// PanicError wraps the value a goroutine panicked with
+type PanicError struct {
+	Value any
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Stack returns the caller's stack, innermost call first, skipping skip
+// frames above the caller and the runtime's panic machinery
+func Stack(skip int) []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []Frame
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, Frame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more {
+			return stack
+		}
+	}
+}
+
+// FormatStack writes stack one frame per line, the way a panic prints it
+func FormatStack(stack []Frame) string {
+	var b strings.Builder
+	for _, f := range stack {
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+	}
+	return b.String()
+}
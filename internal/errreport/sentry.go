@@ -0,0 +1,176 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const sentrySendTimeout = 10 * time.Second
+
+// SentryReporter posts events to Sentry's store endpoint, or to anything
+// that speaks its protocol, like GlitchTip
+type SentryReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	// appModule marks frames of this program as in-app, so the tracker can
+	// tell them from the standard library's and dependencies'
+	appModule  string
+	httpClient *http.Client
+}
+
+// NewSentryReporter parses a DSN like https://key@o1.ingest.sentry.io/42
+func NewSentryReporter(dsn, environment, appModule string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no public key")
+	}
+	path, project, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if project == "" {
+		project, path = path, ""
+	}
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no project ID")
+	}
+	if path != "" {
+		path = "/" + path
+	}
+
+	serverName, _ := os.Hostname()
+	return &SentryReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=tubely/1.0, sentry_key=%s", u.User.Username()),
+		environment: environment,
+		serverName:  serverName,
+		appModule:   appModule,
+		httpClient:  &http.Client{Timeout: sentrySendTimeout},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// Report sends the event in the background
+func (s *SentryReporter) Report(ctx context.Context, event Event) {
+	body, err := json.Marshal(s.encode(event))
+	if err != nil {
+		slog.WarnContext(ctx, "Couldn't encode error report", "error", err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sentrySendTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			slog.WarnContext(ctx, "Couldn't send error report", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			slog.WarnContext(ctx, "Couldn't send error report", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.WarnContext(ctx, "Error tracker rejected report", "status", resp.Status)
+		}
+	}()
+}
+
+func (s *SentryReporter) encode(event Event) sentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+	e := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Platform:    "go",
+		Level:       "error",
+		ServerName:  s.serverName,
+		Environment: s.environment,
+		Tags:        event.Tags,
+	}
+	if event.Method != "" {
+		e.Request = &sentryRequest{Method: event.Method, URL: event.URL}
+	}
+
+	exception := sentryException{Type: reflect.TypeOf(event.Err).String(), Value: event.Err.Error()}
+	if p, ok := event.Err.(PanicError); ok {
+		exception.Type = "panic"
+		exception.Value = fmt.Sprint(p.Value)
+		e.Level = "fatal"
+	}
+	// Sentry wants the outermost call first
+	for i := len(event.Stack) - 1; i >= 0; i-- {
+		f := event.Stack[i]
+		module, function := splitFunction(f.Function)
+		exception.Stacktrace.Frames = append(exception.Stacktrace.Frames, sentryFrame{
+			Function: function,
+			Module:   module,
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    s.appModule != "" && (module == "main" || strings.HasPrefix(module, s.appModule)),
+		})
+	}
+	e.Exception.Values = []sentryException{exception}
+	return e
+}
+
+// splitFunction splits a runtime function name like
+// github.com/x/y/pkg.(*T).Method into its package path and the rest
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
@@ -0,0 +1,82 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	EncoderLibx264      = "libx264"
+	EncoderNVENC        = "h264_nvenc"
+	EncoderQSV          = "h264_qsv"
+	EncoderVideoToolbox = "h264_videotoolbox"
+)
+
+// hardwareEncoders are tried in this order when detecting automatically
+var hardwareEncoders = []string{EncoderNVENC, EncoderQSV, EncoderVideoToolbox}
+
+// probeTimeout bounds each test encode; a missing GPU fails straight away,
+// but a driver that hangs shouldn't hold up startup
+const probeTimeout = 15 * time.Second
+
+// H264Args returns the ffmpeg arguments for encoding H.264 with encoder at
+// about the quality libx264 gives with crf. Every encoder has its own
+// quality scale; these are mapped so 23 looks roughly the same on all.
+func H264Args(encoder string, crf int) []string {
+	switch encoder {
+	case EncoderNVENC:
+		return []string{"-c:v", EncoderNVENC, "-preset", "p4", "-rc", "vbr", "-cq", strconv.Itoa(crf), "-b:v", "0", "-pix_fmt", "yuv420p"}
+	case EncoderQSV:
+		return []string{"-c:v", EncoderQSV, "-preset", "veryfast", "-global_quality", strconv.Itoa(crf), "-pix_fmt", "nv12"}
+	case EncoderVideoToolbox:
+		// -q:v runs from 1 to 100, higher is better
+		return []string{"-c:v", EncoderVideoToolbox, "-q:v", strconv.Itoa(100 - crf*3/2), "-pix_fmt", "yuv420p"}
+	default:
+		return []string{"-c:v", EncoderLibx264, "-preset", "veryfast", "-crf", strconv.Itoa(crf), "-pix_fmt", "yuv420p"}
+	}
+}
+
+// H264Encoder is the encoder picked at startup, libx264 unless a hardware
+// one passed its test encode
+func (r *Runner) H264Encoder() string {
+	if r.h264Encoder == "" {
+		return EncoderLibx264
+	}
+	return r.h264Encoder
+}
+
+// DetectH264Encoder picks the H.264 encoder to use. want is "off" or empty
+// for libx264, "auto" for the first hardware encoder that works, or the name
+// of one encoder. Hardware encoders are only used after a test encode works,
+// since ffmpeg builds list them whether or not the host has the hardware.
+// If none works, libx264 is picked and the error says why.
+func (r *Runner) DetectH264Encoder(ctx context.Context, want string) (string, error) {
+	var candidates []string
+	switch want {
+	case "", "off":
+	case "auto":
+		candidates = hardwareEncoders
+	case EncoderNVENC, EncoderQSV, EncoderVideoToolbox:
+		candidates = []string{want}
+	default:
+		return "", fmt.Errorf("unknown H.264 encoder %q", want)
+	}
+
+	r.h264Encoder = EncoderLibx264
+	var errs []error
+	for _, encoder := range candidates {
+		args := []string{"-hide_banner", "-nostdin", "-f", "lavfi", "-i", "color=c=black:s=256x256:d=0.2"}
+		args = append(args, H264Args(encoder, 23)...)
+		args = append(args, "-f", "null", "-")
+		if err := r.FFmpeg(ctx, args, Options{Timeout: probeTimeout}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", encoder, err))
+			continue
+		}
+		r.h264Encoder = encoder
+		return encoder, nil
+	}
+	return r.h264Encoder, errors.Join(errs...)
+}
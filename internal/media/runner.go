@@ -0,0 +1,211 @@
+// Package media runs ffmpeg and ffprobe with deadlines, a cap on how many
+// ffmpeg processes run at once, and errors that say why they failed.
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+// stderrTail is how much of the end of stderr is kept for error messages;
+// ffmpeg prints the reason it gave up last
+const stderrTail = 2 << 10
+
+type Config struct {
+	// FFmpegPath and FFprobePath default to looking the tools up in PATH
+	FFmpegPath  string
+	FFprobePath string
+	// MaxConcurrent caps how many ffmpeg processes run at once, 0 means no
+	// limit. ffprobe is cheap and isn't counted.
+	MaxConcurrent int
+	// Timeout is how long a process may run when the caller doesn't give
+	// its own, 0 means no limit
+	Timeout time.Duration
+	// Observe, if set, is called after every process with how long it ran
+	// and its error, for metrics
+	Observe func(program string, duration time.Duration, err error)
+}
+
+type Runner struct {
+	ffmpegPath  string
+	ffprobePath string
+	slots       chan struct{}
+	waiting     atomic.Int64
+	timeout     time.Duration
+	observe     func(program string, duration time.Duration, err error)
+	// h264Encoder is set by DetectH264Encoder
+	h264Encoder string
+}
+
+func NewRunner(cfg Config) *Runner {
+	r := &Runner{
+		ffmpegPath:  cfg.FFmpegPath,
+		ffprobePath: cfg.FFprobePath,
+		timeout:     cfg.Timeout,
+		observe:     cfg.Observe,
+	}
+	if r.ffmpegPath == "" {
+		r.ffmpegPath = "ffmpeg"
+	}
+	if r.ffprobePath == "" {
+		r.ffprobePath = "ffprobe"
+	}
+	if cfg.MaxConcurrent > 0 {
+		r.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return r
+}
+
+// LookPath finds the binary the runner uses for program, "ffmpeg" or
+// "ffprobe"
+func (r *Runner) LookPath(program string) (string, error) {
+	path := r.ffmpegPath
+	if program == "ffprobe" {
+		path = r.ffprobePath
+	}
+	return exec.LookPath(path)
+}
+
+// Options are where a process reads and writes. Unset streams are
+// discarded, apart from the end of stderr, which goes into the error.
+type Options struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr gets a copy of everything the process writes to stderr
+	Stderr io.Writer
+	// Timeout replaces the runner's default for this process
+	Timeout time.Duration
+}
+
+// Error is returned when a process fails to start, exits with an error or
+// is stopped by its context. It unwraps to the *exec.ExitError or to the
+// context's error.
+type Error struct {
+	Program string
+	Err     error
+	// Stderr is the end of what the process wrote to stderr
+	Stderr  string
+	timeout time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s failed: %v", e.Program, e.Err)
+	if errors.Is(e.Err, context.DeadlineExceeded) && e.timeout > 0 {
+		msg = fmt.Sprintf("%s timed out after %s", e.Program, e.timeout)
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// FFmpeg runs ffmpeg with args, first waiting for a free slot if the runner
+// has a concurrency limit
+func (r *Runner) FFmpeg(ctx context.Context, args []string, opts Options) error {
+	if r.slots != nil {
+		r.waiting.Add(1)
+		select {
+		case r.slots <- struct{}{}:
+			r.waiting.Add(-1)
+		case <-ctx.Done():
+			r.waiting.Add(-1)
+			return &Error{Program: "ffmpeg", Err: ctx.Err()}
+		}
+		defer func() { <-r.slots }()
+	}
+	return r.run(ctx, "ffmpeg", r.ffmpegPath, args, opts)
+}
+
+// Queue reports how many ffmpeg processes are waiting for a slot and how
+// many slots there are. Both are 0 when there's no limit.
+func (r *Runner) Queue() (waiting, slots int) {
+	return int(r.waiting.Load()), cap(r.slots)
+}
+
+func (r *Runner) FFprobe(ctx context.Context, args []string, opts Options) error {
+	return r.run(ctx, "ffprobe", r.ffprobePath, args, opts)
+}
+
+func (r *Runner) run(ctx context.Context, program, path string, args []string, opts Options) error {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = r.timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracing.Start(ctx, program, tracing.KindInternal, tracing.String("process.command_args", strings.Join(args, " ")))
+	tail := &tailBuffer{limit: stderrTail}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = tail
+	if opts.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(tail, opts.Stderr)
+	}
+	// don't let a grandchild holding a pipe open keep Wait from returning
+	cmd.WaitDelay = time.Second
+
+	// logged at debug since callers report failures; the context carries
+	// the request or upload the process is for
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start)
+	if r.observe != nil {
+		r.observe(program, elapsed, err)
+	}
+	if err == nil {
+		span.End(nil)
+		slog.DebugContext(ctx, "ran "+program, "args", args, "duration", elapsed)
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// the exit status of a killed process says nothing useful
+		err = ctxErr
+	}
+	runErr := &Error{Program: program, Err: err, Stderr: tail.lastLines(3), timeout: timeout}
+	span.End(runErr)
+	slog.DebugContext(ctx, program+" failed", "args", args, "duration", elapsed, "error", runErr)
+	return runErr
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = t.buf[over:]
+	}
+	return len(p), nil
+}
+
+// lastLines returns up to n of the last non-empty lines joined with "; ".
+// ffmpeg's own summary ("Conversion failed!") comes after the actual reason,
+// so one line isn't enough.
+func (t *tailBuffer) lastLines(n int) string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(string(t.buf), func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines[max(0, len(lines)-n):], "; ")
+}
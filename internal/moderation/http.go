@@ -0,0 +1,64 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPModerator moderates frames with a model served over HTTP, like one
+// running next to the server. Each frame is POSTed as image/jpeg and the
+// service answers with {"labels": [{"name": ..., "confidence": ...}]},
+// confidence from 0 to 100.
+type HTTPModerator struct {
+	url           string
+	minConfidence float64
+	client        *http.Client
+}
+
+func NewHTTPModerator(url string, minConfidence float64) *HTTPModerator {
+	return &HTTPModerator{
+		url:           url,
+		minConfidence: minConfidence,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (m *HTTPModerator) Name() string { return "http" }
+
+func (m *HTTPModerator) ModerateFrame(ctx context.Context, frame []byte) ([]Label, error) {
+	type response struct {
+		Labels []Label `json:"labels"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "image/jpeg")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("moderation service returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var out response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("couldn't decode moderation response: %w", err)
+	}
+	labels := []Label{}
+	for _, label := range out.Labels {
+		if label.Confidence >= m.minConfidence {
+			labels = append(labels, label)
+		}
+	}
+	return labels, nil
+}
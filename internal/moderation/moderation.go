@@ -0,0 +1,48 @@
+// Package moderation screens frames of uploaded videos for unsafe content
+package moderation
+
+import (
+	"context"
+	"sort"
+)
+
+// Label is a kind of unsafe content found in a frame, with how confident
+// the moderator is of it, from 0 to 100
+type Label struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ContentModerator looks at frames of a video. An error means the frame
+// couldn't be moderated, not that anything was found in it.
+type ContentModerator interface {
+	// ModerateFrame returns the unsafe content found in frame, a JPEG,
+	// leaving out labels below the moderator's minimum confidence
+	ModerateFrame(ctx context.Context, frame []byte) ([]Label, error)
+	// Name identifies the moderator in stored results
+	Name() string
+}
+
+// Merge combines the labels of several frames, keeping each label's
+// highest confidence, most confident first
+func Merge(frames ...[]Label) []Label {
+	best := map[string]float64{}
+	for _, labels := range frames {
+		for _, label := range labels {
+			if label.Confidence > best[label.Name] {
+				best[label.Name] = label.Confidence
+			}
+		}
+	}
+	merged := make([]Label, 0, len(best))
+	for name, confidence := range best {
+		merged = append(merged, Label{Name: name, Confidence: confidence})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Confidence != merged[j].Confidence {
+			return merged[i].Confidence > merged[j].Confidence
+		}
+		return merged[i].Name < merged[j].Name
+	})
+	return merged
+}
@@ -0,0 +1,96 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Rekognition moderates frames with AWS Rekognition's
+// DetectModerationLabels, called over its JSON API
+type Rekognition struct {
+	config        aws.Config
+	endpoint      string
+	minConfidence float64
+	client        *http.Client
+	signer        *v4.Signer
+}
+
+// NewRekognition returns a moderator using the region and credentials of
+// config
+func NewRekognition(config aws.Config, minConfidence float64) *Rekognition {
+	return &Rekognition{
+		config:        config,
+		endpoint:      fmt.Sprintf("https://rekognition.%s.amazonaws.com/", config.Region),
+		minConfidence: minConfidence,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		signer:        v4.NewSigner(),
+	}
+}
+
+func (r *Rekognition) Name() string { return "rekognition" }
+
+func (r *Rekognition) ModerateFrame(ctx context.Context, frame []byte) ([]Label, error) {
+	type image struct {
+		Bytes []byte
+	}
+	type request struct {
+		Image         image
+		MinConfidence float64
+	}
+	type response struct {
+		ModerationLabels []struct {
+			Name       string
+			Confidence float64
+		}
+	}
+
+	body, err := json.Marshal(request{Image: image{Bytes: frame}, MinConfidence: r.minConfidence})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "RekognitionService.DetectModerationLabels")
+
+	creds, err := r.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get AWS credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := r.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "rekognition", r.config.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("couldn't sign request: %w", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("rekognition returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var out response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("couldn't decode rekognition response: %w", err)
+	}
+	labels := make([]Label, 0, len(out.ModerationLabels))
+	for _, label := range out.ModerationLabels {
+		labels = append(labels, Label{Name: label.Name, Confidence: label.Confidence})
+	}
+	return labels, nil
+}
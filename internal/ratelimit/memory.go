@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// buckets idle for this long are full again and can be forgotten
+const memoryBucketIdle = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter keeps buckets in process, so each instance limits on its own
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+func (m *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastPrune) > memoryBucketIdle {
+		for k, b := range m.buckets {
+			if now.Sub(b.last) > memoryBucketIdle {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
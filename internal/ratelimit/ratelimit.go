@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens are added per second, up to Burst
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests a minute with bursts of up to n
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+type Limiter interface {
+	// Allow takes a token from key's bucket. When the bucket is empty it
+	// returns false and how long until the next token.
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
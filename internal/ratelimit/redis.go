@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenBucketScript refills and takes from a bucket atomically. It returns
+// {allowed, milliseconds until the next token}.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`
+
+const (
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = time.Second
+	redisMaxIdle     = 8
+)
+
+// RedisLimiter keeps buckets in Redis so every instance shares them. It
+// speaks just enough RESP to run the token bucket script.
+type RedisLimiter struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	idle     chan *redisConn
+}
+
+// NewRedisLimiter takes a redis://[:password@]host:port[/db] URL
+func NewRedisLimiter(rawURL, prefix string) (*RedisLimiter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis URL scheme %q", u.Scheme)
+	}
+	l := &RedisLimiter{
+		addr:   u.Host,
+		prefix: prefix,
+		idle:   make(chan *redisConn, redisMaxIdle),
+	}
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		l.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		l.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return l, nil
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	conn, err := l.get(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+
+	reply, err := conn.do(
+		"EVAL", tokenBucketScript, "1", l.prefix+key,
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		strconv.Itoa(limit.Burst),
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+	)
+	if err != nil {
+		conn.Close()
+		return false, 0, err
+	}
+	l.put(conn)
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply from rate limit script: %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	waitMS, _ := values[1].(int64)
+	return allowed == 1, time.Duration(waitMS) * time.Millisecond, nil
+}
+
+func (l *RedisLimiter) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-l.idle:
+		return conn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: redisDialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", l.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if l.password != "" {
+		if _, err := conn.do("AUTH", l.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if l.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(l.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (l *RedisLimiter) put(conn *redisConn) {
+	select {
+	case l.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (any, error) {
+	c.SetDeadline(time.Now().Add(redisIOTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRESP(c.r)
+}
+
+// readRESP reads one reply. Integers come back as int64, bulk and simple
+// strings as string, arrays as []any and nil replies as nil.
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			values[i], err = readRESP(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
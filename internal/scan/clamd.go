@@ -0,0 +1,170 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is how much of a file goes in each INSTREAM chunk
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon, streaming them over its socket
+// so it doesn't need access to our files
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd returns a scanner for the clamd listening at address: a path for
+// a unix socket, or host:port for TCP. A scan that takes longer than
+// timeout fails; 0 leaves it to ctx.
+func NewClamd(address string, timeout time.Duration) *Clamd {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return &Clamd{network: network, address: address, timeout: timeout}
+}
+
+func (c *Clamd) Name() string { return "clamd" }
+
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to clamd: %w", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if c.timeout > 0 && (!ok || time.Now().Add(c.timeout).Before(deadline)) {
+		deadline, ok = time.Now().Add(c.timeout), true
+	}
+	if ok {
+		conn.SetDeadline(deadline)
+	}
+	// a canceled ctx unblocks reads and writes in flight
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	return closeConn{conn, stop}, nil
+}
+
+type closeConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c closeConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// Ping checks clamd is up and answering
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("couldn't ping clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("couldn't ping clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd answered ping with %q", reply)
+	}
+	return nil
+}
+
+// Scan sends body with the INSTREAM command: chunks prefixed with their
+// length, ended by an empty one
+func (c *Clamd) Scan(ctx context.Context, body io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return Result{}, c.scanError(ctx, conn, err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return Result{}, c.scanError(ctx, conn, err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return Result{}, c.scanError(ctx, conn, err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, fmt.Errorf("couldn't read file to scan: %w", readErr)
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return Result{}, c.scanError(ctx, conn, err)
+	}
+	if err := w.Flush(); err != nil {
+		return Result{}, c.scanError(ctx, conn, err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, fmt.Errorf("couldn't read clamd's reply: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// scanError explains a failed write. clamd stops reading and replies with
+// an error once a stream passes StreamMaxLength, so its reply says more
+// than the broken pipe does.
+func (c *Clamd) scanError(ctx context.Context, conn net.Conn, err error) error {
+	if ctx.Err() == nil {
+		if reply, replyErr := readReply(conn); replyErr == nil {
+			if _, parseErr := parseReply(reply); parseErr != nil {
+				return parseErr
+			}
+		}
+	}
+	return fmt.Errorf("couldn't send file to clamd: %w", err)
+}
+
+// readReply reads one NUL-terminated reply
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply reads "stream: OK", "stream: <threat> FOUND" or
+// "<message> ERROR"
+func parseReply(reply string) (Result, error) {
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		threat := strings.TrimSuffix(reply, " FOUND")
+		threat = strings.TrimPrefix(threat, "stream: ")
+		return Result{Infected: true, Threat: threat}, nil
+	case strings.HasSuffix(reply, ": OK"):
+		return Result{}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return Result{}, ErrTooLarge
+	default:
+		return Result{}, fmt.Errorf("clamd couldn't scan the file: %s", reply)
+	}
+}
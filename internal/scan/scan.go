@@ -0,0 +1,33 @@
+// Package scan checks uploaded files for malware before they're stored
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrTooLarge is returned when a file is bigger than the scanner accepts.
+// clamd's StreamMaxLength defaults to 25 MB, well below most videos.
+var ErrTooLarge = errors.New("file is too large for the scanner")
+
+// Result is what a scanner made of a file
+type Result struct {
+	Infected bool
+	// Threat names what was found in an infected file
+	Threat string
+}
+
+// Scanner checks a file's contents. An error means the file couldn't be
+// scanned, not that anything was found in it.
+type Scanner interface {
+	Scan(ctx context.Context, body io.Reader) (Result, error)
+	// Name identifies the scanner in stored results
+	Name() string
+}
+
+// Pinger is implemented by scanners that run as a separate service, for
+// readiness probes
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
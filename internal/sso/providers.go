@@ -0,0 +1,84 @@
+package sso
+
+import (
+	"context"
+	"strconv"
+)
+
+// Google signs in with Google's OpenID Connect endpoints
+func Google(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		Scopes:       []string{"openid", "email"},
+		identity:     googleIdentity("https://openidconnect.googleapis.com/v1/userinfo"),
+	}
+}
+
+func googleIdentity(userInfoURL string) func(context.Context, *Provider, string) (Identity, error) {
+	return func(ctx context.Context, p *Provider, accessToken string) (Identity, error) {
+		var info struct {
+			Sub           string `json:"sub"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}
+		if err := p.get(ctx, userInfoURL, accessToken, &info); err != nil {
+			return Identity{}, err
+		}
+		return Identity{
+			Subject:       info.Sub,
+			Email:         info.Email,
+			EmailVerified: info.EmailVerified,
+		}, nil
+	}
+}
+
+// GitHub signs in with a GitHub OAuth app. GitHub isn't an OpenID provider,
+// so the identity comes from its REST API.
+func GitHub(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		Scopes:       []string{"read:user", "user:email"},
+		identity:     githubIdentity("https://api.github.com"),
+	}
+}
+
+func githubIdentity(apiURL string) func(context.Context, *Provider, string) (Identity, error) {
+	return func(ctx context.Context, p *Provider, accessToken string) (Identity, error) {
+		var user struct {
+			ID int64 `json:"id"`
+		}
+		if err := p.get(ctx, apiURL+"/user", accessToken, &user); err != nil {
+			return Identity{}, err
+		}
+
+		// the profile email is whatever the user chose to show, so use the
+		// primary address from the emails list, which says if it's verified
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := p.get(ctx, apiURL+"/user/emails", accessToken, &emails); err != nil {
+			return Identity{}, err
+		}
+
+		identity := Identity{Subject: strconv.FormatInt(user.ID, 10)}
+		for _, email := range emails {
+			if email.Primary {
+				identity.Email = email.Email
+				identity.EmailVerified = email.Verified
+			}
+		}
+		return identity, nil
+	}
+}
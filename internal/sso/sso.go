@@ -0,0 +1,140 @@
+// Package sso runs the OAuth 2.0 authorization code flow against external
+// login providers and reports who signed in.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Identity is the account the provider vouched for
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	identity func(ctx context.Context, p *Provider, accessToken string) (Identity, error)
+}
+
+// NewState returns a random value for the state parameter or a PKCE verifier
+func NewState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL is where to send the browser to sign in
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	return p.AuthURL + "?" + v.Encode()
+}
+
+// Exchange trades the code from the callback for an access token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		return "", fmt.Errorf("couldn't exchange code: %w", err)
+	}
+	// GitHub reports a bad code with a 200 and an error field
+	if token.Error != "" {
+		return "", fmt.Errorf("couldn't exchange code: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("couldn't exchange code: no access token in response")
+	}
+	return token.AccessToken, nil
+}
+
+// Identity looks up who the access token belongs to
+func (p *Provider) Identity(ctx context.Context, accessToken string) (Identity, error) {
+	identity, err := p.identity(ctx, p, accessToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("couldn't get %s identity: %w", p.Name, err)
+	}
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("couldn't get %s identity: no subject", p.Name)
+	}
+	return identity, nil
+}
+
+func (p *Provider) get(ctx context.Context, url, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return p.doJSON(req, v)
+}
+
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
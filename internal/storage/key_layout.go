@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// KeyLayout is a prefix put before the key of every object a user stores,
+// so a bucket can be laid out by owner, like "users/{user_id}/", and IAM
+// policies and lifecycle rules scoped to one user's objects. The empty
+// layout keeps everyone's objects side by side.
+type KeyLayout string
+
+// keyLayoutOwner is replaced with the ID of the user the object belongs to
+const keyLayoutOwner = "{user_id}"
+
+func ParseKeyLayout(s string) (KeyLayout, error) {
+	if s == "" {
+		return "", nil
+	}
+	if !strings.HasSuffix(s, "/") || strings.HasPrefix(s, "/") || strings.Contains(s, "//") {
+		return "", fmt.Errorf("%q must end with a / and have no empty path segments", s)
+	}
+	literal := strings.ReplaceAll(s, keyLayoutOwner, "")
+	for _, r := range literal {
+		if !isKeyLayoutChar(r) {
+			return "", fmt.Errorf("%q can only use letters, digits, -, _, ., / and %s", s, keyLayoutOwner)
+		}
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(s, "/"), "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("%q can't have . or .. segments", s)
+		}
+	}
+	return KeyLayout(s), nil
+}
+
+func isKeyLayoutChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_./", r)
+}
+
+// Key is where an object owner stores at key goes in the bucket
+func (l KeyLayout) Key(owner uuid.UUID, key string) string {
+	return strings.ReplaceAll(string(l), keyLayoutOwner, owner.String()) + key
+}
+
+// Prefixes are where owner's objects under prefix can be: the layout's
+// place for them, and the bare prefix for objects stored before the layout
+// was set that haven't been re-keyed
+func (l KeyLayout) Prefixes(owner uuid.UUID, prefix string) []string {
+	if l == "" {
+		return []string{prefix}
+	}
+	return []string{l.Key(owner, prefix), prefix}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// PutOptions are how a store keeps the objects it's given, for stores that
+// have storage classes and tags. The zero value is the store's defaults.
+type PutOptions struct {
+	StorageClass string
+	Tags         map[string]string
+}
+
+type putOptionsKey struct{}
+
+// WithPutOptions returns a context whose puts use opts
+func WithPutOptions(ctx context.Context, opts PutOptions) context.Context {
+	return context.WithValue(ctx, putOptionsKey{}, opts)
+}
+
+func putOptionsFrom(ctx context.Context) PutOptions {
+	opts, _ := ctx.Value(putOptionsKey{}).(PutOptions)
+	return opts
+}
+
+// Tagger is implemented by stores that can tag objects, which lifecycle
+// rules can select them by
+type Tagger interface {
+	// SetTags replaces the tags of the object at key. The object's age,
+	// which lifecycle rules count from, starts over.
+	SetTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+const (
+	// LifecycleRulePrefix starts the IDs of the bucket lifecycle rules
+	// Tubely manages; rules named otherwise are left alone
+	LifecycleRulePrefix = "tubely-"
+	// KindTag says what a video file is, so rules can pick originals
+	KindTag = "tubely-kind"
+	// KindOriginal is KindTag on the stored video files themselves
+	KindOriginal = "original"
+	// TrashedTag marks the files of videos in the trash
+	TrashedTag = "tubely-trashed"
+)
+
+// LifecyclePolicy is what the bucket lifecycle rules Tubely manages do
+type LifecyclePolicy struct {
+	// ColdOriginalsAfter moves originals to ColdStorageClass once they've
+	// been stored this long, 0 never does
+	ColdOriginalsAfter time.Duration
+	ColdStorageClass   string
+	// ExpireTrash tags the files of trashed videos so the bucket deletes
+	// them a day after TrashRetention, in case the server doesn't
+	ExpireTrash    bool
+	TrashRetention time.Duration
+}
+
+// Rules are the rules the policy needs in every bucket
+func (p LifecyclePolicy) Rules() []LifecycleRule {
+	days := func(d time.Duration) int {
+		return int(math.Ceil(d.Hours() / 24))
+	}
+	rules := []LifecycleRule{}
+	if p.ExpireTrash && p.TrashRetention > 0 {
+		rules = append(rules, LifecycleRule{
+			ID:       LifecycleRulePrefix + "expire-trashed",
+			TagKey:   TrashedTag,
+			TagValue: "true",
+			// a day late, so a video can't lose its files while it can
+			// still be restored
+			ExpireAfterDays: days(p.TrashRetention) + 1,
+		})
+	}
+	if p.ColdOriginalsAfter > 0 {
+		rules = append(rules, LifecycleRule{
+			ID:                  LifecycleRulePrefix + "cold-originals",
+			TagKey:              KindTag,
+			TagValue:            KindOriginal,
+			TransitionAfterDays: days(p.ColdOriginalsAfter),
+			TransitionClass:     p.ColdStorageClass,
+		})
+	}
+	return rules
+}
+
+// LifecycleRule has the bucket expire or move the objects tagged TagKey =
+// TagValue once they're old enough. A zero number of days leaves that
+// action out.
+type LifecycleRule struct {
+	ID                  string `json:"id"`
+	TagKey              string `json:"tag_key"`
+	TagValue            string `json:"tag_value"`
+	ExpireAfterDays     int    `json:"expire_after_days,omitempty"`
+	TransitionAfterDays int    `json:"transition_after_days,omitempty"`
+	TransitionClass     string `json:"transition_class,omitempty"`
+}
+
+// LifecycleManager is implemented by stores that can age objects out on
+// their own, like S3 with bucket lifecycle configurations
+type LifecycleManager interface {
+	// SetLifecycleRules replaces the bucket's rules whose IDs start with
+	// prefix with rules, keeping any others
+	SetLifecycleRules(ctx context.Context, prefix string, rules []LifecycleRule) error
+}
+
+// tagging encodes tags the way S3 takes them on a put, as a query string
+func tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return aws.String(values.Encode())
+}
+
+// SetTags copies the object onto itself with the new tags, in the class
+// it's in, since only a copy restarts its age. Archived objects can't be
+// copied until they're restored.
+func (s *S3Store) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+	if info.Archived {
+		return errors.New("object is archived")
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(s.bucket + "/" + url.PathEscape(key)),
+		MetadataDirective: types.MetadataDirectiveCopy,
+		TaggingDirective:  types.TaggingDirectiveReplace,
+		Tagging:           tagging(tags),
+		StorageClass:      types.StorageClass(info.StorageClass),
+	}
+	// an empty tag set still has to replace the old one
+	if input.Tagging == nil {
+		input.Tagging = aws.String("")
+	}
+	_, err = s.client.CopyObject(ctx, input)
+	return err
+}
+
+func (s *S3Store) SetLifecycleRules(ctx context.Context, prefix string, rules []LifecycleRule) error {
+	out, err := s.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+	})
+	var apiErr smithy.APIError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration") {
+		return err
+	}
+
+	kept := []types.LifecycleRule{}
+	if out != nil {
+		for _, rule := range out.Rules {
+			if !strings.HasPrefix(aws.ToString(rule.ID), prefix) {
+				kept = append(kept, rule)
+			}
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	for _, rule := range rules {
+		r := types.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilter{
+				Tag: &types.Tag{Key: aws.String(rule.TagKey), Value: aws.String(rule.TagValue)},
+			},
+		}
+		if rule.ExpireAfterDays > 0 {
+			r.Expiration = &types.LifecycleExpiration{Days: aws.Int32(int32(rule.ExpireAfterDays))}
+		}
+		if rule.TransitionAfterDays > 0 {
+			r.Transitions = []types.Transition{{
+				Days:         aws.Int32(int32(rule.TransitionAfterDays)),
+				StorageClass: types.TransitionStorageClass(rule.TransitionClass),
+			}}
+		}
+		kept = append(kept, r)
+	}
+
+	// S3 refuses a configuration without rules
+	if len(kept) == 0 {
+		_, err := s.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(s.bucket),
+		})
+		return err
+	}
+	_, err = s.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s.bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: kept},
+	})
+	return err
+}
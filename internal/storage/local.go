@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStore keeps objects on disk under root. Objects are expected to be
+// served over HTTP from baseURL, so "presigned" GET URLs are plain links.
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStore(root, baseURL string) *LocalStore {
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return p, nil
+}
+
+// Check makes sure files can be written under root
+func (s *LocalStore) Check(ctx context.Context) error {
+	return CheckWritable(s.root)
+}
+
+// CheckWritable creates and removes a file in dir
+func CheckWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// written beside the object and renamed into place, so a put that's
+	// canceled or fails doesn't leave half a file behind
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// temp files are private, objects aren't
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, contextReader{ctx, body}); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// contextReader fails reads once ctx is done, so a local copy stops when
+// the request it's for is canceled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// PutWithChecksum hashes the body as it's written and removes the file if
+// it doesn't match sha256
+func (s *LocalStore) PutWithChecksum(ctx context.Context, key string, body io.Reader, contentType string, sum []byte) error {
+	hash := sha256.New()
+	if err := s.Put(ctx, key, io.TeeReader(body, hash), contentType); err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		p, _ := s.path(key)
+		os.Remove(p)
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStore) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Size:         info.Size(),
+		ContentType:  ContentTypeByExtension(filepath.Ext(p)),
+		ETag:         FileETag(info),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// localContentTypes are the types of files Tubely stores, which the
+// system's MIME database may not know about
+var localContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".vtt":  "text/vtt; charset=utf-8",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+}
+
+// ContentTypeByExtension is the type of a stored file with extension ext,
+// or "" if it's unknown
+func ContentTypeByExtension(ext string) string {
+	if contentType, ok := localContentTypes[strings.ToLower(ext)]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// FileETag is the ETag of a file on disk, from its modification time and
+// size, for anything serving the local store's files over HTTP
+func FileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *LocalStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *LocalStore) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
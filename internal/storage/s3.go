@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// streamPartSize is how much of a stream is buffered for each part of a
+// multipart upload; S3 wants at least 5 MB for all but the last
+const streamPartSize = 8 << 20
+
+type S3Store struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func NewS3Store(client *s3.Client, bucket string) *S3Store {
+	return &S3Store{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		bucket:  bucket,
+	}
+}
+
+// Check makes sure the bucket exists and the credentials can reach it
+func (s *S3Store) Check(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	return err
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	opts := putOptionsFrom(ctx)
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		Body:         body,
+		ContentType:  aws.String(contentType),
+		StorageClass: types.StorageClass(opts.StorageClass),
+		Tagging:      tagging(opts.Tags),
+	})
+	return err
+}
+
+// PutWithChecksum has S3 verify the body against sha256, failing with
+// ErrChecksumMismatch if it doesn't match
+func (s *S3Store) PutWithChecksum(ctx context.Context, key string, body io.Reader, contentType string, sha256 []byte) error {
+	opts := putOptionsFrom(ctx)
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		Body:              body,
+		ContentType:       aws.String(contentType),
+		StorageClass:      types.StorageClass(opts.StorageClass),
+		Tagging:           tagging(opts.Tags),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:    aws.String(base64.StdEncoding.EncodeToString(sha256)),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "BadDigest" || apiErr.ErrorCode() == "XAmzContentChecksumMismatch") {
+		return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	return err
+}
+
+// PutStream uploads body as a multipart upload, a part at a time, so it
+// never has to be held in memory or on disk in full
+func (s *S3Store) PutStream(ctx context.Context, key string, body io.Reader, contentType string) error {
+	opts := putOptionsFrom(ctx)
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ContentType:  aws.String(contentType),
+		StorageClass: types.StorageClass(opts.StorageClass),
+		Tagging:      tagging(opts.Tags),
+	})
+	if err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		// the parts already sent are billed until the upload is aborted,
+		// so abort it even if ctx is what failed
+		_, abortErr := s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		return errors.Join(err, abortErr)
+	}
+
+	var parts []types.CompletedPart
+	buf := make([]byte, streamPartSize)
+	for number := int32(1); ; number++ {
+		n, readErr := io.ReadFull(body, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return abort(readErr)
+		}
+		// an empty body still needs a part
+		if n > 0 || number == 1 {
+			out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(s.bucket),
+				Key:        aws.String(key),
+				UploadId:   created.UploadId,
+				PartNumber: aws.Int32(number),
+				Body:       bytes.NewReader(buf[:n]),
+			})
+			if err != nil {
+				return abort(err)
+			}
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(number)})
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	// the restore header is only there for archived objects:
+	// ongoing-request="true" while restoring, "false" once a copy is readable
+	restore := aws.ToString(out.Restore)
+	archived := out.StorageClass == types.StorageClassGlacier ||
+		out.StorageClass == types.StorageClassDeepArchive ||
+		out.ArchiveStatus != ""
+	return ObjectInfo{
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+		StorageClass: string(out.StorageClass),
+		Archived:     archived && !strings.Contains(restore, `ongoing-request="false"`),
+		Restoring:    strings.Contains(restore, `ongoing-request="true"`),
+	}, nil
+}
+
+func (s *S3Store) Restore(ctx context.Context, key string, days int) error {
+	request := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+	}
+	// archive tiers are restored in place and reject Days
+	if days > 0 {
+		request.Days = aws.Int32(int32(days))
+	}
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		RestoreRequest: request,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// SetStorageClass copies the object onto itself in the new class, which
+// is how S3 changes the class of an existing object
+func (s *S3Store) SetStorageClass(ctx context.Context, key, storageClass string) error {
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(s.bucket + "/" + url.PathEscape(key)),
+		StorageClass:      types.StorageClass(storageClass),
+		MetadataDirective: types.MetadataDirectiveCopy,
+	})
+	return err
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) PresignDownload(ctx context.Context, key, contentDisposition string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String(s.bucket),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(contentDisposition),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	req, err := s.presign.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
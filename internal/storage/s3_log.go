@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// LogS3Calls is an AWS API option that logs every S3 call at debug level
+// with its bucket, key, duration and error, using the caller's context so
+// the call can be tied to the request or upload that made it. Add it to
+// aws.Config.APIOptions.
+func LogS3Calls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyLogCalls",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+
+			attrs := []any{"operation", awsmiddleware.GetOperationName(ctx)}
+			if bucket := inputField(in.Parameters, "Bucket"); bucket != "" {
+				attrs = append(attrs, "bucket", bucket)
+			}
+			if key := inputField(in.Parameters, "Key"); key != "" {
+				attrs = append(attrs, "key", key)
+			}
+			attrs = append(attrs, "duration", time.Since(start))
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+			slog.DebugContext(ctx, "s3 call", attrs...)
+			return out, metadata, err
+		}), middleware.After)
+}
+
+// inputField reads a *string field of an S3 input such as
+// *s3.GetObjectInput; the inputs share no interface for it
+func inputField(params any, name string) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	field := v.Elem().FieldByName(name)
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*string)(nil)) || field.IsNil() {
+		return ""
+	}
+	return *field.Interface().(*string)
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ObserveS3Calls returns an AWS API option that calls observe after every
+// S3 call with its operation name, such as "PutObject", how long it took,
+// retries included, and its error
+func ObserveS3Calls(observe func(operation string, duration time.Duration, err error)) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyObserveCalls",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				observe(awsmiddleware.GetOperationName(ctx), time.Since(start), err)
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// permissionCheckPrefix is where the probe object is written; it's deleted
+// straight after, if the credentials allow it
+const permissionCheckPrefix = ".tubely-permission-check/"
+
+// PermissionCheck is the outcome of trying one S3 action
+type PermissionCheck struct {
+	Action string
+	// Allowed is only meaningful when Err is nil
+	Allowed bool
+	// Err is set when the call failed for some other reason than access
+	// being denied, so it's unknown whether the action is allowed
+	Err error
+}
+
+// CheckPermissions tries each S3 action the server needs against the
+// bucket, with a small probe object, and reports which are denied. extra
+// are read-only calls the server never makes; if they're allowed, the
+// credentials were likely given more than they need, such as s3:*.
+// s3:RestoreObject can't be tried without an archived object and isn't
+// checked.
+func (s *S3Store) CheckPermissions(ctx context.Context) (needed, extra []PermissionCheck) {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := permissionCheckPrefix + hex.EncodeToString(suffix)
+
+	try := func(action string, call func() error) PermissionCheck {
+		err := call()
+		if isAccessDenied(err) {
+			return PermissionCheck{Action: action}
+		}
+		return PermissionCheck{Action: action, Allowed: err == nil, Err: err}
+	}
+
+	needed = append(needed, try("s3:PutObject", func() error {
+		return s.Put(ctx, key, strings.NewReader("tubely"), "text/plain")
+	}))
+	needed = append(needed, try("s3:GetObject", func() error {
+		body, err := s.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// the key was looked up, which is as far as the check can go
+			// when the probe couldn't be written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return body.Close()
+	}))
+	needed = append(needed, try("s3:ListBucket", func() error {
+		_, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(s.bucket),
+			Prefix:  aws.String(permissionCheckPrefix),
+			MaxKeys: aws.Int32(1),
+		})
+		return err
+	}))
+	needed = append(needed, try("s3:AbortMultipartUpload", func() error {
+		created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key + ".multipart"),
+		})
+		if err != nil {
+			// creating one is covered by s3:PutObject
+			if isAccessDenied(err) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key + ".multipart"),
+			UploadId: created.UploadId,
+		})
+		return err
+	}))
+	needed = append(needed, try("s3:DeleteObject", func() error {
+		return s.Delete(ctx, key)
+	}))
+
+	extra = append(extra, try("s3:ListAllMyBuckets", func() error {
+		_, err := s.client.ListBuckets(ctx, &s3.ListBucketsInput{})
+		return err
+	}))
+	extra = append(extra, try("s3:GetBucketPolicy", func() error {
+		_, err := s.client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(s.bucket)})
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucketPolicy" {
+			return nil
+		}
+		return err
+	}))
+	return needed, extra
+}
+
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "Forbidden", "AllAccessDisabled":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ErrCircuitOpen is returned for writes to a bucket while its circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("object store is failing, writes are paused")
+
+// RetryPolicy is how an S3 client retries calls that fail with a transient
+// error, like a throttle, a 5xx or a dropped connection, and when it stops
+// trying writes altogether
+type RetryPolicy struct {
+	// MaxAttempts counts the first try; the delay before each retry is
+	// random, up to a cap that doubles each time and stops at MaxBackoff
+	MaxAttempts int
+	MaxBackoff  time.Duration
+	// BreakerFailures writes in a row that fail even after their retries
+	// open the breaker, 0 turns it off. It stays open for BreakerCooldown,
+	// then lets writes through again, reopening on the next failure until
+	// one works.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// breakerOperations are the writes the breaker guards. Reads go through
+// regardless, so videos already stored keep playing.
+var breakerOperations = map[string]bool{
+	"PutObject":               true,
+	"CreateMultipartUpload":   true,
+	"UploadPart":              true,
+	"CompleteMultipartUpload": true,
+	"CopyObject":              true,
+	"DeleteObject":            true,
+}
+
+// WithRetries is an option for s3.New and s3.NewFromConfig that applies the
+// policy to the client, with a breaker of its own. onBreak, if set, is
+// called when the breaker opens or closes.
+func WithRetries(policy RetryPolicy, onBreak func(open bool)) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+			so.MaxAttempts = policy.MaxAttempts
+			so.MaxBackoff = policy.MaxBackoff
+			so.Backoff = retry.NewExponentialJitterBackoff(policy.MaxBackoff)
+		})
+		if policy.BreakerFailures > 0 {
+			breaker := &circuitBreaker{failures: policy.BreakerFailures, cooldown: policy.BreakerCooldown, onBreak: onBreak}
+			o.APIOptions = append(o.APIOptions, breaker.middleware)
+		}
+	}
+}
+
+// ObserveS3Retries returns an AWS API option that calls observe after every
+// S3 call that needed more than one attempt, with how many retries it took
+func ObserveS3Retries(observe func(operation string, retries int)) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyObserveRetries",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				if results, ok := retry.GetAttemptResults(metadata); ok && len(results.Results) > 1 {
+					observe(awsmiddleware.GetOperationName(ctx), len(results.Results)-1)
+				}
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
+
+type circuitBreaker struct {
+	failures int
+	cooldown time.Duration
+	onBreak  func(open bool)
+
+	mu sync.Mutex
+	// failed counts writes in a row that failed; once it reaches failures
+	// the breaker is open until openUntil
+	failed    int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyCircuitBreaker",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := awsmiddleware.GetOperationName(ctx)
+			if !breakerOperations[operation] {
+				return next.HandleInitialize(ctx, in)
+			}
+			if until, open := b.open(time.Now()); open {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("%s: %w until %s", operation, ErrCircuitOpen, until.UTC().Format(time.RFC3339))
+			}
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			b.record(ctx, err)
+			return out, metadata, err
+		}), middleware.After)
+}
+
+func (b *circuitBreaker) open(now time.Time) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil, now.Before(b.openUntil)
+}
+
+// record counts a write's result. Only transient errors count against the
+// store; a missing key or a caller giving up says nothing about its health.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if err != nil && (ctx.Err() != nil || retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) != aws.TrueTernary) {
+		return
+	}
+
+	b.mu.Lock()
+	wasOpen := b.failed >= b.failures
+	if err == nil {
+		b.failed = 0
+	} else {
+		b.failed++
+		if b.failed >= b.failures {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	}
+	isOpen := b.failed >= b.failures
+	b.mu.Unlock()
+
+	if b.onBreak != nil && wasOpen != isOpen {
+		b.onBreak(isOpen)
+	}
+}
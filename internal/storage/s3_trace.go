@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+// TraceS3Calls is an AWS API option that records a client span for every
+// S3 call, as a child of the span in the caller's context, with the
+// attributes OpenTelemetry's AWS SDK instrumentation uses
+func TraceS3Calls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TubelyTraceCalls",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := awsmiddleware.GetOperationName(ctx)
+			attrs := []tracing.Attr{
+				tracing.String("rpc.system", "aws-api"),
+				tracing.String("rpc.service", "S3"),
+				tracing.String("rpc.method", operation),
+			}
+			if bucket := inputField(in.Parameters, "Bucket"); bucket != "" {
+				attrs = append(attrs, tracing.String("aws.s3.bucket", bucket))
+			}
+			if key := inputField(in.Parameters, "Key"); key != "" {
+				attrs = append(attrs, tracing.String("aws.s3.key", key))
+			}
+			ctx, span := tracing.Start(ctx, "S3."+operation, tracing.KindClient, attrs...)
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			span.End(err)
+			return out, metadata, err
+		}), middleware.After)
+}
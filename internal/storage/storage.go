@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound            = errors.New("object not found")
+	ErrPresignNotSupported = errors.New("presigning is not supported by this store")
+	ErrChecksumMismatch    = errors.New("object doesn't match its checksum")
+)
+
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	StorageClass string
+	// Archived objects can't be read until they're restored. Restoring is
+	// set while a restore is running.
+	Archived  bool
+	Restoring bool
+}
+
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error)
+}
+
+// DownloadPresigner is implemented by stores that can presign a GET whose
+// response carries a Content-Disposition, so browsers save the object under
+// a name of our choosing rather than its key
+type DownloadPresigner interface {
+	PresignDownload(ctx context.Context, key, contentDisposition string, expires time.Duration) (string, error)
+}
+
+// StreamPutter is implemented by stores that can't Put a body of unknown
+// length as is, like S3, which has to sign a body it can't rewind
+type StreamPutter interface {
+	PutStream(ctx context.Context, key string, body io.Reader, contentType string) error
+}
+
+// ChecksumPutter is implemented by stores that can check a body against its
+// SHA-256 as it's stored, refusing it if it was corrupted on the way
+type ChecksumPutter interface {
+	PutWithChecksum(ctx context.Context, key string, body io.Reader, contentType string, sha256 []byte) error
+}
+
+// Checker is implemented by stores that can tell whether they're usable,
+// for readiness probes
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Restorer is implemented by stores that archive objects, like S3 with its
+// Glacier storage classes
+type Restorer interface {
+	// Restore starts bringing an archived object back. Regular restores
+	// make a copy readable for days; days is 0 for archives that move the
+	// object back to a readable tier instead, like Intelligent-Tiering's.
+	// Starting a restore that's already running isn't an error.
+	Restore(ctx context.Context, key string, days int) error
+}
+
+// StorageClassSetter is implemented by stores with storage classes, for
+// moving objects that are rarely read to cheaper tiers and back
+type StorageClassSetter interface {
+	// SetStorageClass rewrites the object in the given class, keeping its
+	// contents and metadata
+	SetStorageClass(ctx context.Context, key, storageClass string) error
+}
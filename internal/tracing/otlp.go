@@ -0,0 +1,280 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spans are sent in batches of up to exportBatch, at least every
+	// exportInterval
+	exportBatch    = 512
+	exportInterval = 5 * time.Second
+	// spans beyond this many waiting are dropped rather than holding up
+	// the server when the collector is down
+	exportQueue   = 8192
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter batches ended spans and posts them as OTLP/HTTP JSON
+type Exporter struct {
+	endpoint   string
+	headers    map[string]string
+	service    string
+	httpClient *http.Client
+
+	queue chan *Span
+	flush chan chan struct{}
+}
+
+// ExporterConfig is read from the standard OpenTelemetry variables
+type ExporterConfig struct {
+	// Endpoint is the full URL spans are posted to, usually ending in
+	// /v1/traces
+	Endpoint string
+	// Headers are sent with every export, for collector authentication
+	Headers map[string]string
+	// Service is the service.name resource attribute
+	Service string
+}
+
+// ConfigFromEnv reads OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_ENDPOINT with /v1/traces added, along with
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_SERVICE_NAME. ok is false when no
+// endpoint is set, so tracing stays off.
+func ConfigFromEnv(getenv func(string) string) (cfg ExporterConfig, ok bool, err error) {
+	cfg.Endpoint = getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if cfg.Endpoint == "" {
+		if base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			cfg.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if cfg.Endpoint == "" {
+		return ExporterConfig{}, false, nil
+	}
+
+	cfg.Headers = map[string]string{}
+	if headers := getenv("OTEL_EXPORTER_OTLP_HEADERS"); headers != "" {
+		for _, pair := range strings.Split(headers, ",") {
+			key, value, found := strings.Cut(pair, "=")
+			if !found || strings.TrimSpace(key) == "" {
+				return ExporterConfig{}, false, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS must be key=value pairs, got %q", pair)
+			}
+			cfg.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	cfg.Service = getenv("OTEL_SERVICE_NAME")
+	if cfg.Service == "" {
+		cfg.Service = "tubely"
+	}
+	return cfg, true, nil
+}
+
+func NewExporter(cfg ExporterConfig) *Exporter {
+	e := &Exporter{
+		endpoint:   cfg.Endpoint,
+		headers:    cfg.Headers,
+		service:    cfg.Service,
+		httpClient: &http.Client{Timeout: exportTimeout},
+		queue:      make(chan *Span, exportQueue),
+		flush:      make(chan chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *Exporter) enqueue(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		// the collector isn't keeping up; losing spans beats blocking
+	}
+}
+
+// Flush sends every span ended so far and waits for it, or for ctx
+func (e *Exporter) Flush(ctx context.Context) {
+	sent := make(chan struct{})
+	select {
+	case e.flush <- sent:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-sent:
+	case <-ctx.Done():
+	}
+}
+
+func (e *Exporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= exportBatch {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = nil
+			}
+		case sent := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = nil
+			}
+			close(sent)
+		}
+	}
+}
+
+func (e *Exporter) send(batch []*Span) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		slog.Warn("Couldn't encode spans", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Couldn't export spans", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		slog.Warn("Couldn't export spans", "spans", len(batch), "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		slog.Warn("Collector rejected spans", "status", resp.Status, "spans", len(batch), "body", string(bytes.TrimSpace(msg)))
+	}
+}
+
+// The types below are the OTLP JSON encoding of an ExportTraceServiceRequest.
+// IDs are hex and 64 bit integers are strings, as the JSON mapping wants.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	// 0 unset, 2 error
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func (e *Exporter) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttrs(s.attrs),
+		}
+		if s.parent != (SpanID{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			span.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttrs([]Attr{String("service.name", e.service)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "tubely"}, Spans: spans}},
+	}}}
+}
+
+func encodeAttrs(attrs []Attr) []otlpAttr {
+	out := make([]otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		case bool:
+			v.BoolValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		out = append(out, otlpAttr{Key: a.Key, Value: v})
+	}
+	return out
+}
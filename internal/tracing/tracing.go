@@ -0,0 +1,173 @@
+// Package tracing records spans and sends them to an OpenTelemetry
+// collector over OTLP/HTTP. Spans nest through contexts, and a W3C
+// traceparent header on an incoming request continues the caller's trace.
+// Until SetDefault is called with an exporter every span is a no-op.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Kind says what side of a call a span is on, numbered as in OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+// SpanContext identifies a span, possibly one in another process
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Attr is a key and a string, integer, float or bool value
+type Attr struct {
+	Key   string
+	Value any
+}
+
+type Span struct {
+	exporter *Exporter
+	context  SpanContext
+	parent   SpanID
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    []Attr
+	err      error
+	ended    atomic.Bool
+}
+
+var defaultExporter atomic.Pointer[Exporter]
+
+// SetDefault makes spans go to exporter; nil turns tracing off
+func SetDefault(exporter *Exporter) {
+	defaultExporter.Store(exporter)
+}
+
+// Flush sends the spans the default exporter is holding, if there is one
+func Flush(ctx context.Context) {
+	if exporter := defaultExporter.Load(); exporter != nil {
+		exporter.Flush(ctx)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start begins a span that's a child of the one in ctx, or of a remote
+// parent set with ContextWithRemoteParent, and returns a context carrying
+// it. The span does nothing when tracing is off, but must still be ended.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	exporter := defaultExporter.Load()
+	if exporter == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		exporter: exporter,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+		attrs:    attrs,
+	}
+	parent := SpanContextFrom(ctx)
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+	}
+	rand.Read(span.context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanContextFrom returns the span in ctx, or the remote parent if there's
+// no local span
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok && span != nil {
+		return span.context
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteParent makes spans started from the returned context
+// children of sc. It's how a trace carries on from a traceparent header or
+// into work that outlives the request that started it.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SetName renames the span, for when the best name is only known once the
+// work is done, like a server span's matched route
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s != nil {
+		s.attrs = append(s.attrs, attrs...)
+	}
+}
+
+// End records the span as failed if err isn't nil and queues it for
+// export. Only the first call counts.
+func (s *Span) End(err error) {
+	if s == nil || s.ended.Swap(true) {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.exporter.enqueue(s)
+}
+
+// Traceparent formats sc as a W3C traceparent header, sampled
+func Traceparent(sc SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceparent reads a W3C traceparent header, returning an invalid
+// SpanContext if it's missing or malformed
+func ParseTraceparent(header string) SpanContext {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+	return sc
+}
+
+func String(key, value string) Attr    { return Attr{key, value} }
+func Int64(key string, v int64) Attr   { return Attr{key, v} }
+func Int(key string, v int) Attr       { return Attr{key, int64(v)} }
+func Bool(key string, v bool) Attr     { return Attr{key, v} }
+func Float(key string, v float64) Attr { return Attr{key, v} }
@@ -0,0 +1,254 @@
+// Package webpush sends encrypted Web Push messages (RFC 8291) to browser
+// push services, identifying the server with VAPID (RFC 8292).
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// MaxPayload is the most a single message can carry; push services accept
+// 4096 byte bodies and the encryption header and tag take the rest
+const MaxPayload = 3993
+
+// recordSize is the aes128gcm record size; a message is always one record
+const recordSize = 4096
+
+// ErrGone is returned when the push service says the subscription no longer
+// exists, so it should be forgotten
+var ErrGone = errors.New("push subscription is gone")
+
+// Subscription is what the browser's PushManager hands out, with the keys
+// base64url encoded as in PushSubscription.toJSON()
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// Validate checks the endpoint and keys look usable, so a bad subscription
+// is turned away when it's saved rather than on every send
+func (s Subscription) Validate() error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("push endpoint must be an https URL")
+	}
+	_, _, err = s.keys()
+	return err
+}
+
+// keys decodes the browser's public key and auth secret; browsers send them
+// in base64url, padded or not
+func (s Subscription) keys() (*ecdh.PublicKey, []byte, error) {
+	publicKey, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s.P256dh, "="))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s.Auth, "="))
+	if err != nil || len(authSecret) != 16 {
+		return nil, nil, errors.New("invalid auth secret")
+	}
+	return uaPublic, authSecret, nil
+}
+
+type Client struct {
+	key *ecdsa.PrivateKey
+	// subject is the mailto: or https: contact push services can use to
+	// reach whoever runs the server
+	subject    string
+	httpClient *http.Client
+}
+
+func NewClient(key *ecdsa.PrivateKey, subject string) *Client {
+	return &Client{
+		key:        key,
+		subject:    subject,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded P-256 key in SEC 1 or PKCS#8 form, as
+// made by "openssl ecparam -name prime256v1 -genkey -noout"
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		parsed, perr := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if perr != nil {
+			return nil, fmt.Errorf("couldn't parse private key: %w", err)
+		}
+		var ok bool
+		if key, ok = parsed.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.New("private key is not ECDSA")
+		}
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("private key is not on the P-256 curve")
+	}
+	return key, nil
+}
+
+// PublicKey is the application server key browsers need to subscribe,
+// base64url encoded
+func (c *Client) PublicKey() string {
+	key, err := c.key.PublicKey.ECDH()
+	if err != nil {
+		// LoadPrivateKey only accepts P-256 keys, which always convert
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// Send encrypts payload for sub and posts it to the push service. ttl is how
+// long the service should keep trying to deliver it.
+func (c *Client) Send(ctx context.Context, sub Subscription, payload []byte, ttl time.Duration) error {
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("payload is %d bytes, at most %d fit in a message", len(payload), MaxPayload)
+	}
+	if err := sub.Validate(); err != nil {
+		return err
+	}
+	endpoint, _ := url.Parse(sub.Endpoint)
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	token, err := c.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, c.PublicKey()))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push service answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// vapidToken signs the JWT that tells the push service at audience who is
+// sending
+func (c *Client) vapidToken(audience string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": c.subject,
+	})
+	return token.SignedString(c.key)
+}
+
+// encrypt builds an aes128gcm body (RFC 8188) keyed for the subscription as
+// described in RFC 8291
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	uaPublic, authSecret, err := sub.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// a fresh key pair and salt for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic.Bytes()...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdfExpand(authSecret, ecdhSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdfExpand(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfExpand(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	// 0x02 marks the last (and only) record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+func hkdfExpand(salt, secret, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/google/uuid"
+)
+
+type jobStatus string
+
+const (
+	jobStatusQueued    jobStatus = "queued"
+	jobStatusRunning   jobStatus = "running"
+	jobStatusCompleted jobStatus = "completed"
+	jobStatusFailed    jobStatus = "failed"
+	jobStatusCanceled  jobStatus = "canceled"
+	jobStatusSkipped   jobStatus = "skipped"
+)
+
+type uploadStage string
+
+const (
+	stageReceiving  uploadStage = "receiving"
+	stageScanning   uploadStage = "scanning"
+	stageProbing    uploadStage = "probing"
+	stageScreening  uploadStage = "screening"
+	stageAnalyzing  uploadStage = "analyzing"
+	stageProcessing uploadStage = "processing"
+	stageRenditions uploadStage = "renditions"
+	stagePreview    uploadStage = "preview"
+	stageSprites    uploadStage = "sprites"
+	stageModeration uploadStage = "moderation"
+	stageUploading  uploadStage = "uploading"
+)
+
+var videoPipelineStages = []uploadStage{
+	stageReceiving,
+	stageScanning,
+	stageProbing,
+	stageScreening,
+	stageAnalyzing,
+	stageProcessing,
+	stageRenditions,
+	stagePreview,
+	stageSprites,
+	stageModeration,
+	stageUploading,
+}
+
+// how long finished jobs stay queryable, and how many finished jobs feed the
+// throughput estimate
+const (
+	finishedJobTTL    = time.Hour
+	throughputSamples = 20
+	// how many lines of tool output a job keeps for the admin log stream
+	maxJobLogLines = 1000
+)
+
+type jobStageState struct {
+	Name     uploadStage `json:"name"`
+	Status   jobStatus   `json:"status"`
+	Progress int         `json:"progress"`
+}
+
+type uploadJob struct {
+	ID             uuid.UUID
+	VideoID        uuid.UUID
+	OrganizationID uuid.NullUUID
+	// StorageRegion is the region the video's files are stored in
+	StorageRegion string
+	UserID        uuid.UUID
+	Size          int64
+	// SHA256 is the hex checksum of the upload, set while it's received
+	SHA256 string
+	// Filename is the name the upload was sent with, for downloads
+	Filename string
+	// RequestID is the request that started the job, so its logs can be
+	// tied back to it
+	RequestID string
+	// Trace is the span of that request; processing in the background
+	// carries on its trace
+	Trace     tracing.SpanContext
+	CreatedAt time.Time
+
+	mu         sync.Mutex
+	status     jobStatus
+	stages     []jobStageState
+	errMsg     string
+	warnings   []string
+	startedAt  time.Time
+	finishedAt time.Time
+	// set while the job waits out an upload blackout window
+	deferredUntil time.Time
+	// how far the received bytes were off from what the client declared
+	lengthMismatch *uploadLengthMismatch
+	costEstimate   *costEstimate
+	cancel         context.CancelFunc
+	// the latest lines ffmpeg wrote to stderr, after logDropped older ones
+	logLines   []string
+	logDropped int
+	// closed and replaced whenever the job changes
+	changed chan struct{}
+}
+
+type uploadJobSnapshot struct {
+	ID         uuid.UUID       `json:"id"`
+	VideoID    uuid.UUID       `json:"video_id"`
+	Status     jobStatus       `json:"status"`
+	Percent    int             `json:"percent"`
+	Stages     []jobStageState `json:"stages"`
+	Error      string          `json:"error,omitempty"`
+	Warnings   []string        `json:"warnings,omitempty"`
+	Size       int64           `json:"size"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+	// RequestID is the X-Request-ID of the upload, to look up in the logs
+	RequestID string `json:"request_id,omitempty"`
+	// DeferredUntil is when a job held back by a blackout window will start
+	DeferredUntil *time.Time `json:"deferred_until,omitempty"`
+	// LengthMismatch is set when the body or video part didn't match its
+	// declared size, usually because the client's connection was cut
+	LengthMismatch *uploadLengthMismatch `json:"length_mismatch,omitempty"`
+	// CostEstimate is what the video will cost to keep and serve, refined
+	// as processing learns more about it
+	CostEstimate *costEstimate `json:"cost_estimate,omitempty"`
+}
+
+// newUploadJob creates a job whose first stage, receiving, is already running
+func newUploadJob(id uuid.UUID, video database.Video, userID uuid.UUID, size int64) *uploadJob {
+	stages := make([]jobStageState, len(videoPipelineStages))
+	for i, name := range videoPipelineStages {
+		stages[i] = jobStageState{Name: name, Status: jobStatusQueued}
+	}
+	stages[0].Status = jobStatusRunning
+
+	return &uploadJob{
+		ID:             id,
+		VideoID:        video.ID,
+		OrganizationID: video.OrganizationID,
+		StorageRegion:  video.StorageRegion,
+		UserID:         userID,
+		Size:           size,
+		CreatedAt:      time.Now().UTC(),
+		status:         jobStatusQueued,
+		stages:         stages,
+		cancel:         func() {},
+		changed:        make(chan struct{}),
+	}
+}
+
+// notify wakes everything waiting on the job; callers must hold j.mu
+func (j *uploadJob) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// watch returns a channel that's closed the next time the job changes
+func (j *uploadJob) watch() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.changed
+}
+
+func (j *uploadJob) setCancel(cancel context.CancelFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cancel = cancel
+}
+
+func (j *uploadJob) requestCancel() {
+	j.mu.Lock()
+	cancel := j.cancel
+	j.mu.Unlock()
+	cancel()
+}
+
+func (j *uploadJob) setDeferredUntil(until time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.deferredUntil = until
+	j.notify()
+}
+
+func (j *uploadJob) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.deferredUntil = time.Time{}
+	j.status = jobStatusRunning
+	j.startedAt = time.Now().UTC()
+	j.notify()
+}
+
+// received records the size of the stored upload and completes the
+// receiving stage
+func (j *uploadJob) received(size int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Size = size
+	j.stages[0].Status = jobStatusCompleted
+	j.stages[0].Progress = 100
+	j.notify()
+}
+
+func (j *uploadJob) setCostEstimate(estimate costEstimate) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.costEstimate = &estimate
+	j.notify()
+}
+
+func (j *uploadJob) setStage(name uploadStage, status jobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range j.stages {
+		if j.stages[i].Name == name {
+			j.stages[i].Status = status
+			if status == jobStatusCompleted {
+				j.stages[i].Progress = 100
+			}
+		}
+	}
+	j.notify()
+}
+
+// setProgress records how far into a stage the job is. Watchers are only
+// woken when the whole percentage changes so a fast copy doesn't flood them.
+func (j *uploadJob) setProgress(name uploadStage, done, total int64) {
+	if total <= 0 {
+		return
+	}
+	percent := int(min(done*100/total, 100))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range j.stages {
+		if j.stages[i].Name == name && j.stages[i].Progress != percent {
+			j.stages[i].Progress = percent
+			j.notify()
+		}
+	}
+}
+
+func (j *uploadJob) setLengthMismatch(m uploadLengthMismatch) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lengthMismatch = &m
+	j.notify()
+}
+
+func (j *uploadJob) addWarning(warning string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.warnings = append(j.warnings, warning)
+	j.notify()
+}
+
+// finish marks the job as done and returns how long it spent running
+func (j *uploadJob) finish(status jobStatus, err error) time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	if err != nil {
+		j.errMsg = err.Error()
+	}
+	j.finishedAt = time.Now().UTC()
+	for i := range j.stages {
+		if j.stages[i].Status == jobStatusQueued || j.stages[i].Status == jobStatusRunning {
+			j.stages[i].Status = status
+		}
+	}
+	j.notify()
+	if j.startedAt.IsZero() {
+		return 0
+	}
+	return j.finishedAt.Sub(j.startedAt)
+}
+
+func (j *uploadJob) appendLog(line string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.logLines = append(j.logLines, line)
+	if len(j.logLines) > maxJobLogLines {
+		j.logLines = j.logLines[1:]
+		j.logDropped++
+	}
+	j.notify()
+}
+
+// logSince returns the log lines from number next on and the number of the
+// first one returned, which is higher than next if lines were dropped
+func (j *uploadJob) logSince(next int) ([]string, int, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	first := max(next, j.logDropped)
+	var lines []string
+	if i := first - j.logDropped; i < len(j.logLines) {
+		lines = append(lines, j.logLines[i:]...)
+	}
+	return lines, first, !j.finishedAt.IsZero()
+}
+
+func (j *uploadJob) expired() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finishedAt.IsZero() && time.Since(j.finishedAt) > finishedJobTTL
+}
+
+func (j *uploadJob) snapshot() uploadJobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := uploadJobSnapshot{
+		ID:        j.ID,
+		VideoID:   j.VideoID,
+		Status:    j.status,
+		Stages:    append([]jobStageState(nil), j.stages...),
+		Error:     j.errMsg,
+		Warnings:  append([]string(nil), j.warnings...),
+		Size:      j.Size,
+		RequestID: j.RequestID,
+		CreatedAt: j.CreatedAt,
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		s.FinishedAt = &finishedAt
+	}
+	if !j.deferredUntil.IsZero() {
+		deferredUntil := j.deferredUntil
+		s.DeferredUntil = &deferredUntil
+	}
+	if j.lengthMismatch != nil {
+		lengthMismatch := *j.lengthMismatch
+		s.LengthMismatch = &lengthMismatch
+	}
+	if j.costEstimate != nil {
+		estimate := *j.costEstimate
+		s.CostEstimate = &estimate
+	}
+
+	// skipped stages don't count towards the overall percentage
+	var total, counted int
+	for _, stage := range j.stages {
+		if stage.Status != jobStatusSkipped {
+			total += stage.Progress
+			counted++
+		}
+	}
+	if counted > 0 {
+		s.Percent = total / counted
+	}
+	return s
+}
+
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*uploadJob
+	// set once the server is shutting down and takes no new uploads
+	closed bool
+	// bytes per second of recently completed jobs, oldest first
+	throughput []float64
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{
+		jobs: map[uuid.UUID]*uploadJob{},
+	}
+}
+
+// add registers job, returning false if its ID is already in use
+func (t *jobTracker) add(job *uploadJob) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, j := range t.jobs {
+		if j.expired() {
+			delete(t.jobs, id)
+		}
+	}
+	if _, ok := t.jobs[job.ID]; ok {
+		return false
+	}
+	t.jobs[job.ID] = job
+	return true
+}
+
+// close stops new uploads from being accepted
+func (t *jobTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+}
+
+func (t *jobTracker) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// cancelUnfinished cancels every job that's still queued or running and
+// returns how many there were
+func (t *jobTracker) cancelUnfinished() int {
+	t.mu.Lock()
+	jobs := make([]*uploadJob, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, job)
+	}
+	t.mu.Unlock()
+
+	canceled := 0
+	for _, job := range jobs {
+		if job.snapshot().FinishedAt == nil {
+			job.requestCancel()
+			canceled++
+		}
+	}
+	return canceled
+}
+
+// waitIdle waits until no job is queued or running, or for ctx
+func (t *jobTracker) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		unfinished := 0
+		for _, n := range t.active() {
+			unfinished += n
+		}
+		if unfinished == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *jobTracker) get(id uuid.UUID) (*uploadJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	return job, ok
+}
+
+// hasUnfinished reports whether a job for the video is still queued or
+// running
+func (t *jobTracker) hasUnfinished(videoID uuid.UUID) bool {
+	t.mu.Lock()
+	jobs := make([]*uploadJob, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		if job.VideoID == videoID {
+			jobs = append(jobs, job)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, job := range jobs {
+		job.mu.Lock()
+		unfinished := job.finishedAt.IsZero()
+		job.mu.Unlock()
+		if unfinished {
+			return true
+		}
+	}
+	return false
+}
+
+// active counts the jobs that haven't finished by status
+func (t *jobTracker) active() map[jobStatus]int {
+	t.mu.Lock()
+	jobs := make([]*uploadJob, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, job)
+	}
+	t.mu.Unlock()
+
+	counts := map[jobStatus]int{}
+	for _, job := range jobs {
+		job.mu.Lock()
+		if job.finishedAt.IsZero() {
+			counts[job.status]++
+		}
+		job.mu.Unlock()
+	}
+	return counts
+}
+
+func (t *jobTracker) recordThroughput(size int64, elapsed time.Duration) {
+	if size <= 0 || elapsed <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.throughput = append(t.throughput, float64(size)/elapsed.Seconds())
+	if len(t.throughput) > throughputSamples {
+		t.throughput = t.throughput[len(t.throughput)-throughputSamples:]
+	}
+}
+
+// estimate returns how long processing size bytes should take based on the
+// recent throughput, or false if nothing has been processed yet
+func (t *jobTracker) estimate(size int64) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.throughput) == 0 {
+		return 0, false
+	}
+	var total float64
+	for _, bps := range t.throughput {
+		total += bps
+	}
+	avg := total / float64(len(t.throughput))
+	return time.Duration(float64(size) / avg * float64(time.Second)), true
+}
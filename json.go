@@ -2,16 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"log"
+	"log/slog"
 	"net/http"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
+	// the middleware has already put the request's ID on the response
+	requestID := w.Header().Get("X-Request-ID")
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		slog.Error("Responding with 5XX error", "msg", msg, "error", err, "request_id", requestID)
+	} else if err != nil {
+		slog.Info(msg, "status", code, "error", err, "request_id", requestID)
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
@@ -32,3 +35,49 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+type pagination struct {
+	// NextCursor is passed back to get the next page, and is null on the last
+	NextCursor *string `json:"next_cursor"`
+	// Total counts the items across all pages, when that's known
+	Total *int64 `json:"total"`
+}
+
+type listEnvelope struct {
+	Data       any        `json:"data"`
+	Pagination pagination `json:"pagination"`
+	RequestID  string     `json:"request_id"`
+}
+
+// respondWithList sends a list in the standard envelope, or as a bare array
+// when envelopes are turned off for older clients
+func (cfg *apiConfig) respondWithList(w http.ResponseWriter, r *http.Request, items any, page pagination) {
+	if !cfg.listEnvelope {
+		respondWithJSON(w, http.StatusOK, items)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, listEnvelope{
+		Data:       items,
+		Pagination: page,
+		RequestID:  requestIDFrom(r.Context()),
+	})
+}
+
+// completeList is the pagination of a list that fits in one response
+func completeList(n int) pagination {
+	total := int64(n)
+	return pagination{Total: &total}
+}
+
+func respondWithXML(w http.ResponseWriter, contentType string, payload interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	dat, err := xml.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling XML: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+type objectKind string
+
+const (
+	objectOriginal  objectKind = storage.KindOriginal
+	objectRendition objectKind = "rendition"
+)
+
+// storageClasses are the S3 storage classes new video files are put in,
+// empty for the bucket's default
+type storageClasses struct {
+	originals  string
+	renditions string
+}
+
+func (c storageClasses) forKind(kind objectKind) string {
+	if kind == objectOriginal {
+		return c.originals
+	}
+	return c.renditions
+}
+
+// videoPutContext has the video files put with ctx stored in the class
+// configured for kind and tagged with it
+func (cfg *apiConfig) videoPutContext(ctx context.Context, kind objectKind) context.Context {
+	return storage.WithPutOptions(ctx, storage.PutOptions{
+		StorageClass: cfg.storageClasses.forKind(kind),
+		Tags:         map[string]string{storage.KindTag: string(kind)},
+	})
+}
+
+// appliedLifecycle is a bucket whose rules were set
+type appliedLifecycle struct {
+	Bucket string                  `json:"bucket"`
+	Rules  []storage.LifecycleRule `json:"rules"`
+}
+
+// applyLifecycleRules sets the policy's rules on the default bucket and the
+// storage regions' buckets. Organizations' own buckets are theirs to manage.
+func (cfg *apiConfig) applyLifecycleRules(ctx context.Context) ([]appliedLifecycle, error) {
+	type bucket struct {
+		name  string
+		store storage.ObjectStore
+	}
+	buckets := []bucket{{name: cfg.s3Bucket, store: cfg.store}}
+	for _, region := range cfg.storageRegions {
+		buckets = append(buckets, bucket{name: region.bucket, store: region.store})
+	}
+
+	rules := cfg.lifecycle.Rules()
+	applied := []appliedLifecycle{}
+	for _, b := range buckets {
+		manager, ok := b.store.(storage.LifecycleManager)
+		if !ok {
+			continue
+		}
+		if err := manager.SetLifecycleRules(ctx, storage.LifecycleRulePrefix, rules); err != nil {
+			return applied, fmt.Errorf("couldn't set lifecycle rules of bucket %s: %w", b.name, err)
+		}
+		applied = append(applied, appliedLifecycle{Bucket: b.name, Rules: rules})
+	}
+	return applied, nil
+}
+
+// handlerAdminStorageLifecycle applies the configured lifecycle rules to the
+// buckets, replacing the ones set before
+func (cfg *apiConfig) handlerAdminStorageLifecycle(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	applied, err := cfg.applyLifecycleRules(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't apply lifecycle rules", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, applied)
+}
+
+// tagTrashedVideo marks the files of a video that was trashed, or unmarks
+// them when it's restored or held, so the expire-trashed rule only deletes
+// what's still in the trash. Files shared with other videos are never
+// marked.
+func (cfg *apiConfig) tagTrashedVideo(ctx context.Context, video database.Video, trashed bool) error {
+	if !cfg.lifecycle.ExpireTrash {
+		return nil
+	}
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return err
+	}
+	tagger, ok := target.store.(storage.Tagger)
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	tag := func(url string, kind objectKind) {
+		key, ok := target.keyFromURL(url)
+		if !ok {
+			return
+		}
+		tags := map[string]string{storage.KindTag: string(kind)}
+		if trashed {
+			refs, err := cfg.db.ObjectRefCount(video.OrganizationID, video.StorageRegion, key)
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			if refs > 1 {
+				return
+			}
+			tags[storage.TrashedTag] = "true"
+		}
+		if err := tagger.SetTags(ctx, key, tags); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	if video.VideoURL != nil {
+		tag(*video.VideoURL, objectOriginal)
+	}
+	for _, rendition := range video.Renditions {
+		tag(rendition.URL, objectRendition)
+	}
+	return errors.Join(errs...)
+}
+
+// hotStorageClassFor is the class a file of kind goes back to when its video
+// is unarchived
+func (cfg *apiConfig) hotStorageClassFor(kind objectKind) string {
+	return cmp.Or(cfg.storageClasses.forKind(kind), hotStorageClass)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	linkCheckTimeout = 10 * time.Second
+	stageLinkCheck   = uploadStage("link_check")
+)
+
+type brokenLink struct {
+	VideoID uuid.UUID `json:"video_id"`
+	URL     string    `json:"url"`
+	Reason  string    `json:"reason"`
+}
+
+func (cfg *apiConfig) runLinkChecker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		broken, err := cfg.checkVideoLinks(ctx)
+		if err != nil {
+			log.Printf("Link check failed: %v", err)
+			continue
+		}
+		if len(broken) > 0 && cfg.linkCheckAlertURL != "" {
+			if err := sendLinkAlert(ctx, cfg.linkCheckAlertURL, broken); err != nil {
+				log.Printf("Couldn't send broken link alert: %v", err)
+			}
+		}
+	}
+}
+
+// checkVideoLinks checks a sample of videos both directly against storage
+// and through their public URLs, and updates each video's link status
+func (cfg *apiConfig) checkVideoLinks(ctx context.Context) ([]brokenLink, error) {
+	videos, err := cfg.db.GetVideosForLinkCheck(cfg.linkCheckSampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	allBroken := []brokenLink{}
+	for _, video := range videos {
+		target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+		if err != nil {
+			log.Printf("Link check: couldn't resolve storage for video %s: %v", video.ID, err)
+			continue
+		}
+
+		broken := []brokenLink{}
+		if video.VideoURL != nil {
+			if reason, ok := cfg.checkStoredURL(ctx, target, *video.VideoURL); !ok {
+				broken = append(broken, brokenLink{VideoID: video.ID, URL: *video.VideoURL, Reason: reason})
+			}
+		}
+		if video.ThumbnailURL != nil {
+			if reason, ok := cfg.checkStoredURL(ctx, cfg.defaultStore(), *video.ThumbnailURL); !ok {
+				broken = append(broken, brokenLink{VideoID: video.ID, URL: *video.ThumbnailURL, Reason: reason})
+			}
+		}
+
+		status := database.LinkStatusOK
+		if len(broken) > 0 {
+			status = database.LinkStatusBroken
+		}
+		if err := cfg.db.UpdateVideoLinkStatus(video.ID, status); err != nil {
+			log.Printf("Link check: couldn't update video %s: %v", video.ID, err)
+		}
+		for _, b := range broken {
+			log.Printf("Broken link for video %s: %s (%s)", b.VideoID, b.URL, b.Reason)
+			cfg.recordVideoEvent(b.VideoID, stageLinkCheck, database.EventLinkBroken, fmt.Sprintf("%s: %s", b.URL, b.Reason))
+		}
+		allBroken = append(allBroken, broken...)
+	}
+
+	log.Printf("Link check: %d videos checked, %d broken links", len(videos), len(allBroken))
+	return allBroken, nil
+}
+
+// checkStoredURL returns false and the reason when url is broken. Errors that
+// don't tell us whether the object exists are logged and treated as healthy,
+// so a flaky network doesn't mark everything broken.
+func (cfg *apiConfig) checkStoredURL(ctx context.Context, target storeTarget, url string) (string, bool) {
+	if key, ok := target.keyFromURL(url); ok {
+		_, err := target.store.Head(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			return "object is missing from storage", false
+		}
+		if err != nil {
+			log.Printf("Link check: couldn't head %s: %v", key, err)
+			return "", true
+		}
+	} else if path, ok := cfg.localAssetPath(url); ok {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return "file is missing from the assets directory", false
+		}
+		return "", true
+	}
+
+	// organization buckets are private, so only the default store's public
+	// URLs can be checked from outside
+	if !target.isDefault {
+		return "", true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, linkCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Sprintf("invalid URL: %v", err), false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Link check: couldn't reach %s: %v", url, err)
+		return "", true
+	}
+	resp.Body.Close()
+
+	// CloudFront answers 403 for missing objects when the bucket isn't public
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return fmt.Sprintf("public URL returns %d", resp.StatusCode), false
+	}
+	return "", true
+}
+
+func sendLinkAlert(ctx context.Context, alertURL string, broken []brokenLink) error {
+	type alert struct {
+		Text        string       `json:"text"`
+		BrokenLinks []brokenLink `json:"broken_links"`
+	}
+	dat, err := json.Marshal(alert{
+		Text:        fmt.Sprintf("Tubely link check found %d broken links", len(broken)),
+		BrokenLinks: broken,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, linkCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alertURL, bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("alert endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"time"
+)
+
+const (
+	// shedRoundTime is roughly how long a slot's worth of queued ffmpeg
+	// work takes to clear, used to turn queue depth into a Retry-After
+	shedRoundTime = 30 * time.Second
+	shedMinRetry  = 10 * time.Second
+	shedMaxRetry  = 10 * time.Minute
+)
+
+// loadShedding is when uploads are turned away so the ones already in
+// flight can finish. A zero threshold is off.
+type loadShedding struct {
+	// maxFFmpegQueue is how many ffmpeg processes may wait for a slot
+	maxFFmpegQueue int
+	// maxDiskUsage is the fraction of the temp dir's disk that may be used
+	maxDiskUsage float64
+	// maxMemory is how many bytes the process may hold from the OS
+	maxMemory uint64
+}
+
+// loadShedMiddleware answers uploads with 503 while the server is
+// overloaded. It only wraps upload routes; reads don't touch the scratch
+// disk or ffmpeg and keep being served.
+func (cfg *apiConfig) loadShedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := cfg.overloaded()
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cfg.metrics.uploadsShed.Inc(reason)
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(cfg.shedRetryAfter().Seconds()))))
+		respondWithError(w, http.StatusServiceUnavailable, "The server is busy, try the upload again later", fmt.Errorf("shedding load: %s", reason))
+	})
+}
+
+// overloaded returns which limit the server is over: "ffmpeg_queue",
+// "disk" or "memory", or "" when it can take more uploads. A reading that
+// fails doesn't count as over.
+func (cfg *apiConfig) overloaded() string {
+	shed := cfg.loadShedding
+	if shed.maxFFmpegQueue > 0 {
+		if waiting, _ := cfg.media.Queue(); waiting > shed.maxFFmpegQueue {
+			return "ffmpeg_queue"
+		}
+	}
+	if shed.maxDiskUsage > 0 {
+		used, err := diskUsage(cfg.tempDir)
+		if err != nil {
+			log.Printf("Couldn't check disk usage of %s: %v", cfg.tempDir, err)
+		} else if used > shed.maxDiskUsage {
+			return "disk"
+		}
+	}
+	if shed.maxMemory > 0 && processMemory() > shed.maxMemory {
+		return "memory"
+	}
+	return ""
+}
+
+// shedRetryAfter guesses when the backlog will have cleared from the
+// ffmpeg processes waiting for a slot and the uploads queued behind them
+func (cfg *apiConfig) shedRetryAfter() time.Duration {
+	waiting, slots := cfg.media.Queue()
+	depth := waiting + cfg.jobs.active()[jobStatusQueued]
+	rounds := depth/max(slots, 1) + 1
+	return min(max(time.Duration(rounds)*shedRoundTime, shedMinRetry), shedMaxRetry)
+}
+
+// processMemory is the memory the Go runtime holds from the OS, less what
+// it has handed back
+func processMemory() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+// newLogger builds the server's logger from LOG_FORMAT ("text" or "json")
+// and LOG_LEVEL ("debug", "info", "warn" or "error"). Records logged with a
+// context get the request and upload it belongs to, so one upload can be
+// followed from the request through ffmpeg and the object store.
+func newLogger(format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT must be \"text\" or \"json\", got %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler adds the IDs carried by a record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := tracing.SpanContextFrom(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", hex.EncodeToString(sc.TraceID[:])))
+	}
+	if job, ok := ctx.Value(uploadJobKey{}).(*uploadJob); ok {
+		// a background job's context has no request, so the job carries it
+		if job.RequestID != "" && requestIDFrom(ctx) == "" {
+			record.AddAttrs(slog.String("request_id", job.RequestID))
+		}
+		record.AddAttrs(
+			slog.String("upload_id", job.ID.String()),
+			slog.String("video_id", job.VideoID.String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -2,140 +2,556 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sso"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webpush"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
+	db           database.Client
+	jwtSecret    string
+	platform     string
+	filepathRoot string
+	assetsRoot   string
+	s3Bucket     string
+	s3Region     string
+	// storageRegions are the buckets besides the default one that uploads
+	// can pick, by name
+	storageRegions   map[string]storageRegion
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	store            storage.ObjectStore
+	objectBaseURL    string
+	jobs             *jobTracker
+	urlSigner        *cdn.URLSigner
+	webPush          *webpush.Client
+	playbackURLTTL   time.Duration
+	secretBox        *secrets.Box
+	orgStores        *orgStoreCache
+	// s3Retry is how the clients of organizations' own buckets retry
+	s3Retry storage.RetryPolicy
+	// keyLayout is where in a bucket each user's objects go
+	keyLayout      storage.KeyLayout
+	blankVideoMode blankVideoMode
+	// re-encode rotated phone videos upright instead of keeping the
+	// rotation flag
+	bakeVideoRotation   bool
+	uploadPassthrough   bool
+	uploadPipeTranscode bool
+	// decode the ends of each stored video before it's marked ready
+	verifyStoredPlayback bool
+	strictUploadLength   bool
+	renditionLadder      []renditionSpec
+	thumbnail            thumbnailOptions
+	previewFormat        previewFormat
+	listEnvelope         bool
+	spriteInterval       time.Duration
+	// how many bits a perceptual hash may differ from a blocklist entry
+	// and still match it
+	blocklistDistance int
+	// scanner checks uploads for malware before anything else opens them,
+	// it's nil when MALWARE_SCANNER is off
+	scanner    scan.Scanner
+	scanAction scanAction
+	// moderator screens frames of uploads, which stay hidden until
+	// they're approved; it's nil when CONTENT_MODERATOR is off
+	moderator        moderation.ContentModerator
+	moderationFrames int
+	media            *media.Runner
+
+	// where uploads and ffmpeg outputs are written while they're processed
+	tempDir        string
+	minFreeDisk    int64
+	tempFileMaxAge time.Duration
+	trashRetention time.Duration
+	loadShedding   loadShedding
+	// videos failed or processing for longer than this are expired, zero
+	// keeps them forever
+	stuckVideoExpiry time.Duration
+	// change feed entries are kept this long, zero keeps them forever
+	changeFeedRetention time.Duration
+	metrics             *serverMetrics
+	// mediaTypes are the content types uploads may have
+	mediaTypes mediaTypeRegistry
+	// uploadLimits caps request bodies by the kind of media they carry
+	uploadLimits map[mediaKind]uploadLimit
+	// pricing is what cost estimates are worked out with
+	pricing storagePricing
+	// scrapers of /metrics must send metricsToken when it's set
+	metricsToken string
+	// errorReporter gets recovered panics, it's nil when SENTRY_DSN isn't set
+	errorReporter errreport.Reporter
+	// shortIDLength is the length of new videos' short IDs, 0 when they
+	// only get UUIDs
+	shortIDLength int
+	// archiveStorageClass is where archived videos' files are moved to
+	archiveStorageClass string
+	storageClasses      storageClasses
+	lifecycle           storage.LifecyclePolicy
+
+	// uploads received in a blackout window wait for it to close, then go
+	// through uploadDrain a few at a time
+	uploadBlackouts []uploadWindow
+	uploadDrain     chan struct{}
+
+	linkCheckSampleSize int
+	linkCheckAlertURL   string
+
+	// premiereWebhookURL is posted to when a premiere starts
+	premiereWebhookURL string
+	// webhookClient delivers users' webhooks
+	webhookClient *http.Client
+
+	maxPinnedVideos int
+
+	// lowercased emails of the users allowed on /api/admin endpoints
+	adminEmails map[string]bool
+
+	uploadLimiter     ratelimit.Limiter
+	uploadUserLimit   ratelimit.Limit
+	uploadIPLimit     ratelimit.Limit
+	commentUserLimit  ratelimit.Limit
+	rateLimitIPHeader string
+
+	publicBaseURL string
+
+	// external login providers by the name used in their routes
+	ssoProviders map[string]*sso.Provider
 }
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	// CONFIG_FILE holds settings in .env syntax; the environment wins over it
+	env, err := config.NewLoader(os.LookupEnv, os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings := loadSettings(env)
+	if err := env.Err(); err != nil {
+		log.Fatal(err)
 	}
 
-	db, err := database.NewClient(pathToDB)
+	logger, err := newLogger(settings.logFormat, settings.logLevel)
 	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+		log.Fatal(err)
 	}
+	// log.Printf calls go through it too
+	slog.SetDefault(logger)
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
-	}
+	appMetrics := newServerMetrics()
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	var errorReporter errreport.Reporter
+	if settings.sentryDSN != "" {
+		sentry, err := errreport.NewSentryReporter(settings.sentryDSN, settings.sentryEnvironment, "github.com/bootdotdev/learn-file-storage-s3-golang-starter")
+		if err != nil {
+			log.Fatal(err)
+		}
+		errorReporter = sentry
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	if settings.tracingOn {
+		tracing.SetDefault(tracing.NewExporter(settings.trace))
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	db, err := database.NewClient(settings.dbPath)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	var objectBaseURL string
+	storageRegions := map[string]storageRegion{}
+	var store storage.ObjectStore
+	switch settings.storageBackend {
+	case "s3":
+		ctx := context.Background()
+		awsConfig, err := awsconfig.LoadDefaultConfig(ctx,
+			awsconfig.WithRegion(settings.s3Region),
+		)
+		if err != nil {
+			log.Fatalf("Couldn't load AWS config: %v", err)
+		}
+		awsConfig.APIOptions = append(awsConfig.APIOptions, storage.TraceS3Calls, storage.LogS3Calls, storage.ObserveS3Calls(appMetrics.observeS3), storage.ObserveS3Retries(appMetrics.observeS3Retries))
+		retries := func(bucket string) func(*s3.Options) {
+			return storage.WithRetries(settings.s3Retry, appMetrics.s3BreakerChanged(bucket))
+		}
+
+		s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			if settings.s3Endpoint != "" {
+				o.BaseEndpoint = aws.String(settings.s3Endpoint)
+				o.UsePathStyle = true
+			}
+		}, retries(settings.s3Bucket))
+		s3Store := storage.NewS3Store(s3Client, settings.s3Bucket)
+		store = s3Store
+		objectBaseURL = "https://" + settings.s3CfDistribution
+
+		storageRegions, err = parseStorageRegions(settings.storageRegions, awsConfig, settings.s3Endpoint, retries)
+		if err != nil {
+			log.Fatalf("Invalid STORAGE_REGIONS: %v", err)
+		}
+
+		if settings.s3CheckPermissions {
+			logS3PermissionCheck(s3Store, settings.s3Bucket)
+			for _, region := range storageRegions {
+				logS3PermissionCheck(region.store, region.bucket)
+			}
+		}
+	case "local":
+		objectBaseURL = "http://localhost:" + settings.port + "/assets"
+		store = storage.NewLocalStore(settings.assetsRoot, objectBaseURL)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	var urlSigner *cdn.URLSigner
+	if settings.cfKeyPairID != "" {
+		cfPrivateKey, err := cdn.LoadPrivateKey(settings.cfPrivateKeyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront private key: %v", err)
+		}
+		urlSigner = cdn.NewURLSigner(settings.cfKeyPairID, cfPrivateKey)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	var webPush *webpush.Client
+	if settings.vapidKeyPath != "" {
+		vapidKey, err := webpush.LoadPrivateKey(settings.vapidKeyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load VAPID private key: %v", err)
+		}
+		webPush = webpush.NewClient(vapidKey, settings.vapidSubject)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	var moderator moderation.ContentModerator
+	switch settings.contentModerator {
+	case "rekognition":
+		awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(),
+			awsconfig.WithRegion(settings.moderationRegion),
+		)
+		if err != nil {
+			log.Fatalf("Couldn't load AWS config: %v", err)
+		}
+		moderator = moderation.NewRekognition(awsConfig, settings.moderationMinConfidence)
+	case "http":
+		moderator = moderation.NewHTTPModerator(settings.moderationURL, settings.moderationMinConfidence)
 	}
 
-	ctx := context.Background()
-	awsConfig, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(s3Region),
-	)
+	mediaConfig := settings.media
+	mediaConfig.Observe = appMetrics.observeMedia
+	mediaRunner := media.NewRunner(mediaConfig)
+	// a hardware encoder is only used if a test encode works on this host
+	encoder, err := mediaRunner.DetectH264Encoder(context.Background(), settings.hwAccel)
 	if err != nil {
-		// What do you think we should do if there's an error?
-		log.Fatal("couldnt load default config")
+		log.Printf("No hardware H.264 encoder available, using libx264: %v", err)
 	}
+	log.Printf("Encoding H.264 with %s", encoder)
 
-	s3Client := s3.NewFromConfig(awsConfig)
+	if err := os.MkdirAll(settings.tempDir, 0o700); err != nil {
+		log.Fatalf("Couldn't create temp directory: %v", err)
+	}
+
+	var uploadLimiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if settings.rateLimitRedisURL != "" {
+		uploadLimiter, err = ratelimit.NewRedisLimiter(settings.rateLimitRedisURL, "tubely:ratelimit:")
+		if err != nil {
+			log.Fatalf("Couldn't configure Redis rate limiter: %v", err)
+		}
+	}
+
+	ssoProviders := map[string]*sso.Provider{}
+	if settings.googleClientID != "" {
+		ssoProviders["google"] = sso.Google(settings.googleClientID, settings.googleClientSecret, settings.ssoBaseURL+"/api/auth/google/callback")
+	}
+	if settings.githubClientID != "" {
+		ssoProviders["github"] = sso.GitHub(settings.githubClientID, settings.githubClientSecret, settings.ssoBaseURL+"/api/auth/github/callback")
+	}
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
-	}
-
-	err = cfg.ensureAssetsDir()
-	if err != nil {
+		jwtSecret:        settings.jwtSecret,
+		platform:         settings.platform,
+		filepathRoot:     settings.filepathRoot,
+		assetsRoot:       settings.assetsRoot,
+		s3Bucket:         settings.s3Bucket,
+		s3Region:         settings.s3Region,
+		storageRegions:   storageRegions,
+		s3CfDistribution: settings.s3CfDistribution,
+		port:             settings.port,
+		store:            store,
+		objectBaseURL:    objectBaseURL,
+		jobs:             newJobTracker(),
+		webPush:          webPush,
+		urlSigner:        urlSigner,
+		playbackURLTTL:   settings.playbackURLTTL,
+		secretBox:        settings.secretBox,
+		orgStores:        newOrgStoreCache(),
+		s3Retry:          settings.s3Retry,
+		keyLayout:        settings.keyLayout,
+		blankVideoMode:   settings.blankVideoMode,
+
+		bakeVideoRotation:    settings.bakeVideoRotation,
+		uploadPassthrough:    settings.uploadPassthrough,
+		uploadPipeTranscode:  settings.uploadPipeTranscode,
+		verifyStoredPlayback: settings.verifyStoredPlayback,
+		strictUploadLength:   settings.strictUploadLength,
+		renditionLadder:      settings.renditionLadder,
+		thumbnail:            settings.thumbnail,
+		previewFormat:        settings.previewFormat,
+		listEnvelope:         settings.listEnvelope,
+		spriteInterval:       settings.spriteInterval,
+		blocklistDistance:    settings.blocklistDistance,
+		scanner:              settings.scanner,
+		scanAction:           settings.scanAction,
+		moderator:            moderator,
+		moderationFrames:     settings.moderationFrames,
+		media:                mediaRunner,
+		tempDir:              settings.tempDir,
+		minFreeDisk:          settings.minFreeDisk,
+		loadShedding:         settings.loadShedding,
+		tempFileMaxAge:       settings.tempFileMaxAge,
+		trashRetention:       settings.trashRetention,
+		stuckVideoExpiry:     settings.stuckVideoExpiry,
+		changeFeedRetention:  settings.changeFeedRetention,
+		shortIDLength:        settings.shortIDLength,
+		metrics:              appMetrics,
+		metricsToken:         settings.metricsToken,
+		errorReporter:        errorReporter,
+		mediaTypes:           settings.mediaTypes,
+		uploadLimits:         settings.uploadLimits,
+		pricing:              settings.pricing,
+		archiveStorageClass:  settings.archiveStorageClass,
+		storageClasses:       settings.storageClasses,
+		lifecycle:            settings.lifecycle,
+
+		uploadBlackouts: settings.uploadBlackouts,
+		uploadDrain:     make(chan struct{}, settings.uploadDrainConcurrency),
+
+		linkCheckSampleSize: settings.linkCheckSampleSize,
+		linkCheckAlertURL:   settings.linkCheckAlertURL,
+
+		premiereWebhookURL: settings.premiereWebhookURL,
+		webhookClient:      newWebhookClient(settings.platform == "dev"),
+
+		maxPinnedVideos: settings.maxPinnedVideos,
+
+		adminEmails: settings.adminEmails,
+
+		uploadLimiter:     uploadLimiter,
+		uploadUserLimit:   settings.uploadUserLimit,
+		uploadIPLimit:     settings.uploadIPLimit,
+		commentUserLimit:  settings.commentUserLimit,
+		rateLimitIPHeader: settings.rateLimitIPHeader,
+
+		publicBaseURL: settings.publicBaseURL,
+		ssoProviders:  ssoProviders,
+	}
+
+	if err := cfg.ensureAssetsDir(); err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// upload jobs only live in memory, so anything still processing was
+	// interrupted by the last shutdown
+	if err := db.ResetInterruptedProcessing(); err != nil {
+		log.Fatalf("Couldn't reset interrupted uploads: %v", err)
+	}
+
+	go cfg.runTempJanitor(context.Background(), tempJanitorInterval)
+	go cfg.runDeletionRetrier(context.Background(), deletionRetryInterval)
+	go cfg.runScheduledDeletions(context.Background(), scheduledDeletionInterval)
+	go cfg.runTrashPurger(context.Background(), trashPurgeInterval)
+	go cfg.runPremiereStarter(context.Background(), premiereInterval)
+	go cfg.runWebhookRetrier(context.Background(), webhookRetryInterval)
+	if cfg.stuckVideoExpiry > 0 {
+		go cfg.runStuckVideoExpirer(context.Background(), stuckVideoInterval)
+	}
+	if cfg.changeFeedRetention > 0 {
+		go cfg.runChangePruner(context.Background(), changePruneInterval)
+	}
+	if settings.linkCheckInterval > 0 {
+		go cfg.runLinkChecker(context.Background(), settings.linkCheckInterval)
+	}
+
 	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	// GET patterns answer HEAD too
+	assetsHandler := http.StripPrefix("/assets", assetsFileServer(cfg.assetsRoot))
+	mux.Handle("GET /assets/", noCacheMiddleware(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/tokens", cfg.handlerScopedTokenCreate)
+	mux.HandleFunc("GET /api/auth/providers", cfg.handlerSSOProviders)
+	mux.HandleFunc("GET /api/auth/{provider}/login", cfg.handlerSSOLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", cfg.handlerSSOCallback)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
+	mux.HandleFunc("GET /api/push/public_key", cfg.handlerPushPublicKey)
+	mux.HandleFunc("POST /api/push/subscriptions", cfg.handlerPushSubscribe)
+	mux.HandleFunc("DELETE /api/push/subscriptions", cfg.handlerPushUnsubscribe)
+	mux.HandleFunc("POST /api/api_keys", cfg.handlerAPIKeyCreate)
+	mux.HandleFunc("GET /api/api_keys", cfg.handlerAPIKeysList)
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.handlerAPIKeyRevoke)
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhookCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksList)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.handlerWebhookDeliveries)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/replay", cfg.handlerWebhookReplay)
+	mux.HandleFunc("POST /api/organizations", cfg.handlerOrganizationCreate)
+	mux.HandleFunc("POST /api/organizations/{orgID}/members", cfg.handlerOrganizationMemberAdd)
+	mux.HandleFunc("GET /api/organizations/{orgID}/storage", cfg.handlerOrganizationStorageGet)
+	mux.HandleFunc("PUT /api/organizations/{orgID}/storage", cfg.handlerOrganizationStorageSet)
+	mux.HandleFunc("PUT /api/organizations/{orgID}/storage_region", cfg.handlerOrganizationStorageRegionSet)
+	mux.HandleFunc("GET /api/storage/regions", cfg.handlerStorageRegions)
+	mux.HandleFunc("POST /api/organizations/{orgID}/service_accounts", cfg.handlerServiceAccountCreate)
+	mux.HandleFunc("GET /api/organizations/{orgID}/service_accounts", cfg.handlerServiceAccountsList)
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/service_accounts/{accountID}", cfg.handlerServiceAccountDisable)
+	mux.HandleFunc("POST /api/organizations/{orgID}/service_accounts/{accountID}/keys", cfg.handlerServiceAccountKeyCreate)
+	mux.HandleFunc("GET /api/organizations/{orgID}/service_accounts/{accountID}/keys", cfg.handlerServiceAccountKeysList)
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/service_accounts/{accountID}/keys/{keyID}", cfg.handlerServiceAccountKeyRevoke)
+
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.Handle("POST /api/thumbnail_upload/{videoID}", cfg.loadShedMiddleware(cfg.uploadRateLimitMiddleware(http.HandlerFunc(cfg.handlerUploadThumbnail))))
+	mux.Handle("POST /api/video_upload/{videoID}", cfg.loadShedMiddleware(cfg.uploadRateLimitMiddleware(http.HandlerFunc(cfg.handlerUploadVideo))))
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/export", cfg.handlerVideosExport)
+	mux.HandleFunc("GET /api/changes", cfg.handlerVideoChanges)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
+	mux.HandleFunc("POST /api/videos/batch-update", cfg.handlerVideosBatchUpdate)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/play", cfg.handlerVideoPlay)
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
+	mux.HandleFunc("GET /api/videos/trash", cfg.handlerVideosTrash)
+	mux.HandleFunc("GET /api/videos/archived", cfg.handlerVideosArchived)
+	mux.HandleFunc("POST /api/videos/{videoID}/archive", cfg.handlerVideoArchive)
+	mux.HandleFunc("POST /api/videos/{videoID}/unarchive", cfg.handlerVideoUnarchive)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("PUT /api/videos/{videoID}/scheduled_deletion", cfg.handlerVideoScheduleDeletion)
+	mux.HandleFunc("PUT /api/videos/{videoID}/premiere", cfg.handlerVideoPremiereSet)
+	mux.HandleFunc("GET /api/videos/{videoID}/premiere", cfg.handlerVideoPremiere)
+	mux.HandleFunc("POST /api/videos/{videoID}/pin", cfg.handlerVideoPin)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/pin", cfg.handlerVideoUnpin)
+	mux.HandleFunc("PUT /api/videos/{videoID}/indexing", cfg.handlerVideoIndexing)
+	mux.HandleFunc("PUT /api/videos/{videoID}/embed_domains", cfg.handlerVideoEmbedDomains)
+	mux.HandleFunc("GET /api/videos/{videoID}/tags", cfg.handlerVideoTagsGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.handlerVideoTagsSet)
+	mux.HandleFunc("POST /api/videos/{videoID}/heartbeat", cfg.handlerVideoHeartbeat)
+	mux.HandleFunc("POST /api/videos/{videoID}/view", cfg.handlerVideoView)
+	mux.HandleFunc("GET /api/videos/{videoID}/stats", cfg.handlerVideoStats)
+	mux.HandleFunc("GET /api/videos/{videoID}/heatmap", cfg.handlerVideoHeatmap)
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics/export", cfg.handlerVideoAnalyticsExport)
+	mux.HandleFunc("POST /api/videos/{videoID}/audio", cfg.handlerVideoAudioExtract)
+	mux.HandleFunc("POST /api/videos/{videoID}/trim", cfg.handlerVideoTrim)
+	mux.HandleFunc("GET /api/videos/{videoID}/mediainfo", cfg.handlerVideoMediaInfo)
+	mux.HandleFunc("POST /api/videos/{videoID}/captions", cfg.handlerVideoCaptionsUpload)
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerVideoCaptionsList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerVideoCaptionsDelete)
+	mux.HandleFunc("GET /api/tags", cfg.handlerTagsSearch)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerVideoEmbed)
+	mux.HandleFunc("GET /watch/{videoID}", cfg.handlerVideoEmbed)
+	mux.HandleFunc("GET /sitemap.xml", cfg.handlerSitemap)
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+	mux.HandleFunc("PUT /api/channel/order", cfg.handlerChannelOrder)
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsList)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideoAdd)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistVideoRemove)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.handlerPlaylistOrder)
+	mux.HandleFunc("PUT /api/videos/{videoID}/reaction", cfg.handlerReactionSet)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/reaction", cfg.handlerReactionDelete)
+	mux.HandleFunc("GET /api/watch_later", cfg.handlerWatchLaterList)
+	mux.HandleFunc("PUT /api/watch_later/{videoID}", cfg.handlerWatchLaterAdd)
+	mux.HandleFunc("DELETE /api/watch_later/{videoID}", cfg.handlerWatchLaterRemove)
+	mux.HandleFunc("POST /api/videos/{videoID}/comments", cfg.handlerCommentCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/comments/{commentID}", cfg.handlerCommentDelete)
+	mux.HandleFunc("GET /api/users/{userID}/videos", cfg.handlerChannelVideos)
+	mux.HandleFunc("GET /api/users/{userID}/feed", cfg.handlerChannelFeed)
+	mux.HandleFunc("GET /api/users/{userID}", cfg.handlerProfileGet)
+	mux.HandleFunc("PATCH /api/users/me", cfg.handlerProfileUpdate)
+	mux.Handle("POST /api/users/me/avatar", cfg.loadShedMiddleware(cfg.uploadRateLimitMiddleware(http.HandlerFunc(cfg.handlerAvatarUpload))))
+	mux.HandleFunc("DELETE /api/users/me/avatar", cfg.handlerAvatarDelete)
+
+	mux.Handle("POST /api/v2/video_upload/{videoID}", cfg.loadShedMiddleware(cfg.uploadRateLimitMiddleware(http.HandlerFunc(cfg.handlerUploadVideoV2))))
+	mux.HandleFunc("GET /api/v2/uploads/{uploadID}", cfg.handlerUploadStatus)
+	mux.HandleFunc("POST /api/v2/uploads/{uploadID}/cancel", cfg.handlerUploadCancel)
+	mux.HandleFunc("GET /api/v2/videos/{videoID}/events", cfg.handlerVideoEventsGet)
+	mux.HandleFunc("GET /api/uploads/{uploadID}/progress", cfg.handlerUploadProgress)
+
+	// every admin route needs the admin scope on a scoped token
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /api/admin/failures", cfg.handlerAdminFailures)
+	adminMux.HandleFunc("GET /api/admin/storage_costs", cfg.handlerAdminStorageCosts)
+	adminMux.HandleFunc("PUT /api/admin/storage/lifecycle", cfg.handlerAdminStorageLifecycle)
+	adminMux.HandleFunc("GET /api/admin/videos", cfg.handlerAdminVideos)
+	adminMux.HandleFunc("DELETE /api/admin/videos/{videoID}", cfg.handlerAdminVideoDelete)
+	adminMux.HandleFunc("PUT /api/admin/videos/{videoID}/legal_hold", cfg.handlerVideoLegalHold)
+	adminMux.HandleFunc("PUT /api/admin/videos/{videoID}/moderation", cfg.handlerAdminVideoModeration)
+	adminMux.HandleFunc("GET /api/admin/users", cfg.handlerAdminUsers)
+	adminMux.HandleFunc("PUT /api/admin/users/{userID}/suspension", cfg.handlerAdminUserSuspension)
+	adminMux.HandleFunc("GET /api/admin/uploads/{uploadID}/log", cfg.handlerAdminUploadLog)
+	adminMux.HandleFunc("GET /api/admin/videos/{videoID}/mediainfo", cfg.handlerAdminVideoMediaInfo)
+	adminMux.HandleFunc("GET /api/admin/blocklist", cfg.handlerBlocklistList)
+	adminMux.HandleFunc("POST /api/admin/blocklist", cfg.handlerBlocklistCreate)
+	adminMux.HandleFunc("DELETE /api/admin/blocklist/{entryID}", cfg.handlerBlocklistDelete)
+	mux.Handle("/api/admin/", cfg.requireScope(auth.ScopeAdmin, adminMux))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
+	// requests get a context shutdown can cancel, for uploads still running
+	// when its deadline passes
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:        ":" + cfg.port,
+		Handler:     requestIDMiddleware(tracingMiddleware(cfg.metricsMiddleware(cfg.recoverMiddleware(cfg.authMiddleware(cfg.suspensionMiddleware(mux)))))),
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		log.Printf("Serving on: http://localhost:%s/app/\n", cfg.port)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	cfg.shutdown(srv, cancelRequests, settings.shutdownTimeout)
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// probeMediaReport runs a full ffprobe analysis of the file at filePath:
+// every stream, the container and its tags, and chapters
+func probeMediaReport(ctx context.Context, runner *media.Runner, filePath string) (json.RawMessage, error) {
+	args := []string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams", "-show_chapters",
+		filePath,
+	}
+	stdout := &cappedBuffer{limit: ffprobeMaxOutput}
+	err := runner.FFprobe(ctx, args, media.Options{Stdout: stdout, Timeout: ffprobeTimeout})
+	if stdout.exceeded {
+		return nil, fmt.Errorf("ffprobe output exceeded %d bytes", ffprobeMaxOutput)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the file name is one of our temp files, which is no use to anyone
+	var report map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		return nil, err
+	}
+	if format, ok := report["format"].(map[string]any); ok {
+		delete(format, "filename")
+	}
+	return json.Marshal(report)
+}
+
+// saveMediaReport keeps the analysis of the uploaded and the stored file.
+// It's only for inspection, so failing to make it doesn't fail the upload.
+func (cfg *apiConfig) saveMediaReport(ctx context.Context, job *uploadJob, sourcePath, storedPath string) {
+	source, err := probeMediaReport(ctx, cfg.media, sourcePath)
+	if err != nil {
+		log.Printf("Couldn't analyze upload of video %s: %v", job.VideoID, err)
+		return
+	}
+	stored := source
+	if storedPath != sourcePath {
+		stored, err = probeMediaReport(ctx, cfg.media, storedPath)
+		if err != nil {
+			log.Printf("Couldn't analyze stored file of video %s: %v", job.VideoID, err)
+			return
+		}
+	}
+	if err := cfg.db.SaveMediaReport(job.VideoID, source, stored); err != nil {
+		log.Printf("Couldn't save media report of video %s: %v", job.VideoID, err)
+	}
+}
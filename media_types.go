@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type mediaKind string
+
+const (
+	mediaKindVideo mediaKind = "video"
+	mediaKindImage mediaKind = "image"
+)
+
+// mediaProcessor is what happens to an upload of a type after it's checked
+type mediaProcessor string
+
+const (
+	// processPassthrough uploads are MP4s that are stored as they are, even
+	// streamed straight to storage, when they're already fit for playback
+	processPassthrough mediaProcessor = "passthrough"
+	// processRemux uploads are remuxed or transcoded into MP4 by ffmpeg
+	processRemux mediaProcessor = "remux"
+	// processImage uploads are decoded and re-encoded as thumbnails
+	processImage mediaProcessor = "image"
+)
+
+// mediaType is everything the upload handlers need to know about a content
+// type they accept
+type mediaType struct {
+	ContentType string
+	Kind        mediaKind
+	// Name is how error messages list the type
+	Name      string
+	Extension string
+	// validate checks the start of the file really is this type
+	validate  func(header []byte) error
+	processor mediaProcessor
+	// probeFormat is the ffprobe format name of the container, for videos
+	probeFormat string
+}
+
+// knownMediaTypes are the types uploads can be. ACCEPTED_MEDIA_TYPES picks
+// which of them are enabled; a new format is added here.
+var knownMediaTypes = []mediaType{
+	{ContentType: "video/mp4", Kind: mediaKindVideo, Name: "MP4", Extension: ".mp4",
+		validate: containerValidator("video/mp4", containerISOBMFF), processor: processPassthrough, probeFormat: "mp4"},
+	// MP4 and QuickTime share a box format and phones mix up the two
+	{ContentType: "video/quicktime", Kind: mediaKindVideo, Name: "MOV", Extension: ".mov",
+		validate: containerValidator("video/quicktime", containerISOBMFF), processor: processRemux, probeFormat: "mov"},
+	{ContentType: "video/x-matroska", Kind: mediaKindVideo, Name: "MKV", Extension: ".mkv",
+		validate: containerValidator("video/x-matroska", containerMatroska), processor: processRemux, probeFormat: "matroska"},
+	{ContentType: "video/webm", Kind: mediaKindVideo, Name: "WebM", Extension: ".webm",
+		validate: containerValidator("video/webm", containerMatroska), processor: processRemux, probeFormat: "webm"},
+	{ContentType: "video/x-msvideo", Kind: mediaKindVideo, Name: "AVI", Extension: ".avi",
+		validate: containerValidator("video/x-msvideo", containerAVI), processor: processRemux, probeFormat: "avi"},
+	{ContentType: "video/avi", Kind: mediaKindVideo, Name: "AVI", Extension: ".avi",
+		validate: containerValidator("video/avi", containerAVI), processor: processRemux, probeFormat: "avi"},
+	{ContentType: "image/jpeg", Kind: mediaKindImage, Name: "JPEG", Extension: ".jpg",
+		validate: imageValidator("image/jpeg"), processor: processImage},
+	{ContentType: "image/png", Kind: mediaKindImage, Name: "PNG", Extension: ".png",
+		validate: imageValidator("image/png"), processor: processImage},
+}
+
+// ffprobe format names of the containers we can read, whichever of their
+// content types are enabled, since clients mislabel files
+var supportedContainers = func() []string {
+	var formats []string
+	for _, t := range knownMediaTypes {
+		if t.probeFormat != "" {
+			formats = append(formats, t.probeFormat)
+		}
+	}
+	return formats
+}()
+
+type mediaTypeRegistry struct {
+	types map[string]mediaType
+}
+
+// newMediaTypeRegistry enables the comma separated content types in spec,
+// or every known type if it's empty
+func newMediaTypeRegistry(spec string) (mediaTypeRegistry, error) {
+	known := map[string]mediaType{}
+	for _, t := range knownMediaTypes {
+		known[t.ContentType] = t
+	}
+	if strings.TrimSpace(spec) == "" {
+		return mediaTypeRegistry{types: known}, nil
+	}
+
+	r := mediaTypeRegistry{types: map[string]mediaType{}}
+	for _, contentType := range strings.Split(spec, ",") {
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		t, ok := known[contentType]
+		if !ok {
+			return mediaTypeRegistry{}, fmt.Errorf("unknown media type %q", contentType)
+		}
+		r.types[contentType] = t
+	}
+	for _, kind := range []mediaKind{mediaKindVideo, mediaKindImage} {
+		if r.describe(kind) == "" {
+			return mediaTypeRegistry{}, fmt.Errorf("no %s type is enabled", kind)
+		}
+	}
+	return r, nil
+}
+
+// lookup returns the enabled type of the given kind for contentType
+func (r mediaTypeRegistry) lookup(contentType string, kind mediaKind) (mediaType, bool) {
+	t, ok := r.types[contentType]
+	if !ok || t.Kind != kind {
+		return mediaType{}, false
+	}
+	return t, true
+}
+
+// describe lists the names of the enabled types of a kind, like "MP4, MOV
+// and AVI"
+func (r mediaTypeRegistry) describe(kind mediaKind) string {
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range knownMediaTypes {
+		if _, ok := r.types[t.ContentType]; ok && t.Kind == kind && !seen[t.Name] {
+			seen[t.Name] = true
+			names = append(names, t.Name)
+		}
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
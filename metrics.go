@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// uploadSizeBuckets run from 1 MB to 10 GB
+var uploadSizeBuckets = []float64{1 << 20, 10 << 20, 50 << 20, 100 << 20, 250 << 20, 500 << 20, 1 << 30, 2 << 30, 5 << 30, 10 << 30}
+
+// serverMetrics are the metrics served at /metrics
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	httpRequests    *metrics.Counter
+	httpDuration    *metrics.Histogram
+	uploads         *metrics.Counter
+	uploadSize      *metrics.Histogram
+	uploadsInFlight *metrics.Gauge
+	uploadsShed     *metrics.Counter
+	stageDuration   *metrics.Histogram
+	mediaDuration   *metrics.Histogram
+	s3Duration      *metrics.Histogram
+	s3Retries       *metrics.Counter
+	s3CircuitOpen   *metrics.Gauge
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry: r,
+		httpRequests: r.Counter("tubely_http_requests_total",
+			"HTTP requests by route pattern and status code.", "route", "code"),
+		httpDuration: r.Histogram("tubely_http_request_duration_seconds",
+			"Time to answer HTTP requests by route pattern.", metrics.DefaultBuckets, "route"),
+		uploads: r.Counter("tubely_uploads_total",
+			"Finished upload jobs by result: completed, failed or canceled.", "result"),
+		uploadSize: r.Histogram("tubely_upload_size_bytes",
+			"Size of received uploads.", uploadSizeBuckets),
+		uploadsInFlight: r.Gauge("tubely_uploads_in_flight",
+			"Upload jobs not finished yet, queued ones still being received or waiting for a slot.", "status"),
+		uploadsShed: r.Counter("tubely_uploads_shed_total",
+			"Uploads turned away with 503 while the server was overloaded, by the limit it was over: ffmpeg_queue, disk or memory.", "reason"),
+		stageDuration: r.Histogram("tubely_upload_stage_duration_seconds",
+			"Time spent in each stage of the upload pipeline; processing is the faststart remux or re-encode.", metrics.DefaultBuckets, "stage", "result"),
+		mediaDuration: r.Histogram("tubely_media_process_duration_seconds",
+			"Run time of ffmpeg and ffprobe processes.", metrics.DefaultBuckets, "program", "result"),
+		s3Duration: r.Histogram("tubely_s3_request_duration_seconds",
+			"Time taken by S3 calls, retries included, by operation.", metrics.DefaultBuckets, "operation", "result"),
+		s3Retries: r.Counter("tubely_s3_retries_total",
+			"S3 calls retried after a transient error, by operation.", "operation"),
+		s3CircuitOpen: r.Gauge("tubely_s3_circuit_open",
+			"1 while writes to a bucket are paused because they keep failing.", "bucket"),
+	}
+}
+
+// result is the label for whether an operation worked
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func (m *serverMetrics) observeMedia(program string, duration time.Duration, err error) {
+	m.mediaDuration.Observe(duration.Seconds(), program, result(err))
+}
+
+func (m *serverMetrics) observeS3(operation string, duration time.Duration, err error) {
+	m.s3Duration.Observe(duration.Seconds(), operation, result(err))
+}
+
+func (m *serverMetrics) observeS3Retries(operation string, retries int) {
+	m.s3Retries.Add(float64(retries), operation)
+}
+
+// s3BreakerChanged returns the callback for when the breaker on a bucket's
+// client opens or closes
+func (m *serverMetrics) s3BreakerChanged(bucket string) func(open bool) {
+	return func(open bool) {
+		if open {
+			log.Printf("Writes to bucket %s keep failing, pausing them", bucket)
+			m.s3CircuitOpen.Set(1, bucket)
+			return
+		}
+		log.Printf("Writes to bucket %s are working again", bucket)
+		m.s3CircuitOpen.Set(0, bucket)
+	}
+}
+
+// metricsMiddleware counts requests by the route pattern they matched, so
+// IDs in paths don't make a series each
+func (cfg *apiConfig) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// the mux sets the pattern on the request it routed
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		cfg.metrics.httpRequests.Inc(route, strconv.Itoa(rec.status))
+		cfg.metrics.httpDuration.Observe(time.Since(start).Seconds(), route)
+	})
+}
+
+// handlerMetrics serves the metrics for Prometheus to scrape. When
+// METRICS_TOKEN is set, scrapers must send it as a bearer token.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if cfg.metricsToken != "" {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid metrics token", err)
+			return
+		}
+	}
+
+	active := cfg.jobs.active()
+	for _, status := range []jobStatus{jobStatusQueued, jobStatusRunning} {
+		cfg.metrics.uploadsInFlight.Set(float64(active[status]), string(status))
+	}
+	cfg.metrics.registry.ServeHTTP(w, r)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+// moderateUpload screens frames of a processed upload before it's stored.
+// The video is hidden first, so if the moderator fails it stays pending
+// until a reviewer decides.
+func (cfg *apiConfig) moderateUpload(ctx context.Context, job *uploadJob, path string, duration time.Duration) error {
+	if err := cfg.db.SetVideoModerationPending(job.VideoID); err != nil {
+		return fmt.Errorf("couldn't mark video pending moderation: %w", err)
+	}
+
+	frames, err := sampleFrames(ctx, cfg.media, path, duration, cfg.moderationFrames)
+	if err != nil {
+		return err
+	}
+	found := make([][]moderation.Label, 0, len(frames))
+	for i, frame := range frames {
+		labels, err := cfg.moderator.ModerateFrame(ctx, frame)
+		if err != nil {
+			return fmt.Errorf("couldn't moderate frame with %s: %w", cfg.moderator.Name(), err)
+		}
+		found = append(found, labels)
+		job.setProgress(stageModeration, int64(i+1), int64(len(frames)))
+	}
+
+	labels := database.ModerationLabels{}
+	for _, label := range moderation.Merge(found...) {
+		labels = append(labels, database.ModerationLabel{Name: label.Name, Confidence: label.Confidence})
+	}
+	status := database.ModerationApproved
+	if len(labels) > 0 {
+		status = database.ModerationFlagged
+	}
+	if _, err := cfg.db.SetVideoModeration(job.VideoID, status, labels, uuid.NullUUID{}); err != nil {
+		return fmt.Errorf("couldn't save moderation result: %w", err)
+	}
+	if status == database.ModerationFlagged {
+		names := make([]string, len(labels))
+		for i, label := range labels {
+			names[i] = fmt.Sprintf("%s (%.0f%%)", label.Name, label.Confidence)
+		}
+		cfg.recordJobEvent(job, stageModeration, database.EventContentFlagged, "held for review: "+strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// sampleFrames takes n frames spread evenly through the video, leaving out
+// the very start and end, as JPEGs at most 640 pixels wide
+func sampleFrames(ctx context.Context, runner *media.Runner, path string, duration time.Duration, n int) ([][]byte, error) {
+	frames := make([][]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		at := duration * time.Duration(i) / time.Duration(n+1)
+		args := []string{
+			"-v", "error",
+			"-ss", fmt.Sprintf("%.3f", at.Seconds()),
+			"-i", path,
+			"-frames:v", "1",
+			"-vf", "scale='min(640,iw)':-2",
+			"-f", "image2", "-c:v", "mjpeg", "pipe:1",
+		}
+		var out bytes.Buffer
+		if err := runner.FFmpeg(ctx, args, media.Options{Stdout: &out}); err != nil {
+			return nil, fmt.Errorf("couldn't extract frame at %s: %w", at, err)
+		}
+		if out.Len() == 0 {
+			return nil, fmt.Errorf("no frame at %s", at)
+		}
+		frames = append(frames, out.Bytes())
+	}
+	return frames, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// storeTarget is an object store together with the public base URL of the
+// objects written to it
+type storeTarget struct {
+	store   storage.ObjectStore
+	baseURL string
+	// isDefault is false for organizations that bring their own bucket
+	isDefault bool
+}
+
+func (t storeTarget) objectURL(key string) string {
+	return t.baseURL + "/" + key
+}
+
+func (t storeTarget) keyFromURL(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, t.baseURL+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+type cachedOrgStore struct {
+	target    storeTarget
+	updatedAt time.Time
+}
+
+type orgStoreCache struct {
+	mu     sync.Mutex
+	stores map[uuid.UUID]cachedOrgStore
+}
+
+func newOrgStoreCache() *orgStoreCache {
+	return &orgStoreCache{
+		stores: map[uuid.UUID]cachedOrgStore{},
+	}
+}
+
+func (cfg *apiConfig) defaultStore() storeTarget {
+	return storeTarget{
+		store:     cfg.store,
+		baseURL:   cfg.objectBaseURL,
+		isDefault: true,
+	}
+}
+
+// storeFor routes to the organization's own bucket when it has configured
+// one, then to the named storage region, and to the default store otherwise
+func (cfg *apiConfig) storeFor(orgID uuid.NullUUID, region string) (storeTarget, error) {
+	if !orgID.Valid {
+		return cfg.regionStore(region)
+	}
+
+	st, err := cfg.db.GetOrganizationStorage(orgID.UUID)
+	if err != nil {
+		return storeTarget{}, err
+	}
+	if st.Bucket == "" {
+		return cfg.regionStore(region)
+	}
+
+	cfg.orgStores.mu.Lock()
+	defer cfg.orgStores.mu.Unlock()
+	if cached, ok := cfg.orgStores.stores[orgID.UUID]; ok && cached.updatedAt.Equal(st.UpdatedAt) {
+		return cached.target, nil
+	}
+
+	if cfg.secretBox == nil {
+		return storeTarget{}, errors.New("STORAGE_CREDENTIALS_KEY is not set, can't decrypt organization credentials")
+	}
+	secretAccessKey, err := cfg.secretBox.Open(st.SecretAccessKeyEncrypted)
+	if err != nil {
+		return storeTarget{}, fmt.Errorf("couldn't decrypt credentials for organization %s: %w", orgID.UUID, err)
+	}
+
+	client := s3.New(s3.Options{
+		Region:      st.Region,
+		Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(st.AccessKeyID, secretAccessKey, "")),
+		APIOptions:  []func(*middleware.Stack) error{storage.TraceS3Calls, storage.LogS3Calls, storage.ObserveS3Calls(cfg.metrics.observeS3), storage.ObserveS3Retries(cfg.metrics.observeS3Retries)},
+	}, func(o *s3.Options) {
+		if st.Endpoint != "" {
+			o.BaseEndpoint = aws.String(st.Endpoint)
+			o.UsePathStyle = true
+		}
+	}, storage.WithRetries(cfg.s3Retry, cfg.metrics.s3BreakerChanged(st.Bucket)))
+	target := storeTarget{
+		store:   storage.NewS3Store(client, st.Bucket),
+		baseURL: orgBucketURL(st),
+	}
+	cfg.orgStores.stores[orgID.UUID] = cachedOrgStore{target: target, updatedAt: st.UpdatedAt}
+	return target, nil
+}
+
+func (cfg *apiConfig) regionStore(region string) (storeTarget, error) {
+	if region == "" {
+		return cfg.defaultStore(), nil
+	}
+	r, ok := cfg.storageRegions[region]
+	if !ok {
+		return storeTarget{}, fmt.Errorf("storage region %q isn't configured", region)
+	}
+	return r.target, nil
+}
+
+func orgBucketURL(st database.OrganizationStorage) string {
+	return bucketURL(st.Bucket, st.Region, st.Endpoint)
+}
+
+func bucketURL(bucket, region, endpoint string) string {
+	if endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/google/uuid"
+)
+
+// maxStreamHead caps how much of an MP4 is held in memory while looking for
+// its moov box. Anything with a bigger header goes through a temp file.
+const maxStreamHead = 32 << 20
+
+// streamedUpload is a video that was stored while it was received, without
+// a temp file: a faststart MP4 that needs no remux, or an upload that was
+// transcoded on the way through.
+type streamedUpload struct {
+	key   string
+	probe FFProbeOutput
+	// sha256 is the upload's; storedSHA256 and size are the stored file's,
+	// which is only different when it was transcoded
+	sha256       string
+	storedSHA256 string
+	size         int64
+	transcoded   bool
+	// report is the full analysis of the head of the file
+	report json.RawMessage
+}
+
+// receivePassthroughUpload reads the video part of the form as it arrives.
+// A faststart MP4 whose streams can be stored as they are is piped straight
+// into the object store, and with UPLOAD_PIPE_TRANSCODE other containers are
+// piped through ffmpeg on their way there; anything else is copied to a temp
+// file for the usual processing, as receiveVideoUpload would.
+func (cfg *apiConfig) receivePassthroughUpload(w http.ResponseWriter, r *http.Request, video database.Video, job *uploadJob) (receivedUpload, bool) {
+	part, ok := cfg.openVideoPart(w, r)
+	if !ok {
+		return receivedUpload{}, false
+	}
+	defer part.Close()
+	job.Filename = cleanFilename(part.FileName())
+	videoType, file := part.videoType, part.file
+
+	var rest io.Reader = file
+	if videoType.processor == processPassthrough {
+		head, faststart, err := readMP4Head(file)
+		if respondIfTooLarge(w, err) {
+			return receivedUpload{}, false
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+			return receivedUpload{}, false
+		}
+		rest = io.MultiReader(bytes.NewReader(head), file)
+		// during a blackout the upload waits in a temp file like any other
+		if _, deferred := cfg.uploadBlackoutUntil(time.Now()); faststart && !deferred {
+			streamed, size, ok, err := cfg.streamUpload(r.Context(), job, head, file)
+			if respondIfTooLarge(w, err) {
+				return receivedUpload{}, false
+			}
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't store video", err)
+				return receivedUpload{}, false
+			}
+			if ok {
+				if m, ok := checkUploadLength(lengthSourcePart, partContentLength(part.Header), size); ok && cfg.lengthMismatch(job, m) {
+					cfg.deleteStreamedObject(job, streamed.key)
+					respondWithError(w, http.StatusBadRequest, "Video size doesn't match the part's Content-Length", m)
+					return receivedUpload{}, false
+				}
+				job.SHA256 = streamed.sha256
+				job.received(size)
+				return receivedUpload{video: video, job: job, streamed: &streamed}, true
+			}
+		}
+	}
+	if videoType.processor == processRemux && cfg.uploadPipeTranscode {
+		head, err := readPipeHead(file)
+		if respondIfTooLarge(w, err) {
+			return receivedUpload{}, false
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+			return receivedUpload{}, false
+		}
+		rest = io.MultiReader(bytes.NewReader(head), file)
+		if _, deferred := cfg.uploadBlackoutUntil(time.Now()); !deferred {
+			streamed, size, ok, err := cfg.transcodeUpload(r.Context(), job, head, file, videoType.Extension)
+			if respondIfTooLarge(w, err) {
+				return receivedUpload{}, false
+			}
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't transcode video", err)
+				return receivedUpload{}, false
+			}
+			if ok {
+				if m, ok := checkUploadLength(lengthSourcePart, partContentLength(part.Header), size); ok && cfg.lengthMismatch(job, m) {
+					cfg.deleteStreamedObject(job, streamed.key)
+					respondWithError(w, http.StatusBadRequest, "Video size doesn't match the part's Content-Length", m)
+					return receivedUpload{}, false
+				}
+				job.SHA256 = streamed.sha256
+				job.received(size)
+				return receivedUpload{video: video, job: job, streamed: &streamed}, true
+			}
+		}
+	}
+
+	tempFile, err := os.CreateTemp(cfg.tempDir, "tubely-upload-*"+videoType.Extension)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't create temp file", err)
+		return receivedUpload{}, false
+	}
+	defer tempFile.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), newContextReader(r.Context(), rest))
+	if err != nil {
+		os.Remove(tempFile.Name())
+		if respondIfTooLarge(w, err) {
+			return receivedUpload{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "couldn't copy to temp file", err)
+		return receivedUpload{}, false
+	}
+	if m, ok := checkUploadLength(lengthSourcePart, partContentLength(part.Header), size); ok && cfg.lengthMismatch(job, m) {
+		os.Remove(tempFile.Name())
+		respondWithError(w, http.StatusBadRequest, "Video size doesn't match the part's Content-Length", m)
+		return receivedUpload{}, false
+	}
+	job.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	job.received(size)
+	return receivedUpload{video: video, job: job, tempPath: tempFile.Name()}, true
+}
+
+// readMP4Head reads the top-level boxes before mdat, the media data, and
+// reports whether moov is among them. Nothing past the returned head is
+// consumed, so head followed by the rest of r is the whole file.
+func readMP4Head(r *bufio.Reader) ([]byte, bool, error) {
+	var head bytes.Buffer
+	moov := false
+	for {
+		header, err := r.Peek(16)
+		if len(header) < 8 {
+			// the file ended, or will fail on its own later
+			return head.Bytes(), false, nil
+		}
+		boxType := string(header[4:8])
+		if boxType == "mdat" {
+			return head.Bytes(), moov, nil
+		}
+		size := uint64(binary.BigEndian.Uint32(header))
+		headerSize := uint64(8)
+		if size == 1 {
+			if err != nil {
+				return head.Bytes(), false, nil
+			}
+			size, headerSize = binary.BigEndian.Uint64(header[8:]), 16
+		}
+		// a size of 0 runs to the end of the file, which leaves no room for
+		// media data after it
+		if size < headerSize || uint64(head.Len())+size > maxStreamHead {
+			return head.Bytes(), false, nil
+		}
+		if _, err := io.CopyN(&head, r, int64(size)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return head.Bytes(), false, nil
+			}
+			return nil, false, err
+		}
+		if boxType == "moov" {
+			moov = true
+		}
+	}
+}
+
+// streamUpload probes head, the start of a faststart MP4, and if the video
+// can be stored as it is, sends head and the rest of body to the object
+// store. It returns false without reading body if the video needs
+// processing first.
+func (cfg *apiConfig) streamUpload(ctx context.Context, job *uploadJob, head []byte, body io.Reader) (streamedUpload, int64, bool, error) {
+	headFile, err := os.CreateTemp(cfg.tempDir, "tubely-head.mp4")
+	if err != nil {
+		return streamedUpload{}, 0, false, err
+	}
+	defer os.Remove(headFile.Name())
+	_, err = headFile.Write(head)
+	headFile.Close()
+	if err != nil {
+		return streamedUpload{}, 0, false, err
+	}
+
+	// moov has everything ffprobe looks at, the media data isn't needed
+	probe, err := probeVideo(ctx, cfg.media, headFile.Name())
+	if err != nil {
+		return streamedUpload{}, 0, false, nil
+	}
+	prefix, err := probe.storagePrefix()
+	if err != nil || !probe.mp4Compatible() || (cfg.bakeVideoRotation && probe.rotation() != 0) {
+		return streamedUpload{}, 0, false, nil
+	}
+	report, err := probeMediaReport(ctx, cfg.media, headFile.Name())
+	if err != nil {
+		slog.WarnContext(ctx, "Couldn't analyze upload of video", "video_id", job.VideoID, "error", err)
+	}
+
+	key, err := newVideoKey(cfg.keyLayout.Key(job.UserID, prefix))
+	if err != nil {
+		return streamedUpload{}, 0, false, err
+	}
+	target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+	if err != nil {
+		return streamedUpload{}, 0, false, fmt.Errorf("couldn't resolve object store: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := putStream(cfg.videoPutContext(ctx, objectOriginal), target, key, pr, "video/mp4")
+		// stop the copy below if the store gave up early
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(pw, hash), io.MultiReader(bytes.NewReader(head), body))
+	pw.CloseWithError(err)
+	if uploadErr := <-uploaded; err == nil {
+		err = uploadErr
+	}
+	if err != nil {
+		cfg.deleteStreamedObject(job, key)
+		return streamedUpload{}, 0, false, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	return streamedUpload{key: key, probe: probe, sha256: sum, storedSHA256: sum, size: size, report: report}, size, true, nil
+}
+
+// finishStreamedUpload screens and publishes a video that's already in the
+// object store. The stages that need the whole file on disk, like
+// renditions and previews, are skipped.
+func (cfg *apiConfig) finishStreamedUpload(ctx context.Context, job *uploadJob, upload streamedUpload) (database.Video, error) {
+	job.start()
+	ctx = withUploadJob(ctx, job)
+	if err := cfg.db.UpdateVideoProcessingStatus(job.VideoID, database.ProcessingStatusProcessing); err != nil {
+		slog.WarnContext(ctx, "Couldn't mark video as processing", "error", err)
+	}
+	// the head of the file was probed, and transcoded, while it was received
+	job.setStage(stageProbing, jobStatusCompleted)
+	if upload.transcoded {
+		job.setStage(stageProcessing, jobStatusCompleted)
+	} else {
+		job.setStage(stageProcessing, jobStatusSkipped)
+	}
+	for _, stage := range []uploadStage{stageAnalyzing, stageRenditions, stagePreview, stageSprites} {
+		job.setStage(stage, jobStatusSkipped)
+	}
+
+	// only the exact hash can be checked, perceptual hashes need a frame
+	// decoded from the file
+	err := cfg.runStage(job, stageScreening, func() error {
+		entry, err := cfg.db.FindBlocklistEntry(database.BlocklistSHA256, upload.sha256)
+		if err != nil {
+			return fmt.Errorf("couldn't check blocklist: %w", err)
+		}
+		if entry.ID == uuid.Nil {
+			cfg.checkDuplicateUpload(job)
+			return nil
+		}
+		err = &blocklistMatch{entry: entry}
+		message := err.Error()
+		if entry.Action == database.BlocklistQuarantine {
+			key, qerr := cfg.quarantineStreamedUpload(ctx, job, upload.key)
+			if qerr != nil {
+				err = fmt.Errorf("couldn't quarantine upload: %v: %w", qerr, err)
+			} else {
+				message += ", quarantined at " + key
+			}
+		}
+		cfg.deleteStreamedObject(job, upload.key)
+		cfg.recordJobEvent(job, stageScreening, database.EventContentBlocked, message)
+		return err
+	})
+	if err != nil {
+		return database.Video{}, cfg.finishJob(ctx, job, err)
+	}
+
+	err = cfg.runStage(job, stageUploading, func() error {
+		target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+		if err != nil {
+			return fmt.Errorf("couldn't resolve object store: %w", err)
+		}
+		if err := waitForObject(ctx, target, upload.key, upload.size); err != nil {
+			return fmt.Errorf("stored video isn't readable: %w", err)
+		}
+		if cfg.verifyStoredPlayback {
+			if err := cfg.verifyPlayback(ctx, target, []string{upload.key}, upload.probe.duration()); err != nil {
+				return err
+			}
+		}
+		if err := cfg.setVideoFile(ctx, job.VideoID, target.objectURL(upload.key)); err != nil {
+			return fmt.Errorf("couldn't update video URL: %w", err)
+		}
+		if err := cfg.db.UpdateVideoMediaInfo(job.VideoID, upload.probe.mediaInfo(upload.size)); err != nil {
+			return fmt.Errorf("couldn't update video media info: %w", err)
+		}
+		if err := cfg.db.UpdateVideoChecksums(job.VideoID, upload.storedSHA256, upload.sha256); err != nil {
+			return fmt.Errorf("couldn't update video checksums: %w", err)
+		}
+		if err := cfg.db.UpdateVideoOriginalFilename(job.VideoID, job.Filename); err != nil {
+			return fmt.Errorf("couldn't update video filename: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		cfg.deleteStreamedObject(job, upload.key)
+		return database.Video{}, cfg.finishJob(ctx, job, err)
+	}
+
+	if upload.report != nil {
+		if err := cfg.db.SaveMediaReport(job.VideoID, upload.report, upload.report); err != nil {
+			slog.WarnContext(ctx, "Couldn't save media report of video", "error", err)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return database.Video{}, cfg.finishJob(ctx, job, fmt.Errorf("couldn't get updated video: %w", err))
+	}
+	job.setCostEstimate(cfg.videoCost(video))
+	cfg.finishJob(ctx, job, nil)
+	return video, nil
+}
+
+// quarantineStreamedUpload copies a stored upload under the quarantine
+// prefix and returns the new key
+func (cfg *apiConfig) quarantineStreamedUpload(ctx context.Context, job *uploadJob, key string) (string, error) {
+	target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+	if err != nil {
+		return "", err
+	}
+	path, err := getFile(ctx, cfg.tempDir, target, key)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+	return cfg.quarantineUpload(ctx, job, path)
+}
+
+// deleteStreamedObject removes a streamed upload that won't be published
+func (cfg *apiConfig) deleteStreamedObject(job *uploadJob, key string) {
+	target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+	if err == nil {
+		err = target.store.Delete(context.Background(), key)
+	}
+	if err != nil {
+		log.Printf("Couldn't delete streamed upload %s: %v", key, err)
+	}
+}
+
+// processReceivedUpload processes an upload received into a temp file, or
+// finishes one that was streamed to the object store
+func (cfg *apiConfig) processReceivedUpload(ctx context.Context, upload receivedUpload) (video database.Video, err error) {
+	ctx, span := tracing.Start(ctx, "process upload", tracing.KindInternal,
+		tracing.String("upload.id", upload.job.ID.String()),
+		tracing.String("video.id", upload.job.VideoID.String()),
+		tracing.Int64("upload.size", upload.job.Size),
+	)
+	defer func() { span.End(err) }()
+	// a panic in processing fails the upload instead of the whole server
+	defer func() {
+		if p := recover(); p != nil {
+			err = cfg.reportPanic(ctx, p, errreport.Event{Tags: map[string]string{
+				"upload_id": upload.job.ID.String(),
+				"video_id":  upload.job.VideoID.String(),
+			}})
+			if upload.job.snapshot().FinishedAt == nil {
+				cfg.finishJob(ctx, upload.job, err)
+			}
+		}
+	}()
+
+	cfg.recordJobEvent(upload.job, stageReceiving, database.EventUploadReceived, "")
+	cfg.sendWebhookEvent(upload.job.VideoID, webhookVideoUploaded, nil)
+	if upload.streamed != nil {
+		return cfg.finishStreamedUpload(ctx, upload.job, *upload.streamed)
+	}
+	return cfg.processVideoUpload(ctx, upload.job, upload.tempPath)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+const (
+	// pipeProbeHead is how much of an upload is held in memory to probe
+	// before it's piped into ffmpeg. Matroska and WebM describe their
+	// tracks up front; a MOV with its moov at the end won't probe from
+	// this and goes through a temp file instead.
+	pipeProbeHead = 4 << 20
+	// pipeOutputHead is how much of ffmpeg's output is kept to probe the
+	// stored file; a fragmented MP4 describes its tracks in the first box
+	pipeOutputHead = 1 << 20
+)
+
+// readPipeHead reads up to pipeProbeHead bytes of r. A short read just means
+// the file is small.
+func readPipeHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, pipeProbeHead)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return head[:n], err
+}
+
+// transcodeUpload probes head, the start of an upload that has to be
+// transcoded, and if its tracks can be read from there, pipes head and the
+// rest of body through ffmpeg into the object store as a fragmented MP4,
+// without the upload or the transcoded file touching the disk. It returns
+// false without reading body if the upload has to go through a temp file.
+func (cfg *apiConfig) transcodeUpload(ctx context.Context, job *uploadJob, head []byte, body io.Reader, ext string) (streamedUpload, int64, bool, error) {
+	headPath, err := writeTempFile(cfg.tempDir, "tubely-head-*"+ext, head)
+	if err != nil {
+		return streamedUpload{}, 0, false, err
+	}
+	defer os.Remove(headPath)
+	inputProbe, err := probeVideo(ctx, cfg.media, headPath)
+	if err != nil {
+		return streamedUpload{}, 0, false, nil
+	}
+	prefix, err := inputProbe.storagePrefix()
+	if err != nil {
+		return streamedUpload{}, 0, false, nil
+	}
+
+	key, err := newVideoKey(cfg.keyLayout.Key(job.UserID, prefix))
+	if err != nil {
+		return streamedUpload{}, 0, false, err
+	}
+	target, err := cfg.storeFor(job.OrganizationID, job.StorageRegion)
+	if err != nil {
+		return streamedUpload{}, 0, false, fmt.Errorf("couldn't resolve object store: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := putStream(cfg.videoPutContext(ctx, objectOriginal), target, key, pr, "video/mp4")
+		// stop ffmpeg writing if the store gave up early
+		pr.CloseWithError(err)
+		uploaded <- err
+	}()
+
+	inputHash := sha256.New()
+	input := newProgressReader(io.TeeReader(io.MultiReader(bytes.NewReader(head), newContextReader(ctx, body)), inputHash), func(int64) {})
+	outputHash := sha256.New()
+	output := &byteCounter{}
+	outputHead := &headBuffer{limit: pipeOutputHead}
+	stats := &ffmpegStats{}
+	var stderr io.Writer = stats
+	if log := ffmpegLogFrom(ctx); log != nil {
+		stderr = io.MultiWriter(stats, log)
+	}
+	err = cfg.media.FFmpeg(ctx, pipeTranscodeArgs(), media.Options{
+		Stdin:  input,
+		Stdout: io.MultiWriter(pw, outputHash, output, outputHead),
+		Stderr: stderr,
+	})
+	pw.CloseWithError(err)
+	if uploadErr := <-uploaded; err == nil {
+		err = uploadErr
+	}
+	if err != nil {
+		cfg.deleteStreamedObject(job, key)
+		return streamedUpload{}, 0, false, err
+	}
+
+	// the stored file is described by its own first fragment, with the
+	// duration ffmpeg reached since a fragmented MP4 doesn't record it there
+	probe, report := inputProbe, json.RawMessage(nil)
+	if headPath, err := writeTempFile(cfg.tempDir, "tubely-head-*.mp4", outputHead.buf.Bytes()); err != nil {
+		slog.WarnContext(ctx, "Couldn't save head of transcoded upload", "video_id", job.VideoID, "error", err)
+	} else {
+		defer os.Remove(headPath)
+		if out, err := probeVideo(ctx, cfg.media, headPath); err != nil {
+			slog.WarnContext(ctx, "Couldn't probe transcoded upload", "video_id", job.VideoID, "error", err)
+		} else {
+			probe = out
+		}
+		report, err = probeMediaReport(ctx, cfg.media, headPath)
+		if err != nil {
+			slog.WarnContext(ctx, "Couldn't analyze transcoded upload", "video_id", job.VideoID, "error", err)
+		}
+	}
+	if duration := stats.duration(); duration > 0 {
+		probe.Format.Duration = strconv.FormatFloat(duration.Seconds(), 'f', -1, 64)
+		probe.Format.BitRate = strconv.FormatInt(int64(float64(output.n*8)/duration.Seconds()), 10)
+	}
+
+	return streamedUpload{
+		key:          key,
+		probe:        probe,
+		sha256:       hex.EncodeToString(inputHash.Sum(nil)),
+		storedSHA256: hex.EncodeToString(outputHash.Sum(nil)),
+		size:         output.n,
+		transcoded:   true,
+		report:       report,
+	}, input.read, true, nil
+}
+
+// pipeTranscodeArgs reads the upload from stdin and writes a fragmented MP4
+// to stdout, since a faststart MP4 needs to seek back in its output. A
+// hardware encoder that fails couldn't be retried with the input gone, so
+// this always uses libx264.
+func pipeTranscodeArgs() []string {
+	args := []string{
+		"-i", "pipe:0",
+		"-map", "0:v:0", "-map", "0:a:0?",
+	}
+	args = append(args, media.H264Args(media.EncoderLibx264, 23)...)
+	return append(args,
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"pipe:1",
+	)
+}
+
+// writeTempFile writes data to a new temp file in dir and returns its path;
+// the caller must remove it
+func writeTempFile(dir, pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// headBuffer keeps the first limit bytes written to it and drops the rest
+type headBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// ffmpegStatsTime matches the position in ffmpeg's status lines, like
+// "time=00:01:02.50"
+var ffmpegStatsTime = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// ffmpegStats keeps the last position ffmpeg reported on stderr, which once
+// it's done is the length of what it wrote. Status lines end with a carriage
+// return.
+type ffmpegStats struct {
+	mu      sync.Mutex
+	partial []byte
+	last    time.Duration
+}
+
+func (s *ffmpegStats) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexAny(s.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if m := ffmpegStatsTime.FindSubmatch(s.partial[:i]); m != nil {
+			hours, _ := strconv.Atoi(string(m[1]))
+			minutes, _ := strconv.Atoi(string(m[2]))
+			seconds, _ := strconv.ParseFloat(string(m[3]), 64)
+			s.last = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+		}
+		s.partial = s.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *ffmpegStats) duration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
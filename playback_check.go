@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+const (
+	// how much of each end of a stored video is decoded to check it plays
+	playbackCheckSpan = 2 * time.Second
+	// how long ffmpeg's presigned URL for the check stays valid
+	playbackCheckURLTTL = 10 * time.Minute
+)
+
+var errUnplayableOutput = errors.New("stored video doesn't play")
+
+// verifyPlayback decodes the start and the end of each stored video at keys,
+// which all last duration, reading them back from the store the way a
+// player would. A transcode that came out corrupt, or an object that was
+// cut short on the way in, fails here instead of reaching viewers.
+func (cfg *apiConfig) verifyPlayback(ctx context.Context, target storeTarget, keys []string, duration time.Duration) error {
+	starts := []time.Duration{0}
+	if duration > 2*playbackCheckSpan {
+		starts = append(starts, duration-playbackCheckSpan)
+	}
+	for _, key := range keys {
+		url, err := target.store.PresignGet(ctx, key, playbackCheckURLTTL)
+		if err != nil {
+			return fmt.Errorf("couldn't sign URL to check %s: %w", key, err)
+		}
+		for _, start := range starts {
+			if err := decodeSpan(ctx, cfg.media, url, start, playbackCheckSpan); err != nil {
+				return fmt.Errorf("%w: %s at %s: %v", errUnplayableOutput, key, start, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeSpan decodes every stream of the file at url from start for span,
+// failing on the first error ffmpeg runs into
+func decodeSpan(ctx context.Context, runner *media.Runner, url string, start, span time.Duration) error {
+	args := []string{
+		"-hide_banner",
+		"-v", "error",
+		"-xerror",
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(span.Seconds(), 'f', 3, 64),
+		"-i", url,
+		"-f", "null",
+		"-",
+	}
+	return runner.FFmpeg(ctx, args, media.Options{Stderr: ffmpegLogFrom(ctx)})
+}
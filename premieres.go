@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// premieres the background check finds start at most this late; pages
+	// and the countdown endpoint start them on time as they're asked for
+	premiereInterval       = 5 * time.Second
+	premiereBatch          = 50
+	premiereWebhookTimeout = 10 * time.Second
+)
+
+type premiereStatus string
+
+const (
+	premiereScheduled premiereStatus = "scheduled"
+	premiereLive      premiereStatus = "live"
+	premiereEnded     premiereStatus = "ended"
+)
+
+// premiereState says where a premiere is at now. While it's live, offset is
+// how far into the video every viewer should be, so they all watch in step.
+func premiereState(video database.Video, now time.Time) (status premiereStatus, offset time.Duration) {
+	if video.PremiereAt == nil || now.Before(*video.PremiereAt) {
+		return premiereScheduled, 0
+	}
+	elapsed := now.Sub(*video.PremiereAt)
+	if video.DurationSeconds != nil && elapsed.Seconds() >= *video.DurationSeconds {
+		return premiereEnded, 0
+	}
+	return premiereLive, elapsed
+}
+
+// handlerVideoPremiereSet schedules a video's premiere, hiding it from
+// everyone but its owner until then; a null premiere_at cancels it
+func (cfg *apiConfig) handlerVideoPremiereSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PremiereAt *time.Time `json:"premiere_at"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r, auth.ScopeVideosWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.PremiereAt != nil && !params.PremiereAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "premiere_at must be in the future", nil)
+		return
+	}
+	if video.PremiereAt != nil && !video.PremierePending {
+		respondWithError(w, http.StatusConflict, "The video has already premiered", nil)
+		return
+	}
+	// the video has to be ready to play the moment the premiere starts
+	if params.PremiereAt != nil && video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Upload the video before scheduling its premiere", nil)
+		return
+	}
+
+	if err := cfg.db.SetVideoPremiere(video.ID, params.PremiereAt); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't schedule premiere", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoPremiere is what a premiere page polls: the countdown before
+// it starts and, while it's live, the point in the video to play from.
+// Times come from the server's clock so viewers' clocks don't matter.
+func (cfg *apiConfig) handlerVideoPremiere(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID         uuid.UUID      `json:"video_id"`
+		Title           string         `json:"title"`
+		Description     string         `json:"description"`
+		ThumbnailURL    *string        `json:"thumbnail_url"`
+		PremiereAt      time.Time      `json:"premiere_at"`
+		ServerTime      time.Time      `json:"server_time"`
+		Status          premiereStatus `json:"status"`
+		StartsInSeconds float64        `json:"starts_in_seconds"`
+		// PlaybackOffsetSeconds is only set while the premiere is live
+		PlaybackOffsetSeconds *float64 `json:"playback_offset_seconds"`
+		DurationSeconds       *float64 `json:"duration_seconds"`
+	}
+
+	videoID, err := cfg.pathVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.PremiereAt == nil || video.DeletedAt != nil || video.ArchivedAt != nil {
+		respondWithError(w, http.StatusNotFound, "The video has no premiere", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	video = cfg.startDuePremiere(r.Context(), video, now)
+	status, offset := premiereState(video, now)
+	resp := response{
+		VideoID:         video.ID,
+		Title:           video.Title,
+		Description:     video.Description,
+		ThumbnailURL:    video.ThumbnailURL,
+		PremiereAt:      video.PremiereAt.UTC(),
+		ServerTime:      now,
+		Status:          status,
+		StartsInSeconds: max(video.PremiereAt.Sub(now).Seconds(), 0),
+		DurationSeconds: video.DurationSeconds,
+	}
+	if status == premiereLive {
+		seconds := offset.Seconds()
+		resp.PlaybackOffsetSeconds = &seconds
+	}
+	// a cached countdown is a wrong one
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// startDuePremiere starts the video's premiere if its time has come, so a
+// viewer who asks right on time isn't turned away while the background
+// check catches up. It returns the video as it is now.
+func (cfg *apiConfig) startDuePremiere(ctx context.Context, video database.Video, now time.Time) database.Video {
+	if !video.PremierePending || video.PremiereAt == nil || now.Before(*video.PremiereAt) {
+		return video
+	}
+	if _, err := cfg.startPremiere(ctx, video, now); err != nil {
+		log.Printf("Couldn't start premiere of video %s: %v", video.ID, err)
+		return video
+	}
+	// whether this call or another started it, it has started
+	video.PremierePending = false
+	return video
+}
+
+// startPremiere makes the video visible and announces it. Only the call
+// that flips it announces anything, so the owner and the webhook hear about
+// each premiere once however many instances race for it.
+func (cfg *apiConfig) startPremiere(ctx context.Context, video database.Video, now time.Time) (bool, error) {
+	started, err := cfg.db.StartPremiere(video.ID, now)
+	if err != nil || !started {
+		return false, err
+	}
+	cfg.recordVideoEvent(video.ID, "", database.EventPremiereStarted, fmt.Sprintf("premiered at %s", video.PremiereAt.UTC().Format(time.RFC3339)))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pushSendTimeout)
+		defer cancel()
+		cfg.pushToUser(ctx, video.UserID, uploadNotification{
+			Type:    database.EventPremiereStarted,
+			VideoID: video.ID.String(),
+			Title:   fmt.Sprintf("%q is premiering", video.Title),
+			Body:    "Your premiere has started.",
+		})
+		if cfg.premiereWebhookURL != "" {
+			if err := cfg.sendPremiereWebhook(ctx, video); err != nil {
+				log.Printf("Couldn't send premiere webhook for video %s: %v", video.ID, err)
+			}
+		}
+	}()
+	return true, nil
+}
+
+func (cfg *apiConfig) sendPremiereWebhook(ctx context.Context, video database.Video) error {
+	type payload struct {
+		Event      string    `json:"event"`
+		VideoID    uuid.UUID `json:"video_id"`
+		UserID     uuid.UUID `json:"user_id"`
+		Title      string    `json:"title"`
+		PremiereAt time.Time `json:"premiere_at"`
+		WatchURL   string    `json:"watch_url"`
+	}
+	dat, err := json.Marshal(payload{
+		Event:      "premiere.started",
+		VideoID:    video.ID,
+		UserID:     video.UserID,
+		Title:      video.Title,
+		PremiereAt: video.PremiereAt.UTC(),
+		WatchURL:   cfg.watchURL(video),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, premiereWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.premiereWebhookURL, bytes.NewReader(dat))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("webhook endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runPremiereStarter starts premieres whose time has come, so videos go
+// public on time even when nobody is waiting on the page
+func (cfg *apiConfig) runPremiereStarter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		videos, err := cfg.db.GetDuePremieres(now, premiereBatch)
+		if err != nil {
+			log.Printf("Couldn't load due premieres: %v", err)
+			continue
+		}
+		for _, video := range videos {
+			started, err := cfg.startPremiere(ctx, video, now)
+			if err != nil {
+				log.Printf("Couldn't start premiere of video %s: %v", video.ID, err)
+				continue
+			}
+			if started {
+				log.Printf("Started premiere of video %s", video.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+type previewFormat string
+
+const (
+	previewOff  previewFormat = "off"
+	previewWebP previewFormat = "webp"
+	previewGIF  previewFormat = "gif"
+)
+
+func (f previewFormat) contentType() string {
+	return "image/" + string(f)
+}
+
+// the hover preview is a short clip from the middle of the video, small
+// enough to load for every video in a grid
+const (
+	previewDuration = 3 * time.Second
+	previewFPS      = 10
+	previewMaxSize  = 320
+)
+
+func previewPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("previews/%s/", videoID)
+}
+
+func newPreviewKey(videoID uuid.UUID, format previewFormat) (string, error) {
+	randomHex := make([]byte, 16)
+	if _, err := rand.Read(randomHex); err != nil {
+		return "", fmt.Errorf("couldn't generate random hex: %w", err)
+	}
+	return fmt.Sprintf("%s%x.%s", previewPrefix(videoID), randomHex, format), nil
+}
+
+// generatePreview writes a looping animation of previewDuration taken from
+// the middle of the video at filePath, which lasts duration
+func generatePreview(ctx context.Context, runner *media.Runner, filePath string, duration time.Duration, format previewFormat, progress func(done time.Duration)) (string, error) {
+	ext := filepath.Ext(filePath)
+	outputFilePath := strings.TrimSuffix(filePath, ext) + ".preview." + string(format)
+
+	start := max(0, duration/2-previewDuration/2)
+	scale := fmt.Sprintf("fps=%d,scale=w=%d:h=%d:force_original_aspect_ratio=decrease", previewFPS, previewMaxSize, previewMaxSize)
+
+	args := []string{
+		"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+		"-t", fmt.Sprintf("%.3f", previewDuration.Seconds()),
+		"-i", filePath,
+		"-an",
+	}
+	if format == previewGIF {
+		// a palette made from the clip itself looks far better than the
+		// default one
+		args = append(args,
+			"-filter_complex", scale+",split[a][b];[a]palettegen[p];[b][p]paletteuse",
+			"-loop", "0",
+		)
+	} else {
+		args = append(args,
+			"-vf", scale,
+			"-c:v", "libwebp", "-quality", "75", "-loop", "0",
+		)
+	}
+	args = append(args,
+		"-progress", "pipe:1", "-nostats",
+		"-f", string(format),
+		outputFilePath,
+	)
+	if err := runFFmpeg(ctx, runner, args, progress); err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// principal is who a request is signed in as
+type principal struct {
+	UserID uuid.UUID
+	// Scopes restrict what the caller can do. An unscoped JWT has none and
+	// can do anything its user can; an API key can only do what they list.
+	Scopes []auth.Scope
+	// APIKeyID is the key the caller used, uuid.Nil for a JWT
+	APIKeyID uuid.UUID
+
+	userOnce sync.Once
+	user     *database.User
+	userErr  error
+}
+
+func (p *principal) viaAPIKey() bool {
+	return p.APIKeyID != uuid.Nil
+}
+
+// allows reports whether the caller can do something that needs scope
+func (p *principal) allows(scope auth.Scope) bool {
+	if p.viaAPIKey() {
+		return auth.HasScope(p.Scopes, scope)
+	}
+	return auth.Claims{UserID: p.UserID, Scopes: p.Scopes}.Allows(scope)
+}
+
+// loadUser returns the caller's user, loading it at most once per request
+// however many checks need it
+func (p *principal) loadUser(db database.Client) (*database.User, error) {
+	p.userOnce.Do(func() {
+		p.user, p.userErr = db.GetUser(p.UserID)
+	})
+	return p.user, p.userErr
+}
+
+// authResult is what authMiddleware made of a request's credentials. When
+// principal is nil, status, message and err are the response for handlers
+// that need someone signed in, unless there was no token at all.
+type authResult struct {
+	principal *principal
+	noToken   bool
+	status    int
+	message   string
+	err       error
+}
+
+type authKey struct{}
+
+// apiKeyUsedPrecision is how stale an API key's last use can get before
+// it's saved again, so busy keys don't write on every request
+const apiKeyUsedPrecision = time.Minute
+
+// authMiddleware checks the request's JWT or API key once, so handlers and
+// the middleware after it can ask who's calling without parsing the token
+// or looking the key up again. Requests it can't identify still go
+// through; handlers that need a user turn them away.
+func (cfg *apiConfig) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := cfg.identify(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, res)))
+	})
+}
+
+// requestAuth returns what authMiddleware found, checking the request on
+// the spot if it didn't go through it
+func (cfg *apiConfig) requestAuth(r *http.Request) *authResult {
+	if res, ok := r.Context().Value(authKey{}).(*authResult); ok {
+		return res
+	}
+	return cfg.identify(r)
+}
+
+// requestPrincipal returns who the request is signed in as, if anyone
+func (cfg *apiConfig) requestPrincipal(r *http.Request) (*principal, bool) {
+	p := cfg.requestAuth(r).principal
+	return p, p != nil
+}
+
+func (cfg *apiConfig) identify(r *http.Request) *authResult {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return &authResult{noToken: true, err: err}
+	}
+
+	if !auth.IsAPIKey(token) {
+		claims, err := auth.ParseJWT(token, cfg.jwtSecret)
+		if err != nil {
+			return &authResult{status: http.StatusUnauthorized, message: "Couldn't validate JWT", err: err}
+		}
+		return &authResult{principal: &principal{UserID: claims.UserID, Scopes: claims.Scopes}}
+	}
+
+	key, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(token))
+	if err != nil {
+		return &authResult{status: http.StatusInternalServerError, message: "Couldn't look up API key", err: err}
+	}
+	if key.ID == uuid.Nil || key.RevokedAt != nil {
+		return &authResult{status: http.StatusUnauthorized, message: "Invalid API key", err: errors.New("unknown or revoked API key")}
+	}
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyUsedPrecision {
+		if err := cfg.db.MarkAPIKeyUsed(key.ID); err != nil {
+			log.Printf("Couldn't update last use of API key %s: %v", key.ID, err)
+		}
+	}
+	scopes := make([]auth.Scope, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = auth.Scope(scope)
+	}
+	return &authResult{principal: &principal{UserID: key.UserID, Scopes: scopes, APIKeyID: key.ID}}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// progressReader reports how many bytes have been read through it. It passes
+// Seek through so the S3 client can still measure and rewind a file body; the
+// count follows the position but is only reported on the next read, so
+// seeking to the end to get the length doesn't show up as progress.
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	report func(read int64)
+}
+
+func newProgressReader(r io.Reader, report func(read int64)) *progressReader {
+	return &progressReader{r: r, report: report}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.report(p.read)
+	}
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := p.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("progressReader: underlying reader can't seek")
+	}
+	pos, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	p.read = pos
+	return pos, nil
+}
+
+// contextReader fails reads once ctx is done. Copying a body that's already
+// been received never blocks on the client, so without it a copy carries on
+// after the client has gone.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
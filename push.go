@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webpush"
+	"github.com/google/uuid"
+)
+
+const (
+	// uploads that finish quicker than this are still on the uploader's
+	// screen, so they don't get a notification
+	pushMinProcessing = 30 * time.Second
+	// a notification about an upload isn't worth much a day later
+	pushTTL         = 24 * time.Hour
+	pushSendTimeout = time.Minute
+)
+
+// uploadNotification is the message the service worker turns into a
+// browser notification
+type uploadNotification struct {
+	Type    database.EventType `json:"type"`
+	VideoID string             `json:"video_id"`
+	Title   string             `json:"title"`
+	Body    string             `json:"body"`
+}
+
+// notifyUploadFinished pushes the outcome of a long upload to every browser
+// its uploader subscribed. It runs in the background and only logs failures.
+func (cfg *apiConfig) notifyUploadFinished(job *uploadJob, eventType database.EventType, elapsed time.Duration, jobErr error) {
+	if cfg.webPush == nil || elapsed < pushMinProcessing {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pushSendTimeout)
+		defer cancel()
+
+		video, err := cfg.db.GetVideo(job.VideoID)
+		if err != nil {
+			log.Printf("Couldn't get video %s for push notification: %v", job.VideoID, err)
+			return
+		}
+
+		notification := uploadNotification{
+			Type:    eventType,
+			VideoID: job.VideoID.String(),
+			Title:   fmt.Sprintf("%q is ready", video.Title),
+			Body:    "Your video has finished processing.",
+		}
+		if jobErr != nil {
+			notification.Title = fmt.Sprintf("%q couldn't be processed", video.Title)
+			notification.Body = jobErr.Error()
+		}
+		cfg.pushToUser(ctx, job.UserID, notification)
+	}()
+}
+
+// pushToUser sends a notification to every browser the user subscribed,
+// only logging failures
+func (cfg *apiConfig) pushToUser(ctx context.Context, userID uuid.UUID, notification uploadNotification) {
+	if cfg.webPush == nil {
+		return
+	}
+	subscriptions, err := cfg.db.GetPushSubscriptions(userID)
+	if err != nil {
+		log.Printf("Couldn't get push subscriptions of user %s: %v", userID, err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("Couldn't encode push notification: %v", err)
+		return
+	}
+	if len(payload) > webpush.MaxPayload {
+		notification.Body = ""
+		payload, _ = json.Marshal(notification)
+	}
+
+	for _, s := range subscriptions {
+		err := cfg.webPush.Send(ctx, webpush.Subscription{
+			Endpoint: s.Endpoint,
+			P256dh:   s.P256dh,
+			Auth:     s.Auth,
+		}, payload, pushTTL)
+		if errors.Is(err, webpush.ErrGone) {
+			if _, err := cfg.db.DeletePushSubscription(s.UserID, s.Endpoint); err != nil {
+				log.Printf("Couldn't delete expired push subscription: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't send push notification to user %s: %v", userID, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// uploadRateLimitMiddleware limits uploads per client IP and per user. If
+// the limiter itself fails the request goes through, since a Redis outage
+// shouldn't take uploads down with it.
+func (cfg *apiConfig) uploadRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadIPLimit.Enabled() {
+			if !cfg.allowUpload(w, r, "uploads:ip:"+cfg.clientIP(r), cfg.uploadIPLimit) {
+				return
+			}
+		}
+		if cfg.uploadUserLimit.Enabled() {
+			// unauthenticated requests are only limited by IP, the handler
+			// rejects them anyway
+			if userID, ok := cfg.requestUserID(r); ok {
+				if !cfg.allowUpload(w, r, "uploads:user:"+userID.String(), cfg.uploadUserLimit) {
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) allowUpload(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	return cfg.allowRequest(w, r, key, limit, "Too many uploads, try again later")
+}
+
+// allowRequest takes a token from key's bucket, answering 429 with message
+// when it's empty
+func (cfg *apiConfig) allowRequest(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit, message string) bool {
+	allowed, wait, err := cfg.uploadLimiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		log.Printf("Rate limiter failed for %s, letting the request through: %v", key, err)
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, message, nil)
+	return false
+}
+
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	// behind a proxy the last address in its header is the one the proxy
+	// appended; the ones before it come from the client and can be made up
+	if cfg.rateLimitIPHeader != "" {
+		if values := r.Header.Values(cfg.rateLimitIPHeader); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestUserID identifies the caller without writing a response, for code
+// that only needs to know who is asking
+func (cfg *apiConfig) requestUserID(r *http.Request) (uuid.UUID, bool) {
+	if p, ok := cfg.requestPrincipal(r); ok {
+		return p.UserID, true
+	}
+	return uuid.Nil, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+)
+
+// reportPanic logs a recovered panic with its stack and sends it to the
+// error reporter, along with whatever event already says about where it
+// happened. It returns the panic as an error and must be called from the
+// deferred function that recovered.
+func (cfg *apiConfig) reportPanic(ctx context.Context, value any, event errreport.Event) error {
+	err := errreport.PanicError{Value: value}
+	event.Err = err
+	// skip reportPanic and the deferred function
+	event.Stack = errreport.Stack(2)
+	if event.Tags == nil {
+		event.Tags = map[string]string{}
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		event.Tags["request_id"] = id
+	}
+
+	slog.ErrorContext(ctx, "Recovered from panic", "error", err, "stack", errreport.FormatStack(event.Stack))
+	if cfg.errorReporter != nil {
+		cfg.errorReporter.Report(ctx, event)
+	}
+	return err
+}
+
+// recoverMiddleware turns a panic in a handler into a 500 with the request
+// ID, so one bad request is reported instead of taking its connection down
+// with nothing but a line in the log
+func (cfg *apiConfig) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// handlers panic with this on purpose to drop the connection
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(p)
+			}
+
+			cfg.reportPanic(r.Context(), p, errreport.Event{
+				Tags:   map[string]string{"route": r.Pattern},
+				Method: r.Method,
+				URL:    r.URL.String(),
+			})
+			// a handler that already started its response can only be cut off
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			type panicResponse struct {
+				Error     string `json:"error"`
+				RequestID string `json:"request_id"`
+			}
+			respondWithJSON(w, http.StatusInternalServerError, panicResponse{
+				Error:     "Internal server error",
+				RequestID: requestIDFrom(r.Context()),
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
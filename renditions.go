@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// renditionSpec is one step of the rendition ladder. Height is the short
+// side, so a 720p rendition of a portrait video is 720 pixels wide.
+type renditionSpec struct {
+	name   string
+	height int
+}
+
+// renderedRendition is a transcoded rendition waiting to be uploaded
+type renderedRendition struct {
+	spec   renditionSpec
+	path   string
+	width  int
+	height int
+}
+
+func renditionPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("renditions/%s/", videoID)
+}
+
+// parseRenditionLadder reads a list like "1080p,720p,480p", largest first
+func parseRenditionLadder(s string) ([]renditionSpec, error) {
+	var ladder []renditionSpec
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(field, "p"))
+		if err != nil || height < 2 || height%2 != 0 {
+			return nil, fmt.Errorf("%q isn't an even height like 720p", field)
+		}
+		spec := renditionSpec{name: strconv.Itoa(height) + "p", height: height}
+		if slices.Contains(ladder, spec) {
+			continue
+		}
+		ladder = append(ladder, spec)
+	}
+	slices.SortFunc(ladder, func(a, b renditionSpec) int { return b.height - a.height })
+	return ladder, nil
+}
+
+// renditionsFor returns the ladder steps that aren't larger than the video
+func (cfg *apiConfig) renditionsFor(probe FFProbeOutput) []renditionSpec {
+	stream, ok := probe.videoStream()
+	if !ok {
+		return nil
+	}
+	width, height := stream.displaySize()
+	short := min(width, height)
+
+	var specs []renditionSpec
+	for _, spec := range cfg.renditionLadder {
+		if spec.height <= short {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// transcodeRendition scales the video at filePath so its short side is
+// spec.height and writes it to a faststart MP4 next to it
+func transcodeRendition(ctx context.Context, runner *media.Runner, filePath string, spec renditionSpec, portrait bool, progress func(done time.Duration)) (string, error) {
+	ext := filepath.Ext(filePath)
+	outputFilePath := strings.TrimSuffix(filePath, ext) + "." + spec.name + ext
+
+	// -2 keeps the aspect ratio with an even size, which H.264 needs
+	scale := fmt.Sprintf("scale=-2:%d", spec.height)
+	if portrait {
+		scale = fmt.Sprintf("scale=%d:-2", spec.height)
+	}
+	buildArgs := func(videoArgs []string) []string {
+		args := []string{
+			"-i", filePath,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-vf", scale,
+		}
+		args = append(args, videoArgs...)
+		return append(args,
+			"-c:a", "aac", "-b:a", "128k",
+			"-movflags", "faststart",
+			"-progress", "pipe:1", "-nostats",
+			"-f", "mp4",
+			outputFilePath,
+		)
+	}
+	if err := runH264Encode(ctx, runner, 23, outputFilePath, buildArgs, progress); err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
+
+// putFile uploads the file at path to key, reporting how many bytes have
+// been sent, and returns its size
+func putFile(ctx context.Context, target storeTarget, key, path, contentType string, progress func(read int64)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := target.store.Put(ctx, key, newProgressReader(f, progress), contentType); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// how long removing what a failed upload stored may take
+const partialCleanupTimeout = 30 * time.Second
+
+// deletePartialObjects removes objects an upload stored before it failed.
+// The upload's context is often the reason it failed, so this uses its own.
+// Anything it misses is under the video's prefixes and goes when the video
+// does.
+func deletePartialObjects(target storeTarget, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), partialCleanupTimeout)
+	defer cancel()
+	for _, key := range keys {
+		if err := target.store.Delete(ctx, key); err != nil {
+			log.Printf("Couldn't delete %s left by a failed upload: %v", key, err)
+		}
+	}
+}
+
+// putVerifiedFile is putFile for a file whose hex SHA-256 is known. Stores
+// that can check it refuse the file if it arrives corrupted.
+func putVerifiedFile(ctx context.Context, target storeTarget, key, path, contentType, sum string, progress func(read int64)) (int64, error) {
+	checker, ok := target.store.(storage.ChecksumPutter)
+	if !ok {
+		return putFile(ctx, target, key, path, contentType, progress)
+	}
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := checker.PutWithChecksum(ctx, key, newProgressReader(f, progress), contentType, raw); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// putStream uploads body to key as it's read, without knowing its size
+// up front. Stores that can't stream get a plain Put.
+func putStream(ctx context.Context, target storeTarget, key string, body io.Reader, contentType string) error {
+	if streamer, ok := target.store.(storage.StreamPutter); ok {
+		return streamer.PutStream(ctx, key, body, contentType)
+	}
+	return target.store.Put(ctx, key, body, contentType)
+}
+
+// getFile downloads key to a new temp file in dir and returns its path; the
+// caller must remove it
+func getFile(ctx context.Context, dir string, target storeTarget, key string) (string, error) {
+	body, err := target.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp(dir, "tubely-object"+filepath.Ext(key))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// readableAttempts and readableBackoff bound how long waitForObject waits
+// for a stored object to show up; the backoff doubles after each miss
+const (
+	readableAttempts = 6
+	readableBackoff  = 250 * time.Millisecond
+)
+
+// waitForObject checks key can be read back from the store before anything
+// points at it, retrying while the store still reports it missing or, when
+// size is known, at the wrong size. S3 compatible stores don't all read
+// their own writes straight away, and a video mustn't be marked ready
+// before its URL works.
+func waitForObject(ctx context.Context, target storeTarget, key string, size int64) error {
+	backoff := readableBackoff
+	for attempt := 1; ; attempt++ {
+		info, err := target.store.Head(ctx, key)
+		switch {
+		case err == nil && (size <= 0 || info.Size == size):
+			return nil
+		case err == nil:
+			err = fmt.Errorf("object is %d bytes, expected %d", info.Size, size)
+		case !errors.Is(err, storage.ErrNotFound):
+			return err
+		}
+		if attempt == readableAttempts {
+			return fmt.Errorf("%s still not readable after %d attempts: %w", key, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func renditionPaths(renditions []renderedRendition) []string {
+	paths := make([]string, len(renditions))
+	for i, rendition := range renditions {
+		paths[i] = rendition.path
+	}
+	return paths
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const s3PermissionCheckTimeout = 30 * time.Second
+
+// s3PermissionUses says what breaks without each permission the server needs
+var s3PermissionUses = map[string]string{
+	"s3:PutObject":            "uploads of videos and thumbnails will fail with AccessDenied",
+	"s3:GetObject":            "processing steps that read stored videos and archive checks will fail",
+	"s3:ListBucket":           "deleting a video will leave its renditions, previews and sprites behind",
+	"s3:AbortMultipartUpload": "failed streamed uploads will leave incomplete parts in the bucket",
+	"s3:DeleteObject":         "deleted videos will stay in the bucket",
+}
+
+// logS3PermissionCheck tries the S3 actions the server relies on and warns
+// about each one the credentials are missing, and about any it doesn't need
+func logS3PermissionCheck(store *storage.S3Store, bucket string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3PermissionCheckTimeout)
+	defer cancel()
+
+	needed, extra := store.CheckPermissions(ctx)
+	missing := 0
+	for _, check := range needed {
+		switch {
+		case check.Err != nil:
+			log.Printf("Couldn't check %s on bucket %s: %v", check.Action, bucket, check.Err)
+		case !check.Allowed:
+			missing++
+			log.Printf("WARNING: missing %s on bucket %s, %s", check.Action, bucket, s3PermissionUses[check.Action])
+		}
+	}
+	for _, check := range extra {
+		if check.Err == nil && check.Allowed {
+			log.Printf("The S3 credentials allow %s, which the server never uses; consider a policy limited to bucket %s", check.Action, bucket)
+		}
+	}
+	if missing == 0 {
+		log.Printf("S3 permission check passed for bucket %s", bucket)
+	}
+}
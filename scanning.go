@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+)
+
+// scanAction is what happens to an upload the scanner finds malware in
+type scanAction string
+
+const (
+	// scanReject throws the upload away
+	scanReject scanAction = "reject"
+	// scanQuarantine keeps a copy under the video's quarantine prefix for
+	// review, like a blocklist entry's quarantine action does
+	scanQuarantine scanAction = "quarantine"
+)
+
+var errMalware = errors.New("upload contains malware")
+
+// malwareFound is the error an upload fails with when the scanner finds
+// something in it
+type malwareFound struct {
+	threat string
+}
+
+func (m *malwareFound) Error() string {
+	return fmt.Sprintf("%s: %s", errMalware, m.threat)
+}
+
+func (m *malwareFound) Unwrap() error { return errMalware }
+
+// scanBody runs the scanner over body. It returns nil without a scanner.
+func (cfg *apiConfig) scanBody(ctx context.Context, body io.Reader) (*database.ScanResult, error) {
+	if cfg.scanner == nil {
+		return nil, nil
+	}
+	result, err := cfg.scanner.Scan(ctx, body)
+	if errors.Is(err, scan.ErrTooLarge) {
+		return nil, fmt.Errorf("couldn't scan upload, it's larger than the scanner accepts: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't scan upload: %w", err)
+	}
+	scanned := &database.ScanResult{
+		Status:    database.ScanClean,
+		Scanner:   cfg.scanner.Name(),
+		ScannedAt: time.Now().UTC(),
+	}
+	if result.Infected {
+		scanned.Status = database.ScanInfected
+		scanned.Threat = result.Threat
+	}
+	return scanned, nil
+}
+
+// scanUpload scans a received video before anything else reads it,
+// ffprobe included, and records the result on the video. An infected
+// upload fails with a *malwareFound, after being quarantined if that's
+// the configured action.
+func (cfg *apiConfig) scanUpload(ctx context.Context, job *uploadJob, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	result, err := cfg.scanBody(ctx, newProgressReader(f, func(read int64) {
+		job.setProgress(stageScanning, read, job.Size)
+	}))
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.UpdateVideoScan(job.VideoID, result); err != nil {
+		return fmt.Errorf("couldn't record scan result: %w", err)
+	}
+	if result == nil || result.Status != database.ScanInfected {
+		return nil
+	}
+
+	err = &malwareFound{threat: result.Threat}
+	message := err.Error()
+	if cfg.scanAction == scanQuarantine {
+		key, qerr := cfg.quarantineUpload(ctx, job, path)
+		if qerr != nil {
+			err = fmt.Errorf("couldn't quarantine upload: %v: %w", qerr, err)
+		} else {
+			message += ", quarantined at " + key
+		}
+	}
+	cfg.recordJobEvent(job, stageScanning, database.EventContentBlocked, message)
+	return err
+}
+
+// scanThumbnail scans an uploaded thumbnail before it's decoded. It writes
+// the error response itself and returns false if the thumbnail can't be
+// used; otherwise it returns the result to record once the thumbnail is
+// saved.
+func (cfg *apiConfig) scanThumbnail(w http.ResponseWriter, r *http.Request, video database.Video, data []byte) (*database.ScanResult, bool) {
+	result, err := cfg.scanBody(r.Context(), bytes.NewReader(data))
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't scan thumbnail, try again later", err)
+		return nil, false
+	}
+	if result == nil || result.Status != database.ScanInfected {
+		return result, true
+	}
+
+	found := &malwareFound{threat: result.Threat}
+	message := found.Error()
+	if cfg.scanAction == scanQuarantine {
+		key, err := cfg.quarantineThumbnail(r.Context(), video, data)
+		if err != nil {
+			message += ", couldn't quarantine: " + err.Error()
+		} else {
+			message += ", quarantined at " + key
+		}
+	}
+	cfg.recordVideoEvent(video.ID, stageScanning, database.EventContentBlocked, "thumbnail "+message)
+	if err := cfg.db.UpdateThumbnailScan(video.ID, result); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record scan result", err)
+		return nil, false
+	}
+	respondWithError(w, http.StatusUnprocessableEntity, "The thumbnail failed the malware scan", found)
+	return nil, false
+}
+
+func (cfg *apiConfig) quarantineThumbnail(ctx context.Context, video database.Video, data []byte) (string, error) {
+	target, err := cfg.storeFor(video.OrganizationID, video.StorageRegion)
+	if err != nil {
+		return "", err
+	}
+	key, err := cfg.quarantineKey(video.UserID, video.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := target.store.Put(ctx, key, bytes.NewReader(data), "application/octet-stream"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
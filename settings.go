@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/scan"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+// settings is everything the server can be configured with, read and
+// checked before anything is started
+type settings struct {
+	logFormat string
+	logLevel  string
+
+	sentryDSN         string
+	sentryEnvironment string
+	metricsToken      string
+	trace             tracing.ExporterConfig
+	tracingOn         bool
+
+	dbPath       string
+	jwtSecret    string
+	platform     string
+	filepathRoot string
+	assetsRoot   string
+	port         string
+
+	storageBackend     string
+	s3Bucket           string
+	s3Region           string
+	s3CfDistribution   string
+	s3Endpoint         string
+	storageRegions     string
+	s3CheckPermissions bool
+	s3Retry            storage.RetryPolicy
+	keyLayout          storage.KeyLayout
+
+	cfKeyPairID      string
+	cfPrivateKeyPath string
+	vapidKeyPath     string
+	vapidSubject     string
+	playbackURLTTL   time.Duration
+	secretBox        *secrets.Box
+
+	blankVideoMode       blankVideoMode
+	mediaTypes           mediaTypeRegistry
+	uploadLimits         map[mediaKind]uploadLimit
+	thumbnail            thumbnailOptions
+	previewFormat        previewFormat
+	listEnvelope         bool
+	spriteInterval       time.Duration
+	bakeVideoRotation    bool
+	uploadPassthrough    bool
+	uploadPipeTranscode  bool
+	verifyStoredPlayback bool
+	strictUploadLength   bool
+	renditionLadder      []renditionSpec
+	pricing              storagePricing
+
+	uploadBlackouts        []uploadWindow
+	uploadDrainConcurrency int
+
+	linkCheckInterval   time.Duration
+	linkCheckSampleSize int
+	linkCheckAlertURL   string
+
+	premiereWebhookURL string
+
+	blocklistDistance int
+	scanner           scan.Scanner
+	scanAction        scanAction
+
+	contentModerator        string
+	moderationURL           string
+	moderationRegion        string
+	moderationMinConfidence float64
+	moderationFrames        int
+
+	media   media.Config
+	hwAccel string
+
+	tempDir        string
+	minFreeDisk    int64
+	tempFileMaxAge time.Duration
+	loadShedding   loadShedding
+
+	archiveStorageClass string
+	storageClasses      storageClasses
+	lifecycle           storage.LifecyclePolicy
+	trashRetention      time.Duration
+	shutdownTimeout     time.Duration
+	stuckVideoExpiry    time.Duration
+	changeFeedRetention time.Duration
+	shortIDLength       int
+	maxPinnedVideos     int
+	adminEmails         map[string]bool
+
+	uploadUserLimit   ratelimit.Limit
+	uploadIPLimit     ratelimit.Limit
+	commentUserLimit  ratelimit.Limit
+	rateLimitRedisURL string
+	rateLimitIPHeader string
+
+	publicBaseURL      string
+	ssoBaseURL         string
+	googleClientID     string
+	googleClientSecret string
+	githubClientID     string
+	githubClientSecret string
+}
+
+// loadSettings reads every setting, recording problems in env rather than
+// stopping at the first
+func loadSettings(env *config.Loader) settings {
+	s := settings{
+		logFormat:         env.Getenv("LOG_FORMAT"),
+		logLevel:          env.Getenv("LOG_LEVEL"),
+		sentryDSN:         env.Getenv("SENTRY_DSN"),
+		sentryEnvironment: env.Getenv("SENTRY_ENVIRONMENT"),
+		metricsToken:      env.Getenv("METRICS_TOKEN"),
+
+		dbPath:       env.Required("DB_PATH"),
+		jwtSecret:    env.Required("JWT_SECRET"),
+		platform:     env.Required("PLATFORM"),
+		filepathRoot: env.Required("FILEPATH_ROOT"),
+		assetsRoot:   env.Required("ASSETS_ROOT"),
+		port:         env.Required("PORT"),
+	}
+	if s.port != "" {
+		if n, err := strconv.Atoi(s.port); err != nil || n < 1 || n > 65535 {
+			env.Failf("PORT must be between 1 and 65535, got %q", s.port)
+		}
+	}
+	if _, err := newLogger(s.logFormat, s.logLevel); err != nil {
+		env.Fail(err)
+	}
+
+	// spans are only recorded when an OTLP endpoint is configured
+	var err error
+	s.trace, s.tracingOn, err = tracing.ConfigFromEnv(env.Getenv)
+	if err != nil {
+		env.Fail(err)
+	}
+
+	s.storageBackend = env.OneOf("STORAGE_BACKEND", "s3", "s3", "local")
+	// organizations can bring their own bucket whatever the backend, so the
+	// retry policy is read either way
+	s.s3Retry = storage.RetryPolicy{
+		MaxAttempts:     env.Int("S3_MAX_ATTEMPTS", 5, 1, 20),
+		MaxBackoff:      env.Duration("S3_MAX_BACKOFF", 20*time.Second, time.Millisecond),
+		BreakerFailures: env.Int("S3_BREAKER_FAILURES", 5, 0, 1000),
+		BreakerCooldown: env.Duration("S3_BREAKER_COOLDOWN", 30*time.Second, time.Second),
+	}
+	s.storageRegions = env.Getenv("STORAGE_REGIONS")
+	layout, err := storage.ParseKeyLayout(env.Getenv("OBJECT_KEY_LAYOUT"))
+	if err != nil {
+		env.Invalid("OBJECT_KEY_LAYOUT", err)
+	}
+	s.keyLayout = layout
+	switch s.storageBackend {
+	case "s3":
+		s.s3Bucket = env.Required("S3_BUCKET")
+		s.s3Region = env.Required("S3_REGION")
+		s.s3CfDistribution = env.Required("S3_CF_DISTRO")
+		// S3_ENDPOINT points the client at an S3-compatible server such as MinIO
+		s.s3Endpoint = env.Getenv("S3_ENDPOINT")
+		// only the syntax is checked here, the buckets are set up later
+		if _, err := parseStorageRegions(s.storageRegions, aws.Config{}, s.s3Endpoint, nil); err != nil {
+			env.Invalid("STORAGE_REGIONS", err)
+		}
+		// the check writes and deletes a small probe object, so it can be
+		// turned off for buckets where that isn't wanted
+		s.s3CheckPermissions = env.Bool("S3_CHECK_PERMISSIONS", true)
+	case "local":
+		if s.storageRegions != "" {
+			env.Failf("STORAGE_REGIONS needs STORAGE_BACKEND=s3")
+		}
+	}
+
+	// signed playback URLs are optional; without a key pair the play endpoint
+	// presigns the object directly
+	s.cfKeyPairID = env.Getenv("CF_KEY_PAIR_ID")
+	s.cfPrivateKeyPath = env.Getenv("CF_PRIVATE_KEY_PATH")
+	if (s.cfKeyPairID == "") != (s.cfPrivateKeyPath == "") {
+		env.Failf("CF_KEY_PAIR_ID and CF_PRIVATE_KEY_PATH must be set together")
+	}
+	// browser notifications need a VAPID key pair to identify the server to
+	// push services
+	s.vapidKeyPath = env.Getenv("VAPID_PRIVATE_KEY_PATH")
+	s.vapidSubject = env.Getenv("VAPID_SUBJECT")
+	if (s.vapidKeyPath == "") != (s.vapidSubject == "") {
+		env.Failf("VAPID_PRIVATE_KEY_PATH and VAPID_SUBJECT must be set together")
+	}
+	s.playbackURLTTL = env.Duration("PLAYBACK_URL_TTL", 15*time.Minute, time.Nanosecond)
+
+	// organizations can bring their own bucket; their credentials are
+	// encrypted with this key before they're stored
+	if key := env.Getenv("STORAGE_CREDENTIALS_KEY"); key != "" {
+		s.secretBox, err = secrets.NewBox(key)
+		if err != nil {
+			env.Invalid("STORAGE_CREDENTIALS_KEY", err)
+		}
+	}
+
+	s.blankVideoMode = blankVideoMode(env.OneOf("BLANK_VIDEO_DETECTION", string(blankVideoFlag),
+		string(blankVideoOff), string(blankVideoFlag), string(blankVideoWarn)))
+
+	s.mediaTypes, err = newMediaTypeRegistry(env.Getenv("ACCEPTED_MEDIA_TYPES"))
+	if err != nil {
+		env.Invalid("ACCEPTED_MEDIA_TYPES", err)
+	}
+	s.uploadLimits = map[mediaKind]uploadLimit{
+		// videos are streamed from the form, never parsed into memory
+		mediaKindVideo: {
+			maxBytes: int64(env.Int("MAX_VIDEO_UPLOAD_MB", 1024, 1, math.MaxInt)) << 20,
+		},
+		// the whole image is decoded in memory, so don't take just anything
+		mediaKindImage: {
+			maxBytes:        int64(env.Int("MAX_THUMBNAIL_UPLOAD_MB", 20, 1, math.MaxInt)) << 20,
+			multipartMemory: int64(env.Int("THUMBNAIL_MULTIPART_MEMORY_MB", 10, 0, math.MaxInt)) << 20,
+		},
+	}
+
+	s.thumbnail = thumbnailOptions{
+		format:  thumbnailFormat(env.OneOf("THUMBNAIL_FORMAT", string(thumbnailJPEG), string(thumbnailJPEG), string(thumbnailWebP))),
+		quality: env.Int("THUMBNAIL_QUALITY", 85, 1, 100),
+		crop:    env.Bool("THUMBNAIL_CROP", false),
+	}
+	s.previewFormat = previewFormat(env.OneOf("PREVIEW_FORMAT", string(previewWebP),
+		string(previewOff), string(previewWebP), string(previewGIF)))
+	s.listEnvelope = env.Bool("LIST_ENVELOPE", true)
+	// 0 turns sprites off
+	s.spriteInterval = env.Duration("SPRITE_INTERVAL", 10*time.Second, 0)
+	s.bakeVideoRotation = env.Bool("BAKE_VIDEO_ROTATION", false)
+	s.uploadPassthrough = env.Bool("UPLOAD_PASSTHROUGH", false)
+	s.uploadPipeTranscode = env.Bool("UPLOAD_PIPE_TRANSCODE", false)
+	if s.uploadPipeTranscode && !s.uploadPassthrough {
+		env.Invalid("UPLOAD_PIPE_TRANSCODE", errors.New("needs UPLOAD_PASSTHROUGH"))
+	}
+	s.verifyStoredPlayback = env.Bool("VERIFY_STORED_PLAYBACK", true)
+	s.strictUploadLength = env.Bool("STRICT_UPLOAD_LENGTH", false)
+	s.renditionLadder, err = parseRenditionLadder(env.Getenv("RENDITION_LADDER"))
+	if err != nil {
+		env.Invalid("RENDITION_LADDER", err)
+	}
+
+	// defaults are S3 Standard and data transfer out in us-east-1
+	s.pricing = storagePricing{
+		storagePerGBMonth: env.Float("STORAGE_PRICE_PER_GB_MONTH", 0.023, 0),
+		deliveryPerGB:     env.Float("DELIVERY_PRICE_PER_GB", 0.09, 0),
+		monthlyViews:      env.Int("COST_ESTIMATE_MONTHLY_VIEWS", 100, 0, math.MaxInt),
+	}
+
+	s.uploadBlackouts, err = parseUploadWindows(env.Getenv("UPLOAD_BLACKOUT_WINDOWS"))
+	if err != nil {
+		env.Invalid("UPLOAD_BLACKOUT_WINDOWS", err)
+	}
+	s.uploadDrainConcurrency = env.Int("UPLOAD_DRAIN_CONCURRENCY", 2, 1, math.MaxInt)
+
+	// the link checker only runs when an interval is configured
+	s.linkCheckInterval = env.Duration("LINK_CHECK_INTERVAL", 0, time.Nanosecond)
+	s.linkCheckSampleSize = env.Int("LINK_CHECK_SAMPLE_SIZE", 50, 1, math.MaxInt)
+	s.linkCheckAlertURL = env.Getenv("LINK_CHECK_ALERT_URL")
+	s.premiereWebhookURL = env.Getenv("PREMIERE_WEBHOOK_URL")
+
+	s.blocklistDistance = env.Int("BLOCKLIST_PERCEPTUAL_DISTANCE", 8, 0, 64)
+
+	// uploads are only scanned for malware when a scanner is configured
+	switch env.OneOf("MALWARE_SCANNER", "off", "off", "clamd") {
+	case "clamd":
+		s.scanner = scan.NewClamd(env.Required("CLAMD_ADDRESS"), env.Duration("MALWARE_SCAN_TIMEOUT", 10*time.Minute, 0))
+	}
+	s.scanAction = scanAction(env.OneOf("MALWARE_SCAN_ACTION", string(scanReject), string(scanReject), string(scanQuarantine)))
+
+	// with a content moderator, uploads stay hidden until they're approved
+	s.contentModerator = env.OneOf("CONTENT_MODERATOR", "off", "off", "rekognition", "http")
+	switch s.contentModerator {
+	case "rekognition":
+		s.moderationRegion = env.String("MODERATION_REGION", s.s3Region)
+		if s.moderationRegion == "" {
+			s.moderationRegion = env.Required("MODERATION_REGION")
+		}
+	case "http":
+		s.moderationURL = env.Required("MODERATION_URL")
+	}
+	s.moderationMinConfidence = env.Float("MODERATION_MIN_CONFIDENCE", 80, 0)
+	s.moderationFrames = env.Int("MODERATION_FRAMES", 5, 1, 100)
+
+	s.media = media.Config{
+		FFmpegPath:    env.Getenv("FFMPEG_PATH"),
+		FFprobePath:   env.Getenv("FFPROBE_PATH"),
+		MaxConcurrent: env.Int("FFMPEG_MAX_PROCESSES", runtime.NumCPU(), 0, math.MaxInt),
+		// 0 means no limit
+		Timeout: env.Duration("FFMPEG_TIMEOUT", 2*time.Hour, 0),
+	}
+	checkProgram(env, "FFMPEG_PATH", s.media.FFmpegPath, "ffmpeg")
+	checkProgram(env, "FFPROBE_PATH", s.media.FFprobePath, "ffprobe")
+	s.hwAccel = env.OneOf("FFMPEG_HWACCEL", "off",
+		"off", "auto", media.EncoderNVENC, media.EncoderQSV, media.EncoderVideoToolbox)
+
+	s.tempDir = env.String("TEMP_DIR", os.TempDir())
+	s.minFreeDisk = int64(env.Int("MIN_FREE_DISK_MB", 1024, 0, math.MaxInt)) << 20
+	s.tempFileMaxAge = env.Duration("TEMP_FILE_MAX_AGE", 24*time.Hour, time.Nanosecond)
+	// with no cap on ffmpeg processes nothing waits, so the queue can't be
+	// over
+	s.loadShedding = loadShedding{
+		maxFFmpegQueue: env.Int("LOAD_SHED_FFMPEG_QUEUE", 4*s.media.MaxConcurrent, 0, math.MaxInt),
+		maxDiskUsage:   env.Float("LOAD_SHED_DISK_PERCENT", 90, 0) / 100,
+		maxMemory:      uint64(env.Int("LOAD_SHED_MEMORY_MB", 0, 0, math.MaxInt)) << 20,
+	}
+
+	// the storage class archived videos are moved to; empty leaves them where
+	// they are
+	s.archiveStorageClass = "STANDARD_IA"
+	if class, ok := env.Lookup("ARCHIVE_STORAGE_CLASS"); ok {
+		s.archiveStorageClass = class
+	}
+	// empty puts new files in the bucket's default class
+	s.storageClasses = storageClasses{
+		originals:  env.Getenv("STORAGE_CLASS_ORIGINALS"),
+		renditions: env.Getenv("STORAGE_CLASS_RENDITIONS"),
+	}
+	// how long deleted videos can be restored from the trash
+	s.trashRetention = env.Duration("TRASH_RETENTION", 30*24*time.Hour, 0)
+	s.lifecycle = storage.LifecyclePolicy{
+		ColdOriginalsAfter: env.Duration("LIFECYCLE_COLD_ORIGINALS_AFTER", 0, 0),
+		ColdStorageClass:   env.String("LIFECYCLE_COLD_STORAGE_CLASS", "GLACIER"),
+		ExpireTrash:        env.Bool("LIFECYCLE_EXPIRE_TRASH", false),
+		TrashRetention:     s.trashRetention,
+	}
+	// how long a shutdown waits for uploads to finish before canceling them
+	s.shutdownTimeout = env.Duration("SHUTDOWN_TIMEOUT", 2*time.Minute, 0)
+	s.stuckVideoExpiry = env.Duration("STUCK_VIDEO_EXPIRY", 7*24*time.Hour, 0)
+	s.changeFeedRetention = env.Duration("CHANGE_FEED_RETENTION", 30*24*time.Hour, 0)
+	s.shortIDLength, err = parseVideoIDFormat(env.Getenv("VIDEO_ID_FORMAT"), env.Getenv("VIDEO_SHORT_ID_LENGTH"))
+	if err != nil {
+		env.Fail(err)
+	}
+	s.maxPinnedVideos = env.Int("MAX_PINNED_VIDEOS", 3, 0, math.MaxInt)
+
+	s.adminEmails = map[string]bool{}
+	for _, email := range strings.Split(env.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			s.adminEmails[strings.ToLower(email)] = true
+		}
+	}
+
+	// uploads per minute, 0 turns a limit off
+	s.uploadUserLimit = ratelimit.PerMinute(env.Int("UPLOAD_RATE_LIMIT_PER_USER", 10, 0, math.MaxInt))
+	s.uploadIPLimit = ratelimit.PerMinute(env.Int("UPLOAD_RATE_LIMIT_PER_IP", 30, 0, math.MaxInt))
+	// comments per minute per user, 0 turns the limit off
+	s.commentUserLimit = ratelimit.PerMinute(env.Int("COMMENT_RATE_LIMIT_PER_USER", 10, 0, math.MaxInt))
+	// share limits between instances through Redis if it's configured
+	s.rateLimitRedisURL = env.Getenv("RATE_LIMIT_REDIS_URL")
+	s.rateLimitIPHeader = env.Getenv("RATE_LIMIT_IP_HEADER")
+
+	// where the server is reachable from outside, for links in sitemaps and
+	// feeds
+	s.publicBaseURL = strings.TrimSuffix(env.String("PUBLIC_BASE_URL", "http://localhost:"+s.port), "/")
+	// providers redirect back to SSO_BASE_URL/api/auth/{provider}/callback
+	s.ssoBaseURL = strings.TrimSuffix(env.String("SSO_BASE_URL", s.publicBaseURL), "/")
+	s.googleClientID = env.Getenv("GOOGLE_CLIENT_ID")
+	s.googleClientSecret = env.Getenv("GOOGLE_CLIENT_SECRET")
+	s.githubClientID = env.Getenv("GITHUB_CLIENT_ID")
+	s.githubClientSecret = env.Getenv("GITHUB_CLIENT_SECRET")
+	return s
+}
+
+// checkProgram records a problem if the program setting names, or def when
+// it isn't set, can't be run
+func checkProgram(env *config.Loader, name, path, def string) {
+	if path == "" {
+		path = def
+	}
+	if _, err := exec.LookPath(path); err != nil {
+		env.Failf("%s: %s can't be run: %v", name, path, err)
+	}
+}
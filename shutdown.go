@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+// shutdownGrace is how long canceled uploads get to stop their ffmpeg
+// processes, abort their S3 multipart uploads and remove their temp files
+const shutdownGrace = 15 * time.Second
+
+// shutdown stops the server without leaving half-done uploads behind. New
+// uploads are turned away while the ones in flight, v1 requests and v2
+// background jobs alike, get until timeout to finish. Anything still running
+// then is canceled, which kills its ffmpeg processes and aborts streamed S3
+// uploads, and is left awaiting an upload. Temp files left over are removed,
+// all of them only if every upload stopped, and buffered spans sent last.
+func (cfg *apiConfig) shutdown(srv *http.Server, cancelRequests context.CancelFunc, timeout time.Duration) {
+	cfg.jobs.close()
+	active := cfg.jobs.active()
+	slog.Info("Shutting down", "timeout", timeout,
+		"uploads_queued", active[jobStatusQueued], "uploads_running", active[jobStatusRunning])
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// with every upload finished or given up no temp file is in use, but
+	// ones still stopping may yet be using theirs
+	maxTempAge := time.Duration(0)
+	// Shutdown waits for requests, including v1 uploads, but not for v2 jobs
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = cfg.jobs.waitIdle(ctx)
+	}
+	if err != nil {
+		canceled := cfg.jobs.cancelUnfinished()
+		cancelRequests()
+		slog.Warn("Uploads didn't finish in time, canceled them", "canceled", canceled)
+
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancelGrace()
+		if err := cfg.jobs.waitIdle(graceCtx); err != nil {
+			slog.Warn("Canceled uploads didn't stop in time", "error", err)
+			maxTempAge = cfg.tempFileMaxAge
+		}
+	}
+	srv.Close()
+
+	removed, err := removeStaleTempFiles(cfg.tempDir, maxTempAge)
+	if err != nil {
+		slog.Warn("Couldn't clean up temp files", "error", err)
+	} else if removed > 0 {
+		slog.Info("Removed leftover temp files", "count", removed)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	tracing.Flush(flushCtx)
+	slog.Info("Shut down")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at
+const sniffLen = 512
+
+type videoContainer string
+
+const (
+	containerISOBMFF  videoContainer = "isobmff"
+	containerMatroska videoContainer = "matroska"
+	containerAVI      videoContainer = "avi"
+)
+
+// top-level boxes an old QuickTime file may start with instead of ftyp
+var quickTimeLeadingBoxes = [][]byte{
+	[]byte("moov"), []byte("mdat"), []byte("wide"), []byte("free"), []byte("skip"),
+}
+
+// readSniffHeader reads the start of f and rewinds it
+func readSniffHeader(f io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+func sniffVideoContainer(header []byte) (videoContainer, bool) {
+	switch {
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		// the box size has to at least cover the major brand and version
+		if binary.BigEndian.Uint32(header[0:4]) >= 16 {
+			return containerISOBMFF, true
+		}
+	case len(header) >= 8 && isQuickTimeLeadingBox(header[4:8]):
+		return containerISOBMFF, true
+	case bytes.HasPrefix(header, []byte("\x1A\x45\xDF\xA3")):
+		return containerMatroska, true
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("AVI ")):
+		return containerAVI, true
+	}
+	return "", false
+}
+
+func isQuickTimeLeadingBox(boxType []byte) bool {
+	for _, b := range quickTimeLeadingBoxes {
+		if bytes.Equal(boxType, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerValidator makes sure a file declared as the given content type
+// really is the container it should be
+func containerValidator(declared string, want videoContainer) func(header []byte) error {
+	return func(header []byte) error {
+		got, ok := sniffVideoContainer(header)
+		if !ok || got != want {
+			return fmt.Errorf("file content doesn't match its Content-Type: declared %s, detected %s", declared, http.DetectContentType(header))
+		}
+		return nil
+	}
+}
+
+func imageValidator(declared string) func(header []byte) error {
+	return func(header []byte) error {
+		detected := http.DetectContentType(header)
+		if detected != declared {
+			return fmt.Errorf("file content doesn't match its Content-Type: declared %s, detected %s", declared, detected)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// seek-bar previews are tiles of this size, letterboxed so portrait videos
+// line up too, laid out spriteColumns to a row
+const (
+	spriteTileWidth  = 160
+	spriteTileHeight = 90
+	spriteColumns    = 10
+	// long videos get a longer interval so the sheet stays a sane size
+	maxSpriteFrames = 400
+)
+
+// spriteSheet is a generated sprite image and the interval between its frames
+type spriteSheet struct {
+	path     string
+	interval time.Duration
+	frames   int
+}
+
+func spritePrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("sprites/%s/", videoID)
+}
+
+func newSpriteKey(prefix, ext string) (string, error) {
+	randomHex := make([]byte, 16)
+	if _, err := rand.Read(randomHex); err != nil {
+		return "", fmt.Errorf("couldn't generate random hex: %w", err)
+	}
+	return fmt.Sprintf("%s%x.%s", prefix, randomHex, ext), nil
+}
+
+// generateSpriteSheet tiles a frame from every interval of the video at
+// filePath, which lasts duration, into one JPEG
+func generateSpriteSheet(ctx context.Context, runner *media.Runner, filePath string, duration, interval time.Duration, progress func(done time.Duration)) (spriteSheet, error) {
+	if duration <= 0 {
+		return spriteSheet{}, fmt.Errorf("video duration is unknown")
+	}
+	frames := int(math.Ceil(float64(duration) / float64(interval)))
+	if frames > maxSpriteFrames {
+		frames = maxSpriteFrames
+		interval = time.Duration(math.Ceil(float64(duration) / maxSpriteFrames))
+	}
+	rows := (frames + spriteColumns - 1) / spriteColumns
+
+	ext := filepath.Ext(filePath)
+	outputFilePath := strings.TrimSuffix(filePath, ext) + ".sprite.jpg"
+
+	filter := fmt.Sprintf(
+		"fps=1/%f,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		interval.Seconds(),
+		spriteTileWidth, spriteTileHeight,
+		spriteTileWidth, spriteTileHeight,
+		spriteColumns, rows,
+	)
+	args := []string{
+		"-i", filePath,
+		"-an",
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "5",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "image2",
+		outputFilePath,
+	}
+	if err := runFFmpeg(ctx, runner, args, progress); err != nil {
+		os.Remove(outputFilePath)
+		return spriteSheet{}, err
+	}
+	return spriteSheet{path: outputFilePath, interval: interval, frames: frames}, nil
+}
+
+// webVTT maps each interval of the video to its tile in the sprite at
+// spriteURL, using the media fragment syntax players like Video.js read
+func (s spriteSheet) webVTT(spriteURL string, duration time.Duration) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < s.frames; i++ {
+		start := time.Duration(i) * s.interval
+		end := min(start+s.interval, duration)
+		if start >= end {
+			break
+		}
+		x := (i % spriteColumns) * spriteTileWidth
+		y := (i / spriteColumns) * spriteTileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteURL, x, y, spriteTileWidth, spriteTileHeight)
+	}
+	return b.String()
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// uploadSpriteSheet stores the sprite and a WebVTT index pointing into it
+// under prefix
+func uploadSpriteSheet(ctx context.Context, target storeTarget, prefix string, sprite spriteSheet, duration time.Duration, progress func(read int64)) (database.SpriteURLs, error) {
+	spriteKey, err := newSpriteKey(prefix, "jpg")
+	if err != nil {
+		return database.SpriteURLs{}, err
+	}
+	if _, err := putFile(ctx, target, spriteKey, sprite.path, "image/jpeg", progress); err != nil {
+		return database.SpriteURLs{}, fmt.Errorf("couldn't upload sprite sheet to object store: %w", err)
+	}
+	spriteURL := target.objectURL(spriteKey)
+
+	vttKey, err := newSpriteKey(prefix, "vtt")
+	if err != nil {
+		return database.SpriteURLs{}, err
+	}
+	vtt := sprite.webVTT(spriteURL, duration)
+	if err := target.store.Put(ctx, vttKey, strings.NewReader(vtt), "text/vtt"); err != nil {
+		deletePartialObjects(target, []string{spriteKey})
+		return database.SpriteURLs{}, fmt.Errorf("couldn't upload sprite index to object store: %w", err)
+	}
+	vttURL := target.objectURL(vttKey)
+	return database.SpriteURLs{Sprite: &spriteURL, VTT: &vttURL}, nil
+}
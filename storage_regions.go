@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// defaultRegionName is how clients ask for the default bucket; videos in it
+// have an empty storage region
+const defaultRegionName = "default"
+
+var regionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// storageRegion is a named bucket videos can be kept in for data residency,
+// besides the default one
+type storageRegion struct {
+	Name      string `json:"name"`
+	AWSRegion string `json:"aws_region"`
+	bucket    string
+	store     *storage.S3Store
+	target    storeTarget
+}
+
+// parseStorageRegions reads STORAGE_REGIONS, a comma separated list of
+// name=aws-region/bucket. The buckets are reached with the same credentials
+// as the default one; retries, if set, gives each bucket's client its retry
+// policy.
+func parseStorageRegions(spec string, awsConfig aws.Config, endpoint string, retries func(bucket string) func(*s3.Options)) (map[string]storageRegion, error) {
+	regions := map[string]storageRegion{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(entry, "=")
+		awsRegion, bucket, ok2 := strings.Cut(location, "/")
+		if !ok || !ok2 || awsRegion == "" || bucket == "" {
+			return nil, fmt.Errorf("%q isn't name=aws-region/bucket", entry)
+		}
+		if !regionNamePattern.MatchString(name) || name == defaultRegionName {
+			return nil, fmt.Errorf("invalid region name %q", name)
+		}
+		if _, ok := regions[name]; ok {
+			return nil, fmt.Errorf("region %q is listed twice", name)
+		}
+
+		optFns := []func(*s3.Options){func(o *s3.Options) {
+			o.Region = awsRegion
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+				o.UsePathStyle = true
+			}
+		}}
+		if retries != nil {
+			optFns = append(optFns, retries(bucket))
+		}
+		client := s3.NewFromConfig(awsConfig, optFns...)
+		store := storage.NewS3Store(client, bucket)
+		regions[name] = storageRegion{
+			Name:      name,
+			AWSRegion: awsRegion,
+			bucket:    bucket,
+			store:     store,
+			target: storeTarget{
+				store:   store,
+				baseURL: bucketURL(bucket, awsRegion, endpoint),
+			},
+		}
+	}
+	return regions, nil
+}
+
+// resolveStorageRegion maps a region name from a client to what's stored
+// on videos, reporting false if there's no such region
+func (cfg *apiConfig) resolveStorageRegion(name string) (string, bool) {
+	if name == defaultRegionName {
+		return "", true
+	}
+	_, ok := cfg.storageRegions[name]
+	return name, ok
+}
+
+// pickStorageRegion settles which region an upload's files go to: the one
+// the client asked for with ?region=, else the one the video already has,
+// else the organization's default. Once a video has files its region can't
+// change, since they'd be left behind in the old bucket.
+func (cfg *apiConfig) pickStorageRegion(w http.ResponseWriter, r *http.Request, video database.Video) (database.Video, bool) {
+	region := video.StorageRegion
+	requested := r.URL.Query().Get("region")
+	if requested == "" && video.VideoURL == nil && video.OrganizationID.Valid {
+		org, err := cfg.db.GetOrganization(video.OrganizationID.UUID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+			return database.Video{}, false
+		}
+		region = org.StorageRegion
+	}
+	if requested != "" {
+		var ok bool
+		if region, ok = cfg.resolveStorageRegion(requested); !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown storage region %q", requested), nil)
+			return database.Video{}, false
+		}
+	}
+	if region == video.StorageRegion {
+		return video, true
+	}
+
+	if video.VideoURL != nil {
+		respondWithError(w, http.StatusConflict, "The video's files are already stored in another region", nil)
+		return database.Video{}, false
+	}
+	if region != "" && video.OrganizationID.Valid {
+		st, err := cfg.db.GetOrganizationStorage(video.OrganizationID.UUID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization storage", err)
+			return database.Video{}, false
+		}
+		if st.Bucket != "" {
+			respondWithError(w, http.StatusConflict, "The organization stores videos in its own bucket", nil)
+			return database.Video{}, false
+		}
+	}
+	if err := cfg.db.SetVideoStorageRegion(video.ID, region); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set storage region", err)
+		return database.Video{}, false
+	}
+	video.StorageRegion = region
+	return video, true
+}
+
+func (cfg *apiConfig) handlerStorageRegions(w http.ResponseWriter, r *http.Request) {
+	regions := []storageRegion{{Name: defaultRegionName, AWSRegion: cfg.s3Region}}
+	names := make([]string, 0, len(cfg.storageRegions))
+	for name := range cfg.storageRegions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		regions = append(regions, cfg.storageRegions[name])
+	}
+	cfg.respondWithList(w, r, regions, completeList(len(regions)))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// how often videos stuck failed or processing are looked for
+	stuckVideoInterval = time.Hour
+	stuckVideoBatch    = 50
+)
+
+// partialObjects lists what processing may have stored for a video before it
+// failed. The thumbnails and captions are the owner's, so they stay.
+func (cfg *apiConfig) partialObjects(video database.Video) []database.CreatePendingDeletionParams {
+	objects := []database.CreatePendingDeletionParams{}
+	for _, prefix := range []string{hlsPrefix(video.ID), renditionPrefix(video.ID), previewPrefix(video.ID), spritePrefix(video.ID)} {
+		for _, key := range cfg.keyLayout.Prefixes(video.UserID, prefix) {
+			objects = append(objects, database.CreatePendingDeletionParams{
+				Kind:           database.DeletionPrefix,
+				Key:            key,
+				OrganizationID: video.OrganizationID,
+				Region:         video.StorageRegion,
+			})
+		}
+	}
+	return objects
+}
+
+// expireStuckVideo gives up on a video whose processing failed or never
+// finished: its partial files are deleted, it's marked failed_expired and its
+// owner is told. It returns false if the video had moved on in the meantime.
+func (cfg *apiConfig) expireStuckVideo(ctx context.Context, video database.Video) (bool, error) {
+	deletions, expired, err := cfg.db.ExpireStuckVideo(video.ID, cfg.partialObjects(video))
+	if err != nil || !expired {
+		return false, err
+	}
+	// anything that fails here stays queued and is retried in the background
+	if failed := cfg.processPendingDeletions(ctx, deletions); failed > 0 {
+		log.Printf("Video %s expired, %d objects queued for retry", video.ID, failed)
+	}
+
+	message := fmt.Sprintf("Processing didn't finish within %s, so the upload was removed", cfg.stuckVideoExpiry)
+	err = cfg.db.CreateVideoEvent(database.CreateVideoEventParams{
+		VideoID: video.ID,
+		Type:    database.EventProcessingExpired,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("Couldn't record expiry of video %s: %v", video.ID, err)
+	}
+	pushCtx, cancel := context.WithTimeout(ctx, pushSendTimeout)
+	defer cancel()
+	cfg.pushToUser(pushCtx, video.UserID, uploadNotification{
+		Type:    database.EventProcessingExpired,
+		VideoID: video.ID.String(),
+		Title:   fmt.Sprintf("%q was removed", video.Title),
+		Body:    message + ". Upload it again to publish it.",
+	})
+	return true, nil
+}
+
+// runStuckVideoExpirer expires videos that have been failed or processing
+// for longer than STUCK_VIDEO_EXPIRY, so broken entries and their files don't
+// pile up
+func (cfg *apiConfig) runStuckVideoExpirer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		videos, err := cfg.db.GetVideosStuckSince(time.Now().Add(-cfg.stuckVideoExpiry), stuckVideoBatch)
+		if err != nil {
+			log.Printf("Couldn't load stuck videos: %v", err)
+			continue
+		}
+		for _, video := range videos {
+			// a deferred upload can sit queued for a while, leave it be
+			if cfg.jobs.hasUnfinished(video.ID) {
+				continue
+			}
+			expired, err := cfg.expireStuckVideo(ctx, video)
+			if err != nil {
+				log.Printf("Couldn't expire video %s stuck %s: %v", video.ID, video.ProcessingStatus, err)
+				continue
+			}
+			if expired {
+				log.Printf("Expired video %s stuck %s", video.ID, video.ProcessingStatus)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// suspensionMiddleware turns away requests authenticated as a suspended
+// user. Access JWTs live for weeks, so turning them away at sign in isn't
+// enough. Requests without a valid token go through to be handled as usual.
+func (cfg *apiConfig) suspensionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := cfg.requestUserID(r); ok && !cfg.checkNotSuspended(w, userID) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkNotSuspended writes the error response itself if the user is
+// suspended
+func (cfg *apiConfig) checkNotSuspended(w http.ResponseWriter, userID uuid.UUID) bool {
+	suspended, err := cfg.db.IsUserSuspended(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check account", err)
+		return false
+	}
+	if suspended {
+		respondWithError(w, http.StatusForbidden, "Your account is suspended", nil)
+		return false
+	}
+	return true
+}
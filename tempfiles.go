@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// tempFilePrefix starts the name of every temp file the server makes,
+	// including the ffmpeg outputs written next to them
+	tempFilePrefix      = "tubely-"
+	tempJanitorInterval = time.Hour
+
+	// uploadSpaceFactor is how many copies of an upload processing can have
+	// on disk at once: the upload, the faststart copy and the renditions
+	uploadSpaceFactor = 3
+)
+
+var errInsufficientDisk = errors.New("not enough free disk space")
+
+// checkUploadSpace makes sure the temp dir's disk has room to receive and
+// process size bytes while keeping minFreeDisk free. A size of -1, an
+// unknown length, only checks the reserve. If free space can't be read the
+// upload is let through.
+func (cfg *apiConfig) checkUploadSpace(size int64) error {
+	free, err := freeDiskSpace(cfg.tempDir)
+	if err != nil {
+		log.Printf("Couldn't check free space in %s: %v", cfg.tempDir, err)
+		return nil
+	}
+	need := cfg.minFreeDisk + max(size, 0)*uploadSpaceFactor
+	if free < uint64(need) {
+		return fmt.Errorf("%w: %d bytes free, %d needed", errInsufficientDisk, free, need)
+	}
+	return nil
+}
+
+func respondWithInsufficientDisk(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "300")
+	respondWithError(w, http.StatusInsufficientStorage, "The server is out of space for uploads, try again later", err)
+}
+
+// removeStaleTempFiles deletes temp files in dir older than maxAge. They're
+// left behind by requests that crashed or by a server that was killed
+// mid-upload; live ones are rewritten or removed well before maxAge.
+func removeStaleTempFiles(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't remove stale temp file %s: %v", path, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// runTempJanitor clears out stale temp files at startup and then every
+// interval
+func (cfg *apiConfig) runTempJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		removed, err := removeStaleTempFiles(cfg.tempDir, cfg.tempFileMaxAge)
+		if err != nil {
+			log.Printf("Couldn't clean temp dir %s: %v", cfg.tempDir, err)
+		} else if removed > 0 {
+			log.Printf("Removed %d stale temp files from %s", removed, cfg.tempDir)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+type thumbnailFormat string
+
+const (
+	thumbnailJPEG thumbnailFormat = "jpeg"
+	thumbnailWebP thumbnailFormat = "webp"
+)
+
+func (f thumbnailFormat) extension() string {
+	if f == thumbnailWebP {
+		return "webp"
+	}
+	return "jpg"
+}
+
+// the sizes every thumbnail is stored at; images are never scaled up
+var (
+	thumbnailLarge = image.Pt(1280, 720)
+	thumbnailSmall = image.Pt(320, 180)
+)
+
+// decoding a huge image needs width*height*4 bytes, so refuse anything a
+// thumbnail has no use for before decoding it
+const maxThumbnailPixels = 50_000_000
+
+type thumbnailOptions struct {
+	format  thumbnailFormat
+	quality int
+	// crop cuts the image to 16:9 around its center before resizing
+	crop bool
+}
+
+// decodeThumbnail decodes a JPEG or PNG and turns it the right way up using
+// its EXIF orientation, since the EXIF data doesn't survive re-encoding
+func decodeThumbnail(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read image: %w", err)
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is %dx%d, which is too large", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	return orient(img, jpegOrientation(data)), nil
+}
+
+// cropToAspect returns the largest centered part of img with the aspect
+// ratio of size
+func cropToAspect(img image.Image, size image.Point) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w*size.Y > h*size.X {
+		w = h * size.X / size.Y
+	} else {
+		h = w * size.Y / size.X
+	}
+	x := b.Min.X + (b.Dx()-w)/2
+	y := b.Min.Y + (b.Dy()-h)/2
+	return subImage(img, image.Rect(x, y, x+w, y+h))
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// resizeToFit scales img down to fit inside size, averaging the source
+// pixels under each destination pixel. Transparent areas end up white, since
+// neither output format keeps alpha here.
+func resizeToFit(img image.Image, size image.Point) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size.X {
+		h = max(1, (h*size.X+w/2)/w)
+		w = size.X
+	}
+	if h > size.Y {
+		w = max(1, (w*size.Y+h/2)/h)
+		h = size.Y
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Over)
+	if w == b.Dx() && h == b.Dy() {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*b.Dy()/h, max((y+1)*b.Dy()/h, y*b.Dy()/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*b.Dx()/w, max((x+1)*b.Dx()/w, x*b.Dx()/w+1)
+			var r, g, bl, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					bl += int(row[sx*4+2])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// encodeThumbnail writes img in the configured format. Go can't encode WebP,
+// so ffmpeg does it from a lossless PNG.
+func encodeThumbnail(ctx context.Context, runner *media.Runner, img image.Image, opts thumbnailOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if opts.format != thumbnailWebP {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.quality})
+		return buf.Bytes(), err
+	}
+
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	args := []string{
+		"-v", "error",
+		"-f", "png_pipe", "-i", "pipe:0",
+		"-c:v", "libwebp", "-quality", fmt.Sprint(opts.quality),
+		"-f", "webp", "pipe:1",
+	}
+	var out bytes.Buffer
+	if err := runner.FFmpeg(ctx, args, media.Options{Stdin: &buf, Stdout: &out}); err != nil {
+		return nil, fmt.Errorf("couldn't encode WebP: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 1 (upright) if
+// it has none or data isn't a JPEG
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	// walk the segments up to the image data looking for APP1 Exif
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[i+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if o, err := exifOrientation(segment[6:]); err == nil {
+				return o
+			}
+			return 1
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) (int, error) {
+	if len(tiff) < 8 {
+		return 0, errors.New("short TIFF header")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, errors.New("bad byte order")
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0, errors.New("IFD out of range")
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 0, errors.New("bad orientation")
+			}
+			return o, nil
+		}
+	}
+	return 0, errors.New("no orientation tag")
+}
+
+// orient applies an EXIF orientation so the image displays upright
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counterclockwise to display
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
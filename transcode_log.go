@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+type uploadJobKey struct{}
+
+// withUploadJob ties everything run with the returned context to job:
+// runFFmpeg copies ffmpeg's stderr into the job's log, and log records get
+// the job's IDs
+func withUploadJob(ctx context.Context, job *uploadJob) context.Context {
+	return context.WithValue(ctx, uploadJobKey{}, job)
+}
+
+// ffmpegLogFrom returns a writer that adds each line written to it to the
+// job's log, or nil if ctx has no job
+func ffmpegLogFrom(ctx context.Context) io.Writer {
+	job, ok := ctx.Value(uploadJobKey{}).(*uploadJob)
+	if !ok {
+		return nil
+	}
+	return &jobLogWriter{job: job}
+}
+
+// jobLogWriter splits output into lines; ffmpeg ends its status lines with
+// a carriage return, so that counts as a line end too
+type jobLogWriter struct {
+	job     *uploadJob
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *jobLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(w.partial[:i]); len(line) > 0 {
+			w.job.appendLog(string(line))
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
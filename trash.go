@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// how often the trash is checked for videos past the retention window
+	trashPurgeInterval = 10 * time.Minute
+	trashPurgeBatch    = 50
+)
+
+// trashExpiresAt is when a video trashed at deletedAt gets purged
+func (cfg *apiConfig) trashExpiresAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(cfg.trashRetention)
+}
+
+// runTrashPurger deletes videos, and everything stored for them, once they've
+// been in the trash longer than the retention window. Videos under legal hold
+// stay in the trash until the hold is released.
+func (cfg *apiConfig) runTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		videos, err := cfg.db.GetVideosTrashedBefore(time.Now().Add(-cfg.trashRetention), trashPurgeBatch)
+		if err != nil {
+			log.Printf("Couldn't load trashed videos: %v", err)
+			continue
+		}
+		for _, video := range videos {
+			if err := cfg.deleteVideo(ctx, video); err != nil {
+				log.Printf("Couldn't purge video %s trashed at %s: %v", video.ID, video.DeletedAt, err)
+				continue
+			}
+			log.Printf("Purged video %s from the trash", video.ID)
+		}
+	}
+}
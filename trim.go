@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// trimTimestamp is a position in a video, given either as seconds or as
+// [hh:]mm:ss[.fff]
+type trimTimestamp time.Duration
+
+func (t *trimTimestamp) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		if seconds < 0 || math.IsInf(seconds, 0) {
+			return fmt.Errorf("invalid timestamp %s", data)
+		}
+		*t = trimTimestamp(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("timestamps must be seconds or hh:mm:ss")
+	}
+	d, err := parseClockTimestamp(text)
+	if err != nil {
+		return err
+	}
+	*t = trimTimestamp(d)
+	return nil
+}
+
+func parseClockTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || (len(parts) > 1 && seconds >= 60) {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	// the fields before the seconds are minutes, or hours and minutes
+	var whole int
+	for i, part := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (i > 0 && n > 59) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		whole = whole*60 + n
+	}
+	return time.Duration(whole)*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// keyframeTolerance is how far a cut can be from a keyframe and still be
+// treated as starting on it, about a frame at 25 fps
+const keyframeTolerance = 40 * time.Millisecond
+
+// trimVideo writes the part of the video at filePath between start and end
+// to a file next to it. A cut that starts on a keyframe is a plain stream
+// copy; any other start has to be re-encoded, since a copy would begin with
+// frames that can't be decoded.
+func trimVideo(ctx context.Context, runner *media.Runner, filePath string, start, end time.Duration) (string, error) {
+	ext := filepath.Ext(filePath)
+	outputFilePath := strings.TrimSuffix(filePath, ext) + ".trimmed.mp4"
+
+	copyStreams := start == 0
+	if !copyStreams {
+		aligned, err := startsOnKeyframe(ctx, runner, filePath, start)
+		if err != nil {
+			return "", err
+		}
+		copyStreams = aligned
+	}
+
+	buildArgs := func(codecArgs []string) []string {
+		args := []string{
+			"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+			"-to", fmt.Sprintf("%.3f", end.Seconds()),
+			"-i", filePath,
+			"-map", "0:v:0", "-map", "0:a:0?",
+		}
+		args = append(args, codecArgs...)
+		return append(args,
+			"-movflags", "faststart",
+			"-progress", "pipe:1", "-nostats",
+			"-f", "mp4",
+			outputFilePath,
+		)
+	}
+	var err error
+	if copyStreams {
+		err = runFFmpeg(ctx, runner, buildArgs([]string{"-c", "copy", "-avoid_negative_ts", "make_zero"}), func(time.Duration) {})
+	} else {
+		err = runH264Encode(ctx, runner, 20, outputFilePath, func(videoArgs []string) []string {
+			return buildArgs(append(videoArgs, "-c:a", "aac", "-b:a", "160k"))
+		}, func(time.Duration) {})
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+		return "", err
+	}
+	return outputFilePath, nil
+}
+
+// startsOnKeyframe reports whether the video at filePath has a keyframe at
+// start, only reading the packets around it
+func startsOnKeyframe(ctx context.Context, runner *media.Runner, filePath string, start time.Duration) (bool, error) {
+	window := fmt.Sprintf("%.3f%%+1", max(0, start-time.Second).Seconds())
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", window,
+		"-print_format", "json",
+		"-show_entries", "packet=pts_time,flags",
+		filePath,
+	}
+	stdout := &cappedBuffer{limit: ffprobeMaxOutput}
+	err := runner.FFprobe(ctx, args, media.Options{Stdout: stdout, Timeout: ffprobeTimeout})
+	if stdout.exceeded {
+		return false, fmt.Errorf("ffprobe output exceeded %d bytes", ffprobeMaxOutput)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var data struct {
+		Packets []struct {
+			PTSTime string `json:"pts_time"`
+			Flags   string `json:"flags"`
+		} `json:"packets"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return false, err
+	}
+	for _, packet := range data.Packets {
+		if !strings.HasPrefix(packet.Flags, "K") {
+			continue
+		}
+		pts, err := strconv.ParseFloat(packet.PTSTime, 64)
+		if err != nil {
+			continue
+		}
+		offset := time.Duration(pts*float64(time.Second)) - start
+		if offset.Abs() <= keyframeTolerance {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// uploadWindow is a daily blackout span in server local time, as offsets from
+// midnight. It wraps past midnight when end is before start.
+type uploadWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseUploadWindows reads a comma separated list like "22:00-06:00,12:00-13:00"
+func parseUploadWindows(s string) ([]uploadWindow, error) {
+	windows := []uploadWindow{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		startString, endString, found := strings.Cut(part, "-")
+		if !found {
+			return nil, fmt.Errorf("window %q isn't HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(startString)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(endString)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %q is empty", part)
+		}
+		windows = append(windows, uploadWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a HH:MM time", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// endAfter returns when the window that now falls in closes, and false if now
+// isn't in the window
+func (w uploadWindow) endAfter(now time.Time) (time.Time, bool) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	if w.start < w.end {
+		if offset >= w.start && offset < w.end {
+			return midnight.Add(w.end), true
+		}
+		return time.Time{}, false
+	}
+	// wraps midnight: either the evening part or the morning part
+	if offset >= w.start {
+		return midnight.AddDate(0, 0, 1).Add(w.end), true
+	}
+	if offset < w.end {
+		return midnight.Add(w.end), true
+	}
+	return time.Time{}, false
+}
+
+// uploadBlackoutUntil returns when uploads can next be processed if now is in
+// a blackout window. Back to back windows are treated as one.
+func (cfg *apiConfig) uploadBlackoutUntil(now time.Time) (time.Time, bool) {
+	until := now
+	for {
+		extended := false
+		for _, window := range cfg.uploadBlackouts {
+			if end, ok := window.endAfter(until); ok && end.After(until) {
+				until = end
+				extended = true
+			}
+		}
+		if !extended {
+			break
+		}
+	}
+	return until, until.After(now)
+}
+
+// waitForUploadWindow holds a job back until any blackout window closes, then
+// takes a place in the drain so deferred uploads don't all start at once. The
+// returned release must be called when the job is done.
+func (cfg *apiConfig) waitForUploadWindow(ctx context.Context, job *uploadJob) (release func(), err error) {
+	until, deferred := cfg.uploadBlackoutUntil(time.Now())
+	if !deferred {
+		return func() {}, nil
+	}
+
+	job.setDeferredUntil(until)
+	for deferred {
+		timer := time.NewTimer(time.Until(until))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return func() {}, ctx.Err()
+		case <-timer.C:
+		}
+		// a clock change or a new window may have moved things
+		until, deferred = cfg.uploadBlackoutUntil(time.Now())
+	}
+
+	select {
+	case cfg.uploadDrain <- struct{}{}:
+	case <-ctx.Done():
+		return func() {}, ctx.Err()
+	}
+	return func() { <-cfg.uploadDrain }, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/textproto"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	lengthSourceBody = "content_length"
+	lengthSourcePart = "part_size"
+)
+
+// uploadLengthMismatch records how many bytes arrived against how many the
+// client said it would send, either for the whole request body or for the
+// video part on its own
+type uploadLengthMismatch struct {
+	Source   string `json:"source"`
+	Declared int64  `json:"declared"`
+	Received int64  `json:"received"`
+}
+
+func (m uploadLengthMismatch) Error() string {
+	return fmt.Sprintf("%s mismatch: declared %d bytes, received %d", m.Source, m.Declared, m.Received)
+}
+
+// checkUploadLength returns the mismatch if a declared length is known and
+// differs from what was received. A negative declared length means the
+// client didn't send one, e.g. a chunked body.
+func checkUploadLength(source string, declared, received int64) (uploadLengthMismatch, bool) {
+	if declared < 0 || declared == received {
+		return uploadLengthMismatch{}, false
+	}
+	return uploadLengthMismatch{Source: source, Declared: declared, Received: received}, true
+}
+
+// partContentLength is the size a multipart part declares for itself, or -1.
+// Browsers don't send one, but upload tools and SDKs often do.
+func partContentLength(header textproto.MIMEHeader) int64 {
+	n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// lengthMismatch records a discrepancy on the upload job and its event log,
+// and reports whether the upload should be rejected for it
+func (cfg *apiConfig) lengthMismatch(job *uploadJob, m uploadLengthMismatch) bool {
+	job.setLengthMismatch(m)
+	cfg.recordJobEvent(job, stageReceiving, database.EventLengthMismatch, m.Error())
+	if !cfg.strictUploadLength {
+		job.addWarning(m.Error())
+	}
+	return cfg.strictUploadLength
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// uploadLimit bounds the request body of one kind of upload
+type uploadLimit struct {
+	maxBytes int64
+	// multipartMemory is how much of a parsed form is held in memory, the
+	// rest of a file part is spilled to disk
+	multipartMemory int64
+}
+
+// limitUploadBody refuses a request that declares a body bigger than the
+// limit and caps the body of any other at it, so one that lies about its
+// length is cut off when it passes the limit
+func limitUploadBody(w http.ResponseWriter, r *http.Request, limit uploadLimit) bool {
+	if r.ContentLength > limit.maxBytes {
+		respondWithTooLarge(w, limit.maxBytes, nil)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit.maxBytes)
+	return true
+}
+
+// respondIfTooLarge sends a 413 if err came from reading past the body
+// limit, and reports whether it did
+func respondIfTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	respondWithTooLarge(w, tooLarge.Limit, err)
+	return true
+}
+
+func respondWithTooLarge(w http.ResponseWriter, limit int64, err error) {
+	type tooLargeResponse struct {
+		Error    string `json:"error"`
+		MaxBytes int64  `json:"max_bytes"`
+	}
+	msg := fmt.Sprintf("Upload is larger than the %d MB limit", limit>>20)
+	slog.Info(msg, "status", http.StatusRequestEntityTooLarge, "error", err, "request_id", w.Header().Get("X-Request-ID"))
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, tooLargeResponse{
+		Error:    msg,
+		MaxBytes: limit,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// videoPart is the video file of an upload form, read as it arrives
+type videoPart struct {
+	*multipart.Part
+	videoType mediaType
+	// file reads the part from its start; its first sniffLen bytes have
+	// already been checked
+	file *bufio.Reader
+}
+
+// openVideoPart finds the video part of the form and checks its first bytes
+// before any more of it is read. A file that clearly isn't the video it
+// claims to be gets a 415 straight away, and the connection is closed
+// rather than drained so the client stops sending the rest of it. The part
+// isn't closed on failure either, as that would read it to its end.
+func (cfg *apiConfig) openVideoPart(w http.ResponseWriter, r *http.Request) (videoPart, bool) {
+	reader, err := r.MultipartReader()
+	if respondIfTooLarge(w, err) {
+		return videoPart{}, false
+	}
+	if err != nil {
+		http.Error(w, "unable to parse form data", http.StatusBadRequest)
+		return videoPart{}, false
+	}
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if respondIfTooLarge(w, err) {
+			return videoPart{}, false
+		}
+		if err != nil {
+			http.Error(w, "unable to extract video file from form data", http.StatusBadRequest)
+			return videoPart{}, false
+		}
+		if part.FormName() == "video" {
+			break
+		}
+	}
+
+	contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "invalid Content-Type header", http.StatusBadRequest)
+		return videoPart{}, false
+	}
+	// The real container is checked with ffprobe during processing
+	videoType, ok := cfg.mediaTypes.lookup(contentType, mediaKindVideo)
+	if !ok {
+		w.Header().Set("Connection", "close")
+		http.Error(w, fmt.Sprintf("only %s videos are accepted", cfg.mediaTypes.describe(mediaKindVideo)), http.StatusBadRequest)
+		return videoPart{}, false
+	}
+
+	// Don't trust the header, check the file's magic bytes too
+	file := bufio.NewReaderSize(part, sniffLen)
+	header, err := file.Peek(sniffLen)
+	if respondIfTooLarge(w, err) {
+		return videoPart{}, false
+	}
+	if err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read uploaded file", err)
+		return videoPart{}, false
+	}
+	if err := videoType.validate(header); err != nil {
+		w.Header().Set("Connection", "close")
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error(), err)
+		return videoPart{}, false
+	}
+
+	return videoPart{Part: part, videoType: videoType, file: file}, true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// shortIDAlphabet is nanoid's URL-safe alphabet. It has 64 characters, so
+// masking a random byte picks each one with the same odds.
+const shortIDAlphabet = "useandom-26T198340PX75pxJACKVERYMINDBUSHWOLF_GQZbfghjklqvwyzrict"
+
+const (
+	defaultShortIDLength = 8
+	// short IDs stay well short of a UUID's 32 characters so the two can
+	// never be confused
+	minShortIDLength = 6
+	maxShortIDLength = 21
+	// attempts at a short ID nobody has before giving up
+	shortIDAttempts = 5
+)
+
+// parseVideoIDFormat reads VIDEO_ID_FORMAT, "uuid" or "short", and returns
+// the length of the short IDs new videos get, 0 when they only get UUIDs.
+// Videos always keep their UUID, so links with either keep working when the
+// format changes.
+func parseVideoIDFormat(format, length string) (int, error) {
+	switch format {
+	case "", "uuid":
+		return 0, nil
+	case "short":
+	default:
+		return 0, fmt.Errorf("VIDEO_ID_FORMAT must be \"uuid\" or \"short\", got %q", format)
+	}
+	if length == "" {
+		return defaultShortIDLength, nil
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n < minShortIDLength || n > maxShortIDLength {
+		return 0, fmt.Errorf("VIDEO_SHORT_ID_LENGTH must be from %d to %d, got %q", minShortIDLength, maxShortIDLength, length)
+	}
+	return n, nil
+}
+
+func newShortID(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = shortIDAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
+
+// validVideoRef reports whether ref could be a UUID or a short ID, so
+// anything else is turned away before it reaches the database
+func validVideoRef(ref string) bool {
+	if ref == "" || len(ref) > 36 {
+		return false
+	}
+	if _, err := uuid.Parse(ref); err == nil {
+		return true
+	}
+	if len(ref) > maxShortIDLength {
+		return false
+	}
+	for _, c := range ref {
+		if !strings.ContainsRune(shortIDAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// createVideo creates a video, with a short ID when they're turned on
+func (cfg *apiConfig) createVideo(params database.CreateVideoParams, sourceID *uuid.UUID) (database.Video, error) {
+	for attempt := 1; ; attempt++ {
+		shortID := ""
+		if cfg.shortIDLength > 0 {
+			var err error
+			shortID, err = newShortID(cfg.shortIDLength)
+			if err != nil {
+				return database.Video{}, err
+			}
+		}
+
+		var video database.Video
+		var err error
+		if sourceID != nil {
+			video, err = cfg.db.CreateDerivedVideo(params, *sourceID, shortID)
+		} else {
+			video, err = cfg.db.CreateVideo(params, shortID)
+		}
+		if errors.Is(err, database.ErrShortIDTaken) && attempt < shortIDAttempts {
+			continue
+		}
+		return video, err
+	}
+}
+
+// pathVideoID resolves the {videoID} in the path, a UUID or a short ID. A
+// short ID no video has resolves to uuid.Nil, which finds nothing.
+func (cfg *apiConfig) pathVideoID(r *http.Request) (uuid.UUID, error) {
+	ref := r.PathValue("videoID")
+	if !validVideoRef(ref) {
+		return uuid.Nil, fmt.Errorf("invalid video ID %q", ref)
+	}
+	return cfg.db.ResolveVideoID(ref)
+}
+
+// videoRef is how links name the video: its short ID if it has one
+func videoRef(video database.Video) string {
+	if video.ShortID != nil {
+		return *video.ShortID
+	}
+	return video.ID.String()
+}
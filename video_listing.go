@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoPageSize = 50
+	maxVideoPageSize     = 100
+)
+
+var videoAspectRatios = map[string]bool{"landscape": true, "portrait": true, "other": true}
+
+var processingStatuses = map[database.ProcessingStatus]bool{
+	database.ProcessingStatusAwaitingUpload: true,
+	database.ProcessingStatusProcessing:     true,
+	database.ProcessingStatusReady:          true,
+	database.ProcessingStatusFailed:         true,
+	database.ProcessingStatusExpired:        true,
+}
+
+// parseListVideosParams reads the GET /api/videos query. Errors are meant for
+// the client.
+func parseListVideosParams(query url.Values, viewerID uuid.UUID) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		ViewerID:   viewerID,
+		OwnerID:    viewerID,
+		Sort:       database.VideoSortCreatedAt,
+		Descending: true,
+		Limit:      defaultVideoPageSize,
+	}
+
+	if owner := query.Get("owner"); owner != "" {
+		ownerID, err := uuid.Parse(owner)
+		if err != nil {
+			return params, errors.New("owner must be a user ID")
+		}
+		params.OwnerID = ownerID
+	}
+	if aspect := query.Get("aspect_ratio"); aspect != "" {
+		if !videoAspectRatios[aspect] {
+			return params, errors.New("aspect_ratio must be landscape, portrait or other")
+		}
+		params.AspectRatio = aspect
+	}
+	if status := database.ProcessingStatus(query.Get("status")); status != "" {
+		if !processingStatuses[status] {
+			return params, fmt.Errorf("unknown status %q", status)
+		}
+		params.Status = status
+	}
+	switch moderation := database.ModerationStatus(query.Get("moderation")); moderation {
+	case "":
+	case database.ModerationPending, database.ModerationApproved, database.ModerationFlagged:
+		params.Moderation = moderation
+	default:
+		return params, errors.New("moderation must be pending, approved or flagged")
+	}
+	if tag := query.Get("tag"); tag != "" {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return params, err
+		}
+		params.Tag = tag
+	}
+	if sort := database.VideoSort(query.Get("sort")); sort != "" {
+		if !database.ValidVideoSort(sort) {
+			return params, errors.New("sort must be created_at, title or views")
+		}
+		params.Sort = sort
+		// titles read best A to Z, everything else newest or biggest first
+		params.Descending = sort != database.VideoSortTitle
+	}
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return params, errors.New("limit must be a positive integer")
+		}
+		params.Limit = min(n, maxVideoPageSize)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := decodeVideoCursor(cursor)
+		if err != nil {
+			return params, errors.New("invalid cursor")
+		}
+		params.After = &after
+	}
+	return params, nil
+}
+
+func encodeVideoCursor(cursor database.VideoCursor) (string, error) {
+	dat, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+func decodeVideoCursor(s string) (database.VideoCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	var cursor database.VideoCursor
+	err = json.Unmarshal(dat, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// restoredVideoDays is how long a restored copy of an archived video stays
+// readable before S3 removes it again
+const restoredVideoDays = 7
+
+const (
+	fileArchived  = "archived"
+	fileRestoring = "restoring"
+	fileMissing   = "missing"
+)
+
+// unplayableFile says why a video's file can't be played right now. Error
+// is left out when it's the answer to a restore request.
+type unplayableFile struct {
+	Error            string     `json:"error,omitempty"`
+	Status           string     `json:"restore_status"`
+	StorageClass     string     `json:"storage_class,omitempty"`
+	EstimatedReadyAt *time.Time `json:"estimated_ready_at"`
+	// RestoreURL starts a restore of an archived file
+	RestoreURL string `json:"restore_url,omitempty"`
+}
+
+// restoreEstimate is roughly how long a standard restore takes, going by
+// the S3 documentation
+func restoreEstimate(storageClass string) time.Duration {
+	if storageClass == "DEEP_ARCHIVE" {
+		return 12 * time.Hour
+	}
+	return 5 * time.Hour
+}
+
+// checkVideoFile looks at the stored video file and returns why it can't be
+// played, or nil if it can
+func checkVideoFile(ctx context.Context, target storeTarget, video database.Video) (*unplayableFile, error) {
+	key, ok := target.keyFromURL(*video.VideoURL)
+	if !ok {
+		return nil, errors.New("video URL doesn't point at the object store")
+	}
+	info, err := target.store.Head(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return &unplayableFile{Error: "The video file is missing", Status: fileMissing}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Restoring:
+		file := &unplayableFile{
+			Error:        "The video is being restored from archive storage",
+			Status:       fileRestoring,
+			StorageClass: info.StorageClass,
+		}
+		// restores started elsewhere have no start time to go by
+		if video.RestoreRequestedAt != nil {
+			readyAt := video.RestoreRequestedAt.Add(restoreEstimate(info.StorageClass))
+			if readyAt.After(time.Now()) {
+				file.EstimatedReadyAt = &readyAt
+			}
+		}
+		return file, nil
+	case info.Archived:
+		return &unplayableFile{
+			Error:        "The video is in archive storage and has to be restored first",
+			Status:       fileArchived,
+			StorageClass: info.StorageClass,
+			RestoreURL:   fmt.Sprintf("/api/videos/%s/restore", video.ID),
+		}, nil
+	}
+	return nil, nil
+}
+
+// respondWithUnplayableFile answers 425 Too Early while a restore runs, so
+// players know to come back, and 409 when someone has to act first
+func respondWithUnplayableFile(w http.ResponseWriter, file *unplayableFile) {
+	if file.Status != fileRestoring {
+		respondWithJSON(w, http.StatusConflict, file)
+		return
+	}
+	if file.EstimatedReadyAt != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*file.EstimatedReadyAt).Seconds())+1))
+	}
+	respondWithJSON(w, http.StatusTooEarly, file)
+}
+
+// startVideoRestore asks the store to bring an archived video file back and
+// returns how the file stands afterwards
+func (cfg *apiConfig) startVideoRestore(ctx context.Context, target storeTarget, video database.Video, file *unplayableFile) (*unplayableFile, error) {
+	restorer, ok := target.store.(storage.Restorer)
+	if !ok {
+		return nil, errors.New("store doesn't support restoring objects")
+	}
+	key, _ := target.keyFromURL(*video.VideoURL)
+	days := restoredVideoDays
+	// Intelligent-Tiering moves the object back instead of making a copy
+	if file.StorageClass == "INTELLIGENT_TIERING" {
+		days = 0
+	}
+	if err := restorer.Restore(ctx, key, days); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := cfg.db.SetVideoRestoreRequestedAt(video.ID, now); err != nil {
+		log.Printf("Couldn't record restore of video %s: %v", video.ID, err)
+	}
+	readyAt := now.Add(restoreEstimate(file.StorageClass))
+	return &unplayableFile{
+		Status:           fileRestoring,
+		StorageClass:     file.StorageClass,
+		EstimatedReadyAt: &readyAt,
+	}, nil
+}
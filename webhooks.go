@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	// a delivery is tried this many times, waiting twice as long after each
+	// failure, from webhookRetryBase up to webhookRetryMax: about a day
+	// in all
+	webhookMaxAttempts   = 10
+	webhookRetryBase     = 30 * time.Second
+	webhookRetryMax      = 6 * time.Hour
+	webhookRetryInterval = 30 * time.Second
+	webhookRetryBatch    = 100
+
+	maxWebhooksPerUser = 10
+	// how many events one replay request queues at most
+	webhookReplayLimit = 500
+
+	// webhookSignatureHeader holds t=<unix time>,v1=<hex HMAC-SHA256 of
+	// "<unix time>.<body>" keyed with the webhook's secret>
+	webhookSignatureHeader = "X-Tubely-Signature"
+)
+
+type webhookEvent string
+
+const (
+	// the upload was received and is about to be processed
+	webhookVideoUploaded webhookEvent = "video.uploaded"
+	webhookVideoReady    webhookEvent = "video.processed"
+	webhookVideoFailed   webhookEvent = "video.failed"
+	webhookThumbnail     webhookEvent = "thumbnail.updated"
+)
+
+var webhookEvents = []webhookEvent{
+	webhookVideoUploaded,
+	webhookVideoReady,
+	webhookVideoFailed,
+	webhookThumbnail,
+}
+
+// webhookEventSources are the video events each webhook event is recorded
+// as, so they can be replayed
+var webhookEventSources = map[webhookEvent]database.EventType{
+	webhookVideoUploaded: database.EventUploadReceived,
+	webhookVideoReady:    database.EventUploadCompleted,
+	webhookVideoFailed:   database.EventUploadFailed,
+	webhookThumbnail:     database.EventThumbnailUpdated,
+}
+
+// webhookPayload is the body of every delivery
+type webhookPayload struct {
+	// ID is the same for each webhook the event goes to, and for every
+	// retry, so receivers can drop duplicates
+	ID        uuid.UUID      `json:"id"`
+	Event     webhookEvent   `json:"event"`
+	CreatedAt time.Time      `json:"created_at"`
+	Video     database.Video `json:"video"`
+	Error     string         `json:"error,omitempty"`
+	// Replayed deliveries were asked for again by the webhook's owner.
+	// Their video is as it is now, not as it was at the event.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+var errWebhookAddress = errors.New("webhooks can't be delivered to private addresses")
+
+// newWebhookClient returns the client deliveries are sent with. Since any
+// user can pick the URL, it won't connect to loopback or private addresses,
+// checked as it dials so DNS can't be used to get around it, unless
+// allowPrivate is set for local development. Redirects aren't followed.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return errWebhookAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sendWebhookEvent queues the event for the video owner's webhooks that
+// subscribed to it and starts delivering it. Failures are only logged.
+func (cfg *apiConfig) sendWebhookEvent(videoID uuid.UUID, event webhookEvent, eventErr error) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		log.Printf("Couldn't get video %s for %s webhooks: %v", videoID, event, err)
+		return
+	}
+	webhooks, err := cfg.db.GetWebhooks(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get webhooks of user %s: %v", video.UserID, err)
+		return
+	}
+	var ids []uuid.UUID
+	for _, webhook := range webhooks {
+		if slices.Contains(webhook.Events, string(event)) {
+			ids = append(ids, webhook.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Video:     video,
+	}
+	if eventErr != nil {
+		payload.Error = eventErr.Error()
+	}
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Couldn't encode %s webhook: %v", event, err)
+		return
+	}
+	// the retrier leaves them alone while they're first attempted
+	retryAt := time.Now().Add(webhookRetryBase)
+	deliveries, err := cfg.db.CreateWebhookDeliveries(ids, string(event), dat, retryAt)
+	if err != nil {
+		log.Printf("Couldn't queue %s webhooks for video %s: %v", event, videoID, err)
+		return
+	}
+	go cfg.processWebhookDeliveries(context.Background(), deliveries)
+}
+
+// webhookReplay is how far a replay got. LastEventID is passed as after to
+// replay the rest when there's more.
+type webhookReplay struct {
+	Queued      int   `json:"queued"`
+	LastEventID int64 `json:"last_event_id"`
+	More        bool  `json:"more"`
+}
+
+// replayWebhookEvents queues the recorded events the webhook subscribes to
+// from since on, after the event afterID, and starts delivering them in
+// order
+func (cfg *apiConfig) replayWebhookEvents(webhook database.Webhook, since time.Time, afterID int64) (webhookReplay, error) {
+	types := []database.EventType{}
+	sources := map[database.EventType]webhookEvent{}
+	for _, event := range webhook.Events {
+		if source, ok := webhookEventSources[webhookEvent(event)]; ok {
+			types = append(types, source)
+			sources[source] = webhookEvent(event)
+		}
+	}
+	events, err := cfg.db.GetUserVideoEvents(webhook.UserID, types, since, afterID, webhookReplayLimit+1)
+	if err != nil {
+		return webhookReplay{}, err
+	}
+	replay := webhookReplay{LastEventID: afterID, More: len(events) > webhookReplayLimit}
+	if replay.More {
+		events = events[:webhookReplayLimit]
+	}
+
+	videos := map[uuid.UUID]database.Video{}
+	deliveries := []database.WebhookDelivery{}
+	retryAt := time.Now().Add(webhookRetryBase)
+	for _, e := range events {
+		replay.LastEventID = e.ID
+		video, ok := videos[e.VideoID]
+		if !ok {
+			video, err = cfg.db.GetVideo(e.VideoID)
+			if err != nil {
+				return webhookReplay{}, err
+			}
+			videos[e.VideoID] = video
+		}
+		// the video was deleted since
+		if video.ID == uuid.Nil {
+			continue
+		}
+
+		payload := webhookPayload{
+			// replaying an event again gives it the same ID
+			ID:        uuid.NewSHA1(uuid.NameSpaceURL, []byte("urn:tubely:video-event:"+strconv.FormatInt(e.ID, 10))),
+			Event:     sources[e.Type],
+			CreatedAt: e.CreatedAt.UTC(),
+			Video:     video,
+			Replayed:  true,
+		}
+		if e.Type == database.EventUploadFailed {
+			payload.Error = e.Message
+		}
+		dat, err := json.Marshal(payload)
+		if err != nil {
+			return webhookReplay{}, err
+		}
+		queued, err := cfg.db.CreateWebhookDeliveries([]uuid.UUID{webhook.ID}, string(payload.Event), dat, retryAt)
+		if err != nil {
+			return webhookReplay{}, err
+		}
+		deliveries = append(deliveries, queued...)
+	}
+	go cfg.processWebhookDeliveries(context.Background(), deliveries)
+	replay.Queued = len(deliveries)
+	return replay, nil
+}
+
+// processWebhookDeliveries attempts each delivery, dropping it from the queue
+// once its endpoint takes it and scheduling a retry otherwise. It returns how
+// many are still pending.
+func (cfg *apiConfig) processWebhookDeliveries(ctx context.Context, deliveries []database.WebhookDelivery) int {
+	webhooks := map[uuid.UUID]database.Webhook{}
+	failed := 0
+	for _, d := range deliveries {
+		webhook, ok := webhooks[d.WebhookID]
+		if !ok {
+			var err error
+			webhook, err = cfg.db.GetWebhook(d.WebhookID)
+			if err != nil {
+				log.Printf("Couldn't get webhook %s: %v", d.WebhookID, err)
+				failed++
+				continue
+			}
+			webhooks[d.WebhookID] = webhook
+		}
+		// the webhook was deleted since
+		if webhook.ID == uuid.Nil {
+			if err := cfg.db.DeleteWebhookDelivery(d.ID); err != nil {
+				log.Printf("Couldn't remove webhook delivery %d: %v", d.ID, err)
+			}
+			continue
+		}
+
+		if err := cfg.deliverWebhook(ctx, webhook, d); err != nil {
+			failed++
+			var next time.Time
+			if d.Attempts+1 < webhookMaxAttempts {
+				next = time.Now().Add(webhookBackoff(d.Attempts))
+			}
+			log.Printf("Couldn't deliver %s to webhook %s (attempt %d): %v", d.Event, webhook.ID, d.Attempts+1, err)
+			if err := cfg.db.MarkWebhookDeliveryFailed(d.ID, err.Error(), next); err != nil {
+				log.Printf("Couldn't record failed webhook delivery %d: %v", d.ID, err)
+			}
+			continue
+		}
+		if err := cfg.db.DeleteWebhookDelivery(d.ID); err != nil {
+			log.Printf("Couldn't remove webhook delivery %d: %v", d.ID, err)
+		}
+	}
+	return failed
+}
+
+// webhookBackoff is how long to wait after a delivery's attempts-th retry
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookRetryBase
+	for range attempts {
+		backoff *= 2
+		if backoff >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+	return backoff
+}
+
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, webhook database.Webhook, d database.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tubely-Event", d.Event)
+	req.Header.Set("X-Tubely-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set(webhookSignatureHeader, signWebhook(webhook.Secret, time.Now(), d.Payload))
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("webhook endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook signs the time along with the body so receivers can refuse
+// old deliveries played back to them
+func signWebhook(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookRetrier retries the deliveries whose next attempt is due
+func (cfg *apiConfig) runWebhookRetrier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deliveries, err := cfg.db.GetDueWebhookDeliveries(time.Now(), webhookRetryBatch)
+		if err != nil {
+			log.Printf("Couldn't load webhook deliveries: %v", err)
+			continue
+		}
+		if len(deliveries) == 0 {
+			continue
+		}
+		failed := cfg.processWebhookDeliveries(ctx, deliveries)
+		log.Printf("Retried %d webhook deliveries, %d still failing", len(deliveries), failed)
+	}
+}